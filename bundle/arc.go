@@ -0,0 +1,479 @@
+package bundle
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ARC archives are a sequence of members, each a header followed
+// by its data, ended by a header with method 0.
+const (
+	arcMark      = 0x1a
+	arcNameSize  = 13
+	arcMaxMethod = 9
+
+	arcStoredOld = 1 // Stored, with an older header lacking the size.
+	arcStored    = 2
+	arcPacked    = 3 // Run-length encoded.
+	arcSqueezed  = 4 // Huffman coded, after RLE.
+	arcCrunched  = 8 // LZW with up to 12 bits, after RLE.
+	arcSquashed  = 9 // LZW with up to 13 bits.
+)
+
+// rawArcHeader is an ARC member header, following the mark and
+// method bytes.  Headers of method 1 lack Size.
+type rawArcHeader struct {
+	Name       [arcNameSize]byte
+	PackedSize uint32
+	Date       uint16
+	Time       uint16
+	CRC        uint16
+	Size       uint32
+}
+
+// ErrCorrupt is returned when a bundle's contents do not decode.
+var ErrCorrupt = errors.New("corrupt bundle")
+
+// isArc reports whether head plausibly starts an ARC archive.
+func isArc(head []byte) bool {
+	if len(head) < 2 || head[0] != arcMark || head[1] > arcMaxMethod {
+		return false
+	}
+	if head[1] == 0 {
+		return len(head) == 2
+	}
+	if len(head) < 2+arcNameSize {
+		return false
+	}
+	name := head[2 : 2+arcNameSize]
+	end := bytes.IndexByte(name, 0)
+	if end <= 0 {
+		return false
+	}
+	for _, c := range name[:end] {
+		if c <= ' ' || c >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// unpackArc unpacks an ARC archive.
+func unpackArc(r io.Reader, fn Handler) error {
+	br := bufio.NewReader(r)
+	for {
+		var mark [2]byte
+		if _, err := io.ReadFull(br, mark[:]); err != nil {
+			return fmt.Errorf("arc: %w: %v", ErrCorrupt, err)
+		}
+		if mark[0] != arcMark {
+			return fmt.Errorf("arc: %w: bad header mark %#x", ErrCorrupt, mark[0])
+		}
+		method := mark[1]
+		if method == 0 {
+			return nil
+		}
+		var h rawArcHeader
+		size := binary.Size(h)
+		if method == arcStoredOld {
+			size -= 4
+		}
+		raw := make([]byte, binary.Size(h))
+		if _, err := io.ReadFull(br, raw[:size]); err != nil {
+			return fmt.Errorf("arc: %w: short header: %v", ErrCorrupt, err)
+		}
+		binary.Read(bytes.NewReader(raw), binary.LittleEndian, &h)
+		if method == arcStoredOld {
+			h.Size = h.PackedSize
+		}
+		name := string(h.Name[:])
+		if i := bytes.IndexByte(h.Name[:], 0); i >= 0 {
+			name = name[:i]
+		}
+		if err := checkMember(uint64(h.Size), uint64(h.PackedSize)); err != nil {
+			return fmt.Errorf("arc: %s: %w", name, err)
+		}
+		packed := &countReader{r: io.LimitReader(br, int64(h.PackedSize))}
+		var data io.Reader
+		switch method {
+		case arcStoredOld, arcStored:
+			data = packed
+		case arcPacked:
+			data = newRLE90(bufio.NewReader(packed))
+		case arcSqueezed:
+			data = newRLE90(newUnsqueezer(bufio.NewReader(packed)))
+		case arcCrunched:
+			// Crunched data starts with its maximum code size.
+			var bits [1]byte
+			if _, err := io.ReadFull(packed, bits[:]); err != nil || bits[0] != 12 {
+				return fmt.Errorf("arc: %s: %w: crunched with %d bits", name, ErrCorrupt, bits[0])
+			}
+			data = newRLE90(bufio.NewReader(newLZW(packed, 12)))
+		case arcSquashed:
+			data = newLZW(packed, 13)
+		default:
+			return fmt.Errorf("arc: %s: %w: method %d", name, ErrUnsupported, method)
+		}
+		data = &ratioReader{r: data, packed: packed}
+		check := &crcReader{r: io.LimitReader(data, int64(h.Size))}
+		if err := fn(baseName(name), check); err != nil {
+			return err
+		}
+		if _, err := io.Copy(io.Discard, check); err != nil {
+			return fmt.Errorf("arc: %s: %w: %v", name, ErrCorrupt, err)
+		}
+		if check.n != int64(h.Size) || check.crc != h.CRC {
+			return fmt.Errorf("arc: %s: %w: bad size or CRC", name, ErrCorrupt)
+		}
+		if _, err := io.Copy(io.Discard, packed); err != nil {
+			return fmt.Errorf("arc: %s: %w: %v", name, ErrCorrupt, err)
+		}
+	}
+}
+
+// crc16 updates a CRC-16/ARC, as used by both ARC and LHA.
+func crc16(crc uint16, p []byte) uint16 {
+	for _, b := range p {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// crcReader counts and checksums what is read through it.
+type crcReader struct {
+	r   io.Reader
+	n   int64
+	crc uint16
+}
+
+func (c *crcReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	c.crc = crc16(c.crc, p[:n])
+	return n, err
+}
+
+// rle90 undoes ARC's run-length encoding, in which 0x90 followed
+// by a count repeats the previous byte to make a run of that
+// length, and 0x90 followed by 0 is a literal 0x90.
+type rle90 struct {
+	r      io.ByteReader
+	last   byte
+	repeat int
+	err    error
+}
+
+const rleMark = 0x90
+
+func newRLE90(r io.ByteReader) *rle90 {
+	return &rle90{r: r}
+}
+
+func (d *rle90) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if d.repeat > 0 {
+			p[n] = d.last
+			n++
+			d.repeat--
+			continue
+		}
+		if d.err != nil {
+			break
+		}
+		c, err := d.r.ReadByte()
+		if err != nil {
+			d.err = err
+			break
+		}
+		if c != rleMark {
+			d.last = c
+			p[n] = c
+			n++
+			continue
+		}
+		count, err := d.r.ReadByte()
+		if err != nil {
+			d.err = io.ErrUnexpectedEOF
+			break
+		}
+		if count == 0 {
+			p[n] = rleMark
+			n++
+			continue
+		}
+		d.repeat = int(count) - 1
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, d.err
+}
+
+// unsqueezer decodes ARC's squeezed data: a Huffman tree stored
+// as a count of nodes, each a pair of children, followed by codes
+// read least significant bit first.  A child that is negative is
+// a leaf holding the value -(child+1); the value 256 ends the
+// data.
+type unsqueezer struct {
+	r     io.ByteReader
+	nodes [][2]int16
+	bits  byte
+	nbits int
+	err   error
+}
+
+const (
+	squeezeEOF      = 256
+	squeezeMaxNodes = 256
+)
+
+func newUnsqueezer(r io.ByteReader) *unsqueezer {
+	return &unsqueezer{r: r}
+}
+
+func (u *unsqueezer) readTree() error {
+	var raw [2]byte
+	if err := readBytes(u.r, raw[:]); err != nil {
+		return err
+	}
+	n := int(binary.LittleEndian.Uint16(raw[:]))
+	if n > squeezeMaxNodes {
+		return fmt.Errorf("%w: squeezed with %d nodes", ErrCorrupt, n)
+	}
+	if n == 0 {
+		// An empty tree encodes only the end of the data.
+		u.nodes = [][2]int16{{-(squeezeEOF + 1), -(squeezeEOF + 1)}}
+		return nil
+	}
+	u.nodes = make([][2]int16, n)
+	for i := range u.nodes {
+		var node [4]byte
+		if err := readBytes(u.r, node[:]); err != nil {
+			return err
+		}
+		for j := range u.nodes[i] {
+			child := int16(binary.LittleEndian.Uint16(node[2*j:]))
+			if child >= int16(n) || child < -(squeezeEOF+1) {
+				return fmt.Errorf("%w: squeezed node %d out of range", ErrCorrupt, child)
+			}
+			u.nodes[i][j] = child
+		}
+	}
+	return nil
+}
+
+func (u *unsqueezer) ReadByte() (byte, error) {
+	if u.err != nil {
+		return 0, u.err
+	}
+	if u.nodes == nil {
+		if u.err = u.readTree(); u.err != nil {
+			return 0, u.err
+		}
+	}
+	node := int16(0)
+	for steps := 0; node >= 0; steps++ {
+		if steps > len(u.nodes) {
+			u.err = fmt.Errorf("%w: squeezed tree has a cycle", ErrCorrupt)
+			return 0, u.err
+		}
+		if u.nbits == 0 {
+			b, err := u.r.ReadByte()
+			if err != nil {
+				u.err = io.ErrUnexpectedEOF
+				return 0, u.err
+			}
+			u.bits, u.nbits = b, 8
+		}
+		node = u.nodes[node][u.bits&1]
+		u.bits >>= 1
+		u.nbits--
+	}
+	if value := -(int(node) + 1); value != squeezeEOF {
+		return byte(value), nil
+	}
+	u.err = io.EOF
+	return 0, u.err
+}
+
+func readBytes(r io.ByteReader, p []byte) error {
+	for i := range p {
+		b, err := r.ReadByte()
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		p[i] = b
+	}
+	return nil
+}
+
+// lzw decodes the adaptive LZW of Unix compress, as used by ARC's
+// crunching and squashing.  Codes start at 9 bits and grow to the
+// maximum as the table fills; code 256 clears the table.  Codes
+// are packed least significant bit first in groups of eight, and
+// a group is abandoned when the code size changes.
+type lzw struct {
+	r       io.Reader
+	maxBits int
+
+	buf    [16]byte // One group of codes.
+	size   int      // Bits in buf that may hold a whole code.
+	offset int      // Bit offset of the next code in buf.
+
+	bits    int
+	maxCode int
+	free    int
+	clear   bool
+	prefix  []uint16
+	suffix  []byte
+	first   bool
+	old     int
+	final   byte
+	stack   []byte
+	err     error
+}
+
+const (
+	lzwInitBits = 9
+	lzwClear    = 256
+	lzwFirst    = 257
+)
+
+func newLZW(r io.Reader, maxBits int) *lzw {
+	return &lzw{
+		r:       r,
+		maxBits: maxBits,
+		bits:    lzwInitBits,
+		maxCode: 1<<lzwInitBits - 1,
+		free:    lzwFirst,
+		prefix:  make([]uint16, 1<<maxBits),
+		suffix:  make([]byte, 1<<maxBits),
+		first:   true,
+	}
+}
+
+// code returns the next code, or -1 at the end of the data.
+func (d *lzw) code() (int, error) {
+	if d.clear || d.offset >= d.size || d.free > d.maxCode {
+		if d.free > d.maxCode {
+			d.bits++
+			if d.bits == d.maxBits {
+				d.maxCode = 1 << d.maxBits
+			} else {
+				d.maxCode = 1<<d.bits - 1
+			}
+		}
+		if d.clear {
+			d.bits = lzwInitBits
+			d.maxCode = 1<<d.bits - 1
+			d.clear = false
+		}
+		n, err := io.ReadFull(d.r, d.buf[:d.bits])
+		if n == 0 {
+			if err == io.EOF {
+				return -1, nil
+			}
+			return -1, err
+		}
+		d.offset = 0
+		d.size = n*8 - (d.bits - 1)
+	}
+	code := 0
+	for i := 0; i < d.bits; i++ {
+		bit := d.offset + i
+		code |= int(d.buf[bit/8]>>(bit%8)&1) << i
+	}
+	d.offset += d.bits
+	return code, nil
+}
+
+func (d *lzw) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(d.stack) > 0 {
+			c := copy(p[n:], d.stack)
+			d.stack = d.stack[c:]
+			n += c
+			continue
+		}
+		if d.err != nil {
+			break
+		}
+		d.err = d.next()
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, d.err
+}
+
+// next decodes one code onto the stack.
+func (d *lzw) next() error {
+	code, err := d.code()
+	if err != nil {
+		return err
+	}
+	if code < 0 {
+		return io.EOF
+	}
+	if d.first {
+		if code > 255 {
+			return fmt.Errorf("%w: bad initial LZW code %d", ErrCorrupt, code)
+		}
+		d.first = false
+		d.old, d.final = code, byte(code)
+		d.stack = []byte{d.final}
+		return nil
+	}
+	if code == lzwClear {
+		d.clear = true
+		d.free = lzwFirst - 1
+		if code, err = d.code(); err != nil {
+			return err
+		}
+		if code < 0 {
+			return io.EOF
+		}
+	}
+	in := code
+	var out []byte
+	if code >= d.free {
+		if code > d.free {
+			return fmt.Errorf("%w: LZW code %d out of range", ErrCorrupt, code)
+		}
+		out = append(out, d.final)
+		code = d.old
+	}
+	for code >= 256 {
+		if len(out) >= len(d.suffix) {
+			return fmt.Errorf("%w: LZW string loops", ErrCorrupt)
+		}
+		out = append(out, d.suffix[code])
+		code = int(d.prefix[code])
+	}
+	d.final = byte(code)
+	out = append(out, d.final)
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	d.stack = out
+	if d.free < 1<<d.maxBits {
+		d.prefix[d.free] = uint16(d.old)
+		d.suffix[d.free] = d.final
+		d.free++
+	}
+	d.old = in
+	return nil
+}
@@ -0,0 +1,320 @@
+package bundle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// rle90Encode run-length encodes data as ARC does.
+func rle90Encode(data []byte) []byte {
+	var out []byte
+	for i := 0; i < len(data); {
+		c := data[i]
+		if c == rleMark {
+			out = append(out, rleMark, 0)
+			i++
+			continue
+		}
+		run := 1
+		for i+run < len(data) && data[i+run] == c && run < 255 {
+			run++
+		}
+		if run < 3 {
+			out = append(out, c)
+			i++
+			continue
+		}
+		out = append(out, c, rleMark, byte(run))
+		i += run
+	}
+	return out
+}
+
+// squeeze Huffman codes data as ARC does.
+func squeeze(data []byte) []byte {
+	type node struct {
+		weight int
+		value  int
+		kids   [2]*node
+	}
+	freq := make([]int, squeezeEOF+1)
+	for _, b := range data {
+		freq[b]++
+	}
+	freq[squeezeEOF] = 1
+	var queue []*node
+	for v, f := range freq {
+		if f > 0 {
+			queue = append(queue, &node{weight: f, value: v})
+		}
+	}
+	for len(queue) > 1 {
+		sort.SliceStable(queue, func(i, j int) bool { return queue[i].weight < queue[j].weight })
+		a, b := queue[0], queue[1]
+		queue = append(queue[2:], &node{weight: a.weight + b.weight, kids: [2]*node{a, b}})
+	}
+	root := queue[0]
+	if root.kids[0] == nil {
+		root = &node{kids: [2]*node{root, root}}
+	}
+	// Number the internal nodes breadth first, root first.
+	index := map[*node]int{}
+	var order []*node
+	for q := []*node{root}; len(q) > 0; q = q[1:] {
+		n := q[0]
+		if n.kids[0] == nil {
+			continue
+		}
+		index[n] = len(order)
+		order = append(order, n)
+		q = append(q, n.kids[0], n.kids[1])
+	}
+	codes := map[int][]int{}
+	var walk func(*node, []int)
+	walk = func(n *node, path []int) {
+		if n.kids[0] == nil {
+			if _, ok := codes[n.value]; !ok {
+				codes[n.value] = append([]int(nil), path...)
+			}
+			return
+		}
+		walk(n.kids[0], append(path, 0))
+		walk(n.kids[1], append(path, 1))
+	}
+	walk(root, nil)
+	out := binary.LittleEndian.AppendUint16(nil, uint16(len(order)))
+	for _, n := range order {
+		for _, k := range n.kids {
+			child := -(k.value + 1)
+			if k.kids[0] != nil {
+				child = index[k]
+			}
+			out = binary.LittleEndian.AppendUint16(out, uint16(int16(child)))
+		}
+	}
+	var cur byte
+	nbits := 0
+	emit := func(v int) {
+		for _, bit := range codes[v] {
+			cur |= byte(bit) << nbits
+			if nbits++; nbits == 8 {
+				out = append(out, cur)
+				cur, nbits = 0, 0
+			}
+		}
+	}
+	for _, b := range data {
+		emit(int(b))
+	}
+	emit(squeezeEOF)
+	if nbits > 0 {
+		out = append(out, cur)
+	}
+	return out
+}
+
+// compressLZW codes data as Unix compress does, clearing the
+// table whenever it fills.
+func compressLZW(data []byte, maxBits int) []byte {
+	var out []byte
+	bits, maxCode, maxMax := lzwInitBits, 1<<lzwInitBits-1, 1<<maxBits
+	var group []int
+	flush := func(whole bool) {
+		buf := make([]byte, bits)
+		bit := 0
+		for _, c := range group {
+			for i := 0; i < bits; i++ {
+				buf[bit/8] |= byte(c>>i&1) << (bit % 8)
+				bit++
+			}
+		}
+		if !whole {
+			buf = buf[:(bit+7)/8]
+		}
+		out = append(out, buf...)
+		group = group[:0]
+	}
+	free, clear := lzwFirst, false
+	output := func(code int) {
+		group = append(group, code)
+		if len(group) == 8 {
+			flush(true)
+		}
+		if free > maxCode || clear {
+			if len(group) > 0 {
+				flush(true)
+			}
+			if clear {
+				bits, maxCode, clear = lzwInitBits, 1<<lzwInitBits-1, false
+			} else if bits++; bits == maxBits {
+				maxCode = maxMax
+			} else {
+				maxCode = 1<<bits - 1
+			}
+		}
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	table := map[[2]int]int{}
+	ent := int(data[0])
+	for _, c := range data[1:] {
+		if code, ok := table[[2]int{ent, int(c)}]; ok {
+			ent = code
+			continue
+		}
+		output(ent)
+		if free < maxMax {
+			table[[2]int{ent, int(c)}] = free
+			free++
+		} else {
+			table = map[[2]int]int{}
+			free, clear = lzwFirst, true
+			output(lzwClear)
+		}
+		ent = int(c)
+	}
+	output(ent)
+	if len(group) > 0 {
+		flush(false)
+	}
+	return out
+}
+
+// arcMember returns an ARC member header and data.
+func arcMember(name string, method byte, data, packed []byte) []byte {
+	out := []byte{arcMark, method}
+	var raw [arcNameSize]byte
+	copy(raw[:], name)
+	out = append(out, raw[:]...)
+	le := binary.LittleEndian
+	out = le.AppendUint32(out, uint32(len(packed)))
+	out = le.AppendUint16(out, 0x5a21)
+	out = le.AppendUint16(out, 0x6000)
+	out = le.AppendUint16(out, crc16(0, data))
+	if method != arcStoredOld {
+		out = le.AppendUint32(out, uint32(len(data)))
+	}
+	return append(out, packed...)
+}
+
+func arcEnd() []byte {
+	return []byte{arcMark, 0}
+}
+
+// sampleData returns text with runs, 0x90s and enough variety to
+// grow, and in time clear, an LZW table.
+func sampleData(n int) []byte {
+	rng := rand.New(rand.NewSource(1))
+	words := []string{"AREA:FIDOTEST\r", "\x01MSGID: 1:387/108 ", "\x90", "SEEN-BY: 387/1 108\r", "========", " * Origin: "}
+	var b bytes.Buffer
+	for b.Len() < n {
+		if rng.Intn(4) == 0 {
+			b.WriteByte(byte(rng.Intn(256)))
+			continue
+		}
+		b.WriteString(words[rng.Intn(len(words))])
+	}
+	return b.Bytes()[:n]
+}
+
+type unpacked struct {
+	name string
+	data []byte
+}
+
+func collect(files *[]unpacked) Handler {
+	return func(name string, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		*files = append(*files, unpacked{name, data})
+		return err
+	}
+}
+
+func TestUnpackArc(t *testing.T) {
+	data := sampleData(200000)
+	small := []byte("Hello, \x90world!!!!!!!\r\n")
+	var archive []byte
+	var want []unpacked
+	add := func(name string, method byte, data, packed []byte) {
+		archive = append(archive, arcMember(name, method, data, packed)...)
+		want = append(want, unpacked{name, data})
+	}
+	for _, d := range [][]byte{small, data, nil} {
+		add("OLD.PKT", arcStoredOld, d, d)
+		add("STORED.PKT", arcStored, d, d)
+		add("PACKED.PKT", arcPacked, d, rle90Encode(d))
+		add("SQUEEZED.PKT", arcSqueezed, d, squeeze(rle90Encode(d)))
+		add("CRUNCHED.PKT", arcCrunched, d, append([]byte{12}, compressLZW(rle90Encode(d), 12)...))
+		add("SQUASHED.PKT", arcSquashed, d, compressLZW(d, 13))
+	}
+	archive = append(archive, arcEnd()...)
+	if !isArc(archive) || Detect(archive[:sniffSize]) != Arc {
+		t.Errorf("archive not detected as ARC")
+	}
+	var got []unpacked
+	if err := unpackArc(bytes.NewReader(archive), collect(&got)); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("unpacked %d files, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].name != want[i].name || !bytes.Equal(got[i].data, want[i].data) {
+			t.Errorf("file %d: got %s (%d bytes), want %s (%d bytes)", i, got[i].name, len(got[i].data), want[i].name, len(want[i].data))
+		}
+	}
+}
+
+func TestUnpackArcSkipped(t *testing.T) {
+	// Members the handler does not read are still checked.
+	data := sampleData(5000)
+	archive := append(arcMember("A.PKT", arcCrunched, data, append([]byte{12}, compressLZW(rle90Encode(data), 12)...)),
+		arcMember("B.PKT", arcStored, []byte("b"), []byte("b"))...)
+	archive = append(archive, arcEnd()...)
+	var names []string
+	err := unpackArc(bytes.NewReader(archive), func(name string, r io.Reader) error {
+		names = append(names, name)
+		return nil
+	})
+	if err != nil || len(names) != 2 || names[1] != "B.PKT" {
+		t.Errorf("got %v, %v", names, err)
+	}
+}
+
+func TestUnpackArcCorrupt(t *testing.T) {
+	data := []byte("some packet data")
+	member := arcMember("A.PKT", arcStored, data, data)
+	bad := append([]byte(nil), member...)
+	bad[len(bad)-1] ^= 1
+	// The size follows the mark, method, name, packed size, date,
+	// time and CRC.
+	huge := append([]byte(nil), member...)
+	binary.LittleEndian.PutUint32(huge[25:], MaxMemberSize+1)
+	packed := append([]byte(nil), member...)
+	binary.LittleEndian.PutUint32(packed[25:], 1<<20)
+	for _, tc := range []struct {
+		name    string
+		archive []byte
+		want    error
+	}{
+		{"bad CRC", append(bad, arcEnd()...), ErrCorrupt},
+		{"too large", append(huge, arcEnd()...), ErrCorrupt},
+		{"too packed", append(packed, arcEnd()...), ErrCorrupt},
+		{"truncated", member[:len(member)-4], ErrCorrupt},
+		{"no end", member, ErrCorrupt},
+		{"method", append(arcMember("A.PKT", 7, data, data), arcEnd()...), ErrUnsupported},
+		{"bad LZW", append(arcMember("A.PKT", arcSquashed, data, []byte{0xff, 0xff, 0xff}), arcEnd()...), ErrCorrupt},
+		{"bad crunch", append(arcMember("A.PKT", arcCrunched, data, []byte{13, 1}), arcEnd()...), ErrCorrupt},
+	} {
+		err := unpackArc(bytes.NewReader(tc.archive), func(string, io.Reader) error { return nil })
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
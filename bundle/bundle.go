@@ -0,0 +1,287 @@
+// Package bundle unpacks and packs mail bundles: the archives of
+// packets in which echomail ("ARCmail") travels, named like
+// `0000fff0.mo1`.
+//
+// A bundle's format is detected from its first bytes rather than
+// its name.  ZIP, ARC and LHA bundles, and bare packets, are
+// unpacked natively; other formats, such as ARJ and RAR, may be
+// unpacked by external commands.
+package bundle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"fat-dragon.org/ginko/pkt"
+)
+
+// Format is the format of a bundle.
+type Format int
+
+const (
+	Unknown Format = iota
+	Packet         // A bare packet, not archived.
+	Zip
+	Arc
+	Arj
+	Lha
+	Rar
+	SevenZip
+)
+
+var formatNames = []string{"unknown", "pkt", "zip", "arc", "arj", "lha", "rar", "7z"}
+
+func (f Format) String() string {
+	if f < 0 || int(f) >= len(formatNames) {
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+	return formatNames[f]
+}
+
+// ParseFormat returns the format with the given name, as used
+// in configuration.
+func ParseFormat(name string) (Format, error) {
+	for i, n := range formatNames {
+		if strings.EqualFold(name, n) {
+			return Format(i), nil
+		}
+	}
+	return Unknown, fmt.Errorf("unknown bundle format %q", name)
+}
+
+// sniffSize is the number of leading bytes examined by Detect.
+const sniffSize = pkt.HeaderSize
+
+// Detect returns the format of a bundle from its first bytes.
+func Detect(head []byte) Format {
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return Zip
+	case bytes.HasPrefix(head, []byte("Rar!\x1a\x07")):
+		return Rar
+	case bytes.HasPrefix(head, []byte("7z\xbc\xaf\x27\x1c")):
+		return SevenZip
+	case bytes.HasPrefix(head, []byte{0x60, 0xea}):
+		return Arj
+	case len(head) >= 7 && head[2] == '-' && head[3] == 'l' && (head[4] == 'h' || head[4] == 'z') && head[6] == '-':
+		return Lha
+	}
+	// ARC's magic is a single byte, so packets are checked first.
+	if _, err := pkt.ParseHeader(head); err == nil {
+		return Packet
+	}
+	if isArc(head) {
+		return Arc
+	}
+	return Unknown
+}
+
+// DetectFile returns the format of the named bundle.
+func DetectFile(name string) (Format, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return Unknown, err
+	}
+	defer f.Close()
+	head := make([]byte, sniffSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return Unknown, err
+	}
+	return Detect(head[:n]), nil
+}
+
+// IsMailName reports whether a file name is that of a packet or
+// an ARCmail bundle, such as `0000fff0.pkt` or `0000fff0.mo1`.
+func IsMailName(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == ".pkt" {
+		return true
+	}
+	if len(ext) != 4 || strings.IndexByte(sequence, ext[3]) < 0 {
+		return false
+	}
+	for _, day := range dayNames {
+		if ext[1:3] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler is called with each file unpacked from a bundle.  The
+// name is a base name, stripped of any directories.
+type Handler func(name string, r io.Reader) error
+
+// ErrUnsupported is returned when a bundle's format can be
+// neither unpacked natively nor by an external command.
+var ErrUnsupported = errors.New("unsupported bundle format")
+
+// Limits on what a file in a bundle may unpack to, so that a
+// small bundle cannot unpack to an unbounded amount of data.  A
+// file over them is taken to be corrupt.
+const (
+	// MaxMemberSize is the most a file may unpack to.
+	MaxMemberSize = 64 << 20
+
+	// MaxRatio is the most a file may unpack to for each byte of
+	// its packed data, beyond its first ratioSlack bytes.
+	MaxRatio   = 1000
+	ratioSlack = 64 << 10
+)
+
+// checkMember checks a file's sizes, as given in its header,
+// against the limits.
+func checkMember(size, packedSize uint64) error {
+	if size > MaxMemberSize {
+		return fmt.Errorf("%w: unpacks to %d bytes", ErrCorrupt, size)
+	}
+	if size > ratioSlack && (size-ratioSlack)/MaxRatio > packedSize {
+		return fmt.Errorf("%w: unpacks %d bytes to %d", ErrCorrupt, packedSize, size)
+	}
+	return nil
+}
+
+// countReader counts what is read from a file's packed data.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ratioReader reads a file's unpacked data, failing once it is
+// more than MaxRatio times the packed data read so far, however
+// much the header claims there is.
+type ratioReader struct {
+	r      io.Reader
+	packed *countReader
+	n      int64
+}
+
+func (r *ratioReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if r.n-ratioSlack > r.packed.n*MaxRatio {
+		return 0, fmt.Errorf("%w: unpacks %d bytes to over %d", ErrCorrupt, r.packed.n, r.n)
+	}
+	return n, err
+}
+
+// Unpacker unpacks bundles.
+type Unpacker struct {
+	// External holds commands for unpacking formats that are not
+	// supported natively, such as {"unrar", "e", "-y",
+	// "{archive}"}.  The command is run in an empty directory,
+	// whose name replaces "{dir}" in its arguments; "{archive}"
+	// is replaced by the bundle's name.  Every file it leaves in
+	// the directory is taken to have been unpacked.
+	External map[Format][]string
+}
+
+// Unpack unpacks the named bundle, calling fn for each file in
+// it.  A bare packet is passed to fn as it is.
+func (u *Unpacker) Unpack(name string, fn Handler) error {
+	format, err := DetectFile(name)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case Packet:
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return fn(filepath.Base(name), f)
+	case Zip:
+		return unpackZip(name, fn)
+	case Arc:
+		return unpackFile(name, fn, unpackArc)
+	case Lha:
+		return unpackFile(name, fn, unpackLha)
+	}
+	if command := u.External[format]; len(command) > 0 {
+		return unpackExternal(name, command, fn)
+	}
+	return fmt.Errorf("%s: %w: %v", name, ErrUnsupported, format)
+}
+
+// unpackFile opens a file for a streaming unpacker.
+func unpackFile(name string, fn Handler, unpack func(io.Reader, Handler) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := unpack(f, fn); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// baseName strips the directories from a name in an archive,
+// which may use either kind of slash.
+func baseName(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// unpackExternal unpacks a bundle with an external command.
+func unpackExternal(name string, command []string, fn Handler) error {
+	archive, err := filepath.Abs(name)
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "bundle")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	args := make([]string, len(command))
+	for i, arg := range command {
+		arg = strings.ReplaceAll(arg, "{archive}", archive)
+		args[i] = strings.ReplaceAll(arg, "{dir}", dir)
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s: %v: %s", name, args[0], err, bytes.TrimSpace(output))
+	}
+	var files []string
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		err = fn(filepath.Base(file), f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
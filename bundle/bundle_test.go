@@ -0,0 +1,195 @@
+package bundle
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"fat-dragon.org/ginko/ftn/ftntest"
+	"fat-dragon.org/ginko/pkt"
+)
+
+func testPacket(t *testing.T) []byte {
+	var b bytes.Buffer
+	w, err := pkt.NewWriter(&b, &pkt.Header{
+		Origin: ftntest.Address(t, "1:387/26"),
+		Dest:   ftntest.Address(t, "1:387/1"),
+		Time:   time.Date(2023, time.January, 2, 3, 4, 5, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestDetect(t *testing.T) {
+	packet := testPacket(t)
+	var zipped bytes.Buffer
+	if err := PackZip(&zipped, []File{{"a.pkt", time.Now(), packet}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		head []byte
+		want Format
+	}{
+		// Node 26 starts the packet with ARC's mark.
+		{"packet", packet, Packet},
+		{"zip", zipped.Bytes(), Zip},
+		{"empty zip", []byte("PK\x05\x06\x00\x00"), Zip},
+		{"arc", arcMember("A.PKT", arcStored, nil, nil), Arc},
+		{"empty arc", arcEnd(), Arc},
+		{"lha", lhaMember(0, "A.PKT", "-lh5-", nil, nil), Lha},
+		{"arj", []byte{0x60, 0xea, 0x29, 0x00}, Arj},
+		{"rar", []byte("Rar!\x1a\x07\x01\x00"), Rar},
+		{"7z", []byte("7z\xbc\xaf\x27\x1c\x00\x04"), SevenZip},
+		{"text", []byte("hello, world"), Unknown},
+		{"arc mark", []byte{arcMark, arcStored, 0, 'A'}, Unknown},
+		{"empty", nil, Unknown},
+	} {
+		head := tc.head
+		if len(head) > sniffSize {
+			head = head[:sniffSize]
+		}
+		if got := Detect(head); got != tc.want {
+			t.Errorf("%s: detected %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, f := range []Format{Packet, Zip, Arc, Arj, Lha, Rar, SevenZip} {
+		if got, err := ParseFormat(f.String()); err != nil || got != f {
+			t.Errorf("ParseFormat(%q) = %v, %v", f.String(), got, err)
+		}
+	}
+	if _, err := ParseFormat("tar"); err == nil {
+		t.Error("ParseFormat(tar) succeeded")
+	}
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, data, 0660); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestUnpack(t *testing.T) {
+	packet := testPacket(t)
+	var zipped bytes.Buffer
+	err := PackZip(&zipped, []File{
+		{"0000006b.pkt", time.Now(), packet},
+		{"sub/../../evil.pkt", time.Now(), []byte("evil")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lha := append(lhaMember(2, "a.pkt", "-lh5-", packet, lhEncode(packet, lhaMethods["-lh5-"], 100)), 0)
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want []unpacked
+	}{
+		{"0000006b.pkt", packet, []unpacked{{"0000006b.pkt", packet}}},
+		{"0000006b.mo0", zipped.Bytes(), []unpacked{{"0000006b.pkt", packet}, {"evil.pkt", []byte("evil")}}},
+		{"0000006b.tu1", lha, []unpacked{{"a.pkt", packet}}},
+	} {
+		var got []unpacked
+		u := &Unpacker{}
+		if err := u.Unpack(writeFile(t, tc.name, tc.data), collect(&got)); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: unpacked %d files, want %d", tc.name, len(got), len(tc.want))
+			continue
+		}
+		for i, want := range tc.want {
+			if got[i].name != want.name || !bytes.Equal(got[i].data, want.data) {
+				t.Errorf("%s: file %d is %s, want %s", tc.name, i, got[i].name, want.name)
+			}
+		}
+	}
+}
+
+func TestUnpackExternal(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no shell")
+	}
+	bundle := writeFile(t, "0000006b.we2", []byte("Rar!\x1a\x07\x00payload"))
+	u := &Unpacker{}
+	if err := u.Unpack(bundle, collect(new([]unpacked))); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("got %v, want ErrUnsupported", err)
+	}
+	u.External = map[Format][]string{
+		Rar: {"/bin/sh", "-c", `mkdir sub && cp "$1" sub/x.pkt && cp "$1" {dir}/a.pkt`, "sh", "{archive}"},
+	}
+	var got []unpacked
+	if err := u.Unpack(bundle, collect(&got)); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].name != "a.pkt" || got[1].name != "x.pkt" || string(got[1].data) != "Rar!\x1a\x07\x00payload" {
+		t.Errorf("unpacked %v", got)
+	}
+	u.External[Rar] = []string{"/bin/sh", "-c", "echo broken; exit 1"}
+	if err := u.Unpack(bundle, collect(new([]unpacked))); err == nil {
+		t.Error("failing command succeeded")
+	}
+}
+
+func TestName(t *testing.T) {
+	for _, tc := range []struct {
+		from, to string
+		day      time.Weekday
+		seq      int
+		want     string
+	}{
+		{"1:387/108", "1:387/1", time.Monday, 0, "0000006b.mo0"},
+		{"1:387/1", "1:387/108", time.Sunday, 10, "0000ff95.sua"},
+		{"2:5020/1", "2:5030/2", time.Saturday, 35, "fff6ffff.saz"},
+		{"1:387/108", "1:387/108.7", time.Wednesday, 1, "0000fff9.we1"},
+	} {
+		if got := Name(ftntest.Address(t, tc.from), ftntest.Address(t, tc.to), tc.day, tc.seq); got != tc.want {
+			t.Errorf("Name(%s, %s, %v, %d) = %s, want %s", tc.from, tc.to, tc.day, tc.seq, got, tc.want)
+		}
+	}
+}
+
+func TestNextName(t *testing.T) {
+	from, to := ftntest.Address(t, "1:387/108"), ftntest.Address(t, "1:387/1")
+	tuesday := time.Date(2023, time.January, 3, 12, 0, 0, 0, time.UTC)
+	taken := map[string]bool{"0000006b.tu0": true, "0000006b.tu1": true}
+	name, err := NextName(from, to, tuesday, func(name string) bool { return taken[name] })
+	if err != nil || name != "0000006b.tu2" {
+		t.Errorf("got %q, %v, want 0000006b.tu2", name, err)
+	}
+	if _, err := NextName(from, to, tuesday, func(string) bool { return true }); err == nil {
+		t.Error("NextName succeeded with every name taken")
+	}
+}
+
+func TestIsMailName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"0000006b.pkt": true,
+		"0000006B.PKT": true,
+		"0000006b.mo0": true,
+		"0000006b.SUZ": true,
+		"0000006b.fr!": false,
+		"0000006b.xx1": false,
+		"nodelist.zip": false,
+		"fidotest.tic": false,
+		"pkt":          false,
+	} {
+		if got := IsMailName(name); got != want {
+			t.Errorf("IsMailName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
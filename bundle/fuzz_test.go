@@ -0,0 +1,27 @@
+package bundle
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func FuzzUnpack(f *testing.F) {
+	data := sampleData(500)
+	f.Add(append(arcMember("A.PKT", arcSqueezed, data, squeeze(rle90Encode(data))), arcEnd()...))
+	f.Add(append(arcMember("A.PKT", arcCrunched, data, append([]byte{12}, compressLZW(rle90Encode(data), 12)...)), arcEnd()...))
+	f.Add(append(arcMember("A.PKT", arcSquashed, data, compressLZW(data, 13)), arcEnd()...))
+	f.Add(append(lhaMember(1, "A.PKT", "-lh5-", data, lhEncode(data, lhaMethods["-lh5-"], 100)), 0))
+	f.Add(append(lhaMember(2, "A.PKT", "-lh7-", data, lhEncode(data, lhaMethods["-lh7-"], 100)), 0))
+	f.Add(append(lhaMember(2, "A.PKT", "-lh5-", bytes.Repeat([]byte(" "), 1<<16), spaceBlocks(1, 1<<16-1, lhaMethods["-lh5-"])), 0))
+	f.Fuzz(func(t *testing.T, archive []byte) {
+		// Whatever the input, unpacking must end without
+		// unpacking more than the headers claim.
+		fn := func(name string, r io.Reader) error {
+			_, err := io.Copy(io.Discard, r)
+			return err
+		}
+		unpackArc(bytes.NewReader(archive), fn)
+		unpackLha(bytes.NewReader(archive), fn)
+	})
+}
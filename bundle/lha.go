@@ -0,0 +1,476 @@
+package bundle
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// LHA archives are a sequence of members, each a header followed
+// by its data, ended by a zero byte.  Headers come in several
+// levels, of which 0, 1 and 2 are common; all start with the
+// method at offset 2 and the level at offset 20.
+const (
+	lhaCommonSize = 21
+	lhaExtName    = 0x01
+)
+
+// lhaMethod describes an LHA compression method.
+type lhaMethod struct {
+	stored   bool
+	dictBits uint // Size of the sliding dictionary.
+	np       int  // Number of offset codes.
+	pbit     uint // Bits in the count of offset code lengths.
+}
+
+var lhaMethods = map[string]lhaMethod{
+	"-lh0-": {true, 0, 0, 0},
+	"-lz4-": {true, 0, 0, 0},
+	"-lh4-": {false, 12, 14, 4},
+	"-lh5-": {false, 13, 14, 4},
+	"-lh6-": {false, 15, 16, 5},
+	"-lh7-": {false, 16, 17, 5},
+}
+
+// lhaHeader is what matters of an LHA member header.
+type lhaHeader struct {
+	method     string
+	name       string
+	packedSize int64
+	size       int64
+	crc        uint16
+}
+
+// readLhaHeader reads a member header, returning nil at the end
+// of the archive.
+func readLhaHeader(r *bufio.Reader) (*lhaHeader, error) {
+	first, err := r.Peek(1)
+	if err == io.EOF || err == nil && first[0] == 0 {
+		return nil, nil
+	}
+	raw := make([]byte, lhaCommonSize)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, fmt.Errorf("lha: %w: short header: %v", ErrCorrupt, err)
+	}
+	le := binary.LittleEndian
+	h := &lhaHeader{
+		method:     string(raw[2:7]),
+		packedSize: int64(le.Uint32(raw[7:])),
+		size:       int64(le.Uint32(raw[11:])),
+	}
+	var size int
+	switch level := raw[20]; level {
+	case 0, 1:
+		size = int(raw[0]) + 2
+	case 2:
+		size = int(le.Uint16(raw[0:]))
+	default:
+		return nil, fmt.Errorf("lha: %w: header level %d", ErrUnsupported, level)
+	}
+	if size < lhaCommonSize+3 {
+		return nil, fmt.Errorf("lha: %w: header size %d", ErrCorrupt, size)
+	}
+	raw = append(raw, make([]byte, size-lhaCommonSize)...)
+	if _, err := io.ReadFull(r, raw[lhaCommonSize:]); err != nil {
+		return nil, fmt.Errorf("lha: %w: short header: %v", ErrCorrupt, err)
+	}
+	var next int // Size of the first extended header.
+	switch raw[20] {
+	case 0, 1:
+		var sum byte
+		for _, b := range raw[2:] {
+			sum += b
+		}
+		if sum != raw[1] {
+			return nil, fmt.Errorf("lha: %w: bad header checksum", ErrCorrupt)
+		}
+		n := int(raw[21])
+		if 22+n+2 > size {
+			return nil, fmt.Errorf("lha: %w: name overruns header", ErrCorrupt)
+		}
+		h.name = string(raw[22 : 22+n])
+		h.crc = le.Uint16(raw[22+n:])
+		if raw[20] == 1 {
+			if 22+n+5 > size {
+				return nil, fmt.Errorf("lha: %w: short level 1 header", ErrCorrupt)
+			}
+			next = int(le.Uint16(raw[size-2:]))
+		}
+	case 2:
+		if size < 26 {
+			return nil, fmt.Errorf("lha: %w: short level 2 header", ErrCorrupt)
+		}
+		h.crc = le.Uint16(raw[21:])
+		next = int(le.Uint16(raw[24:]))
+	}
+	// Level 1 extended headers follow the header and are counted
+	// in the packed size; level 2 ones are within the header.
+	ext := raw[size:]
+	if raw[20] == 2 {
+		ext = raw[26:]
+	}
+	for next != 0 {
+		if next < 3 {
+			return nil, fmt.Errorf("lha: %w: extended header size %d", ErrCorrupt, next)
+		}
+		var e []byte
+		if raw[20] == 1 {
+			e = make([]byte, next)
+			if _, err := io.ReadFull(r, e); err != nil {
+				return nil, fmt.Errorf("lha: %w: short extended header: %v", ErrCorrupt, err)
+			}
+			h.packedSize -= int64(next)
+		} else {
+			if next > len(ext) {
+				return nil, fmt.Errorf("lha: %w: extended header overruns header", ErrCorrupt)
+			}
+			e, ext = ext[:next], ext[next:]
+		}
+		if e[0] == lhaExtName {
+			h.name = string(e[1 : next-2])
+		}
+		next = int(le.Uint16(e[next-2:]))
+	}
+	if h.packedSize < 0 {
+		return nil, fmt.Errorf("lha: %w: negative packed size", ErrCorrupt)
+	}
+	return h, nil
+}
+
+// unpackLha unpacks an LHA archive.
+func unpackLha(r io.Reader, fn Handler) error {
+	br := bufio.NewReader(r)
+	for {
+		h, err := readLhaHeader(br)
+		if err != nil || h == nil {
+			return err
+		}
+		packed := io.LimitReader(br, h.packedSize)
+		m, ok := lhaMethods[h.method]
+		switch {
+		case h.method == "-lhd-":
+			// A directory.
+		case !ok:
+			return fmt.Errorf("lha: %s: %w: method %q", h.name, ErrUnsupported, h.method)
+		default:
+			if err := checkMember(uint64(h.size), uint64(h.packedSize)); err != nil {
+				return fmt.Errorf("lha: %s: %w", h.name, err)
+			}
+			in := &countReader{r: packed}
+			var data io.Reader = in
+			if !m.stored {
+				data = newLhDecoder(bufio.NewReader(in), m, h.size, h.packedSize)
+			}
+			data = &ratioReader{r: data, packed: in}
+			check := &crcReader{r: io.LimitReader(data, h.size)}
+			if err := fn(baseName(strings.ReplaceAll(h.name, "\xff", "/")), check); err != nil {
+				return err
+			}
+			if _, err := io.Copy(io.Discard, check); err != nil {
+				return fmt.Errorf("lha: %s: %w: %v", h.name, ErrCorrupt, err)
+			}
+			if check.n != h.size || check.crc != h.crc {
+				return fmt.Errorf("lha: %s: %w: bad size or CRC", h.name, ErrCorrupt)
+			}
+		}
+		if _, err := io.Copy(io.Discard, packed); err != nil {
+			return fmt.Errorf("lha: %s: %w: %v", h.name, ErrCorrupt, err)
+		}
+	}
+}
+
+// lhBits reads bits most significant first.  Past the end of its
+// input it reads zeros, as the encoder may not have written the
+// last few bits, but only a few bytes of them.
+type lhBits struct {
+	r    io.ByteReader
+	bits uint32
+	n    uint
+	pad  int
+	left int64 // Bytes left in the input.
+}
+
+const lhMaxPad = 4
+
+func (b *lhBits) read(n uint) int {
+	for b.n < n {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			c = 0
+			b.pad++
+		} else {
+			b.left--
+		}
+		b.bits = b.bits<<8 | uint32(c)
+		b.n += 8
+	}
+	b.n -= n
+	return int(b.bits>>b.n) & (1<<n - 1)
+}
+
+func (b *lhBits) overrun() bool {
+	return b.pad > lhMaxPad
+}
+
+// remaining returns the most bits that may yet be read.
+func (b *lhBits) remaining() int64 {
+	return (b.left+lhMaxPad-int64(b.pad))*8 + int64(b.n)
+}
+
+// lhHuffman is a canonical Huffman code, in which codes are
+// assigned in order of length and then of symbol.
+type lhHuffman struct {
+	count  [lhMaxCodeLen + 1]int
+	symbol []int
+	single int // The only symbol, coded in no bits, or -1.
+}
+
+const lhMaxCodeLen = 16
+
+func (h *lhHuffman) init(lengths []int) error {
+	h.single = -1
+	h.count = [lhMaxCodeLen + 1]int{}
+	h.symbol = h.symbol[:0]
+	for _, l := range lengths {
+		if l > lhMaxCodeLen {
+			return fmt.Errorf("%w: code length %d", ErrCorrupt, l)
+		}
+		h.count[l]++
+	}
+	left := 1
+	for l := 1; l <= lhMaxCodeLen; l++ {
+		left = left<<1 - h.count[l]
+		if left < 0 {
+			return fmt.Errorf("%w: oversubscribed code", ErrCorrupt)
+		}
+	}
+	for l := 1; l <= lhMaxCodeLen; l++ {
+		for sym, sl := range lengths {
+			if sl == l {
+				h.symbol = append(h.symbol, sym)
+			}
+		}
+	}
+	return nil
+}
+
+func (h *lhHuffman) initSingle(sym int) {
+	h.single = sym
+}
+
+func (h *lhHuffman) decode(b *lhBits) (int, error) {
+	if h.single >= 0 {
+		return h.single, nil
+	}
+	code, first, index := 0, 0, 0
+	for l := 1; l <= lhMaxCodeLen; l++ {
+		code |= b.read(1)
+		count := h.count[l]
+		if code-first < count {
+			return h.symbol[index+code-first], nil
+		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+	return 0, fmt.Errorf("%w: invalid code", ErrCorrupt)
+}
+
+// lhDecoder decodes LHA's -lh4- to -lh7- methods: LZ77 over a
+// sliding dictionary, with matches and literals coded in blocks,
+// each with its own Huffman codes.
+type lhDecoder struct {
+	bits   lhBits
+	m      lhaMethod
+	window []byte
+	pos    int
+	size   int64 // The size of the unpacked data.
+
+	left     int // Codes remaining in the block.
+	c, p, t  lhHuffman
+	copyLen  int
+	copyDist int
+	err      error
+}
+
+const (
+	lhNC        = 256 + 256 - 3 + 2 // Literals and match lengths.
+	lhNT        = 19                // Codes for code lengths.
+	lhTBit      = 5
+	lhCBit      = 9
+	lhThreshold = 3 // The shortest match.
+)
+
+func newLhDecoder(r io.ByteReader, m lhaMethod, size, packedSize int64) *lhDecoder {
+	d := &lhDecoder{bits: lhBits{r: r, left: packedSize}, m: m, window: make([]byte, 1<<m.dictBits), size: size}
+	for i := range d.window {
+		d.window[i] = ' '
+	}
+	return d
+}
+
+func (d *lhDecoder) Read(p []byte) (int, error) {
+	n := 0
+	mask := len(d.window) - 1
+	for n < len(p) && d.err == nil {
+		if d.copyLen > 0 {
+			c := d.window[(d.pos-d.copyDist)&mask]
+			d.window[d.pos&mask] = c
+			d.pos++
+			p[n] = c
+			n++
+			d.copyLen--
+			continue
+		}
+		d.err = d.next()
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, d.err
+}
+
+// next decodes one literal or match.
+func (d *lhDecoder) next() error {
+	if d.bits.overrun() {
+		return io.ErrUnexpectedEOF
+	}
+	if d.left == 0 {
+		if err := d.readBlock(); err != nil {
+			return err
+		}
+	}
+	d.left--
+	c, err := d.c.decode(&d.bits)
+	if err != nil {
+		return err
+	}
+	if c < 256 {
+		d.window[d.pos&(len(d.window)-1)] = byte(c)
+		d.copyLen, d.copyDist = 1, 0
+		return nil
+	}
+	j, err := d.p.decode(&d.bits)
+	if err != nil {
+		return err
+	}
+	if j > 0 {
+		j = 1<<(j-1) + d.bits.read(uint(j-1))
+	}
+	if j >= d.pos || j >= len(d.window) {
+		return fmt.Errorf("%w: match before start of data", ErrCorrupt)
+	}
+	d.copyLen, d.copyDist = c-256+lhThreshold, j+1
+	return nil
+}
+
+// readBlock reads the header of a block, giving its number of
+// codes and the codes themselves.  As every code unpacks to at
+// least a byte, a block cannot have more codes than there are
+// bytes left to unpack; nor, as every code with more than one
+// symbol takes at least a bit, more than the bits left to read.
+func (d *lhDecoder) readBlock() error {
+	d.left = d.bits.read(16)
+	if d.left == 0 || d.bits.overrun() {
+		return fmt.Errorf("%w: empty block", ErrCorrupt)
+	}
+	if int64(d.left) > d.size-int64(d.pos) {
+		return fmt.Errorf("%w: block of %d codes with %d bytes left", ErrCorrupt, d.left, d.size-int64(d.pos))
+	}
+	if err := d.readPtLen(&d.t, lhNT, lhTBit, 3); err != nil {
+		return err
+	}
+	if err := d.readCLen(); err != nil {
+		return err
+	}
+	if err := d.readPtLen(&d.p, d.m.np, d.m.pbit, -1); err != nil {
+		return err
+	}
+	if d.c.single < 0 && int64(d.left) > d.bits.remaining() {
+		return fmt.Errorf("%w: block of %d codes with %d bits left", ErrCorrupt, d.left, d.bits.remaining())
+	}
+	return nil
+}
+
+// readPtLen reads the code lengths of the code for code lengths,
+// or of the offset code.  Lengths are three bits, or seven and
+// then a one bit for each more; after the special'th length, two
+// bits count lengths of zero.
+func (d *lhDecoder) readPtLen(h *lhHuffman, nn int, nbit uint, special int) error {
+	n := d.bits.read(nbit)
+	if n == 0 {
+		c := d.bits.read(nbit)
+		if c >= nn {
+			return fmt.Errorf("%w: single code %d of %d", ErrCorrupt, c, nn)
+		}
+		h.initSingle(c)
+		return nil
+	}
+	if n > nn {
+		return fmt.Errorf("%w: %d code lengths of %d", ErrCorrupt, n, nn)
+	}
+	lengths := make([]int, nn)
+	for i := 0; i < n; {
+		l := d.bits.read(3)
+		if l == 7 {
+			for d.bits.read(1) == 1 {
+				if l++; l > lhMaxCodeLen {
+					return fmt.Errorf("%w: code length %d", ErrCorrupt, l)
+				}
+			}
+		}
+		lengths[i] = l
+		i++
+		if i == special {
+			for skip := d.bits.read(2); skip > 0 && i < nn; skip-- {
+				lengths[i] = 0
+				i++
+			}
+		}
+	}
+	return h.init(lengths)
+}
+
+// readCLen reads the code lengths of the literal and length code,
+// themselves coded with d.t, in which codes 0 to 2 are runs of
+// zeros.
+func (d *lhDecoder) readCLen() error {
+	n := d.bits.read(lhCBit)
+	if n == 0 {
+		c := d.bits.read(lhCBit)
+		if c >= lhNC {
+			return fmt.Errorf("%w: single code %d of %d", ErrCorrupt, c, lhNC)
+		}
+		d.c.initSingle(c)
+		return nil
+	}
+	if n > lhNC {
+		return fmt.Errorf("%w: %d code lengths of %d", ErrCorrupt, n, lhNC)
+	}
+	lengths := make([]int, lhNC)
+	for i := 0; i < n; {
+		c, err := d.t.decode(&d.bits)
+		if err != nil {
+			return err
+		}
+		if c > 2 {
+			lengths[i] = c - 2
+			i++
+			continue
+		}
+		run := 1
+		switch c {
+		case 1:
+			run = d.bits.read(4) + 3
+		case 2:
+			run = d.bits.read(lhCBit) + 20
+		}
+		if i+run > n {
+			return fmt.Errorf("%w: code lengths overrun", ErrCorrupt)
+		}
+		i += run
+	}
+	return d.c.init(lengths)
+}
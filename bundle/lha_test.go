@@ -0,0 +1,438 @@
+package bundle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+	"testing"
+)
+
+// lhWriter writes bits most significant first.
+type lhWriter struct {
+	out   []byte
+	cur   uint32
+	nbits uint
+}
+
+func (w *lhWriter) write(v int, n uint) {
+	for i := int(n) - 1; i >= 0; i-- {
+		w.cur = w.cur<<1 | uint32(v>>i&1)
+		if w.nbits++; w.nbits == 8 {
+			w.out = append(w.out, byte(w.cur))
+			w.cur, w.nbits = 0, 0
+		}
+	}
+}
+
+func (w *lhWriter) bytes() []byte {
+	if w.nbits > 0 {
+		w.out = append(w.out, byte(w.cur<<(8-w.nbits)))
+		w.cur, w.nbits = 0, 0
+	}
+	return w.out
+}
+
+// huffmanLengths returns code lengths for the given frequencies.
+func huffmanLengths(freq []int) []int {
+	type node struct {
+		weight int
+		syms   []int
+	}
+	lengths := make([]int, len(freq))
+	var queue []node
+	for sym, f := range freq {
+		if f > 0 {
+			queue = append(queue, node{f, []int{sym}})
+		}
+	}
+	for len(queue) > 1 {
+		sort.SliceStable(queue, func(i, j int) bool { return queue[i].weight < queue[j].weight })
+		a, b := queue[0], queue[1]
+		for _, s := range append(a.syms, b.syms...) {
+			lengths[s]++
+		}
+		queue = append(queue[2:], node{a.weight + b.weight, append(append([]int(nil), a.syms...), b.syms...)})
+	}
+	return lengths
+}
+
+// canonicalCodes assigns codes to lengths as lhHuffman expects.
+func canonicalCodes(lengths []int) []int {
+	codes := make([]int, len(lengths))
+	code := 0
+	for l := 1; l <= lhMaxCodeLen; l++ {
+		for sym, sl := range lengths {
+			if sl == l {
+				codes[sym] = code
+				code++
+			}
+		}
+		code <<= 1
+	}
+	return codes
+}
+
+// used returns the symbols with a nonzero frequency.
+func used(freq []int) []int {
+	var syms []int
+	for sym, f := range freq {
+		if f > 0 {
+			syms = append(syms, sym)
+		}
+	}
+	return syms
+}
+
+func lastNonzero(lengths []int) int {
+	n := len(lengths)
+	for n > 0 && lengths[n-1] == 0 {
+		n--
+	}
+	return n
+}
+
+// writePtLen writes code lengths as readPtLen reads them.
+func writePtLen(w *lhWriter, lengths []int, nbit uint, special int) {
+	n := lastNonzero(lengths)
+	w.write(n, nbit)
+	for i := 0; i < n; {
+		l := lengths[i]
+		if l < 7 {
+			w.write(l, 3)
+		} else {
+			w.write(7, 3)
+			for ; l > 7; l-- {
+				w.write(1, 1)
+			}
+			w.write(0, 1)
+		}
+		i++
+		if i == special {
+			skip := 0
+			for skip < 3 && i+skip < n && lengths[i+skip] == 0 {
+				skip++
+			}
+			w.write(skip, 2)
+			i += skip
+		}
+	}
+}
+
+// lhToken is a literal, or a match when length is nonzero.
+type lhToken struct {
+	literal byte
+	length  int
+	dist    int
+}
+
+func lhTokens(data []byte, dictBits uint) []lhToken {
+	var tokens []lhToken
+	for i := 0; i < len(data); {
+		best, dist := 0, 0
+		for d := 1; d <= i && d < 1<<dictBits; d++ {
+			l := 0
+			for i+l < len(data) && l < 256 && data[i+l] == data[i+l-d] {
+				l++
+			}
+			if l > best {
+				best, dist = l, d
+			}
+		}
+		if best >= lhThreshold {
+			tokens = append(tokens, lhToken{length: best, dist: dist})
+			i += best
+			continue
+		}
+		tokens = append(tokens, lhToken{literal: data[i]})
+		i++
+	}
+	return tokens
+}
+
+// offsetCode splits a match's distance into its code and extra
+// bits.
+func offsetCode(dist int) (code, extra int) {
+	j := dist - 1
+	for code = 0; j>>code > 0; code++ {
+	}
+	if code > 0 {
+		extra = j - 1<<(code-1)
+	}
+	return code, extra
+}
+
+// lhEncode codes data as -lh5- to -lh7- do, in blocks of at most
+// blockSize codes.
+func lhEncode(data []byte, m lhaMethod, blockSize int) []byte {
+	w := &lhWriter{}
+	tokens := lhTokens(data, m.dictBits)
+	for len(tokens) > 0 {
+		block := tokens
+		if len(block) > blockSize {
+			block = block[:blockSize]
+		}
+		tokens = tokens[len(block):]
+		cfreq := make([]int, lhNC)
+		pfreq := make([]int, m.np)
+		for _, t := range block {
+			if t.length == 0 {
+				cfreq[t.literal]++
+				continue
+			}
+			cfreq[256+t.length-lhThreshold]++
+			code, _ := offsetCode(t.dist)
+			pfreq[code]++
+		}
+		w.write(len(block), 16)
+		clen := huffmanLengths(cfreq)
+		if syms := used(cfreq); len(syms) == 1 {
+			w.write(0, lhTBit)
+			w.write(0, lhTBit)
+			w.write(0, lhCBit)
+			w.write(syms[0], lhCBit)
+		} else {
+			// Code the lengths, with runs of zeros.
+			type tcode struct{ sym, extra int }
+			var tcodes []tcode
+			n := lastNonzero(clen)
+			for i := 0; i < n; {
+				if clen[i] != 0 {
+					tcodes = append(tcodes, tcode{clen[i] + 2, 0})
+					i++
+					continue
+				}
+				run := 0
+				for i+run < n && clen[i+run] == 0 {
+					run++
+				}
+				i += run
+				for run > 0 {
+					switch {
+					case run <= 2:
+						tcodes = append(tcodes, tcode{0, 0})
+						run--
+					case run == 19:
+						tcodes = append(tcodes, tcode{0, 0})
+						run--
+					case run <= 18:
+						tcodes = append(tcodes, tcode{1, run - 3})
+						run = 0
+					default:
+						r := run
+						if r > 531 {
+							r = 531
+						}
+						tcodes = append(tcodes, tcode{2, r - 20})
+						run -= r
+					}
+				}
+			}
+			tfreq := make([]int, lhNT)
+			for _, tc := range tcodes {
+				tfreq[tc.sym]++
+			}
+			tlen := huffmanLengths(tfreq)
+			tcode2 := canonicalCodes(tlen)
+			if syms := used(tfreq); len(syms) == 1 {
+				w.write(0, lhTBit)
+				w.write(syms[0], lhTBit)
+			} else {
+				writePtLen(w, tlen, lhTBit, 3)
+			}
+			w.write(n, lhCBit)
+			for _, tc := range tcodes {
+				w.write(tcode2[tc.sym], uint(tlen[tc.sym]))
+				switch tc.sym {
+				case 1:
+					w.write(tc.extra, 4)
+				case 2:
+					w.write(tc.extra, lhCBit)
+				}
+			}
+		}
+		plen := huffmanLengths(pfreq)
+		if syms := used(pfreq); len(syms) <= 1 {
+			w.write(0, m.pbit)
+			w.write(append(syms, 0)[0], m.pbit)
+		} else {
+			writePtLen(w, plen, m.pbit, -1)
+		}
+		ccodes, pcodes := canonicalCodes(clen), canonicalCodes(plen)
+		for _, t := range block {
+			if t.length == 0 {
+				w.write(ccodes[t.literal], uint(clen[t.literal]))
+				continue
+			}
+			c := 256 + t.length - lhThreshold
+			w.write(ccodes[c], uint(clen[c]))
+			code, extra := offsetCode(t.dist)
+			w.write(pcodes[code], uint(plen[code]))
+			if code > 1 {
+				w.write(extra, uint(code-1))
+			}
+		}
+	}
+	return w.bytes()
+}
+
+// lhaMember returns an LHA member header and data at the given
+// header level.  Levels 1 and 2 name the file in an extended
+// header.
+func lhaMember(level int, name, method string, data, packed []byte) []byte {
+	le := binary.LittleEndian
+	common := []byte(method)
+	common = le.AppendUint32(common, uint32(len(packed)))
+	common = le.AppendUint32(common, uint32(len(data)))
+	common = le.AppendUint32(common, 0x5a216000)
+	ext := append(append([]byte{lhaExtName}, name...), 0, 0)
+	var out []byte
+	switch level {
+	case 0, 1:
+		h := append([]byte{0, 0}, common...)
+		h = append(h, 0x20, byte(level))
+		baseName := name
+		if level == 1 {
+			baseName = ""
+		}
+		h = append(h, byte(len(baseName)))
+		h = append(h, baseName...)
+		h = le.AppendUint16(h, crc16(0, data))
+		if level == 1 {
+			h = append(h, 'U')
+			h = le.AppendUint16(h, uint16(len(ext)))
+			le.PutUint32(h[7:], uint32(len(packed)+len(ext)))
+		}
+		h[0] = byte(len(h) - 2)
+		for _, b := range h[2:] {
+			h[1] += b
+		}
+		out = h
+		if level == 1 {
+			out = append(out, ext...)
+		}
+	case 2:
+		h := append([]byte{0, 0}, common...)
+		h = append(h, 0x20, 2)
+		h = le.AppendUint16(h, crc16(0, data))
+		h = append(h, 'U')
+		h = le.AppendUint16(h, uint16(len(ext)))
+		h = append(h, ext...)
+		le.PutUint16(h, uint16(len(h)))
+		out = h
+	}
+	return append(out, packed...)
+}
+
+func TestUnpackLha(t *testing.T) {
+	data := sampleData(30000)
+	var archive []byte
+	var want []unpacked
+	add := func(level int, name, method string, data, packed []byte) {
+		archive = append(archive, lhaMember(level, name, method, data, packed)...)
+		want = append(want, unpacked{baseName(name), data})
+	}
+	for level := 0; level <= 2; level++ {
+		add(level, "STORED.PKT", "-lh0-", data, data)
+		add(level, "LH5.PKT", "-lh5-", data, lhEncode(data, lhaMethods["-lh5-"], 1<<16-1))
+	}
+	add(2, "LH6.PKT", "-lh6-", data, lhEncode(data, lhaMethods["-lh6-"], 1<<16-1))
+	add(2, "LH7.PKT", "-lh7-", data, lhEncode(data, lhaMethods["-lh7-"], 1<<16-1))
+	add(2, "BLOCKS.PKT", "-lh5-", data, lhEncode(data, lhaMethods["-lh5-"], 300))
+	add(0, "ONE.PKT", "-lh5-", []byte("x"), lhEncode([]byte("x"), lhaMethods["-lh5-"], 100))
+	add(0, `..\..\EVIL.PKT`, "-lh0-", []byte("evil"), []byte("evil"))
+	add(1, "EMPTY.PKT", "-lh0-", nil, nil)
+	archive = append(archive, 0)
+	if Detect(archive[:sniffSize]) != Lha {
+		t.Errorf("archive not detected as LHA")
+	}
+	var got []unpacked
+	if err := unpackLha(bytes.NewReader(archive), collect(&got)); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("unpacked %d files, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].name != want[i].name || !bytes.Equal(got[i].data, want[i].data) {
+			t.Errorf("file %d: got %s (%d bytes), want %s (%d bytes)", i, got[i].name, len(got[i].data), want[i].name, len(want[i].data))
+		}
+	}
+}
+
+func TestUnpackLhaCorrupt(t *testing.T) {
+	data := sampleData(2000)
+	packed := lhEncode(data, lhaMethods["-lh5-"], 1000)
+	member := lhaMember(0, "A.PKT", "-lh5-", data, packed)
+	badCRC := lhaMember(2, "A.PKT", "-lh5-", append([]byte("x"), data[1:]...), packed)
+	badSum := append([]byte(nil), member...)
+	badSum[1]++
+	for _, tc := range []struct {
+		name    string
+		archive []byte
+		want    error
+	}{
+		{"bad CRC", append(badCRC, 0), ErrCorrupt},
+		{"bad checksum", append(badSum, 0), ErrCorrupt},
+		{"truncated", member[:len(member)-len(packed)/2], ErrCorrupt},
+		{"garbage", append(lhaMember(2, "A.PKT", "-lh5-", data, bytes.Repeat([]byte{0xff}, 100)), 0), ErrCorrupt},
+		{"method", append(lhaMember(2, "A.PKT", "-lh1-", data, packed), 0), ErrUnsupported},
+	} {
+		err := unpackLha(bytes.NewReader(tc.archive), func(string, io.Reader) error { return nil })
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
+
+// spaceBlocks returns blocks of the given number of codes, in
+// which the only code is a space and so takes no bits.
+func spaceBlocks(blocks, codes int, m lhaMethod) []byte {
+	w := &lhWriter{}
+	for i := 0; i < blocks; i++ {
+		w.write(codes, 16)
+		w.write(0, lhTBit)
+		w.write(0, lhTBit)
+		w.write(0, lhCBit)
+		w.write(' ', lhCBit)
+		w.write(0, m.pbit)
+		w.write(0, m.pbit)
+	}
+	return w.bytes()
+}
+
+func TestUnpackLhaBomb(t *testing.T) {
+	m := lhaMethods["-lh5-"]
+	spaces := bytes.Repeat([]byte(" "), 1<<20)
+	// Blocks whose codes take no bits unpack a megabyte from a
+	// hundred or so bytes.  Claiming more packed data than there
+	// is passes the check of the header's sizes.
+	bomb := spaceBlocks(16, 65535, m)
+	claimed := lhaMember(2, "A.PKT", "-lh5-", spaces, append(bomb, make([]byte, 2000)...))
+	claimed = claimed[:len(claimed)-2000]
+	// A block of more codes than can be read from what is left.
+	packed := lhEncode(sampleData(2000), m, 1000)
+	packed[0], packed[1] = 0xff, 0xff
+	for _, tc := range []struct {
+		name    string
+		archive []byte
+	}{
+		{"too packed", append(lhaMember(2, "A.PKT", "-lh5-", spaces, bomb), 0)},
+		{"claims more packed", claimed},
+		{"block too long", append(lhaMember(2, "A.PKT", "-lh5-", spaces[:100], spaceBlocks(1, 1000, m)), 0)},
+		{"block too few bits", append(lhaMember(2, "A.PKT", "-lh5-", spaces[:70000], packed), 0)},
+	} {
+		var n int64
+		err := unpackLha(bytes.NewReader(tc.archive), func(_ string, r io.Reader) error {
+			n, _ = io.Copy(io.Discard, r)
+			return nil
+		})
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, ErrCorrupt)
+		}
+		if n > 1<<18 {
+			t.Errorf("%s: unpacked %d bytes", tc.name, n)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package bundle
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"time"
+
+	"fat-dragon.org/ginko/ftn"
+)
+
+func unpackZip(name string, fn Handler) error {
+	r, err := zip.OpenReader(name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		// The zip package holds each file to the sizes given.
+		if err := checkMember(f.UncompressedSize64, f.CompressedSize64); err != nil {
+			return fmt.Errorf("%s: %s: %w", name, f.Name, err)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", name, f.Name, err)
+		}
+		err = fn(baseName(f.Name), rc)
+		if cerr := rc.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("%s: %s: %w", name, f.Name, cerr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// File is a file to be packed into a bundle.
+type File struct {
+	Name     string
+	Modified time.Time
+	Data     []byte
+}
+
+// PackZip writes a ZIP bundle of the given files.
+func PackZip(w io.Writer, files []File) error {
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     f.Name,
+			Method:   zip.Deflate,
+			Modified: f.Modified,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(f.Data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// dayNames are the extensions of bundles made on each day of the
+// week, without the sequence character.
+var dayNames = [7]string{"su", "mo", "tu", "we", "th", "fr", "sa"}
+
+// sequence are the characters that number the bundles made on a
+// day.
+const sequence = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// Name returns the name of a bundle from one address to another,
+// made on the given day: eight hex digits giving the difference
+// of their nets and nodes, and an extension made of the day of
+// the week and a sequence character, such as `0000fff0.mo1`.
+// Between a point and its boss, the points differ instead.
+func Name(from, to ftn.Address, day time.Weekday, seq int) string {
+	netDiff := int(from.Net()) - int(to.Net())
+	nodeDiff := int(from.Node()) - int(to.Node())
+	if netDiff == 0 && nodeDiff == 0 {
+		nodeDiff = int(from.Point()) - int(to.Point())
+	}
+	return fmt.Sprintf("%04x%04x.%s%c", uint16(netDiff), uint16(nodeDiff), dayNames[day], sequence[seq%len(sequence)])
+}
+
+// NextName returns the first name of a bundle for the given day
+// for which exists reports false.
+func NextName(from, to ftn.Address, t time.Time, exists func(string) bool) (string, error) {
+	for seq := 0; seq < len(sequence); seq++ {
+		if name := Name(from, to, t.Weekday(), seq); !exists(name) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no free bundle name for %v on %v", to, t.Weekday())
+}
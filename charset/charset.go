@@ -0,0 +1,206 @@
+// Package charset converts message text between the character
+// sets used in FidoNet and UTF-8.
+//
+// The character set of a message is named by its CHRS kludge, as
+// described in FTS-5003, or by the older CODEPAGE kludge.  Text
+// is decoded to UTF-8 for processing and encoded back to the
+// message's character set for export.  The tables are built in.
+package charset
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"fat-dragon.org/ginko/message"
+)
+
+// Charset is a character set.  The zero value is unset; it
+// decodes and encodes as CP437, the traditional FidoNet default.
+type Charset struct {
+	name  string
+	level int
+	table *[128]rune // Upper half, for single-byte sets.
+	utf8  bool
+}
+
+var (
+	CP437  = Charset{"CP437", 2, &cp437, false}
+	CP866  = Charset{"CP866", 2, &cp866, false}
+	Latin1 = Charset{"LATIN-1", 2, nil, false}
+	UTF8   = Charset{"UTF-8", 4, nil, true}
+)
+
+// Default is the character set assumed when a message does not
+// name one and no other default is configured.
+var Default = CP437
+
+// aliases maps the names by which character sets are known, in
+// CHRS and CODEPAGE kludges and in configuration, to the sets.
+var aliases = map[string]Charset{
+	"CP437":      CP437,
+	"IBMPC":      CP437,
+	"437":        CP437,
+	"CP866":      CP866,
+	"866":        CP866,
+	"RUSSIAN":    CP866,
+	"ALT":        CP866,
+	"LATIN-1":    Latin1,
+	"LATIN1":     Latin1,
+	"ISO-8859-1": Latin1,
+	"ISO8859-1":  Latin1,
+	"UTF-8":      UTF8,
+	"UTF8":       UTF8,
+}
+
+// Lookup returns the character set with the given name.
+func Lookup(name string) (Charset, error) {
+	if c, ok := aliases[strings.ToUpper(strings.TrimSpace(name))]; ok {
+		return c, nil
+	}
+	return Charset{}, fmt.Errorf("unknown character set %q", name)
+}
+
+// IsSet reports whether the character set is set.
+func (c Charset) IsSet() bool {
+	return c.name != ""
+}
+
+// orDefault returns the set itself, or CP437 if it is unset.
+func (c Charset) orDefault() Charset {
+	if !c.IsSet() {
+		return CP437
+	}
+	return c
+}
+
+// Name returns the name used for the set in CHRS kludges.
+func (c Charset) Name() string {
+	return c.orDefault().name
+}
+
+// Kludge returns the value of a CHRS kludge naming the set, such
+// as "CP866 2".
+func (c Charset) Kludge() string {
+	c = c.orDefault()
+	return c.name + " " + strconv.Itoa(c.level)
+}
+
+func (c Charset) String() string {
+	return c.Name()
+}
+
+// Decode converts text in the character set to UTF-8.  Invalid
+// UTF-8 in UTF-8 text is replaced with U+FFFD.
+func (c Charset) Decode(text string) string {
+	c = c.orDefault()
+	if c.utf8 {
+		return strings.ToValidUTF8(text, "�")
+	}
+	var b strings.Builder
+	b.Grow(len(text))
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+		switch {
+		case ch < 0x80:
+			b.WriteByte(ch)
+		case c.table != nil:
+			b.WriteRune(c.table[ch-0x80])
+		default:
+			b.WriteRune(rune(ch))
+		}
+	}
+	return b.String()
+}
+
+// Encode converts UTF-8 text to the character set.  Characters
+// the set cannot represent are replaced with '?'.
+func (c Charset) Encode(text string) string {
+	c = c.orDefault()
+	if c.utf8 {
+		return text
+	}
+	var b strings.Builder
+	b.Grow(len(text))
+	for _, r := range text {
+		switch {
+		case r < 0x80:
+			b.WriteByte(byte(r))
+		case r == utf8.RuneError:
+			b.WriteByte('?')
+		case c.table != nil:
+			b.WriteByte(c.encodeTable(r))
+		case r <= 0xff:
+			b.WriteByte(byte(r))
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+func (c Charset) encodeTable(r rune) byte {
+	for i, tr := range c.table {
+		if tr == r {
+			return byte(0x80 + i)
+		}
+	}
+	return '?'
+}
+
+// Of returns the character set named by the message's CHRS
+// kludge, or failing that its CODEPAGE kludge.  It reports false
+// if neither names a known set.
+func Of(m *message.Message) (Charset, bool) {
+	if m.Charset != "" {
+		// The level that follows the name is implied by it.
+		name := strings.Fields(m.Charset)[0]
+		if c, err := Lookup(name); err == nil {
+			return c, true
+		}
+	}
+	if codepage, ok := m.Kludge("CODEPAGE"); ok {
+		if c, err := Lookup(codepage); err == nil {
+			return c, true
+		}
+	}
+	return Charset{}, false
+}
+
+// Decode converts the text of a message to UTF-8, using the
+// character set it names or else the given default.  It returns
+// the decoded text and the set it was decoded from, so that it
+// may be encoded back for export.
+func Decode(text string, def Charset) (string, Charset) {
+	c, ok := Of(message.Parse(text))
+	if !ok {
+		c = def.orDefault()
+	}
+	return c.Decode(text), c
+}
+
+// Unmarshal a character set from its name in a JSON stream.
+func (c *Charset) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	charset, err := Lookup(name)
+	if err != nil {
+		return err
+	}
+	*c = charset
+	return nil
+}
+
+// Set implements flag.Value.
+func (c *Charset) Set(name string) error {
+	charset, err := Lookup(name)
+	if err != nil {
+		return err
+	}
+	*c = charset
+	return nil
+}
@@ -0,0 +1,107 @@
+package charset
+
+import (
+	"encoding/json"
+	"testing"
+
+	"fat-dragon.org/ginko/message"
+)
+
+func TestDecodeEncode(t *testing.T) {
+	tests := []struct {
+		c       Charset
+		encoded string
+		decoded string
+	}{
+		{CP437, "Caf\x82 \xb0\xdb \xe1", "Café ░█ ß"},
+		{CP866, "\x8f\xe0\xa8\xa2\xa5\xe2 \xf0", "Привет Ё"},
+		{Latin1, "Caf\xe9 \xa9", "Café ©"},
+		{UTF8, "Привет", "Привет"},
+		{Charset{}, "\x82", "é"},
+	}
+	for _, test := range tests {
+		if got := test.c.Decode(test.encoded); got != test.decoded {
+			t.Errorf("%v.Decode(%q) = %q, want %q", test.c, test.encoded, got, test.decoded)
+		}
+		if got := test.c.Encode(test.decoded); got != test.encoded {
+			t.Errorf("%v.Encode(%q) = %q, want %q", test.c, test.decoded, got, test.encoded)
+		}
+	}
+	if got := CP437.Encode("Привет €"); got != "?????? ?" {
+		t.Errorf("unrepresentable characters encoded as %q", got)
+	}
+	if got := UTF8.Decode("bad\xff"); got != "bad�" {
+		t.Errorf("invalid UTF-8 decoded as %q", got)
+	}
+}
+
+func TestSingleByteRoundTrip(t *testing.T) {
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+	for _, c := range []Charset{CP437, CP866, Latin1} {
+		if got := c.Encode(c.Decode(string(all))); got != string(all) {
+			t.Errorf("%v does not round trip every byte", c)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for name, want := range map[string]Charset{"cp866": CP866, " IBMPC ": CP437, "iso-8859-1": Latin1, "UTF-8": UTF8} {
+		if c, err := Lookup(name); err != nil || c != want {
+			t.Errorf("Lookup(%q) = %v, %v", name, c, err)
+		}
+	}
+	if _, err := Lookup("EBCDIC"); err == nil {
+		t.Error("Lookup found EBCDIC")
+	}
+	if CP866.Kludge() != "CP866 2" || UTF8.Kludge() != "UTF-8 4" || (Charset{}).Kludge() != "CP437 2" {
+		t.Error("unexpected CHRS kludge values")
+	}
+}
+
+func TestOf(t *testing.T) {
+	tests := []struct {
+		text string
+		want Charset
+		ok   bool
+	}{
+		{"\x01CHRS: CP866 2\rtext\r", CP866, true},
+		{"\x01CHRS: UTF-8 4\rtext\r", UTF8, true},
+		{"\x01CODEPAGE: 866\rtext\r", CP866, true},
+		{"\x01CHRS: KLINGON 2\r\x01CODEPAGE: 437\rtext\r", CP437, true},
+		{"\x01CHRS: KLINGON 2\rtext\r", Charset{}, false},
+		{"text\r", Charset{}, false},
+	}
+	for _, test := range tests {
+		c, ok := Of(message.Parse(test.text))
+		if c != test.want || ok != test.ok {
+			t.Errorf("Of(%q) = %v, %v; want %v, %v", test.text, c, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestDecodeMessage(t *testing.T) {
+	text, c := Decode("\x01CHRS: CP866 2\r\x8f\xe0\xa8\xa2\xa5\xe2\r", Latin1)
+	if c != CP866 || text != "\x01CHRS: CP866 2\rПривет\r" {
+		t.Errorf("Decode = %q, %v", text, c)
+	}
+	text, c = Decode("Caf\xe9\r", Latin1)
+	if c != Latin1 || text != "Café\r" {
+		t.Errorf("Decode with default = %q, %v", text, c)
+	}
+	if back := c.Encode(text); back != "Caf\xe9\r" {
+		t.Errorf("re-encoded as %q", back)
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	var v struct{ Charset Charset }
+	if err := json.Unmarshal([]byte(`{"Charset": "cp866"}`), &v); err != nil || v.Charset != CP866 {
+		t.Errorf("unmarshalled %v, %v", v.Charset, err)
+	}
+	if err := json.Unmarshal([]byte(`{"Charset": "nonsense"}`), &v); err == nil {
+		t.Error("unmarshalled an unknown character set")
+	}
+}
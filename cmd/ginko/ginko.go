@@ -1,36 +1,169 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"os"
 
 	"fat-dragon.org/ginko/config"
+	"fat-dragon.org/ginko/dial"
+	"fat-dragon.org/ginko/ftn"
+	"fat-dragon.org/ginko/history"
+	"fat-dragon.org/ginko/logging"
+	"fat-dragon.org/ginko/metrics"
+	"fat-dragon.org/ginko/nodelist"
 	"fat-dragon.org/ginko/proto"
+	"fat-dragon.org/ginko/proto/session"
+	"fat-dragon.org/ginko/spool"
+	"fat-dragon.org/ginko/version"
 )
 
 var configFile string
 var pollHost string
+var logLevel = logging.Info
+var logJSON bool
+var metricsAddr string
+var historyLink string
+var historyCount int
+var showVersion bool
+var nodelistQuery string
+var deliverDirect bool
+var nodediffFile string
+var releaseLink string
 
 func init() {
 	const defaultConfigFile = "/opt/local/etc/ginko.conf"
 	flag.StringVar(&configFile, "c", defaultConfigFile, "config file name")
-	flag.StringVar(&pollHost, "p", "", "Host to poll")
+	flag.StringVar(&pollHost, "p", "", "host or FTN address to poll")
+	flag.Var(&logLevel, "l", "log level (debug, info, warn, error)")
+	flag.BoolVar(&logJSON, "j", false, "log in JSON format")
+	flag.StringVar(&metricsAddr, "m", "", "address on which to serve metrics (e.g. :9554)")
+	flag.StringVar(&historyLink, "H", "", "list recent sessions with a link (\"all\" for every link) and exit")
+	flag.IntVar(&historyCount, "n", 20, "number of sessions listed by -H")
+	flag.BoolVar(&showVersion, "V", false, "print version and exit")
+	flag.StringVar(&nodelistQuery, "N", "", "list nodelist entries matching an address or pattern (e.g. 2:5020/*) and exit")
+	flag.StringVar(&nodediffFile, "U", "", "apply a NODEDIFF to the configured nodelist it was made from, and exit")
+	flag.BoolVar(&deliverDirect, "D", false, "poll every unlinked system with direct mail waiting, and exit")
+	flag.StringVar(&releaseLink, "R", "", "release the files on hold for a system (\"all\" for every system) and exit")
 }
 
 func main() {
 	flag.Parse()
+	if showVersion {
+		fmt.Println(version.Ver())
+		return
+	}
+	logging.SetDefault(logging.New(os.Stderr, logLevel, logJSON))
 	config, err := config.ParseFile(configFile)
 	if err != nil {
 		log.Fatalf("cannot read config file: %v", err)
 	}
-	if pollHost != "" {
-		poll(config, pollHost)
-	} else {
+	if historyLink != "" {
+		listHistory(config, historyLink, historyCount)
+		return
+	}
+	if nodelistQuery != "" {
+		queryNodelists(config, nodelistQuery)
+		return
+	}
+	if nodediffFile != "" {
+		updateNodelist(config, nodediffFile)
+		return
+	}
+	recoverSpools(config)
+	if releaseLink != "" {
+		releaseHeld(config, releaseLink)
+		return
+	}
+	if metricsAddr != "" {
+		serveMetrics(config, metricsAddr)
+	}
+	switch {
+	case deliverDirect:
+		pollDirect(config)
+	case pollHost != "":
+		if err := poll(config, pollHost); err != nil {
+			log.Fatal("poll: ", err)
+		}
+	default:
 		server(config)
 	}
 }
 
+// recoverSpools runs crash recovery over every link's spools,
+// and those for direct delivery, before any session can touch
+// them.
+func recoverSpools(config *config.Config) {
+	for _, link := range config.Links {
+		recoverLink(link)
+	}
+	addrs, err := config.DirectAddresses()
+	if err != nil {
+		log.Fatalf("cannot list direct spools: %v", err)
+	}
+	for _, addr := range addrs {
+		link, err := config.DirectLink(addr)
+		if err != nil {
+			log.Fatalf("cannot find direct spools for %v: %v", addr, err)
+		}
+		recoverLink(link)
+	}
+}
+
+// recoverLink runs crash recovery over a link's spools.  Spools
+// in use by a session, as in a running daemon, are left to it.
+func recoverLink(link *config.Link) {
+	for _, s := range []*spool.Spool{&link.InSpool, &link.OutSpool} {
+		if s.Dir() == "" {
+			continue
+		}
+		err := s.Recover()
+		if errors.Is(err, spool.ErrBusy) {
+			logging.Default().Debugf("not recovering %s, which is in use", s.Dir())
+			continue
+		}
+		if err != nil {
+			log.Fatalf("spool recovery failed for %v: %v", link.Address, err)
+		}
+	}
+}
+
+// serveMetrics starts an HTTP listener exporting the daemon's
+// metrics at /metrics.
+func serveMetrics(config *config.Config, addr string) {
+	metrics.Default.NewGaugeFunc("ginko_queue_depth",
+		"Files waiting in each link's outbound spool, and in those for direct delivery.", []string{"link"},
+		func() []metrics.Sample {
+			var samples []metrics.Sample
+			for _, link := range queuedLinks(config) {
+				depth, err := link.OutSpool.QueueDepth()
+				if err != nil {
+					logging.Default().Warnf("cannot read queue for %v: %v", link.Address, err)
+					continue
+				}
+				samples = append(samples, metrics.Sample{
+					LabelValues: []string{link.Address.String()},
+					Value:       float64(depth),
+				})
+			}
+			return samples
+		})
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default.Handler())
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("cannot listen for metrics: %v", err)
+	}
+	go func() {
+		log.Fatal(http.Serve(listener, mux))
+	}()
+}
+
 func server(config *config.Config) {
 	server, err := net.Listen("tcp", ":24554")
 	if err != nil {
@@ -42,15 +175,251 @@ func server(config *config.Config) {
 		if err != nil {
 			log.Fatalf("accept failed: %v", err)
 		}
-		go proto.Receiver(config, client)
+		go func() {
+			recordHistory(config, proto.Receiver(config, client))
+		}()
 	}
 }
 
-func poll(config *config.Config, host string) {
-	conn, err := net.Dial("tcp", host)
+// poll calls a system and runs a session with it.  The target is
+// either a `host:port` or an FTN address; a system named by
+// address is found through its link and the nodelists, and if we
+// have no link with it we deliver its direct mail unsecured.  It
+// returns an error only if the call could not be placed; the
+// session's outcome is recorded in the history.
+func poll(config *config.Config, target string) error {
+	link, conn, err := connect(config, target)
 	if err != nil {
-		log.Fatal("poll: dial failed:", err)
+		return err
+	}
+	recordHistory(config, proto.Sender(config, link, conn))
+	return nil
+}
+
+// connect places a call to the target, returning the link for
+// the system called, if known, and the connection.  A host is
+// known if it is the host of a link.
+func connect(config *config.Config, target string) (*config.Link, net.Conn, error) {
+	addr, err := ftn.ParseAddress(target)
+	if err != nil {
+		conn, err := net.Dial("tcp", target)
+		if err != nil {
+			return nil, nil, fmt.Errorf("dial failed: %v", err)
+		}
+		return config.LinkForHost(target), conn, nil
+	}
+	link, err := callLink(config, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := dial.New(loadNodelists(config)).Dial(context.Background(), addr, link)
+	if err != nil {
+		return nil, nil, err
+	}
+	return link, conn, nil
+}
+
+// callLink returns the link to use when calling the system with
+// the given address: its configured link, or else one for direct
+// delivery, whose spools are created on demand.
+func callLink(config *config.Config, addr ftn.Address) (*config.Link, error) {
+	if link := config.LinkFor(addr); link != nil {
+		return link, nil
+	}
+	link, err := config.DirectLink(addr)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range []*spool.Spool{&link.InSpool, &link.OutSpool} {
+		if err := s.Create(); err != nil {
+			return nil, fmt.Errorf("cannot create spool for %v: %v", addr, err)
+		}
+	}
+	return link, nil
+}
+
+// queuedLinks returns every link, and a link for each system
+// with a direct spool that is not one.
+func queuedLinks(c *config.Config) []*config.Link {
+	var links []*config.Link
+	for _, link := range c.Links {
+		links = append(links, link)
+	}
+	addrs, err := c.DirectAddresses()
+	if err != nil {
+		logging.Default().Warnf("cannot list direct spools: %v", err)
+		return links
+	}
+	for _, addr := range addrs {
+		if c.LinkFor(addr) != nil {
+			continue
+		}
+		link, err := c.DirectLink(addr)
+		if err != nil {
+			logging.Default().Warnf("cannot find direct spools for %v: %v", addr, err)
+			continue
+		}
+		links = append(links, link)
+	}
+	return links
+}
+
+// pollDirect polls every system we have no link with that has
+// direct mail waiting.  Mail on hold is not sent when we call,
+// so systems with only held mail are not polled.
+func pollDirect(config *config.Config) {
+	addrs, err := config.DirectAddresses()
+	if err != nil {
+		log.Fatalf("cannot list direct spools: %v", err)
+	}
+	for _, addr := range addrs {
+		if config.LinkFor(addr) != nil {
+			continue
+		}
+		link, err := config.DirectLink(addr)
+		if err != nil {
+			log.Fatalf("cannot find direct spools for %v: %v", addr, err)
+		}
+		depth, err := link.OutSpool.ReadyDepth()
+		if err != nil {
+			logging.Default().Warnf("cannot read queue for %v: %v", addr, err)
+			continue
+		}
+		if depth == 0 {
+			continue
+		}
+		if err := poll(config, addr.String()); err != nil {
+			logging.Default().Errorf("poll %v: %v", addr, err)
+		}
+	}
+}
+
+// releaseHeld takes the files on hold for a system, or for every
+// system with a link or direct spool, off hold, so that they are
+// sent in the next session with it.
+func releaseHeld(config *config.Config, target string) {
+	if target != "all" {
+		addr, err := ftn.ParseAddress(target)
+		if err != nil {
+			log.Fatalf("bad address: %v", err)
+		}
+		link := config.LinkFor(addr)
+		if link == nil {
+			if link, err = config.DirectLink(addr); err != nil {
+				log.Fatalf("cannot find direct spools for %v: %v", addr, err)
+			}
+		}
+		releaseSpool(link)
+		return
+	}
+	for _, link := range config.Links {
+		releaseSpool(link)
+	}
+	addrs, err := config.DirectAddresses()
+	if err != nil {
+		log.Fatalf("cannot list direct spools: %v", err)
+	}
+	for _, addr := range addrs {
+		if config.LinkFor(addr) != nil {
+			continue
+		}
+		link, err := config.DirectLink(addr)
+		if err != nil {
+			log.Fatalf("cannot find direct spools for %v: %v", addr, err)
+		}
+		releaseSpool(link)
+	}
+}
+
+func releaseSpool(link *config.Link) {
+	if link.OutSpool.Dir() == "" {
+		return
+	}
+	n, err := link.OutSpool.Release()
+	if err != nil {
+		logging.Default().Errorf("cannot release files for %v: %v", link.Address, err)
+		return
+	}
+	if n > 0 {
+		fmt.Printf("%v: released %d files\n", link.Address, n)
+	}
+}
+
+// recordHistory appends a session to the history in the data
+// directory, if one is configured.
+func recordHistory(config *config.Config, result *session.SessionResult) {
+	if config.DataDir == "" {
+		return
+	}
+	if err := history.Open(config.DataDir).Append(history.FromResult(result)); err != nil {
+		logging.Default().Errorf("cannot record session history: %v", err)
+	}
+}
+
+// listHistory prints the most recent sessions with the given link.
+func listHistory(config *config.Config, link string, count int) {
+	if config.DataDir == "" {
+		log.Fatal("no data directory configured")
+	}
+	var filter func(*history.Record) bool
+	if link != "all" {
+		addr, err := ftn.ParseAddress(link)
+		if err != nil {
+			log.Fatalf("bad link address: %v", err)
+		}
+		filter = history.ForLink(addr)
+	}
+	records, err := history.Open(config.DataDir).Query(filter, count)
+	if err != nil {
+		log.Fatalf("cannot read session history: %v", err)
+	}
+	for _, rec := range records {
+		fmt.Println(rec)
+	}
+}
+
+// loadNodelists reads the configured nodelists.  Lists that
+// cannot be read are logged and skipped.
+func loadNodelists(config *config.Config) nodelist.Index {
+	var index nodelist.Index
+	for _, nl := range config.Nodelists {
+		list, err := nodelist.Load(nl.File, nl.Domain)
+		if err != nil {
+			logging.Default().Errorf("cannot read nodelist: %v", err)
+			continue
+		}
+		for _, err := range list.Errors {
+			logging.Default().Debugf("%s: %v", nl.File, err)
+		}
+		index = append(index, list)
+	}
+	return index
+}
+
+// queryNodelists prints the nodelist entries matching a pattern.
+func queryNodelists(config *config.Config, query string) {
+	pattern, err := ftn.ParsePattern(query)
+	if err != nil {
+		log.Fatalf("bad address pattern: %v", err)
+	}
+	for _, e := range loadNodelists(config).Match(pattern) {
+		fmt.Println(e)
+	}
+}
+
+// updateNodelist applies a nodediff to whichever configured
+// nodelist it was made from.
+func updateNodelist(config *config.Config, diffFile string) {
+	for _, nl := range config.Nodelists {
+		name, err := nodelist.Update(nl.File, diffFile)
+		if errors.Is(err, nodelist.ErrDiffMismatch) {
+			continue
+		}
+		if err != nil {
+			log.Fatalf("cannot update nodelist %s: %v", nl.File, err)
+		}
+		fmt.Println(name)
+		return
 	}
-	defer conn.Close()
-	proto.Sender(config, conn)
+	log.Fatalf("%s does not apply to any configured nodelist", diffFile)
 }
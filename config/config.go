@@ -3,37 +3,148 @@ package config
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
 	"strings"
 	"time"
 
+	"fat-dragon.org/ginko/charset"
 	"fat-dragon.org/ginko/ftn"
 	"fat-dragon.org/ginko/spool"
 	"github.com/yosuke-furukawa/json5/encoding/json5"
 )
 
-// Config represents the system's configuration.
+// Config represents the system's configuration.  The optional
+// nodelist flags, phone number and URL are advertised to the
+// distant end at the start of each session.  Netmail is where
+// netmail for this system is left, in packets; Unpack gives
+// commands for unpacking bundles in formats not understood
+// natively, keyed by format name, as for bundle.Unpacker.  Zones
+// records the domain of each zone our nets are in, for filling
+// in addresses that lack one; see Canonical.
 type Config struct {
-	Admin    string                `json:"admin"`
-	System   string                `json:"system"`
-	Location string                `json:"location"`
-	Nets     []Net                 `json:"nets"`
-	Links    map[ftn.Address]*Link `json:"-"`
+	Admin         string                `json:"admin"`
+	System        string                `json:"system"`
+	Location      string                `json:"location"`
+	NodelistFlags string                `json:"nodelistFlags"`
+	Phone         string                `json:"phone"`
+	URL           string                `json:"url"`
+	DataDir       string                `json:"dataDir"`
+	InsecureIn    spool.Spool           `json:"insecureIn"`
+	Netmail       string                `json:"netmail"`
+	Unpack        map[string][]string   `json:"unpack"`
+	Nodelists     []NodelistFile        `json:"nodelists"`
+	Nets          []Net                 `json:"nets"`
+	Links         map[ftn.Address]*Link `json:"-"`
+	Zones         ftn.ZoneDomains       `json:"-"`
+}
+
+// NodelistFile names a nodelist and the domain of its entries.
+// The file may be given as a base name such as `NODELIST`, in
+// which case the most recent `NODELIST.nnn` is used.
+type NodelistFile struct {
+	File   string     `json:"file"`
+	Domain ftn.Domain `json:"domain"`
 }
 
 // Net represents a configured network this node has joined.
+// Address is our main address in the net; Akas lists any others,
+// such as points.  System and Location, if set, override the
+// system-wide values for sessions in this net.  Charset is the
+// character set assumed for messages in the net that do not name
+// their own, and in which messages posted locally are sent.
+// Areas are the echomail areas carried in the net, and Routes
+// say where netmail for the net is sent.
 type Net struct {
-	Name     string       `json:"name"`
-	Address  ftn.Address  `json:"address"`
-	Links    []Link       `json:"links"`
+	Name     string          `json:"name"`
+	Address  ftn.Address     `json:"address"`
+	Akas     []ftn.Address   `json:"akas"`
+	System   string          `json:"system"`
+	Location string          `json:"location"`
+	Charset  charset.Charset `json:"charset"`
+	Areas    []Area          `json:"areas"`
+	Routes   []Route         `json:"routes"`
+	Links    []Link          `json:"links"`
+}
+
+// Addresses returns all of our addresses in the net, main
+// address first.
+func (n *Net) Addresses() []ftn.Address {
+	return append([]ftn.Address{n.Address}, n.Akas...)
+}
+
+// Area is an echomail area.  Its mail is forwarded to each of
+// the links subscribed to it, and if Dir is set, left there in
+// packets for local readers.  If Base is set, its mail is also
+// stored in that message base, given as for msgbase.Open.
+type Area struct {
+	Tag   string        `json:"tag"`
+	Links []ftn.Address `json:"links"`
+	Dir   string        `json:"dir"`
+	Base  string        `json:"base"`
+}
+
+// Area returns the area with the given tag, compared without
+// regard to case, or nil if the net carries no such area.
+func (n *Net) Area(tag string) *Area {
+	for i := range n.Areas {
+		if strings.EqualFold(n.Areas[i].Tag, tag) {
+			return &n.Areas[i]
+		}
+	}
+	return nil
+}
+
+// Linked reports whether the given address is subscribed to the
+// area.
+func (a *Area) Linked(addr ftn.Address) bool {
+	for _, link := range a.Links {
+		if link.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// SystemIn returns the system name to present in the given
+// net, which may be nil.
+func (c *Config) SystemIn(net *Net) string {
+	if net != nil && net.System != "" {
+		return net.System
+	}
+	return c.System
+}
+
+// LocationIn returns the location to present in the given net,
+// which may be nil.
+func (c *Config) LocationIn(net *Net) string {
+	if net != nil && net.Location != "" {
+		return net.Location
+	}
+	return c.Location
+}
+
+// CharsetIn returns the default character set for messages in
+// the given net, which may be nil.
+func (c *Config) CharsetIn(net *Net) charset.Charset {
+	if net != nil && net.Charset.IsSet() {
+		return net.Charset
+	}
+	return charset.Default
 }
 
 type Link struct {
-	Address   ftn.Address  `json:"address"`
-	Password  string       `json:"password"`
-	InSpool   spool.Spool  `json:"in"`
-	OutSpool  spool.Spool  `json:"out"`
-	PollTime  PollInterval `json:"poll"`
-	LinkedNet *Net         `json:"-"`
+	Address       ftn.Address   `json:"address"`
+	Password      string        `json:"password"`
+	InSpool       spool.Spool   `json:"in"`
+	OutSpool      spool.Spool   `json:"out"`
+	Host          string        `json:"host"`
+	PollTime      PollInterval  `json:"poll"`
+	SmallestFirst bool          `json:"smallestFirst"`
+	RateLimit     int64         `json:"rateLimit"`
+	Windows       []Window      `json:"windows"`
+	Present       []ftn.Address `json:"present"`
+	Match         []ftn.Pattern `json:"match"`
+	LinkedNet     *Net          `json:"-"`
 }
 
 type PollInterval time.Duration
@@ -61,10 +172,53 @@ func (c Config) String() string {
 	return b.String()
 }
 
+// Addresses returns all of our addresses: the main address of
+// each net, in order, followed by every net's AKAs.
 func (c *Config) Addresses() []ftn.Address {
-	addresses := make([]ftn.Address, len(c.Nets))
-	for i, net := range c.Nets {
-		addresses[i] = net.Address
+	addresses := make([]ftn.Address, 0, len(c.Nets))
+	for _, net := range c.Nets {
+		addresses = append(addresses, net.Address)
+	}
+	for _, net := range c.Nets {
+		addresses = append(addresses, net.Akas...)
+	}
+	return addresses
+}
+
+// AddressesFor returns the addresses to present to the given
+// link, which may be nil if the distant end is not yet known.
+//
+// A link may list the addresses to present explicitly.
+// Otherwise we present only those addresses in the same network
+// as the link, so as not to leak our AKAs in other networks;
+// the main address of the link's own net comes first.  With no
+// link, as when answering a call, every address is presented.
+func (c *Config) AddressesFor(link *Link) []ftn.Address {
+	if link == nil {
+		return c.Addresses()
+	}
+	if len(link.Present) > 0 {
+		return link.Present
+	}
+	var candidates []ftn.Address
+	if link.LinkedNet != nil {
+		candidates = link.LinkedNet.Addresses()
+	}
+	candidates = append(candidates, c.Addresses()...)
+	var addresses []ftn.Address
+	seen := make(map[ftn.Address]bool)
+	for _, addr := range candidates {
+		if seen[addr] || !c.Canonical(addr).SameNetwork(c.Canonical(link.Address)) {
+			continue
+		}
+		seen[addr] = true
+		addresses = append(addresses, addr)
+	}
+	if len(addresses) == 0 && link.LinkedNet != nil {
+		// The link is in a different zone from our net, as
+		// when a net spans zones; present the net's own
+		// addresses rather than none at all.
+		addresses = link.LinkedNet.Addresses()
 	}
 	return addresses
 }
@@ -77,16 +231,88 @@ func ParseFromString(data string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Error parsing configuration: %v", err)
 	}
+	c.Zones = make(ftn.ZoneDomains)
+	for _, net := range c.Nets {
+		for _, addr := range net.Addresses() {
+			c.Zones.Add(addr.Zone(), addr.Domain())
+		}
+	}
 	c.Links = make(map[ftn.Address]*Link)
 	for i := range c.Nets {
 		for j := range c.Nets[i].Links {
-			c.Nets[i].Links[j].LinkedNet = &c.Nets[i]
-			c.Links[c.Nets[i].Links[j].Address] = &c.Nets[i].Links[j]
+			link := &c.Nets[i].Links[j]
+			link.LinkedNet = &c.Nets[i]
+			c.Links[c.Canonical(link.Address)] = link
 		}
 	}
 	return &c, nil
 }
 
+// Canonical returns the canonical form of an address, with its
+// domain filled in from its zone if that is the zone of one of
+// our nets, or of FidoNet.
+func (c *Config) Canonical(addr ftn.Address) ftn.Address {
+	return c.Zones.Canonical(addr)
+}
+
+// LinkFor returns the link for the given address, or nil if
+// there is none.  Addresses are compared in canonical form, so
+// the domain may be omitted where the zone implies it.  Failing
+// an exact match, the links' `match` patterns are tried in the
+// order configured.
+func (c *Config) LinkFor(addr ftn.Address) *Link {
+	addr = c.Canonical(addr)
+	if link := c.Links[addr]; link != nil {
+		return link
+	}
+	for i := range c.Nets {
+		for j := range c.Nets[i].Links {
+			link := &c.Nets[i].Links[j]
+			if c.Zones.Equal(link.Address, addr) {
+				return link
+			}
+		}
+	}
+	for i := range c.Nets {
+		for j := range c.Nets[i].Links {
+			link := &c.Nets[i].Links[j]
+			for _, pattern := range link.Match {
+				if pattern.Match(addr) {
+					return link
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// LinkForHost returns the first link whose host is the given
+// `host:port`, or nil if there is none.  Hosts are compared
+// without regard to case, and a link that gives no port matches
+// any.
+func (c *Config) LinkForHost(hostPort string) *Link {
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		host, port = hostPort, ""
+	}
+	for i := range c.Nets {
+		for j := range c.Nets[i].Links {
+			link := &c.Nets[i].Links[j]
+			if link.Host == "" {
+				continue
+			}
+			linkHost, linkPort, err := net.SplitHostPort(link.Host)
+			if err != nil {
+				linkHost, linkPort = link.Host, ""
+			}
+			if strings.EqualFold(linkHost, host) && (linkPort == "" || port == "" || linkPort == port) {
+				return link
+			}
+		}
+	}
+	return nil
+}
+
 // ParseFile parses the system configuration as JSON5 text
 // from the given file, and returns the parsed Config struct.
 func ParseFile(file string) (*Config, error) {
@@ -0,0 +1,304 @@
+package config
+
+import (
+	"path"
+	"reflect"
+	"testing"
+
+	"fat-dragon.org/ginko/charset"
+	"fat-dragon.org/ginko/ftn/ftntest"
+	"fat-dragon.org/ginko/spool"
+)
+
+const akaConfig = `{
+	nets: [{
+		name: "fidonet",
+		address: "1:387/108@fidonet",
+		akas: ["1:387/108.1@fidonet", "2:5020/9999@fidonet"],
+		links: [
+			{ address: "1:387/1@fidonet" },
+			{ address: "2:5020/1@fidonet" },
+			{ address: "1:1/1@fidonet", present: ["1:387/108.1@fidonet"] },
+		],
+	}, {
+		name: "fsxnet",
+		address: "21:1/100@fsxnet",
+		links: [{ address: "21:1/1@fsxnet" }],
+	}],
+}`
+
+func TestAddressesFor(t *testing.T) {
+	c, err := ParseFromString(akaConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := ftntest.Addresses(t, "1:387/108@fidonet", "21:1/100@fsxnet", "1:387/108.1@fidonet", "2:5020/9999@fidonet")
+	if got := c.Addresses(); !reflect.DeepEqual(got, all) {
+		t.Errorf("Addresses() = %v, want %v", got, all)
+	}
+	if got := c.AddressesFor(nil); !reflect.DeepEqual(got, all) {
+		t.Errorf("AddressesFor(nil) = %v, want %v", got, all)
+	}
+	tests := []struct {
+		link string
+		want []string
+	}{
+		{"1:387/1@fidonet", []string{"1:387/108@fidonet", "1:387/108.1@fidonet"}},
+		{"2:5020/1@fidonet", []string{"2:5020/9999@fidonet"}},
+		{"1:1/1@fidonet", []string{"1:387/108.1@fidonet"}},
+		{"21:1/1@fsxnet", []string{"21:1/100@fsxnet"}},
+	}
+	for _, test := range tests {
+		link := c.Links[ftntest.Address(t, test.link)]
+		if link == nil {
+			t.Fatalf("link %s not configured", test.link)
+		}
+		want := ftntest.Addresses(t, test.want...)
+		if got := c.AddressesFor(link); !reflect.DeepEqual(got, want) {
+			t.Errorf("AddressesFor(%s) = %v, want %v", test.link, got, want)
+		}
+	}
+}
+
+func TestLinkFor(t *testing.T) {
+	c, err := ParseFromString(`{
+		nets: [{
+			name: "fidonet",
+			address: "1:387/108@fidonet",
+			links: [
+				{ address: "1:387/1@FidoNet" },
+				{ address: "1:387/2@fidonet", match: ["1:387/2.*", "1:388/*"] },
+			],
+		}, {
+			name: "fsxnet",
+			address: "21:1/100@fsxnet",
+			links: [{ address: "21:1/1@fsxnet" }],
+		}],
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct{ addr, link string }{
+		{"1:387/1", "1:387/1@FidoNet"},
+		{"1:387/1@fidonet", "1:387/1@FidoNet"},
+		{"1:387/1.0@FIDONET", "1:387/1@FidoNet"},
+		{"1:387/2.7", "1:387/2@fidonet"},
+		{"1:388/42@fidonet", "1:387/2@fidonet"},
+		{"21:1/1", "21:1/1@fsxnet"},
+		{"1:387/1@othernet", ""},
+		{"1:387/3", ""},
+	}
+	for _, test := range tests {
+		link := c.LinkFor(ftntest.Address(t, test.addr))
+		got := ""
+		if link != nil {
+			got = link.Address.String()
+		}
+		if got != test.link {
+			t.Errorf("LinkFor(%s) = %q, want %q", test.addr, got, test.link)
+		}
+	}
+}
+
+func TestZones(t *testing.T) {
+	parse := func(domain string) *Config {
+		c, err := ParseFromString(`{ nets: [{ name: "` + domain + `", address: "21:1/100@` + domain + `" }] }`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	fsxnet, other := parse("fsxnet"), parse("OtherNet")
+	addr := ftntest.Address(t, "21:1/1")
+	if got := fsxnet.Canonical(addr).String(); got != "21:1/1@fsxnet" {
+		t.Errorf("Canonical(21:1/1) = %s, want 21:1/1@fsxnet", got)
+	}
+	if got := other.Canonical(addr).String(); got != "21:1/1@othernet" {
+		t.Errorf("Canonical(21:1/1) in another config = %s, want 21:1/1@othernet", got)
+	}
+	if got := addr.Canonical().String(); got != "21:1/1" {
+		t.Errorf("configuration leaked into Address.Canonical: %s", got)
+	}
+}
+
+func TestLinkForHost(t *testing.T) {
+	c, err := ParseFromString(`{
+		nets: [{
+			name: "fidonet",
+			address: "1:387/108@fidonet",
+			links: [
+				{ address: "1:387/1@fidonet", host: "hub.example.org" },
+				{ address: "1:387/2@fidonet", host: "node.example.org:24555" },
+				{ address: "1:387/3@fidonet" },
+			],
+		}],
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct{ host, link string }{
+		{"hub.example.org:24554", "1:387/1@fidonet"},
+		{"HUB.example.org:1234", "1:387/1@fidonet"},
+		{"node.example.org:24555", "1:387/2@fidonet"},
+		{"node.example.org:24554", ""},
+		{"other.example.org:24554", ""},
+	}
+	for _, test := range tests {
+		link := c.LinkForHost(test.host)
+		got := ""
+		if link != nil {
+			got = link.Address.String()
+		}
+		if got != test.link {
+			t.Errorf("LinkForHost(%s) = %q, want %q", test.host, got, test.link)
+		}
+	}
+}
+
+func TestDirectLink(t *testing.T) {
+	dataDir := t.TempDir()
+	c, err := ParseFromString(`{
+		dataDir: "` + dataDir + `",
+		nets: [{ name: "fidonet", address: "2:5020/100@fidonet" }],
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := ftntest.Addresses(t, "2:5020/1", "2:5020/1.7@FidoNet")
+	for _, addr := range addrs {
+		link, err := c.DirectLink(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if link.Password != "" {
+			t.Errorf("direct link for %v has a password", addr)
+		}
+		if got, want := link.InSpool.Dir(), path.Join(dataDir, InsecureDir); got != want {
+			t.Errorf("direct link for %v receives into %q, want %q", addr, got, want)
+		}
+		if err := link.OutSpool.Create(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := c.DirectAddresses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(addrs) {
+		t.Fatalf("DirectAddresses() = %v, want %v", got, addrs)
+	}
+	for i := range got {
+		if !got[i].Equal(addrs[i]) {
+			t.Errorf("DirectAddresses()[%d] = %v, want %v", i, got[i], addrs[i])
+		}
+	}
+	c.InsecureIn = spool.New("/elsewhere")
+	if link, _ := c.DirectLink(addrs[0]); link.InSpool.Dir() != "/elsewhere" {
+		t.Errorf("direct link ignores insecureIn: %q", link.InSpool.Dir())
+	}
+	c.DataDir = ""
+	if _, err := c.DirectLink(addrs[0]); err == nil {
+		t.Error("DirectLink succeeded without a data directory")
+	}
+}
+
+func TestCharsetIn(t *testing.T) {
+	c, err := ParseFromString(`{
+		nets: [
+			{ name: "fidonet", address: "2:5020/100@fidonet", charset: "cp866" },
+			{ name: "fsxnet", address: "21:1/100@fsxnet" },
+		],
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.CharsetIn(&c.Nets[0]); got != charset.CP866 {
+		t.Errorf("fidonet charset %v, want CP866", got)
+	}
+	if got := c.CharsetIn(&c.Nets[1]); got != charset.Default {
+		t.Errorf("fsxnet charset %v, want the default", got)
+	}
+	if got := c.CharsetIn(nil); got != charset.Default {
+		t.Errorf("charset with no net %v, want the default", got)
+	}
+	if _, err := ParseFromString(`{ nets: [{ name: "x", address: "1:1/1", charset: "klingon" }] }`); err == nil {
+		t.Error("accepted an unknown charset")
+	}
+}
+
+func TestAreas(t *testing.T) {
+	c, err := ParseFromString(`{
+		nets: [{
+			name: "fidonet",
+			address: "1:387/108@fidonet",
+			areas: [
+				{ tag: "FIDOTEST", links: ["1:387/1@fidonet", "1:387/108.1"], dir: "/bbs/fidotest", base: "jam:/bbs/msgs/fidotest" },
+			],
+		}],
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	net := &c.Nets[0]
+	area := net.Area("fidotest")
+	if area == nil || area.Tag != "FIDOTEST" || area.Dir != "/bbs/fidotest" || area.Base != "jam:/bbs/msgs/fidotest" {
+		t.Fatalf("Area(fidotest) = %+v", area)
+	}
+	for _, addr := range []string{"1:387/1", "1:387/108.1@fidonet"} {
+		if !area.Linked(ftntest.Address(t, addr)) {
+			t.Errorf("%s not linked to FIDOTEST", addr)
+		}
+	}
+	if area.Linked(ftntest.Address(t, "1:387/2")) {
+		t.Error("1:387/2 linked to FIDOTEST")
+	}
+	if net.Area("NOSUCH") != nil {
+		t.Error("found an unknown area")
+	}
+}
+
+func TestRoute(t *testing.T) {
+	c, err := ParseFromString(`{
+		nets: [{
+			name: "fidonet",
+			address: "1:387/108@fidonet",
+			routes: [
+				{ to: "1:387/*", via: "direct" },
+				{ to: "1:*", via: "net" },
+				{ to: "2:*", via: "zone" },
+				{ to: "*", via: "1:387/1" },
+			],
+		}, {
+			name: "fsxnet",
+			address: "21:1/100@fsxnet",
+		}],
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		dest, want string
+	}{
+		{"1:387/2.5", "1:387/2@fidonet"},
+		{"1:123/45", "1:123/0@fidonet"},
+		{"2:5020/1@fidonet", "2:2/0@fidonet"},
+		{"3:633/280", "1:387/1@fidonet"},
+		{"21:1/1@fsxnet", ""},
+	}
+	for _, test := range tests {
+		dest := ftntest.Address(t, test.dest)
+		got, ok := c.Route(dest)
+		if test.want == "" {
+			if ok {
+				t.Errorf("Route(%s) = %v, want none", test.dest, got)
+			}
+			continue
+		}
+		if want := ftntest.Address(t, test.want); !ok || !got.Equal(want) {
+			t.Errorf("Route(%s) = %v, %v; want %v", test.dest, got, ok, want)
+		}
+	}
+	if _, err := ParseFromString(`{ nets: [{ routes: [{ to: "*", via: "nowhere" }] }] }`); err == nil {
+		t.Error("parsed a route via nowhere")
+	}
+}
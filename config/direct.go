@@ -0,0 +1,106 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"fat-dragon.org/ginko/ftn"
+	"fat-dragon.org/ginko/spool"
+)
+
+// DirectDir is the directory, within the data directory, that
+// holds outbound spools for systems we have no link with.
+const DirectDir = "direct"
+
+// InsecureDir is the directory, within the data directory, that
+// receives files from systems we have no link with, unless
+// `insecureIn` is configured.
+const InsecureDir = "insecure"
+
+// DirectLink returns a link for delivering mail directly to a
+// system we have no configured link with, such as crash netmail.
+// The link has no password, so sessions with it are unsecured.
+// Its outbound spool is kept in the data directory, and files
+// received from it go to the insecure inbound spool.  It belongs
+// to the net in the same domain, if any.
+func (c *Config) DirectLink(addr ftn.Address) (*Link, error) {
+	if c.DataDir == "" {
+		return nil, errors.New("direct delivery requires a data directory")
+	}
+	in := c.InsecureIn
+	if in.Dir() == "" {
+		in = spool.New(path.Join(c.DataDir, InsecureDir))
+	}
+	link := &Link{
+		Address:  addr,
+		InSpool:  in,
+		OutSpool: spool.New(path.Join(c.DataDir, DirectDir, directName(c.Canonical(addr)))),
+	}
+	link.LinkedNet = c.netFor(addr)
+	return link, nil
+}
+
+// DirectAddresses returns the addresses of the systems for which
+// direct outbound spools exist.
+func (c *Config) DirectAddresses() ([]ftn.Address, error) {
+	if c.DataDir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(path.Join(c.DataDir, DirectDir))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var addrs []ftn.Address
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		addr, err := parseDirectName(entry.Name())
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// directName returns the name of the directory holding the
+// direct spool for an address, such as `2.5020.1.0@fidonet`.
+// Addresses contain characters that are awkward in file names,
+// so the components are separated with dots.
+func directName(addr ftn.Address) string {
+	addr = addr.Canonical()
+	name := fmt.Sprintf("%d.%d.%d.%d", addr.Zone(), addr.Net(), addr.Node(), addr.Point())
+	if addr.Domain() != "" {
+		name += "@" + string(addr.Domain())
+	}
+	return name
+}
+
+func parseDirectName(name string) (ftn.Address, error) {
+	body, domain, _ := strings.Cut(name, "@")
+	parts := strings.Split(body, ".")
+	if len(parts) != 4 {
+		return ftn.Address{}, fmt.Errorf("malformed direct spool name %q", name)
+	}
+	var nums [4]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return ftn.Address{}, fmt.Errorf("malformed direct spool name %q", name)
+		}
+		nums[i] = n
+	}
+	text := fmt.Sprintf("%d:%d/%d.%d", nums[0], nums[1], nums[2], nums[3])
+	if domain != "" {
+		text += "@" + domain
+	}
+	return ftn.ParseAddress(text)
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"fat-dragon.org/ginko/ftn"
+)
+
+// Route is a netmail routing rule: netmail for addresses that
+// match To is sent on to the next hop given by Via.  A net's
+// routes are tried in order, so a final route to `*` serves as
+// its default route.
+type Route struct {
+	To  ftn.Pattern `json:"to"`
+	Via Via         `json:"via"`
+}
+
+// Via is the next hop of a route.  It is written as `direct`,
+// for the destination itself (its boss node, for a point); `net`
+// or `zone`, for the host of the destination's net (Z:N/0) or
+// zone (Z:Z/0); or as the address of a hub or uplink.
+type Via struct {
+	host    string
+	address ftn.Address
+}
+
+// Hosts that a route may go via, other than a given address.
+const (
+	ViaDirect = "direct"
+	ViaNet    = "net"
+	ViaZone   = "zone"
+)
+
+// ParseVia parses the next hop of a route.
+func ParseVia(s string) (Via, error) {
+	s = strings.TrimSpace(s)
+	switch host := strings.ToLower(s); host {
+	case ViaDirect, ViaNet, ViaZone:
+		return Via{host: host}, nil
+	}
+	addr, err := ftn.ParseAddress(s)
+	if err != nil {
+		return Via{}, fmt.Errorf("invalid route via %q: %v", s, err)
+	}
+	return Via{address: addr}, nil
+}
+
+// Next returns the next hop for netmail to the given address.
+func (v Via) Next(dest ftn.Address) ftn.Address {
+	switch v.host {
+	case ViaDirect:
+		return ftn.NewAddress(dest.Zone(), dest.Net(), dest.Node(), 0, dest.Domain())
+	case ViaNet:
+		return ftn.NewAddress(dest.Zone(), dest.Net(), 0, 0, dest.Domain())
+	case ViaZone:
+		return ftn.NewAddress(dest.Zone(), ftn.Net(dest.Zone()), 0, 0, dest.Domain())
+	}
+	return v.address
+}
+
+func (v Via) String() string {
+	if v.host != "" {
+		return v.host
+	}
+	return v.address.String()
+}
+
+// Unmarshal the next hop of a route from a string in a JSON
+// stream.
+func (v *Via) UnmarshalJSON(data []byte) error {
+	var viaStr string
+	if err := json.Unmarshal(data, &viaStr); err != nil {
+		return err
+	}
+	via, err := ParseVia(viaStr)
+	if err != nil {
+		return err
+	}
+	*v = via
+	return nil
+}
+
+// netFor returns the net in the same domain as the given
+// address, or nil if there is none.
+func (c *Config) netFor(addr ftn.Address) *Net {
+	domain := c.Canonical(addr).Domain()
+	for i := range c.Nets {
+		if c.Canonical(c.Nets[i].Address).Domain() == domain {
+			return &c.Nets[i]
+		}
+	}
+	return nil
+}
+
+// Route returns the next hop for netmail to the given address,
+// by the first matching route of the net in its domain.  It
+// reports false if no route matches.
+func (c *Config) Route(dest ftn.Address) (ftn.Address, bool) {
+	net := c.netFor(dest)
+	if net == nil {
+		return ftn.Address{}, false
+	}
+	for _, route := range net.Routes {
+		if route.To.Match(c.Canonical(dest)) {
+			return route.Via.Next(dest), true
+		}
+	}
+	return ftn.Address{}, false
+}
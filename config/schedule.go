@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"fat-dragon.org/ginko/spool"
+	"github.com/yosuke-furukawa/json5/encoding/json5"
+)
+
+// Window restricts a class of outbound traffic on a link to a
+// daily time range, e.g. file echoes only between 01:00 and
+// 06:00.  Windows may wrap past midnight.
+type Window struct {
+	Class spool.Class `json:"class"`
+	From  TimeOfDay   `json:"from"`
+	To    TimeOfDay   `json:"to"`
+}
+
+// TimeOfDay is a wall clock time, measured as an offset from
+// local midnight.
+type TimeOfDay time.Duration
+
+// ParseTimeOfDay parses a time of day in "15:04" form.
+func ParseTimeOfDay(s string) (TimeOfDay, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q: %v", s, err)
+	}
+	return TimeOfDay(time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute), nil
+}
+
+// Unmarshal a time of day from a string in a JSON stream.
+func (t *TimeOfDay) UnmarshalJSON(data []byte) error {
+	var timeStr string
+	if err := json5.Unmarshal(data, &timeStr); err != nil {
+		return err
+	}
+	tod, err := ParseTimeOfDay(timeStr)
+	if err != nil {
+		return err
+	}
+	*t = tod
+	return nil
+}
+
+func timeOfDay(now time.Time) TimeOfDay {
+	h, m, s := now.Clock()
+	return TimeOfDay(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second)
+}
+
+// Contains reports whether the window is open at the given time.
+func (w *Window) Contains(now time.Time) bool {
+	tod := timeOfDay(now)
+	if w.From <= w.To {
+		return w.From <= tod && tod < w.To
+	}
+	return tod >= w.From || tod < w.To
+}
+
+// Permits reports whether a file of the given class may be
+// offered on the link at the given time.  Classes with no
+// windows configured are always permitted; otherwise, at least
+// one of the class's windows must be open.
+func (l *Link) Permits(class spool.Class, now time.Time) bool {
+	restricted := false
+	for i := range l.Windows {
+		w := &l.Windows[i]
+		if w.Class != class {
+			continue
+		}
+		if w.Contains(now) {
+			return true
+		}
+		restricted = true
+	}
+	return !restricted
+}
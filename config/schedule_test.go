@@ -0,0 +1,63 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"fat-dragon.org/ginko/spool"
+)
+
+func at(hour, minute int) time.Time {
+	return time.Date(2022, 1, 1, hour, minute, 0, 0, time.Local)
+}
+
+func TestParseScheduleWindows(t *testing.T) {
+	c, err := ParseFromString(`{
+		nets: [{
+			name: "fidonet",
+			address: "1:387/108@fidonet",
+			links: [{
+				address: "1:387/1@fidonet",
+				rateLimit: 4096,
+				windows: [
+					{ class: "fileecho", from: "01:00", to: "06:00" },
+					{ class: "echomail", from: "22:00", to: "02:00" },
+				],
+			}],
+		}],
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := &c.Nets[0].Links[0]
+	if link.RateLimit != 4096 {
+		t.Errorf("rate limit: got %d want 4096", link.RateLimit)
+	}
+	tests := []struct {
+		class spool.Class
+		now   time.Time
+		want  bool
+	}{
+		{spool.ClassFileEcho, at(0, 59), false},
+		{spool.ClassFileEcho, at(1, 0), true},
+		{spool.ClassFileEcho, at(5, 59), true},
+		{spool.ClassFileEcho, at(6, 0), false},
+		{spool.ClassEchomail, at(23, 0), true},
+		{spool.ClassEchomail, at(1, 30), true},
+		{spool.ClassEchomail, at(12, 0), false},
+		{spool.ClassNetmail, at(12, 0), true},
+	}
+	for _, test := range tests {
+		if got := link.Permits(test.class, test.now); got != test.want {
+			t.Errorf("Permits(%v, %v): got %v want %v", test.class, test.now.Format("15:04"), got, test.want)
+		}
+	}
+}
+
+func TestParseTimeOfDayInvalid(t *testing.T) {
+	for _, s := range []string{"", "25:00", "1:00pm", "noon"} {
+		if _, err := ParseTimeOfDay(s); err == nil {
+			t.Errorf("ParseTimeOfDay(%q) unexpectedly succeeded", s)
+		}
+	}
+}
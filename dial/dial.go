@@ -0,0 +1,179 @@
+// Package dial finds and connects to the binkp service of an FTN
+// system.
+//
+// A system is reached, in order of preference, at the host given
+// in its link's configuration; at the hosts given by the `IBN`
+// flags of its nodelist entry, falling back to its `INA` flag or
+// system name for the host and to the default port; and finally
+// at its FTN-style DNS name, such as `f1.n5020.z2.binkp.net`.
+package dial
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"fat-dragon.org/ginko/config"
+	"fat-dragon.org/ginko/ftn"
+	"fat-dragon.org/ginko/nodelist"
+)
+
+// DefaultPort is the port assigned to binkp.
+const DefaultPort = 24554
+
+// DefaultDNSZone is the DNS zone under which FTN-style names
+// are published.
+const DefaultDNSZone = "binkp.net"
+
+// Resolver looks up the addresses of a host.  *net.Resolver
+// satisfies it; tests may substitute a local table.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// Dialer connects to FTN systems.
+type Dialer struct {
+	Nodelist nodelist.Index
+	Resolver Resolver
+	DNSZone  string        // Empty to disable FTN-style DNS names.
+	Timeout  time.Duration // Per connection attempt.
+}
+
+// New returns a dialer that consults the given nodelists and the
+// system resolver.
+func New(index nodelist.Index) *Dialer {
+	return &Dialer{index, net.DefaultResolver, DefaultDNSZone, 30 * time.Second}
+}
+
+// Candidates returns the `host:port` pairs at which the system
+// with the given address might be reached, in order of
+// preference and without resolving the hosts.  The link may be
+// nil.
+func (d *Dialer) Candidates(addr ftn.Address, link *config.Link) []string {
+	var candidates []string
+	add := func(host string, port int) {
+		if host == "" {
+			return
+		}
+		if port == 0 {
+			port = DefaultPort
+		}
+		hostPort := net.JoinHostPort(host, strconv.Itoa(port))
+		for _, c := range candidates {
+			if c == hostPort {
+				return
+			}
+		}
+		candidates = append(candidates, hostPort)
+	}
+	if link != nil && link.Host != "" {
+		host, port := splitHostPort(link.Host)
+		add(host, port)
+	}
+	if e := d.Nodelist.Lookup(addr); e != nil && e.Reachable() {
+		defaultHost := ""
+		if inas := e.Internet("INA"); len(inas) > 0 {
+			defaultHost = inas[0].Host
+		} else if looksLikeHost(e.System) {
+			defaultHost = e.System
+		}
+		for _, ibn := range e.Internet("IBN") {
+			host := ibn.Host
+			if host == "" {
+				host = defaultHost
+			}
+			add(host, ibn.Port)
+		}
+	}
+	add(d.dnsName(addr), 0)
+	return candidates
+}
+
+// dnsName returns the FTN-style DNS name of an address, or the
+// empty string if it has none.
+func (d *Dialer) dnsName(addr ftn.Address) string {
+	if d.DNSZone == "" || addr.Zone() == 0 {
+		return ""
+	}
+	name := fmt.Sprintf("f%d.n%d.z%d.%s", addr.Node(), addr.Net(), addr.Zone(), d.DNSZone)
+	if addr.Point() != 0 {
+		name = fmt.Sprintf("p%d.%s", addr.Point(), name)
+	}
+	return name
+}
+
+// Targets resolves the candidates for a system to network
+// addresses, in order of preference.  Candidates that do not
+// resolve are skipped, as are addresses already listed.
+func (d *Dialer) Targets(ctx context.Context, addr ftn.Address, link *config.Link) ([]string, error) {
+	var targets []string
+	seen := make(map[string]bool)
+	for _, candidate := range d.Candidates(addr, link) {
+		host, port, _ := net.SplitHostPort(candidate)
+		ips := []string{host}
+		if net.ParseIP(host) == nil {
+			var err error
+			if ips, err = d.Resolver.LookupHost(ctx, host); err != nil {
+				continue
+			}
+		}
+		for _, ip := range ips {
+			if target := net.JoinHostPort(ip, port); !seen[target] {
+				seen[target] = true
+				targets = append(targets, target)
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no way to reach %v", addr)
+	}
+	return targets, nil
+}
+
+// Dial connects to the system with the given address, trying
+// each target in turn.  The link may be nil.
+func (d *Dialer) Dial(ctx context.Context, addr ftn.Address, link *config.Link) (net.Conn, error) {
+	targets, err := d.Targets(ctx, addr, link)
+	if err != nil {
+		return nil, err
+	}
+	dialer := net.Dialer{Timeout: d.Timeout}
+	var errs []string
+	for _, target := range targets {
+		conn, err := dialer.DialContext(ctx, "tcp", target)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return nil, fmt.Errorf("cannot reach %v: %s", addr, strings.Join(errs, "; "))
+}
+
+// splitHostPort splits an optional port from a host.
+func splitHostPort(hostPort string) (string, int) {
+	host, portStr, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return strings.Trim(hostPort, "[]"), 0
+	}
+	port, _ := strconv.Atoi(portStr)
+	return host, port
+}
+
+// looksLikeHost reports whether a nodelist system name is a host
+// name, as some systems list theirs in place of an INA flag.
+func looksLikeHost(name string) bool {
+	if !strings.Contains(name, ".") || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '.', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
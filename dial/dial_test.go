@@ -0,0 +1,145 @@
+package dial
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"fat-dragon.org/ginko/config"
+	"fat-dragon.org/ginko/ftn/ftntest"
+	"fat-dragon.org/ginko/nodelist"
+)
+
+const testList = ";A Test Nodelist for Friday, April 8, 2022 -- Day number 098 : 12345\r\n" +
+	"Zone,2,Europe,Somewhere,Zone_Coordinator,-Unpublished-,300,CM\r\n" +
+	"Host,5020,Moscow_Net,Moscow,Net_Host,-Unpublished-,300,CM,IBN:24555,INA:host.example.ru\r\n" +
+	",1,bbs.example.ru,Moscow,A_Sysop,-Unpublished-,300,IBN\r\n" +
+	",2,Two_Ports,Moscow,B_Sysop,-Unpublished-,300,IBN:one.example.ru,IBN:[2001:db8::1]:24556\r\n" +
+	",3,No_Binkp,Moscow,C_Sysop,-Unpublished-,300,INA:three.example.ru\r\n" +
+	"Down,4,Down_Node,Moscow,D_Sysop,-Unpublished-,300,IBN:down.example.ru\r\n"
+
+// table is a resolver that looks hosts up in a map.
+type table map[string][]string
+
+func (t table) LookupHost(_ context.Context, host string) ([]string, error) {
+	if addrs, ok := t[host]; ok {
+		return addrs, nil
+	}
+	return nil, errors.New("no such host: " + host)
+}
+
+func testDialer(t *testing.T, resolver Resolver) *Dialer {
+	list, err := nodelist.Parse(strings.NewReader(testList), "fidonet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(nodelist.Index{list})
+	d.Resolver = resolver
+	return d
+}
+
+func TestCandidates(t *testing.T) {
+	d := testDialer(t, table{})
+	tests := []struct {
+		addr string
+		host string
+		want []string
+	}{
+		{"2:5020/0", "", []string{"host.example.ru:24555", "f0.n5020.z2.binkp.net:24554"}},
+		{"2:5020/1", "", []string{"bbs.example.ru:24554", "f1.n5020.z2.binkp.net:24554"}},
+		{"2:5020/2", "", []string{"one.example.ru:24554", "[2001:db8::1]:24556", "f2.n5020.z2.binkp.net:24554"}},
+		{"2:5020/3", "", []string{"f3.n5020.z2.binkp.net:24554"}},
+		{"2:5020/4", "", []string{"f4.n5020.z2.binkp.net:24554"}},
+		{"2:5020/1.7", "", []string{"p7.f1.n5020.z2.binkp.net:24554"}},
+		{"2:5020/1", "link.example.org", []string{"link.example.org:24554", "bbs.example.ru:24554", "f1.n5020.z2.binkp.net:24554"}},
+		{"2:5020/1", "bbs.example.ru:24554", []string{"bbs.example.ru:24554", "f1.n5020.z2.binkp.net:24554"}},
+		{"2:5020/1", "[2001:db8::9]:1000", []string{"[2001:db8::9]:1000", "bbs.example.ru:24554", "f1.n5020.z2.binkp.net:24554"}},
+	}
+	for _, test := range tests {
+		a := ftntest.Address(t, test.addr)
+		var link *config.Link
+		if test.host != "" {
+			link = &config.Link{Address: a, Host: test.host}
+		}
+		if got := d.Candidates(a, link); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Candidates(%v, %q) = %q, want %q", test.addr, test.host, got, test.want)
+		}
+	}
+	d.DNSZone = ""
+	if got := d.Candidates(ftntest.Address(t, "2:5020/3"), nil); len(got) != 0 {
+		t.Errorf("Candidates without DNS zone = %q, want none", got)
+	}
+}
+
+func TestTargets(t *testing.T) {
+	d := testDialer(t, table{
+		"one.example.ru":               {"192.0.2.1", "2001:db8::2"},
+		"f2.n5020.z2.binkp.net":        {"192.0.2.1"},
+		"f9.n5020.z2.binkp.net":        {"192.0.2.9"},
+		"f1.n5020.z2.binkp.net":        nil,
+		"unrelated.example.ru":         {"192.0.2.99"},
+		"p7.f1.n5020.z2.binkp.example": {"192.0.2.7"},
+	})
+	got, err := d.Targets(context.Background(), ftntest.Address(t, "2:5020/2"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"192.0.2.1:24554", "[2001:db8::2]:24554", "[2001:db8::1]:24556"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Targets = %q, want %q", got, want)
+	}
+	if got, err := d.Targets(context.Background(), ftntest.Address(t, "2:5020/9"), nil); err != nil || !reflect.DeepEqual(got, []string{"192.0.2.9:24554"}) {
+		t.Errorf("Targets for unlisted node = %q, %v", got, err)
+	}
+	if _, err := d.Targets(context.Background(), ftntest.Address(t, "2:5020/1"), nil); err == nil {
+		t.Error("Targets succeeded with nothing resolvable")
+	}
+	d.DNSZone = "binkp.example"
+	if got, err := d.Targets(context.Background(), ftntest.Address(t, "2:5020/1.7"), nil); err != nil || !reflect.DeepEqual(got, []string{"192.0.2.7:24554"}) {
+		t.Errorf("Targets for point = %q, %v", got, err)
+	}
+}
+
+func TestDial(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+	d := testDialer(t, table{
+		"dead.example.org":      {"127.0.0.1"},
+		"f1.n5020.z2.binkp.net": {"127.0.0.1"},
+	})
+	a := ftntest.Address(t, "2:5020/1")
+	link := &config.Link{Address: a, Host: "dead.example.org:" + strconv.Itoa(closedPort)}
+	d.DNSZone = ""
+	if _, err := d.Dial(context.Background(), a, link); err == nil {
+		t.Fatal("Dial succeeded with no listener")
+	}
+	link.Host = "127.0.0.1:" + strconv.Itoa(port)
+	conn, err := d.Dial(context.Background(), a, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
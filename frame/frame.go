@@ -231,6 +231,11 @@ func (c *BusyCmd) String() string {
 	return "BUSY " + c.text
 }
 
+// NewBusy returns a new BusyCmd with the given text.
+func NewBusy(text string) *BusyCmd {
+	return &BusyCmd{text}
+}
+
 // GetCmd represnts a request for a file transfer from the
 // distant end.
 type GetCmd struct {
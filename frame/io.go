@@ -267,8 +267,15 @@ func (d *Data) WriteBytes(out io.Writer) error {
 
 // ReadDataFrameFrom reads data from a file and copies it into a Data frame.
 func ReadDataFrameFrom(in io.Reader, offset int64) (*Data, error) {
+	return ReadDataFrameSize(in, MaxFrameSize)
+}
+
+// ReadDataFrameSize reads at most size bytes of data from a file and
+// copies it into a Data frame.  Sizes larger than MaxFrameSize are
+// clamped.
+func ReadDataFrameSize(in io.Reader, size int) (*Data, error) {
 	b := bytes.NewBuffer([]byte{})
-	_, err := io.CopyN(b, in, MaxFrameSize)
+	_, err := io.CopyN(b, in, int64(min(size, MaxFrameSize)))
 	if err != nil && err != io.EOF {
 		return nil, err
 	}
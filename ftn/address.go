@@ -3,7 +3,6 @@ package ftn
 import (
 	"encoding/json"
 	"fmt"
-	"strconv"
 	"strings"
 )
 
@@ -47,6 +46,12 @@ func NewAddress2d(net Net, node Node) Address {
 	return Address{0, net, node, 0, ""}
 }
 
+// Returns the 2D part of an address, its net and node, as used in
+// packed messages and in SEEN-BY and PATH lines.
+func NetNode(a Address) Address {
+	return NewAddress2d(a.net, a.node)
+}
+
 // Returns a string containing the "5-dimensional"
 // representation of an FTN address.  If the point,
 // domain, or zone portions are missing, they are
@@ -118,6 +123,31 @@ func (a Address) String2d() string {
 	return fmt.Sprintf("%d/%d", a.net, a.node)
 }
 
+// Reports whether two addresses belong to the same network:
+// they are in the same zone and, if both have a known domain,
+// the domains agree.  A missing zone matches any zone, so that
+// 2D addresses compare by domain alone.
+func (a Address) SameNetwork(b Address) bool {
+	a, b = a.Canonical(), b.Canonical()
+	if a.zone != 0 && b.zone != 0 && a.zone != b.zone {
+		return false
+	}
+	if a.domain != "" && b.domain != "" && a.domain != b.domain {
+		return false
+	}
+	return true
+}
+
+// Limits on address components.  Nodelists and packet headers
+// hold them in 16 bits, and in practice they are non-negative
+// signed values.
+const (
+	MaxZone  = 32767
+	MaxNet   = 32767
+	MaxNode  = 32767
+	MaxPoint = 32767
+)
+
 // Parses the string representation of an address and
 // returns the resulting Address object.  Returns an
 // empty address and an error if the address is
@@ -125,62 +155,98 @@ func (a Address) String2d() string {
 //
 // Accepts a number of valid syntaxes:
 //
-//  zone:net/node.point@domain  (5d)
-//  zone:net/node@domain        (5d, no point)
-//  zone:net/nodd.point         (4d)
-//  zone:net/node               (3d)
-//  net/node                    (2d)
+//	zone:net/node.point@domain  (5d)
+//	zone:net/node@domain        (5d, no point)
+//	zone:net/nodd.point         (4d)
+//	zone:net/node               (3d)
+//	net/node                    (2d)
 //
+// Parsing is strict: each component must be a decimal number
+// within range, the zone may not be 0, and the domain may
+// contain only letters, digits, `-` and `_`.  Leading and
+// trailing space is ignored.
 func ParseAddress(address string) (Address, error) {
 	addr := strings.TrimSpace(address)
-	zoneStr, addr1, hasZone := splitOn(addr, ":")
+	body, domain, hasDomain := splitOn(addr, "@")
+	if hasDomain && !validDomain(domain) {
+		return emptyAddress(), fmt.Errorf("invalid domain in %q", address)
+	}
+	zoneStr, rest, hasZone := splitOn(body, ":")
 	if !hasZone {
-		addr1 = zoneStr
+		rest = zoneStr
 	}
-	netStr, addr2, _ := splitOn(addr1, "/")
-	nodeStr, pointStr, hasPoint := splitOn(addr2, ".")
-	domain := ""
-	hasDomain := false
-	if !hasPoint {
-		nodeStr, domain, hasDomain = splitOn(nodeStr, "@")
-	} else {
-		pointStr, domain, hasDomain = splitOn(pointStr, "@")
+	netStr, rest, hasNode := splitOn(rest, "/")
+	if !hasNode {
+		return emptyAddress(), fmt.Errorf("missing node in %q", address)
 	}
+	nodeStr, pointStr, hasPoint := splitOn(rest, ".")
 
 	zone := 0
 	var err error
 	if hasZone {
-		zone, err = strconv.Atoi(zoneStr)
+		zone, err = parseComponent(zoneStr, 1, MaxZone)
 		if err != nil {
 			return emptyAddress(), fmt.Errorf("invalid zone in %q: %v", address, err)
 		}
 	}
 
-	net, err := strconv.Atoi(netStr)
+	net, err := parseComponent(netStr, 0, MaxNet)
 	if err != nil {
 		return emptyAddress(), fmt.Errorf("invalid net in %q: %v", address, err)
 	}
 
-	node, err := strconv.Atoi(nodeStr)
+	node, err := parseComponent(nodeStr, 0, MaxNode)
 	if err != nil {
 		return emptyAddress(), fmt.Errorf("invalid node in %q: %v", address, err)
 	}
 
 	point := 0
 	if hasPoint {
-		point, err = strconv.Atoi(pointStr)
+		point, err = parseComponent(pointStr, 0, MaxPoint)
 		if err != nil {
 			return emptyAddress(), fmt.Errorf("invalid point in %q: %v", address, err)
 		}
 	}
 
-	if hasDomain {
-		if domain == "" {
-			return emptyAddress(), fmt.Errorf("invalid domain in %q", address)
+	return Address{Zone(zone), Net(net), Node(node), Point(point), Domain(domain)}, nil
+}
+
+// Parses a decimal address component, which must lie within
+// the given bounds.  Signs, spaces and other characters are
+// rejected.
+func parseComponent(s string, min, max int) (int, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty value")
+	}
+	n := 0
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("invalid character %q", c)
 		}
+		n = n*10 + int(c-'0')
+		if n > max {
+			return 0, fmt.Errorf("value %s out of range", s)
+		}
+	}
+	if n < min {
+		return 0, fmt.Errorf("value %s out of range", s)
 	}
+	return n, nil
+}
 
-	return Address{Zone(zone), Net(net), Node(node), Point(point), Domain(domain)}, nil
+// Reports whether s is a syntactically valid domain.
+func validDomain(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
 }
 
 // Splits a string on `sep`.  Returns the token before `sep`,
@@ -204,6 +270,74 @@ func splitOn(s string, sep string) (string, string, bool) {
 	return token, rest, found
 }
 
+// Returns the zone, or 0 if the address has none.
+func (a Address) Zone() Zone { return a.zone }
+
+// Returns the net.
+func (a Address) Net() Net { return a.net }
+
+// Returns the node.
+func (a Address) Node() Node { return a.node }
+
+// Returns the point, which is 0 for a node.
+func (a Address) Point() Point { return a.point }
+
+// Returns the domain, or the empty string if the address has
+// none.
+func (a Address) Domain() Domain { return a.domain }
+
+// Compares two addresses, returning -1, 0 or 1 as `a` sorts
+// before, the same as, or after `b`.  Addresses are ordered by
+// zone, net, node and point, and then by canonical domain, an
+// unknown domain sorting first.
+//
+// Compare is a strict total order, for sorting: it returns 0
+// only for addresses with the same canonical form.  It is not
+// Equal, under which an address whose domain is unknown is the
+// same system as one in any domain; such addresses sort apart.
+func (a Address) Compare(b Address) int {
+	a, b = a.Canonical(), b.Canonical()
+	switch {
+	case a.zone != b.zone:
+		return compareInts(int(a.zone), int(b.zone))
+	case a.net != b.net:
+		return compareInts(int(a.net), int(b.net))
+	case a.node != b.node:
+		return compareInts(int(a.node), int(b.node))
+	case a.point != b.point:
+		return compareInts(int(a.point), int(b.point))
+	}
+	return strings.Compare(string(a.domain), string(b.domain))
+}
+
+func compareInts(a, b int) int {
+	if a < b {
+		return -1
+	}
+	return 1
+}
+
+// Marshal an FTN address as text, so that addresses may be used
+// as keys in JSON maps.
+func (a Address) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// Unmarshal an FTN address from text.
+func (a *Address) UnmarshalText(text []byte) error {
+	addr, err := ParseAddress(string(text))
+	if err != nil {
+		return err
+	}
+	*a = addr
+	return nil
+}
+
+// Marshal an FTN address as a string in a JSON stream.
+func (a Address) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
 // Unmarshal an FTN address from a string in a JSON stream.  We
 // parse the address from the string and return that.
 func (a *Address) UnmarshalJSON(data []byte) error {
@@ -211,10 +345,11 @@ func (a *Address) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, &addrStr); err != nil {
 		return err
 	}
-	addr, err := ParseAddress(addrStr)
-	if err != nil {
-		return err
-	}
-	*a = addr
-	return nil
+	return a.UnmarshalText([]byte(addrStr))
+}
+
+// Set parses an address from a command line flag, implementing
+// flag.Value.
+func (a *Address) Set(s string) error {
+	return a.UnmarshalText([]byte(s))
 }
@@ -1,6 +1,12 @@
 package ftn
 
-import "testing"
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"reflect"
+	"testing"
+)
 
 func TestAddressString5dNoPoint(t *testing.T) {
 	stringTest(NewAddress(21, 100, 198, 0, "fsxnet"), "21:100/198@fsxnet", t)
@@ -109,3 +115,112 @@ func parseInvalidTest(addr string, t *testing.T) {
 		t.Errorf("Unexpected success parsing %q got %q", addr, address.String())
 	}
 }
+
+func TestSameNetwork(t *testing.T) {
+	fido := NewAddress(1, 387, 108, 0, "fidonet")
+	tests := []struct {
+		b    Address
+		same bool
+	}{
+		{NewAddress(1, 387, 1, 0, "fidonet"), true},
+		{NewAddress(1, 1, 1, 0, "FidoNet"), true},
+		{NewAddress3d(1, 387, 1), true},
+		{NewAddress2d(387, 1), true},
+		{NewAddress(2, 5020, 1, 0, "fidonet"), false},
+		{NewAddress(1, 387, 1, 0, "othernet"), false},
+		{NewAddress(21, 1, 100, 0, "fsxnet"), false},
+	}
+	for _, test := range tests {
+		if got := fido.SameNetwork(test.b); got != test.same {
+			t.Errorf("%v.SameNetwork(%v) = %v, want %v", fido, test.b, got, test.same)
+		}
+	}
+}
+
+func TestParseStrict(t *testing.T) {
+	parseInvalidTest("1:2/3.4.5", t)
+	parseInvalidTest("1:2/3@fidonet junk", t)
+	parseInvalidTest("1:2/3@fido@net", t)
+	parseInvalidTest("1:2/3@fido.net", t)
+	parseInvalidTest("0:2/3", t)
+	parseInvalidTest("32768:2/3", t)
+	parseInvalidTest("1:32768/3", t)
+	parseInvalidTest("1:2/99999999999999999999", t)
+	parseInvalidTest("1:2/3.32768", t)
+	parseInvalidTest("+1:2/3", t)
+	parseInvalidTest("1:-2/3", t)
+	parseInvalidTest("1: 2/3", t)
+	parseTest(" 1:2/3@fidonet ", "1:2/3@fidonet", t)
+	parseTest("32767:32767/32767.32767@x_y-z", "32767:32767/32767.32767@x_y-z", t)
+}
+
+func TestAccessors(t *testing.T) {
+	a := NewAddress(21, 100, 198, 1, "fsxnet")
+	if a.Zone() != 21 || a.Net() != 100 || a.Node() != 198 || a.Point() != 1 || a.Domain() != "fsxnet" {
+		t.Errorf("unexpected components of %v", a)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	ordered := []string{"387/1", "1:1/1", "1:387/1", "1:387/1.1", "1:387/2", "2:1/1", "99:1/1@a", "99:1/1@b"}
+	for i, as := range ordered {
+		for j, bs := range ordered {
+			a, b := mustAddress(t, as), mustAddress(t, bs)
+			want := 0
+			if i < j {
+				want = -1
+			} else if i > j {
+				want = 1
+			}
+			if got := a.Compare(b); got != want {
+				t.Errorf("%s.Compare(%s) = %d, want %d", as, bs, got, want)
+			}
+		}
+	}
+	if c := mustAddress(t, "1:387/1").Compare(mustAddress(t, "1:387/1@FidoNet")); c != 0 {
+		t.Errorf("canonically equal addresses compare %d", c)
+	}
+	// Equal is looser than Compare.
+	a, b := mustAddress(t, "99:1/1"), mustAddress(t, "99:1/1@a")
+	if !a.Equal(b) || a.Compare(b) != -1 {
+		t.Errorf("%v and %v: Equal %v, Compare %d", a, b, a.Equal(b), a.Compare(b))
+	}
+}
+
+func TestAddressJSON(t *testing.T) {
+	m := map[Address]string{NewAddress(1, 387, 1, 0, "fidonet"): "hub"}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"1:387/1@fidonet":"hub"}` {
+		t.Errorf("Marshal = %s", data)
+	}
+	var back map[Address]string
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back, m) {
+		t.Errorf("round trip = %v, want %v", back, m)
+	}
+	var list []Address
+	if err := json.Unmarshal([]byte(`["2:5020/1.3@fidonet"]`), &list); err != nil || len(list) != 1 || list[0].Point() != 3 {
+		t.Errorf("Unmarshal list = %v, %v", list, err)
+	}
+}
+
+func TestAddressFlag(t *testing.T) {
+	var a Address
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Var(&a, "a", "address")
+	if err := fs.Parse([]string{"-a", "1:387/1"}); err != nil {
+		t.Fatal(err)
+	}
+	if a.String() != "1:387/1" {
+		t.Errorf("flag value %v", a)
+	}
+	if err := fs.Parse([]string{"-a", "1:387"}); err == nil {
+		t.Error("bad address accepted as flag value")
+	}
+}
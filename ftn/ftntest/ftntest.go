@@ -0,0 +1,29 @@
+// Package ftntest provides helpers for tests that use FTN
+// addresses.
+package ftntest
+
+import (
+	"testing"
+
+	"fat-dragon.org/ginko/ftn"
+)
+
+// Address parses an address, failing the test if it is invalid.
+func Address(t testing.TB, s string) ftn.Address {
+	t.Helper()
+	a, err := ftn.ParseAddress(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// Addresses parses each of a list of addresses, as Address does.
+func Addresses(t testing.TB, ss ...string) []ftn.Address {
+	t.Helper()
+	addrs := make([]ftn.Address, len(ss))
+	for i, s := range ss {
+		addrs[i] = Address(t, s)
+	}
+	return addrs
+}
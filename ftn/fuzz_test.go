@@ -0,0 +1,32 @@
+package ftn
+
+import "testing"
+
+func FuzzParseAddress(f *testing.F) {
+	for _, seed := range []string{
+		"21:100/198.1@fsxnet", "1:387/1", "100/198", "1:2/3.4.5",
+		"1:2/3@fidonet junk", "32767:0/0.32767@x", ":1/1", "1:/", "@",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		a, err := ParseAddress(s)
+		if err != nil {
+			return
+		}
+		text := a.String()
+		b, err := ParseAddress(text)
+		if err != nil {
+			t.Fatalf("ParseAddress(%q) failed on String() of %q: %v", text, s, err)
+		}
+		if a != b {
+			t.Fatalf("round trip of %q: %#v != %#v", s, a, b)
+		}
+		if a.Compare(b) != 0 || !a.Equal(b) {
+			t.Fatalf("%q does not compare equal to itself", s)
+		}
+		if _, err := ParsePattern(text); err != nil {
+			t.Fatalf("address %q is not a valid pattern: %v", text, err)
+		}
+	})
+}
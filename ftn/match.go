@@ -0,0 +1,77 @@
+package ftn
+
+import "strings"
+
+// ZoneDomains maps zones to the domains of the networks that use
+// them, so that addresses lacking a domain can be given one.
+// Configurations keep their own table, adding the zones of the
+// networks they are in to those of FidoNet.
+type ZoneDomains map[Zone]Domain
+
+// The zones of FidoNet, 1 through 6, which every table holds.
+var fidoNetZones = ZoneDomains{
+	1: "fidonet",
+	2: "fidonet",
+	3: "fidonet",
+	4: "fidonet",
+	5: "fidonet",
+	6: "fidonet",
+}
+
+// Records that addresses in the given zone belong to the given
+// domain, unless the zone is already known.  Othernets often
+// reuse zone numbers, so the first addition wins.
+func (z ZoneDomains) Add(zone Zone, domain Domain) {
+	if zone == 0 || domain == "" {
+		return
+	}
+	if _, ok := z.Domain(zone); !ok {
+		z[zone] = Domain(strings.ToLower(string(domain)))
+	}
+}
+
+// Returns the domain for the given zone, and whether it is known.
+// The FidoNet zones are always known.
+func (z ZoneDomains) Domain(zone Zone) (Domain, bool) {
+	if domain, ok := fidoNetZones[zone]; ok {
+		return domain, true
+	}
+	domain, ok := z[zone]
+	return domain, ok
+}
+
+// Returns the canonical form of an address: the domain is folded
+// to lower case, and a missing domain is filled in from the zone
+// if the table knows it.  Canonical addresses compare equal with
+// `==` and may be used as map keys.
+func (z ZoneDomains) Canonical(a Address) Address {
+	if a.domain == "" {
+		a.domain, _ = z.Domain(a.zone)
+	} else {
+		a.domain = Domain(strings.ToLower(string(a.domain)))
+	}
+	return a
+}
+
+// Reports whether two addresses refer to the same system, as
+// Address.Equal does, but with domains filled in from the table.
+func (z ZoneDomains) Equal(a, b Address) bool {
+	return z.Canonical(a).Equal(z.Canonical(b))
+}
+
+// Returns the canonical form of an address, as
+// ZoneDomains.Canonical does, knowing only the FidoNet zones.
+func (a Address) Canonical() Address {
+	return fidoNetZones.Canonical(a)
+}
+
+// Reports whether two addresses refer to the same system.  The
+// addresses are compared in canonical form; if the domain of
+// either remains unknown, domains are not compared.
+func (a Address) Equal(b Address) bool {
+	a, b = a.Canonical(), b.Canonical()
+	if a.domain == "" || b.domain == "" {
+		a.domain, b.domain = "", ""
+	}
+	return a == b
+}
@@ -0,0 +1,134 @@
+package ftn
+
+import "testing"
+
+func mustAddress(t *testing.T, s string) Address {
+	t.Helper()
+	a, err := ParseAddress(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestCanonical(t *testing.T) {
+	tests := []struct{ addr, canonical string }{
+		{"1:387/1", "1:387/1@fidonet"},
+		{"1:387/1.0", "1:387/1@fidonet"},
+		{"1:387/1@FidoNet", "1:387/1@fidonet"},
+		{"21:1/100", "21:1/100"},
+		{"99:1/1", "99:1/1"},
+		{"387/1", "387/1"},
+	}
+	for _, test := range tests {
+		if got := mustAddress(t, test.addr).Canonical().String(); got != test.canonical {
+			t.Errorf("Canonical(%q) = %q, want %q", test.addr, got, test.canonical)
+		}
+	}
+}
+
+func TestZoneDomains(t *testing.T) {
+	zones := make(ZoneDomains)
+	zones.Add(21, "FSXNet")
+	zones.Add(21, "othernet")
+	zones.Add(1, "notfidonet")
+	tests := []struct{ addr, canonical string }{
+		{"1:387/1", "1:387/1@fidonet"},
+		{"21:1/100", "21:1/100@fsxnet"},
+		{"21:1/100@OtherNet", "21:1/100@othernet"},
+		{"99:1/1", "99:1/1"},
+	}
+	for _, test := range tests {
+		if got := zones.Canonical(mustAddress(t, test.addr)).String(); got != test.canonical {
+			t.Errorf("Canonical(%q) = %q, want %q", test.addr, got, test.canonical)
+		}
+	}
+	if zones.Equal(mustAddress(t, "21:1/100"), mustAddress(t, "21:1/100@othernet")) {
+		t.Error("21:1/100 equals 21:1/100@othernet, though zone 21 is fsxnet")
+	}
+	if !mustAddress(t, "21:1/100").Equal(mustAddress(t, "21:1/100@othernet")) {
+		t.Error("21:1/100 does not equal 21:1/100@othernet without a table")
+	}
+	if got := mustAddress(t, "21:1/100").Canonical().String(); got != "21:1/100" {
+		t.Errorf("table leaked into Address.Canonical: %s", got)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		equal bool
+	}{
+		{"1:387/1", "1:387/1@fidonet", true},
+		{"1:387/1@FIDONET", "1:387/1@fidonet", true},
+		{"1:387/1.0@fidonet", "1:387/1@fidonet", true},
+		{"99:1/1", "99:1/1@somenet", true},
+		{"1:387/1", "1:387/1.1", false},
+		{"1:387/1@othernet", "1:387/1", false},
+		{"1:387/1", "2:387/1", false},
+	}
+	for _, test := range tests {
+		a, b := mustAddress(t, test.a), mustAddress(t, test.b)
+		if got := a.Equal(b); got != test.equal {
+			t.Errorf("%s.Equal(%s) = %v, want %v", test.a, test.b, got, test.equal)
+		}
+		if got := b.Equal(a); got != test.equal {
+			t.Errorf("%s.Equal(%s) = %v, want %v", test.b, test.a, got, test.equal)
+		}
+	}
+}
+
+func TestPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		addr    string
+		match   bool
+	}{
+		{"*", "1:387/1.5@fidonet", true},
+		{"2:*", "2:5020/1", true},
+		{"2:*", "2:5020/1.1", true},
+		{"2:*", "1:387/1", false},
+		{"1:387/*", "1:387/1", true},
+		{"1:387/*", "1:387/1.2", true},
+		{"1:387/*", "1:388/1", false},
+		{"1:387/1", "1:387/1@fidonet", true},
+		{"1:387/1", "1:387/1.2", false},
+		{"1:387/1.*", "1:387/1", true},
+		{"1:387/1.*", "1:387/1.2", true},
+		{"1:387/1.*", "1:387/2.2", false},
+		{"387/*", "1:387/1", true},
+		{"*@fidonet", "2:5020/1", true},
+		{"*@FidoNet", "1:387/1@fidonet", true},
+		{"*@fsxnet", "1:387/1", false},
+		{"1:*@fidonet", "1:387/1@othernet", false},
+	}
+	for _, test := range tests {
+		p, err := ParsePattern(test.pattern)
+		if err != nil {
+			t.Fatalf("ParsePattern(%q): %v", test.pattern, err)
+		}
+		if got := p.Match(mustAddress(t, test.addr)); got != test.match {
+			t.Errorf("%q.Match(%q) = %v, want %v", test.pattern, test.addr, got, test.match)
+		}
+	}
+}
+
+func TestPatternString(t *testing.T) {
+	for _, s := range []string{"*", "2:*", "1:387/*", "1:387/1", "1:387/1.*", "1:387/1.2@fidonet", "*@fsxnet", "387/1"} {
+		p, err := ParsePattern(s)
+		if err != nil {
+			t.Fatalf("ParsePattern(%q): %v", s, err)
+		}
+		if got := p.String(); got != s {
+			t.Errorf("ParsePattern(%q).String() = %q", s, got)
+		}
+	}
+}
+
+func TestParseInvalidPattern(t *testing.T) {
+	for _, s := range []string{"", "1:", "1:387", "1:*/1", "1:387/*.1", "1:387/x", "1:387/1@", "1:-1/1", "*@a.b"} {
+		if p, err := ParsePattern(s); err == nil {
+			t.Errorf("unexpected success parsing %q got %q", s, p)
+		}
+	}
+}
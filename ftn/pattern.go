@@ -0,0 +1,173 @@
+package ftn
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Stands for any value of a pattern component.
+const anyValue = -1
+
+// An address pattern, which may use `*` in place of the zone,
+// net, node or point, matching any value there.  A wildcard
+// matches everything below it, too, so `2:*` matches every
+// address in zone 2 and `1:387/*` every node and point in net
+// 1:387.  A pattern without a point matches only the node
+// itself, while `1:387/1.*` also matches its points.
+type Pattern struct {
+	zone   int
+	net    int
+	node   int
+	point  int
+	domain Domain
+}
+
+// Parses an address pattern.  Accepts the syntaxes accepted by
+// ParseAddress, with any component replaced by `*`, as well as
+// `*` alone (matching every address) and `*@domain` (matching
+// every address in a domain).  A pattern without a zone matches
+// any zone.
+func ParsePattern(pattern string) (Pattern, error) {
+	p := Pattern{anyValue, anyValue, anyValue, anyValue, ""}
+	body, domain, hasDomain := splitOn(strings.TrimSpace(pattern), "@")
+	if hasDomain {
+		if domain == "" || strings.ContainsAny(domain, "@:/.") {
+			return p, fmt.Errorf("invalid domain in pattern %q", pattern)
+		}
+		if domain != "*" {
+			p.domain = Domain(strings.ToLower(domain))
+		}
+	}
+	if body == "*" {
+		return p, nil
+	}
+	zoneStr, rest, hasZone := splitOn(body, ":")
+	if hasZone {
+		zone, err := patternComponent(zoneStr)
+		if err != nil {
+			return p, fmt.Errorf("invalid zone in pattern %q: %v", pattern, err)
+		}
+		p.zone = zone
+		if rest == "*" {
+			return p, nil
+		}
+	} else {
+		rest = zoneStr
+	}
+	netStr, nodeStr, hasNode := splitOn(rest, "/")
+	if !hasNode {
+		return p, fmt.Errorf("missing node in pattern %q", pattern)
+	}
+	net, err := patternComponent(netStr)
+	if err != nil {
+		return p, fmt.Errorf("invalid net in pattern %q: %v", pattern, err)
+	}
+	p.net = net
+	if net == anyValue {
+		if nodeStr != "*" {
+			return p, fmt.Errorf("node follows wildcard net in pattern %q", pattern)
+		}
+		return p, nil
+	}
+	nodeStr, pointStr, hasPoint := splitOn(nodeStr, ".")
+	node, err := patternComponent(nodeStr)
+	if err != nil {
+		return p, fmt.Errorf("invalid node in pattern %q: %v", pattern, err)
+	}
+	p.node = node
+	if node == anyValue {
+		if hasPoint {
+			return p, fmt.Errorf("point follows wildcard node in pattern %q", pattern)
+		}
+		return p, nil
+	}
+	p.point = 0
+	if hasPoint {
+		point, err := patternComponent(pointStr)
+		if err != nil {
+			return p, fmt.Errorf("invalid point in pattern %q: %v", pattern, err)
+		}
+		p.point = point
+	}
+	return p, nil
+}
+
+func patternComponent(s string) (int, error) {
+	if s == "*" {
+		return anyValue, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("negative value %d", n)
+	}
+	return n, nil
+}
+
+// Reports whether the address matches the pattern.  Domains are
+// compared in canonical form, and are ignored if the address's
+// domain is unknown.
+func (p Pattern) Match(a Address) bool {
+	a = a.Canonical()
+	if p.domain != "" && a.domain != "" && p.domain != a.domain {
+		return false
+	}
+	return matchComponent(p.zone, int(a.zone)) &&
+		matchComponent(p.net, int(a.net)) &&
+		matchComponent(p.node, int(a.node)) &&
+		matchComponent(p.point, int(a.point))
+}
+
+func matchComponent(pattern, value int) bool {
+	return pattern == anyValue || pattern == value
+}
+
+// Returns the text of the pattern, in the syntax accepted by
+// ParsePattern.
+func (p Pattern) String() string {
+	var b strings.Builder
+	switch {
+	case p.zone == anyValue && p.net == anyValue:
+		b.WriteString("*")
+	case p.net == anyValue:
+		fmt.Fprintf(&b, "%d:*", p.zone)
+	default:
+		if p.zone != anyValue {
+			fmt.Fprintf(&b, "%d:", p.zone)
+		}
+		fmt.Fprintf(&b, "%d/%s", p.net, patternString(p.node))
+		if p.node != anyValue && p.point != 0 {
+			fmt.Fprintf(&b, ".%s", patternString(p.point))
+		}
+	}
+	if p.domain != "" {
+		b.WriteString("@")
+		b.WriteString(string(p.domain))
+	}
+	return b.String()
+}
+
+func patternString(n int) string {
+	if n == anyValue {
+		return "*"
+	}
+	return strconv.Itoa(n)
+}
+
+// Unmarshal an address pattern from a string in a JSON stream.
+func (p *Pattern) UnmarshalJSON(data []byte) error {
+	var patternStr string
+	if err := json.Unmarshal(data, &patternStr); err != nil {
+		return err
+	}
+	pattern, err := ParsePattern(patternStr)
+	if err != nil {
+		return err
+	}
+	*p = pattern
+	return nil
+}
@@ -0,0 +1,236 @@
+// Package history keeps a durable record of every session in an
+// append-only file.  Each session is stored as a single line of
+// JSON, so the file can be appended to safely by several
+// processes, inspected with ordinary text tools, and truncated
+// or rotated by an administrator without special tooling.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"fat-dragon.org/ginko/ftn"
+	"fat-dragon.org/ginko/proto/session"
+)
+
+// FileName is the name of the history file within the data
+// directory.
+const FileName = "history.jsonl"
+
+// File records a file exchanged during a session.
+type File struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	Offset   int64  `json:"offset,omitempty"`
+	Bytes    int64  `json:"bytes"`
+	CRC      string `json:"crc,omitempty"`
+	Complete bool   `json:"complete"`
+}
+
+// Record is the history of a single session.
+type Record struct {
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	Role          string    `json:"role"`
+	RemoteAddr    string    `json:"remoteAddr"`
+	Addresses     []string  `json:"addresses,omitempty"`
+	Unsecured     []string  `json:"unsecured,omitempty"`
+	Link          string    `json:"link,omitempty"`
+	System        string    `json:"system,omitempty"`
+	Sysop         string    `json:"sysop,omitempty"`
+	Location      string    `json:"location,omitempty"`
+	Version       string    `json:"version,omitempty"`
+	Mailer        string    `json:"mailer,omitempty"`
+	Authenticated bool      `json:"authenticated"`
+	Result        string    `json:"result"`
+	Sent          []File    `json:"sent,omitempty"`
+	Received      []File    `json:"received,omitempty"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// FromResult builds a history record from a session result.
+func FromResult(r *session.SessionResult) *Record {
+	rec := &Record{
+		Start:         r.Start,
+		End:           r.End,
+		Role:          r.Role.String(),
+		RemoteAddr:    r.RemoteAddr,
+		Authenticated: r.Authenticated(),
+		Result:        r.Outcome(),
+		Sent:          files(r.Sent),
+		Received:      files(r.Received),
+	}
+	for _, addr := range r.RemoteAddrs {
+		rec.Addresses = append(rec.Addresses, addr.String())
+	}
+	for _, addr := range r.Unsecured {
+		rec.Unsecured = append(rec.Unsecured, addr.String())
+	}
+	if r.Link != nil {
+		rec.Link = r.Link.Address.String()
+	}
+	rec.System = r.Remote.System
+	rec.Sysop = r.Remote.Sysop
+	rec.Location = r.Remote.Location
+	rec.Version = r.Remote.Version
+	rec.Mailer = r.Remote.Mailer
+	if r.Err != nil {
+		rec.Error = r.Err.Error()
+	}
+	return rec
+}
+
+func files(stats []session.FileStats) []File {
+	var fs []File
+	for _, f := range stats {
+		file := File{
+			Name:     f.FileName,
+			Size:     f.Size,
+			Offset:   f.Offset,
+			Bytes:    f.Bytes,
+			Complete: f.Complete,
+		}
+		if f.Complete && f.Offset == 0 {
+			file.CRC = fmt.Sprintf("%08x", f.CRC)
+		}
+		fs = append(fs, file)
+	}
+	return fs
+}
+
+// Store is a session history file.
+type Store struct {
+	fileName string
+}
+
+// Open returns the history store in the given data directory.
+// The file is created on first append.
+func Open(dataDir string) *Store {
+	return &Store{path.Join(dataDir, FileName)}
+}
+
+// Append durably adds a record to the end of the history.  The
+// record is written with a single write to a file opened for
+// appending, under an advisory lock, so records from concurrent
+// sessions and processes are never interleaved.
+func (s *Store) Append(rec *Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	f, err := os.OpenFile(s.fileName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0660)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// Query returns up to limit of the most recent records matching
+// the filter, newest first.  A nil filter matches every record,
+// and a limit of zero or less means no limit.  Lines that cannot
+// be parsed, such as one torn by a crash mid-write, are skipped.
+func (s *Store) Query(filter func(*Record) bool, limit int) ([]*Record, error) {
+	f, err := os.Open(s.fileName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var records []*Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		if filter != nil && !filter(&rec) {
+			continue
+		}
+		records = append(records, &rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	if limit > 0 && len(records) > limit {
+		records = records[:limit]
+	}
+	return records, nil
+}
+
+// ForLink returns a filter matching sessions with the given link,
+// or in which the distant end presented the given address.
+// Addresses are compared as by ftn.Address.Equal.
+func ForLink(addr ftn.Address) func(*Record) bool {
+	matches := func(s string) bool {
+		a, err := ftn.ParseAddress(s)
+		return err == nil && a.Equal(addr)
+	}
+	return func(rec *Record) bool {
+		if matches(rec.Link) {
+			return true
+		}
+		for _, a := range rec.Addresses {
+			if matches(a) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (r *Record) String() string {
+	var b strings.Builder
+	who := r.Link
+	if who == "" {
+		who = strings.Join(r.Addresses, ",")
+	}
+	fmt.Fprintf(&b, "%s %-8s %-21s %-24s %-12s sent %d/%d recv %d/%d",
+		r.Start.Local().Format("2006-01-02 15:04:05"),
+		r.Role, r.RemoteAddr, who, r.Result,
+		completeCount(r.Sent), totalBytes(r.Sent),
+		completeCount(r.Received), totalBytes(r.Received))
+	if r.Error != "" {
+		fmt.Fprintf(&b, " (%s)", r.Error)
+	}
+	return b.String()
+}
+
+func completeCount(fs []File) int {
+	n := 0
+	for _, f := range fs {
+		if f.Complete {
+			n++
+		}
+	}
+	return n
+}
+
+func totalBytes(fs []File) int64 {
+	var n int64
+	for _, f := range fs {
+		n += f.Bytes
+	}
+	return n
+}
@@ -0,0 +1,88 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"fat-dragon.org/ginko/config"
+	"fat-dragon.org/ginko/frame"
+	"fat-dragon.org/ginko/ftn"
+	"fat-dragon.org/ginko/proto/session"
+)
+
+func TestFromResult(t *testing.T) {
+	start := time.Unix(1000, 0)
+	var remote session.RemoteInfo
+	for _, line := range []string{"SYS Hub", "ZYZ Sysop Name", "LOC Somewhere", "VER binkd/1.1a binkp/1.1", "TIME whenever"} {
+		remote.Update(frame.NewNull(line))
+	}
+	link := &config.Link{Address: ftn.NewAddress(1, 387, 1, 0, "fidonet")}
+	result := &session.SessionResult{
+		Role:        session.Receiver,
+		RemoteAddr:  "192.0.2.1:4321",
+		RemoteAddrs: []ftn.Address{ftn.NewAddress(1, 387, 1, 0, "fidonet")},
+		Remote:      remote,
+		Link:        link,
+		Start:       start,
+		End:         start.Add(time.Minute),
+		AuthTime:    time.Second,
+		Received: []session.FileStats{
+			{FileName: "a.su0", Size: 10, Bytes: 10, CRC: 0xdeadbeef, Complete: true},
+			{FileName: "b.su0", Size: 10, Offset: 5, Bytes: 5, Complete: true},
+		},
+	}
+	rec := FromResult(result)
+	if rec.Role != "receiver" || rec.Link != "1:387/1@fidonet" || rec.Result != "success" {
+		t.Errorf("unexpected record %+v", rec)
+	}
+	if rec.System != "Hub" || rec.Sysop != "Sysop Name" || rec.Location != "Somewhere" || rec.Version != "binkd/1.1a binkp/1.1" || rec.Mailer != "binkd" {
+		t.Errorf("unexpected node info %+v", rec)
+	}
+	if len(rec.Received) != 2 || rec.Received[0].CRC != "deadbeef" || rec.Received[1].CRC != "" {
+		t.Errorf("unexpected files %+v", rec.Received)
+	}
+
+	result.Err = errors.New("Password validation failed")
+	result.AuthTime = 0
+	if rec := FromResult(result); rec.Result != "auth_failure" || rec.Error == "" {
+		t.Errorf("unexpected failed record %+v", rec)
+	}
+}
+
+func TestAppendAndQuery(t *testing.T) {
+	dir := t.TempDir()
+	store := Open(dir)
+	if records, err := store.Query(nil, 0); err != nil || len(records) != 0 {
+		t.Fatalf("empty store: got %v, %v", records, err)
+	}
+	for i, link := range []string{"1:387/1@fidonet", "21:1/100@fsxnet", "1:387/1@fidonet"} {
+		rec := &Record{Start: time.Unix(int64(i), 0), Link: link, Result: "success"}
+		if err := store.Append(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A torn final line is skipped.
+	f, err := os.OpenFile(dir+"/"+FileName, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"start":"19`)
+	f.Close()
+
+	records, err := store.Query(ForLink(ftn.NewAddress3d(1, 387, 1)), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Start.Unix() != 2 || records[1].Start.Unix() != 0 {
+		t.Errorf("unexpected records %v", records)
+	}
+	records, err = store.Query(nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Start.Unix() != 2 {
+		t.Errorf("limit: unexpected records %v", records)
+	}
+}
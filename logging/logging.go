@@ -0,0 +1,209 @@
+// Package logging provides a small leveled logger that carries
+// context, such as the session a message pertains to, as
+// key/value fields.  Output is either human-readable text in
+// the style of the standard library's log package, or one JSON
+// object per line.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log message.
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses a level name, such as "debug" or "info".
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return Info, fmt.Errorf("unknown log level %q", s)
+}
+
+// Set implements flag.Value.
+func (l *Level) Set(s string) error {
+	level, err := ParseLevel(s)
+	if err != nil {
+		return err
+	}
+	*l = level
+	return nil
+}
+
+// field is a single key/value pair of context.
+type field struct {
+	key   string
+	value interface{}
+}
+
+// sink is the destination shared by a logger and all of the
+// loggers derived from it.
+type sink struct {
+	sync.Mutex
+	out   io.Writer
+	level Level
+	json  bool
+}
+
+// Logger writes leveled messages with attached context.  Loggers
+// are safe for concurrent use.
+type Logger struct {
+	sink   *sink
+	fields []field
+}
+
+// New returns a logger that writes messages at or above the
+// given level to out, as JSON if asJSON is set.
+func New(out io.Writer, level Level, asJSON bool) *Logger {
+	return &Logger{sink: &sink{out: out, level: level, json: asJSON}}
+}
+
+var defaultLogger = New(os.Stderr, Info, false)
+
+// Default returns the process-wide default logger.
+func Default() *Logger {
+	return defaultLogger
+}
+
+// SetDefault replaces the process-wide default logger.  It should
+// be called at startup, before loggers are derived from it.
+func SetDefault(l *Logger) {
+	defaultLogger = l
+}
+
+// With returns a logger that adds the given key/value pairs to
+// every message.  Keys must be strings.
+func (l *Logger) With(keyValues ...interface{}) *Logger {
+	fields := make([]field, len(l.fields), len(l.fields)+len(keyValues)/2)
+	copy(fields, l.fields)
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		key := fmt.Sprint(keyValues[i])
+		fields = append(fields, field{key, keyValues[i+1]})
+	}
+	return &Logger{sink: l.sink, fields: fields}
+}
+
+// Enabled reports whether messages at the given level are
+// written.  It is useful to avoid formatting expensive debug
+// messages.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.sink.level
+}
+
+func (l *Logger) Debug(args ...interface{}) { l.println(Debug, args) }
+func (l *Logger) Info(args ...interface{})  { l.println(Info, args) }
+func (l *Logger) Warn(args ...interface{})  { l.println(Warn, args) }
+func (l *Logger) Error(args ...interface{}) { l.println(Error, args) }
+
+func (l *Logger) Debugf(format string, args ...interface{}) { l.printf(Debug, format, args) }
+func (l *Logger) Infof(format string, args ...interface{})  { l.printf(Info, format, args) }
+func (l *Logger) Warnf(format string, args ...interface{})  { l.printf(Warn, format, args) }
+func (l *Logger) Errorf(format string, args ...interface{}) { l.printf(Error, format, args) }
+
+// println and printf format messages only if they will be
+// written; frames are logged at debug level, and formatting
+// them all would be expensive on large transfers.
+func (l *Logger) println(level Level, args []interface{}) {
+	if l.Enabled(level) {
+		l.output(level, fmt.Sprintln(args...))
+	}
+}
+
+func (l *Logger) printf(level Level, format string, args []interface{}) {
+	if l.Enabled(level) {
+		l.output(level, fmt.Sprintf(format, args...))
+	}
+}
+
+func (l *Logger) output(level Level, msg string) {
+	msg = strings.TrimSuffix(msg, "\n")
+	now := time.Now()
+	var line []byte
+	if l.sink.json {
+		line = l.formatJSON(now, level, msg)
+	} else {
+		line = l.formatText(now, level, msg)
+	}
+	l.sink.Lock()
+	defer l.sink.Unlock()
+	l.sink.out.Write(line)
+}
+
+func (l *Logger) formatText(now time.Time, level Level, msg string) []byte {
+	var b strings.Builder
+	b.WriteString(now.Format("2006/01/02 15:04:05 "))
+	b.WriteString(strings.ToUpper(level.String()))
+	if len(l.fields) > 0 {
+		b.WriteString(" [")
+		for i, f := range l.fields {
+			if i > 0 {
+				b.WriteString(" ")
+			}
+			fmt.Fprintf(&b, "%s=%v", f.key, f.value)
+		}
+		b.WriteString("]")
+	}
+	b.WriteString(" ")
+	b.WriteString(msg)
+	b.WriteString("\n")
+	return []byte(b.String())
+}
+
+func (l *Logger) formatJSON(now time.Time, level Level, msg string) []byte {
+	// Build the object by hand to keep a stable key order:
+	// time, level, context fields, then the message.
+	var b strings.Builder
+	b.WriteString("{")
+	writeJSONField(&b, "time", now.Format(time.RFC3339Nano))
+	b.WriteString(",")
+	writeJSONField(&b, "level", level.String())
+	for _, f := range l.fields {
+		b.WriteString(",")
+		writeJSONField(&b, f.key, f.value)
+	}
+	b.WriteString(",")
+	writeJSONField(&b, "msg", msg)
+	b.WriteString("}\n")
+	return []byte(b.String())
+}
+
+func writeJSONField(b *strings.Builder, key string, value interface{}) {
+	k, _ := json.Marshal(key)
+	b.Write(k)
+	b.WriteString(":")
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case fmt.Stringer:
+		value = v.String()
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(value))
+	}
+	b.Write(data)
+}
@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTextOutput(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b, Info, false).With("session", 7, "role", "receiver")
+	l.Info("hello", "world")
+	line := b.String()
+	if !strings.HasSuffix(line, " INFO [session=7 role=receiver] hello world\n") {
+		t.Errorf("unexpected text output %q", line)
+	}
+}
+
+func TestLevelFiltering(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b, Warn, false)
+	l.Debug("debug")
+	l.Infof("info %d", 1)
+	if b.Len() != 0 {
+		t.Errorf("messages below level written: %q", b.String())
+	}
+	l.Errorf("error %d", 2)
+	if !strings.Contains(b.String(), "ERROR error 2") {
+		t.Errorf("error message not written: %q", b.String())
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b, Debug, true).With("session", 3, "err", errors.New("boom"))
+	l.Debug("frame", 1)
+	var record map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &record); err != nil {
+		t.Fatalf("invalid JSON %q: %v", b.String(), err)
+	}
+	if record["level"] != "debug" || record["msg"] != "frame 1" || record["session"] != 3.0 || record["err"] != "boom" {
+		t.Errorf("unexpected JSON record %v", record)
+	}
+}
+
+func TestWithDoesNotAlias(t *testing.T) {
+	var b bytes.Buffer
+	base := New(&b, Info, false).With("a", 1)
+	base.With("b", 2)
+	base.Info("x")
+	if strings.Contains(b.String(), "b=2") {
+		t.Errorf("derived logger context leaked into parent: %q", b.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	var l Level
+	if err := l.Set("DEBUG"); err != nil || l != Debug {
+		t.Errorf("Set(DEBUG): got %v, %v", l, err)
+	}
+	if err := l.Set("loud"); err == nil {
+		t.Error("Set(loud) unexpectedly succeeded")
+	}
+}
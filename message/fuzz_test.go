@@ -0,0 +1,29 @@
+package message
+
+import (
+	"reflect"
+	"testing"
+
+	"fat-dragon.org/ginko/ftn"
+)
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{echomail, netmail, "", "\r", "AREA:\r", "SEEN-BY: 1/2 3\r\x01PATH: 1/2\r"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		m := Parse(text)
+		if m.String() != text {
+			t.Fatalf("Parse(%q).String() = %q", text, m.String())
+		}
+		seenBy := sortNetNodes(append(m.SeenBy, ftn.NewAddress2d(1, 1)))
+		m.AddSeenBy(ftn.NewAddress2d(1, 1))
+		if !reflect.DeepEqual(m.SeenBy, seenBy) {
+			t.Fatalf("SEEN-BY %v after adding, want %v", m.SeenBy, seenBy)
+		}
+		reparsed := Parse(m.String())
+		if !reflect.DeepEqual(reparsed.SeenBy, m.SeenBy) || !reflect.DeepEqual(reparsed.Path, m.Path) {
+			t.Fatalf("SEEN-BY or PATH changed on reparsing %q", m.String())
+		}
+	})
+}
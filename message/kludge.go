@@ -0,0 +1,156 @@
+package message
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"fat-dragon.org/ginko/ftn"
+)
+
+// KludgeLine is a control line, split into its name and value.
+// Most kludges separate the two with a colon, as in
+// "MSGID: 1:2/3 12345678"; a few, such as INTL, FMPT, TOPT and
+// Via, with a space.
+type KludgeLine struct {
+	Name  string
+	Value string
+}
+
+func parseKludgeLine(text string) KludgeLine {
+	i := strings.IndexAny(text, ": ")
+	if i < 0 {
+		return KludgeLine{text, ""}
+	}
+	value := text[i+1:]
+	if text[i] == ':' {
+		value = strings.TrimPrefix(value, " ")
+	}
+	return KludgeLine{text[:i], value}
+}
+
+// parseKludge sets the typed field for a known kludge.
+func (m *Message) parseKludge(text string) {
+	k := parseKludgeLine(text)
+	switch strings.ToUpper(k.Name) {
+	case "MSGID":
+		m.MsgID = parseMsgID(k.Value)
+	case "REPLY":
+		m.Reply = parseMsgID(k.Value)
+	case "INTL":
+		m.Intl = parseIntl(k.Value)
+	case "FMPT":
+		m.FromPoint = parsePoint(k.Value)
+	case "TOPT":
+		m.ToPoint = parsePoint(k.Value)
+	case "CHRS":
+		m.Charset = strings.TrimSpace(k.Value)
+	case "TZUTC":
+		m.TZUTC = parseTZUTC(k.Value)
+	case "PID":
+		m.PID = strings.TrimSpace(k.Value)
+	case "TID":
+		m.TID = strings.TrimSpace(k.Value)
+	}
+}
+
+// MsgID is the value of a MSGID or REPLY kludge: the origin of
+// the message, usually an FTN address, and a serial number.
+type MsgID struct {
+	Origin string
+	Serial uint32
+}
+
+func parseMsgID(value string) *MsgID {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return nil
+	}
+	serial, err := strconv.ParseUint(fields[1], 16, 32)
+	if err != nil {
+		return nil
+	}
+	return &MsgID{fields[0], uint32(serial)}
+}
+
+// Address returns the origin as an FTN address, if it is one.
+func (id *MsgID) Address() (ftn.Address, bool) {
+	addr, err := ftn.ParseAddress(id.Origin)
+	return addr, err == nil
+}
+
+func (id *MsgID) String() string {
+	return fmt.Sprintf("%s %08x", id.Origin, id.Serial)
+}
+
+// Intl is the value of an INTL kludge: the 3D addresses of the
+// destination and origin of a netmail message.
+type Intl struct {
+	Dest   ftn.Address
+	Origin ftn.Address
+}
+
+func parseIntl(value string) *Intl {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return nil
+	}
+	dest, err := ftn.ParseAddress(fields[0])
+	if err != nil {
+		return nil
+	}
+	origin, err := ftn.ParseAddress(fields[1])
+	if err != nil {
+		return nil
+	}
+	return &Intl{dest, origin}
+}
+
+func parsePoint(value string) ftn.Point {
+	point, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || point < 0 || point > ftn.MaxPoint {
+		return 0
+	}
+	return ftn.Point(point)
+}
+
+// TZUTC is the value of a TZUTC kludge: the offset of the time
+// zone in which the message was written from UTC.
+type TZUTC struct {
+	Minutes int
+}
+
+func parseTZUTC(value string) *TZUTC {
+	value = strings.TrimSpace(value)
+	sign := 1
+	switch {
+	case strings.HasPrefix(value, "-"):
+		sign = -1
+		value = value[1:]
+	case strings.HasPrefix(value, "+"):
+		value = value[1:]
+	}
+	if len(value) != 4 {
+		return nil
+	}
+	hhmm, err := strconv.Atoi(value)
+	if err != nil || hhmm < 0 || hhmm%100 >= 60 {
+		return nil
+	}
+	return &TZUTC{sign * (hhmm/100*60 + hhmm%100)}
+}
+
+// Seconds returns the offset in seconds, as for time.FixedZone.
+func (tz *TZUTC) Seconds() int {
+	return tz.Minutes * 60
+}
+
+func (tz *TZUTC) String() string {
+	minutes := tz.Minutes
+	sign := ""
+	if minutes < 0 {
+		sign = "-"
+		minutes = -minutes
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, minutes/60, minutes%60)
+}
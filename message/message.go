@@ -0,0 +1,284 @@
+// Package message parses the text of FidoNet messages: the area
+// line of echomail, the control ("kludge") lines that begin with
+// ^A, the body with its tear and origin lines, and the SEEN-BY
+// and PATH lines that record where echomail has been.
+//
+// A message is kept as the lines it was parsed from, so that it
+// serializes back exactly as it was read; the typed fields are
+// parsed from those lines.  Changing SEEN-BY or PATH rewrites
+// only the lines concerned.
+package message
+
+import (
+	"strings"
+
+	"fat-dragon.org/ginko/ftn"
+)
+
+// Kind classifies the lines of a message.
+type Kind int
+
+const (
+	Text   Kind = iota // A line of the body.
+	Area               // The AREA: line of echomail.
+	Kludge             // A ^A control line, other than PATH.
+	SeenBy             // A SEEN-BY: line.
+	Path               // A ^APATH: line.
+)
+
+// Line is a line of a message, without its terminating CR.
+type Line struct {
+	Kind Kind
+	Text string
+}
+
+// Message is the parsed text of a message.  Apart from the lines
+// themselves, its fields are derived from them, and are zero
+// where the corresponding line is missing or malformed.
+type Message struct {
+	Lines []Line // Split at CR; the last is empty if the text ends with CR.
+
+	Area      string // Echomail area, or empty for netmail.
+	MsgID     *MsgID
+	Reply     *MsgID
+	Intl      *Intl
+	FromPoint ftn.Point // FMPT
+	ToPoint   ftn.Point // TOPT
+	Charset   string    // CHRS, e.g. "CP866 2".
+	TZUTC     *TZUTC
+	PID       string
+	TID       string
+	Tear      string // The tear line, if any, e.g. "--- GoldED+".
+	Origin    string // The origin line, if any.
+	SeenBy    []ftn.Address
+	Path      []ftn.Address
+}
+
+// Parse parses the text of a message.  Every text parses; lines
+// that cannot be understood are kept, but not reflected in the
+// typed fields.
+func Parse(text string) *Message {
+	m := &Message{}
+	for i, s := range strings.Split(text, "\r") {
+		m.Lines = append(m.Lines, Line{classify(s, i == 0), s})
+	}
+	m.parse()
+	return m
+}
+
+func classify(s string, first bool) Kind {
+	switch {
+	case first && hasPrefixFold(s, "AREA:"):
+		return Area
+	case strings.HasPrefix(s, "\x01PATH:"):
+		return Path
+	case strings.HasPrefix(s, "\x01"):
+		return Kludge
+	case strings.HasPrefix(s, "SEEN-BY:"):
+		return SeenBy
+	default:
+		return Text
+	}
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// parse derives the typed fields from the lines.
+func (m *Message) parse() {
+	lines := m.Lines
+	*m = Message{Lines: lines}
+	var seenBy, path []string
+	for _, line := range m.Lines {
+		switch line.Kind {
+		case Area:
+			m.Area = strings.TrimSpace(line.Text[len("AREA:"):])
+		case Kludge:
+			m.parseKludge(line.Text[1:])
+		case SeenBy:
+			seenBy = append(seenBy, line.Text[len("SEEN-BY:"):])
+		case Path:
+			path = append(path, line.Text[len("\x01PATH:"):])
+		}
+	}
+	m.SeenBy = parseNetNodes(seenBy)
+	m.Path = parseNetNodes(path)
+	m.parseTearAndOrigin()
+}
+
+// parseTearAndOrigin finds the tear and origin lines: the last
+// lines of the body, ignoring blank lines, that look like them.
+func (m *Message) parseTearAndOrigin() {
+	for i := len(m.Lines) - 1; i >= 0; i-- {
+		line := m.Lines[i]
+		if line.Kind != Text {
+			continue
+		}
+		switch {
+		case strings.TrimSpace(line.Text) == "":
+			continue
+		case m.Origin == "" && m.Tear == "" && strings.HasPrefix(line.Text, " * Origin:"):
+			m.Origin = line.Text
+			continue
+		case m.Tear == "" && (line.Text == "---" || strings.HasPrefix(line.Text, "--- ")):
+			m.Tear = line.Text
+		}
+		return
+	}
+}
+
+// OriginAddress returns the address given in parentheses at the
+// end of the origin line.
+func (m *Message) OriginAddress() (ftn.Address, bool) {
+	text := strings.TrimRight(m.Origin, " ")
+	if !strings.HasSuffix(text, ")") {
+		return ftn.Address{}, false
+	}
+	i := strings.LastIndexByte(text, '(')
+	if i < 0 {
+		return ftn.Address{}, false
+	}
+	addr, err := ftn.ParseAddress(strings.TrimSpace(text[i+1 : len(text)-1]))
+	return addr, err == nil
+}
+
+// String returns the text of the message, exactly as parsed
+// unless it has since been changed.
+func (m *Message) String() string {
+	texts := make([]string, len(m.Lines))
+	for i, line := range m.Lines {
+		texts[i] = line.Text
+	}
+	return strings.Join(texts, "\r")
+}
+
+// Body returns the lines of the body, including any tear and
+// origin lines, but not the area, kludge, SEEN-BY or PATH lines.
+func (m *Message) Body() []string {
+	var body []string
+	for _, line := range m.Lines {
+		if line.Kind == Text {
+			body = append(body, line.Text)
+		}
+	}
+	return body
+}
+
+// Kludges returns the kludge lines, other than PATH, in order.
+func (m *Message) Kludges() []KludgeLine {
+	var kludges []KludgeLine
+	for _, line := range m.Lines {
+		if line.Kind == Kludge {
+			kludges = append(kludges, parseKludgeLine(line.Text[1:]))
+		}
+	}
+	return kludges
+}
+
+// Kludge returns the value of the first kludge with the given
+// name, compared without regard to case.
+func (m *Message) Kludge(name string) (string, bool) {
+	for _, k := range m.Kludges() {
+		if strings.EqualFold(k.Name, name) {
+			return k.Value, true
+		}
+	}
+	return "", false
+}
+
+// SetKludge sets the value of a kludge, replacing the first
+// kludge with the name, compared without regard to case, and
+// dropping any others.  A new kludge goes after those that begin
+// the message.
+func (m *Message) SetKludge(name, value string) {
+	text := "\x01" + name + ": " + value
+	at := -1
+	var lines []Line
+	for _, line := range m.Lines {
+		if line.Kind == Kludge && strings.EqualFold(parseKludgeLine(line.Text[1:]).Name, name) {
+			if at < 0 {
+				at = len(lines)
+				lines = append(lines, Line{Kludge, text})
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if at < 0 {
+		at = 0
+		for at < len(lines) && (lines[at].Kind == Area || lines[at].Kind == Kludge) {
+			at++
+		}
+		lines = append(lines[:at], append([]Line{{Kludge, text}}, lines[at:]...)...)
+	}
+	m.Lines = lines
+	m.parse()
+}
+
+// SetSeenBy replaces the SEEN-BY lines with lines listing the
+// given addresses, sorted, in the usual compressed form.  The
+// new lines take the place of the old, or if there were none, go
+// before any PATH lines at the end of the message.
+func (m *Message) SetSeenBy(addrs []ftn.Address) {
+	m.replace(SeenBy, formatNetNodes("SEEN-BY:", sortNetNodes(addrs)), Path)
+}
+
+// AddSeenBy adds addresses to the SEEN-BY lines, if they are not
+// already listed.
+func (m *Message) AddSeenBy(addrs ...ftn.Address) {
+	m.SetSeenBy(append(append([]ftn.Address(nil), m.SeenBy...), addrs...))
+}
+
+// AddPath appends an address to the PATH, unless it is already
+// the last one.
+func (m *Message) AddPath(addr ftn.Address) {
+	path := ftn.NetNode(addr)
+	if n := len(m.Path); n > 0 && m.Path[n-1] == path {
+		return
+	}
+	m.replace(Path, formatNetNodes("\x01PATH:", append(append([]ftn.Address(nil), m.Path...), path)), -1)
+}
+
+// replace replaces the lines of a kind with new ones, placed
+// where the first of the old ones was, or else before the first
+// line of the kind given by before, or else at the end.
+func (m *Message) replace(kind Kind, texts []string, before Kind) {
+	at := -1
+	var lines []Line
+	for _, line := range m.Lines {
+		if line.Kind == kind {
+			if at < 0 {
+				at = len(lines)
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if at < 0 {
+		at = endOfText(lines, before)
+	}
+	var added []Line
+	for _, text := range texts {
+		added = append(added, Line{kind, text})
+	}
+	m.Lines = append(lines[:at], append(added, lines[at:]...)...)
+	m.parse()
+}
+
+// endOfText returns where lines of a missing kind should go:
+// before the first line of the kind given by before, or else at
+// the end.  Echomail ends its lines with CR, so there is usually
+// an empty final line, which must stay last.
+func endOfText(lines []Line, before Kind) int {
+	at := len(lines)
+	if at > 0 && lines[at-1].Text == "" && lines[at-1].Kind == Text {
+		at--
+	}
+	for i, line := range lines {
+		if line.Kind == before {
+			return i
+		}
+	}
+	return at
+}
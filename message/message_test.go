@@ -0,0 +1,183 @@
+package message
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"fat-dragon.org/ginko/ftn"
+	"fat-dragon.org/ginko/ftn/ftntest"
+)
+
+const echomail = "AREA:FSX_GEN\r" +
+	"\x01MSGID: 21:1/100 1a2b3c4d\r" +
+	"\x01REPLY: 21:1/101@fsxnet 0000abcd\r" +
+	"\x01PID: GoldED+/LNX 1.1.5\r" +
+	"\x01TZUTC: -0430\r" +
+	"\x01CHRS: CP437 2\r" +
+	"Hello, all.\r" +
+	"\r" +
+	"--- GoldED+/LNX 1.1.5\r" +
+	" * Origin: Somewhere BBS (21:1/100)\r" +
+	"SEEN-BY: 1/1 100 101 3/1\r" +
+	"SEEN-BY: 4/7\r" +
+	"\x01PATH: 1/100 1\r"
+
+const netmail = "\x01INTL 2:5020/1 1:387/108\r" +
+	"\x01FMPT 7\r" +
+	"\x01TOPT 3\r" +
+	"\x01MSGID: 1:387/108.7 00000001\r" +
+	"\x01TID: ginko 1.0\r" +
+	"Hi.\r" +
+	"\x01Via 1:387/1 @20220408.131415 ginko\r"
+
+func TestParseEchomail(t *testing.T) {
+	m := Parse(echomail)
+	if m.String() != echomail {
+		t.Errorf("String() = %q, want %q", m.String(), echomail)
+	}
+	if m.Area != "FSX_GEN" {
+		t.Errorf("area %q", m.Area)
+	}
+	if m.MsgID == nil || m.MsgID.Origin != "21:1/100" || m.MsgID.Serial != 0x1a2b3c4d || m.MsgID.String() != "21:1/100 1a2b3c4d" {
+		t.Errorf("MSGID %+v", m.MsgID)
+	}
+	if addr, ok := m.Reply.Address(); !ok || addr.String() != "21:1/101@fsxnet" || m.Reply.Serial != 0xabcd {
+		t.Errorf("REPLY %+v", m.Reply)
+	}
+	if m.PID != "GoldED+/LNX 1.1.5" || m.Charset != "CP437 2" {
+		t.Errorf("PID %q, CHRS %q", m.PID, m.Charset)
+	}
+	if m.TZUTC == nil || m.TZUTC.Minutes != -270 || m.TZUTC.String() != "-0430" {
+		t.Errorf("TZUTC %+v", m.TZUTC)
+	}
+	if m.Tear != "--- GoldED+/LNX 1.1.5" || m.Origin != " * Origin: Somewhere BBS (21:1/100)" {
+		t.Errorf("tear %q, origin %q", m.Tear, m.Origin)
+	}
+	if addr, ok := m.OriginAddress(); !ok || addr.String() != "21:1/100" {
+		t.Errorf("origin address %v", addr)
+	}
+	if want := ftntest.Addresses(t, "1/1", "1/100", "1/101", "3/1", "4/7"); !reflect.DeepEqual(m.SeenBy, want) {
+		t.Errorf("SEEN-BY %v, want %v", m.SeenBy, want)
+	}
+	if want := ftntest.Addresses(t, "1/100", "1/1"); !reflect.DeepEqual(m.Path, want) {
+		t.Errorf("PATH %v, want %v", m.Path, want)
+	}
+	body := []string{"Hello, all.", "", "--- GoldED+/LNX 1.1.5", " * Origin: Somewhere BBS (21:1/100)", ""}
+	if !reflect.DeepEqual(m.Body(), body) {
+		t.Errorf("body %q", m.Body())
+	}
+	if pid, ok := m.Kludge("pid"); !ok || pid != "GoldED+/LNX 1.1.5" {
+		t.Errorf("Kludge(pid) = %q, %v", pid, ok)
+	}
+}
+
+func TestParseNetmail(t *testing.T) {
+	m := Parse(netmail)
+	if m.String() != netmail {
+		t.Errorf("String() = %q", m.String())
+	}
+	if m.Area != "" {
+		t.Errorf("netmail in area %q", m.Area)
+	}
+	if m.Intl == nil || m.Intl.Dest.String() != "2:5020/1" || m.Intl.Origin.String() != "1:387/108" {
+		t.Errorf("INTL %+v", m.Intl)
+	}
+	if m.FromPoint != 7 || m.ToPoint != 3 || m.TID != "ginko 1.0" {
+		t.Errorf("FMPT %d TOPT %d TID %q", m.FromPoint, m.ToPoint, m.TID)
+	}
+	kludges := m.Kludges()
+	if len(kludges) != 6 || kludges[5] != (KludgeLine{"Via", "1:387/1 @20220408.131415 ginko"}) {
+		t.Errorf("kludges %q", kludges)
+	}
+}
+
+func TestMalformedKludges(t *testing.T) {
+	text := "\x01MSGID: nonsense\r\x01INTL 2:5020/1\r\x01TZUTC: 25:00\r\x01FMPT x\rSEEN-BY: junk 5/ /7 9\r"
+	m := Parse(text)
+	if m.String() != text {
+		t.Errorf("String() = %q", m.String())
+	}
+	if m.MsgID != nil || m.Intl != nil || m.TZUTC != nil || m.FromPoint != 0 || len(m.SeenBy) != 0 {
+		t.Errorf("malformed kludges parsed: %+v", m)
+	}
+}
+
+func TestSetKludge(t *testing.T) {
+	m := Parse(echomail)
+	m.SetKludge("CHRS", "UTF-8 4")
+	if want := strings.Replace(echomail, "CP437 2", "UTF-8 4", 1); m.String() != want {
+		t.Errorf("after replacing:\n got %q\nwant %q", m.String(), want)
+	}
+	if m.Charset != "UTF-8 4" {
+		t.Errorf("Charset = %q", m.Charset)
+	}
+
+	m = Parse("AREA:TEST\r\x01MSGID: 1:2/3 00000001\rBody.\r\x01chrs: CP866 2\r")
+	m.SetKludge("CHRS", "UTF-8 4")
+	if want := "AREA:TEST\r\x01MSGID: 1:2/3 00000001\rBody.\r\x01CHRS: UTF-8 4\r"; m.String() != want {
+		t.Errorf("replaced in place: %q, want %q", m.String(), want)
+	}
+	m = Parse("AREA:TEST\r\x01MSGID: 1:2/3 00000001\rBody.\r")
+	m.SetKludge("CHRS", "UTF-8 4")
+	if want := "AREA:TEST\r\x01MSGID: 1:2/3 00000001\r\x01CHRS: UTF-8 4\rBody.\r"; m.String() != want {
+		t.Errorf("added: %q, want %q", m.String(), want)
+	}
+}
+
+func TestAddSeenByAndPath(t *testing.T) {
+	m := Parse(echomail)
+	m.AddSeenBy(ftntest.Addresses(t, "21:1/102", "21:1/100.5", "21:2/1")...)
+	m.AddPath(ftntest.Address(t, "21:1/102"))
+	want := strings.Replace(echomail,
+		"SEEN-BY: 1/1 100 101 3/1\rSEEN-BY: 4/7\r\x01PATH: 1/100 1\r",
+		"SEEN-BY: 1/1 100 101 102 2/1 3/1 4/7\r\x01PATH: 1/100 1 102\r", 1)
+	if m.String() != want {
+		t.Errorf("after adding:\n got %q\nwant %q", m.String(), want)
+	}
+	m.AddPath(ftntest.Address(t, "21:1/102"))
+	if m.String() != want {
+		t.Error("AddPath repeated the last address")
+	}
+
+	m = Parse("AREA:TEST\rBody.\r")
+	m.AddSeenBy(ftntest.Addresses(t, "1:2/3")...)
+	m.AddPath(ftntest.Address(t, "1:2/3"))
+	if want := "AREA:TEST\rBody.\rSEEN-BY: 2/3\r\x01PATH: 2/3\r"; m.String() != want {
+		t.Errorf("added to empty: %q, want %q", m.String(), want)
+	}
+	m = Parse("AREA:TEST\rBody.\r\x01PATH: 2/3\r")
+	m.AddSeenBy(ftntest.Addresses(t, "1:2/3")...)
+	if want := "AREA:TEST\rBody.\rSEEN-BY: 2/3\r\x01PATH: 2/3\r"; m.String() != want {
+		t.Errorf("SEEN-BY added after PATH: %q", m.String())
+	}
+}
+
+func TestSeenByWrapping(t *testing.T) {
+	var many []ftn.Address
+	for net := 1000; net < 1040; net++ {
+		many = append(many, ftntest.Addresses(t, "1:"+strconv.Itoa(net)+"/1", "1:"+strconv.Itoa(net)+"/2")...)
+	}
+	m := Parse("AREA:TEST\rBody.\r")
+	m.SetSeenBy(many)
+	lines := 0
+	for _, line := range m.Lines {
+		if line.Kind != SeenBy {
+			continue
+		}
+		lines++
+		if len(line.Text) > maxLineLength {
+			t.Errorf("SEEN-BY line of %d bytes", len(line.Text))
+		}
+		if fields := strings.Fields(line.Text); !strings.Contains(fields[1], "/") {
+			t.Errorf("SEEN-BY line does not start with a net: %q", line.Text)
+		}
+	}
+	if lines < 2 {
+		t.Errorf("SEEN-BY not wrapped")
+	}
+	if !reflect.DeepEqual(Parse(m.String()).SeenBy, sortNetNodes(many)) {
+		t.Error("wrapped SEEN-BY does not parse back")
+	}
+}
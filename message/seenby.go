@@ -0,0 +1,92 @@
+package message
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"fat-dragon.org/ginko/ftn"
+)
+
+// maxLineLength is the length to which SEEN-BY and PATH lines
+// are wrapped.
+const maxLineLength = 79
+
+// parseNetNodes parses the values of SEEN-BY or PATH lines: 2D
+// addresses, in which the net is omitted when it is the same as
+// that of the previous address, as in "5020/1 2 100 5030/1".
+// The net carries over from one line to the next.  Points, which
+// some software includes, are dropped; malformed entries are
+// skipped.
+func parseNetNodes(values []string) []ftn.Address {
+	var addrs []ftn.Address
+	net := -1
+	for _, value := range values {
+		for _, field := range strings.Fields(value) {
+			field, _, _ = strings.Cut(field, ".")
+			thisNet, nodeStr := net, field
+			if netStr, rest, ok := strings.Cut(field, "/"); ok {
+				n, err := strconv.Atoi(netStr)
+				if err != nil || n < 0 || n > ftn.MaxNet {
+					continue
+				}
+				thisNet, nodeStr = n, rest
+			}
+			node, err := strconv.Atoi(nodeStr)
+			if err != nil || node < 0 || node > ftn.MaxNode || thisNet < 0 {
+				continue
+			}
+			net = thisNet
+			addrs = append(addrs, ftn.NewAddress2d(ftn.Net(net), ftn.Node(node)))
+		}
+	}
+	return addrs
+}
+
+// sortNetNodes returns the distinct 2D addresses of a list, in
+// order.
+func sortNetNodes(addrs []ftn.Address) []ftn.Address {
+	seen := make(map[ftn.Address]bool)
+	var sorted []ftn.Address
+	for _, addr := range addrs {
+		addr = ftn.NetNode(addr)
+		if !seen[addr] {
+			seen[addr] = true
+			sorted = append(sorted, addr)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Compare(sorted[j]) < 0
+	})
+	return sorted
+}
+
+// formatNetNodes formats 2D addresses in compressed form, in
+// lines beginning with the prefix and wrapped to maxLineLength.
+// Each line starts with a full net/node.
+func formatNetNodes(prefix string, addrs []ftn.Address) []string {
+	var lines []string
+	var b strings.Builder
+	net := ftn.Net(-1)
+	for _, addr := range addrs {
+		entry := strconv.Itoa(int(addr.Node()))
+		if addr.Net() != net || b.Len() == 0 {
+			entry = strconv.Itoa(int(addr.Net())) + "/" + entry
+		}
+		if b.Len() > 0 && b.Len()+1+len(entry) > maxLineLength {
+			lines = append(lines, b.String())
+			b.Reset()
+			entry = strconv.Itoa(int(addr.Net())) + "/" + strconv.Itoa(int(addr.Node()))
+		}
+		if b.Len() == 0 {
+			b.WriteString(prefix)
+		}
+		b.WriteString(" ")
+		b.WriteString(entry)
+		net = addr.Net()
+	}
+	if b.Len() > 0 {
+		lines = append(lines, b.String())
+	}
+	return lines
+}
@@ -0,0 +1,22 @@
+package metrics
+
+// Metrics exported by ginko.  Links are labelled by their FTN
+// address, and sessions by role ("sender" or "receiver").
+var (
+	SessionsActive = NewGaugeVec("ginko_sessions_active",
+		"Number of sessions in progress.", "role")
+	Sessions = NewCounterVec("ginko_sessions_total",
+		"Sessions completed, by outcome (success, failure or auth_failure).", "role", "outcome")
+	AuthFailures = NewCounterVec("ginko_auth_failures_total",
+		"Failed authentications of configured links.", "link")
+	BytesSent = NewCounterVec("ginko_bytes_sent_total",
+		"File data bytes sent.", "link")
+	BytesReceived = NewCounterVec("ginko_bytes_received_total",
+		"File data bytes received.", "link")
+	FilesSent = NewCounterVec("ginko_files_sent_total",
+		"Files completely sent.", "link")
+	FilesReceived = NewCounterVec("ginko_files_received_total",
+		"Files completely received.", "link")
+	FrameDecodeErrors = NewCounterVec("ginko_frame_decode_errors_total",
+		"Frames that could not be read or decoded.")
+)
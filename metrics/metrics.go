@@ -0,0 +1,247 @@
+// Package metrics implements a minimal registry of counters and
+// gauges with labels, exported over HTTP in the Prometheus text
+// exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Sample is a single labelled value of a metric.
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// collector is implemented by every metric type.
+type collector interface {
+	describe() (name, help, typ string, labels []string)
+	collect() []Sample
+}
+
+// Registry holds a set of metrics for export.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+	names      map[string]bool
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// Default is the registry to which the package-level
+// constructors add metrics.
+var Default = NewRegistry()
+
+func (r *Registry) register(c collector) {
+	name, _, _, _ := c.describe()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteTo writes every metric in the registry to w in the text
+// exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+	var b strings.Builder
+	for _, c := range collectors {
+		name, help, typ, labels := c.describe()
+		fmt.Fprintf(&b, "# HELP %s %s\n", name, escapeHelp(help))
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, typ)
+		for _, s := range c.collect() {
+			b.WriteString(name)
+			if len(labels) > 0 {
+				b.WriteString("{")
+				for i, label := range labels {
+					if i > 0 {
+						b.WriteString(",")
+					}
+					value := ""
+					if i < len(s.LabelValues) {
+						value = s.LabelValues[i]
+					}
+					fmt.Fprintf(&b, "%s=\"%s\"", label, escapeLabel(value))
+				}
+				b.WriteString("}")
+			}
+			b.WriteString(" ")
+			b.WriteString(strconv.FormatFloat(s.Value, 'g', -1, 64))
+			b.WriteString("\n")
+		}
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// Handler returns an HTTP handler that serves the registry.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+// vec is the labelled value storage shared by counters and
+// gauges.
+type vec struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+	mu     sync.Mutex
+	values map[string]*Sample
+}
+
+func newVec(name, help, typ string, labels []string) *vec {
+	return &vec{name: name, help: help, typ: typ, labels: labels, values: make(map[string]*Sample)}
+}
+
+func (v *vec) describe() (string, string, string, []string) {
+	return v.name, v.help, v.typ, v.labels
+}
+
+func (v *vec) collect() []Sample {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	samples := make([]Sample, len(keys))
+	for i, key := range keys {
+		samples[i] = *v.values[key]
+	}
+	return samples
+}
+
+func (v *vec) add(labelValues []string, delta float64, set bool) {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d labels, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.values[key]
+	if !ok {
+		s = &Sample{LabelValues: append([]string(nil), labelValues...)}
+		v.values[key] = s
+	}
+	if set {
+		s.Value = delta
+	} else {
+		s.Value += delta
+	}
+}
+
+// CounterVec is a family of monotonically increasing counters,
+// distinguished by label values.
+type CounterVec struct {
+	*vec
+}
+
+// NewCounterVec creates a counter family in the default registry.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+// NewCounterVec creates a counter family in the registry.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, "counter", labels)}
+	r.register(c)
+	return c
+}
+
+// Inc increments the counter with the given label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.add(labelValues, 1, false)
+}
+
+// Add adds a non-negative amount to the counter with the given
+// label values.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counter " + c.name + " decreased")
+	}
+	c.add(labelValues, delta, false)
+}
+
+// GaugeVec is a family of values that may go up and down,
+// distinguished by label values.
+type GaugeVec struct {
+	*vec
+}
+
+// NewGaugeVec creates a gauge family in the default registry.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labels...)
+}
+
+// NewGaugeVec creates a gauge family in the registry.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, "gauge", labels)}
+	r.register(g)
+	return g
+}
+
+// Set sets the gauge with the given label values.
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.add(labelValues, value, true)
+}
+
+// Inc increments the gauge with the given label values.
+func (g *GaugeVec) Inc(labelValues ...string) {
+	g.add(labelValues, 1, false)
+}
+
+// Dec decrements the gauge with the given label values.
+func (g *GaugeVec) Dec(labelValues ...string) {
+	g.add(labelValues, -1, false)
+}
+
+// GaugeFunc is a gauge family whose samples are computed when
+// the registry is scraped.
+type GaugeFunc struct {
+	name   string
+	help   string
+	labels []string
+	fn     func() []Sample
+}
+
+// NewGaugeFunc creates a computed gauge family in the registry.
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, fn func() []Sample) *GaugeFunc {
+	g := &GaugeFunc{name, help, labels, fn}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) describe() (string, string, string, []string) {
+	return g.name, g.help, "gauge", g.labels
+}
+
+func (g *GaugeFunc) collect() []Sample {
+	return g.fn()
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_bytes_total", "Bytes moved.", "link")
+	g := r.NewGaugeVec("test_active", "Active things.", "role")
+	r.NewGaugeFunc("test_depth", "Queue depth.", []string{"link"}, func() []Sample {
+		return []Sample{{[]string{`1:2/3@"odd"`}, 4}}
+	})
+	c.Add(100, "1:387/1")
+	c.Inc("1:387/1")
+	c.Inc("21:1/100")
+	g.Inc("sender")
+	g.Inc("sender")
+	g.Dec("sender")
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	expected := `# HELP test_bytes_total Bytes moved.
+# TYPE test_bytes_total counter
+test_bytes_total{link="1:387/1"} 101
+test_bytes_total{link="21:1/100"} 1
+# HELP test_active Active things.
+# TYPE test_active gauge
+test_active{role="sender"} 1
+# HELP test_depth Queue depth.
+# TYPE test_depth gauge
+test_depth{link="1:2/3@\"odd\""} 4
+`
+	if got := rec.Body.String(); got != expected {
+		t.Errorf("exposition mismatch:\ngot:\n%s\nwant:\n%s", got, expected)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+}
+
+func TestUnlabelled(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_errors_total", "Errors.")
+	c.Inc()
+	var b strings.Builder
+	r.WriteTo(&b)
+	if !strings.Contains(b.String(), "\ntest_errors_total 1\n") {
+		t.Errorf("unexpected output %q", b.String())
+	}
+}
+
+func TestCounterDecreasePanics(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_total", "Test.")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic")
+		}
+	}()
+	c.Add(-1)
+}
@@ -0,0 +1,76 @@
+package msgbase
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+// sampleBase returns the files of a base of the given format
+// holding two messages, the first deleted.
+func sampleBase(f *testing.F, format string, exts ...string) [][]byte {
+	name := path.Join(f.TempDir(), "sample")
+	b, err := Open(format + ":" + name)
+	if err != nil {
+		f.Fatal(err)
+	}
+	defer b.Close()
+	for i := 0; i < 2; i++ {
+		m := &Message{From: "Sysop", To: "All", Text: "\x01MSGID: 1:387/1 1\rHello\rSEEN-BY: 387/1\r\x01PATH: 387/1\r"}
+		if _, err := b.Write(m); err != nil {
+			f.Fatal(err)
+		}
+	}
+	nums, err := b.Numbers()
+	if err != nil {
+		f.Fatal(err)
+	}
+	if err := b.Delete(nums[0]); err != nil {
+		f.Fatal(err)
+	}
+	var files [][]byte
+	for _, ext := range exts {
+		data, err := os.ReadFile(name + ext)
+		if err != nil {
+			f.Fatal(err)
+		}
+		files = append(files, data)
+	}
+	return files
+}
+
+// readAll writes the files of a base, and reads every message in
+// it, which must end without panicking whatever the files hold.
+func readAll(t *testing.T, format string, files map[string][]byte) {
+	name := path.Join(t.TempDir(), "fuzz")
+	for ext, data := range files {
+		if err := os.WriteFile(name+ext, data, 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := Open(format + ":" + name)
+	if err != nil {
+		return
+	}
+	defer b.Close()
+	nums, _ := b.Numbers()
+	for _, num := range nums {
+		b.Read(num)
+	}
+}
+
+func FuzzJAM(f *testing.F) {
+	sample := sampleBase(f, "jam", ".jhr", ".jdt", ".jdx")
+	f.Add(sample[0], sample[1], sample[2])
+	f.Fuzz(func(t *testing.T, jhr, jdt, jdx []byte) {
+		readAll(t, "jam", map[string][]byte{".jhr": jhr, ".jdt": jdt, ".jdx": jdx})
+	})
+}
+
+func FuzzSquish(f *testing.F) {
+	sample := sampleBase(f, "squish", ".sqd", ".sqi")
+	f.Add(sample[0], sample[1])
+	f.Fuzz(func(t *testing.T, sqd, sqi []byte) {
+		readAll(t, "squish", map[string][]byte{".sqd": sqd, ".sqi": sqi})
+	})
+}
@@ -0,0 +1,616 @@
+package msgbase
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"strings"
+	"time"
+
+	"fat-dragon.org/ginko/ftn"
+	"fat-dragon.org/ginko/message"
+)
+
+var jamSignature = [4]byte{'J', 'A', 'M', 0}
+
+// jamNone marks a missing CRC, or a deleted index entry.
+const jamNone = 0xffffffff
+
+// jamBaseHeader begins the header file of a JAM base.
+type jamBaseHeader struct {
+	Signature   [4]byte
+	DateCreated uint32
+	ModCounter  uint32
+	ActiveMsgs  uint32
+	PasswordCRC uint32
+	BaseMsgNum  uint32
+	Reserved    [1000]byte
+}
+
+const jamBaseHeaderSize = 1024
+
+// jamHeader is the fixed part of a message header, which is
+// followed by its subfields.
+type jamHeader struct {
+	Signature     [4]byte
+	Revision      uint16
+	ReservedWord  uint16
+	SubfieldLen   uint32
+	TimesRead     uint32
+	MsgIDCRC      uint32
+	ReplyCRC      uint32
+	ReplyTo       uint32
+	Reply1st      uint32
+	ReplyNext     uint32
+	DateWritten   uint32
+	DateReceived  uint32
+	DateProcessed uint32
+	MessageNumber uint32
+	Attribute     uint32
+	Attribute2    uint32
+	Offset        uint32 // Of the text, in the .jdt file.
+	TxtLen        uint32
+	PasswordCRC   uint32
+	Cost          uint32
+}
+
+const jamHeaderSize = 76
+
+// jamIndex is an entry of the .jdx file, one for each message
+// number from the base's first.
+type jamIndex struct {
+	ToCRC     uint32
+	HdrOffset uint32
+}
+
+const jamIndexSize = 8
+
+// jamLastRead is a record of the .jlr file.
+type jamLastRead struct {
+	UserCRC  uint32
+	UserID   uint32
+	LastRead uint32
+	HighRead uint32
+}
+
+const jamLastReadSize = 16
+
+// Subfield IDs.
+const (
+	jamOrigAddress  = 0
+	jamDestAddress  = 1
+	jamSenderName   = 2
+	jamReceiverName = 3
+	jamMsgID        = 4
+	jamReplyID      = 5
+	jamSubject      = 6
+	jamPID          = 7
+	jamKludge       = 2000
+	jamSeenBy       = 2001
+	jamPath         = 2002
+	jamFlags        = 2003
+	jamTZUTC        = 2004
+)
+
+const jamDeleted = 0x80000000
+
+// jamAttributes maps our attributes to JAM's.
+var jamAttributes = []struct {
+	attr Attribute
+	jam  uint32
+}{
+	{Local, 0x00000001},
+	{InTransit, 0x00000002},
+	{Private, 0x00000004},
+	{Received, 0x00000008},
+	{Sent, 0x00000010},
+	{KillSent, 0x00000020},
+	{Hold, 0x00000080},
+	{Crash, 0x00000100},
+	{Immediate, 0x00000200},
+	{Direct, 0x00000400},
+	{FileRequest, 0x00001000},
+	{FileAttached, 0x00002000},
+	{ReturnReceiptRequest, 0x00010000},
+	{Orphan, 0x00040000},
+	{Echomail, 0x01000000},
+	{Netmail, 0x02000000},
+}
+
+// toJAM returns JAM attributes for ours, keeping those of the old
+// attributes that we have no equivalent for.
+func toJAM(attrs Attribute, old uint32) uint32 {
+	for _, a := range jamAttributes {
+		old &^= a.jam
+		if attrs.Has(a.attr) {
+			old |= a.jam
+		}
+	}
+	return old
+}
+
+func fromJAM(jam uint32) Attribute {
+	var attrs Attribute
+	for _, a := range jamAttributes {
+		if jam&a.jam != 0 {
+			attrs |= a.attr
+		}
+	}
+	return attrs
+}
+
+// jamCRC returns the CRC of a string as JAM keeps them: CRC-32 of
+// the string in lower case, without the final inversion.
+func jamCRC(s string) uint32 {
+	return ^crc32.ChecksumIEEE([]byte(strings.ToLower(s)))
+}
+
+// JAM is an open JAM message base.  Its messages are numbered
+// from the base's first message number, usually 1; numbers of
+// deleted messages are not reused.
+type JAM struct {
+	jhr, jdt, jdx, jlr *os.File
+}
+
+// OpenJAM opens the JAM base whose files have the given name,
+// without extension, creating it if need be.
+func OpenJAM(name string) (*JAM, error) {
+	j := &JAM{}
+	for _, file := range []struct {
+		f   **os.File
+		ext string
+	}{{&j.jhr, ".jhr"}, {&j.jdt, ".jdt"}, {&j.jdx, ".jdx"}, {&j.jlr, ".jlr"}} {
+		f, err := os.OpenFile(name+file.ext, os.O_RDWR|os.O_CREATE, 0660)
+		if err != nil {
+			j.Close()
+			return nil, err
+		}
+		*file.f = f
+	}
+	if err := j.init(); err != nil {
+		j.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return j, nil
+}
+
+// init writes the header of a new base, or checks that of an
+// existing one.
+func (j *JAM) init() error {
+	if err := lock(j.jhr); err != nil {
+		return err
+	}
+	defer unlock(j.jhr)
+	n, err := size(j.jhr)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		base := jamBaseHeader{
+			Signature:   jamSignature,
+			DateCreated: wallClock(time.Now()),
+			BaseMsgNum:  1,
+		}
+		return writeAt(j.jhr, 0, &base)
+	}
+	_, err = j.base()
+	return err
+}
+
+func (j *JAM) Close() error {
+	var firstErr error
+	for _, f := range []*os.File{j.jhr, j.jdt, j.jdx, j.jlr} {
+		if f == nil {
+			continue
+		}
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (j *JAM) base() (*jamBaseHeader, error) {
+	var base jamBaseHeader
+	if err := readAt(j.jhr, 0, &base); err != nil {
+		return nil, err
+	}
+	if base.Signature != jamSignature {
+		return nil, fmt.Errorf("%w: bad JAM signature", ErrCorrupt)
+	}
+	return &base, nil
+}
+
+// updated records a change to the base in its header.
+func (j *JAM) updated(base *jamBaseHeader) error {
+	base.ModCounter++
+	return writeAt(j.jhr, 0, base)
+}
+
+// indexes returns the index entries of the base.
+func (j *JAM) indexes() ([]jamIndex, error) {
+	n, err := size(j.jdx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := readBytes(j.jdx, 0, n-n%jamIndexSize)
+	if err != nil {
+		return nil, err
+	}
+	indexes := make([]jamIndex, len(data)/jamIndexSize)
+	for i := range indexes {
+		indexes[i] = jamIndex{
+			binary.LittleEndian.Uint32(data[i*jamIndexSize:]),
+			binary.LittleEndian.Uint32(data[i*jamIndexSize+4:]),
+		}
+	}
+	return indexes, nil
+}
+
+// header returns the header of a message, and its offsets in the
+// header and index files.
+func (j *JAM) header(base *jamBaseHeader, num uint32) (*jamHeader, int64, int64, error) {
+	if num < base.BaseMsgNum {
+		return nil, 0, 0, ErrNotFound
+	}
+	idxOff := int64(num-base.BaseMsgNum) * jamIndexSize
+	n, err := size(j.jdx)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if idxOff+jamIndexSize > n {
+		return nil, 0, 0, ErrNotFound
+	}
+	var idx jamIndex
+	if err := readAt(j.jdx, idxOff, &idx); err != nil {
+		return nil, 0, 0, err
+	}
+	if idx.HdrOffset == jamNone {
+		return nil, 0, 0, ErrNotFound
+	}
+	h, err := j.headerAt(int64(idx.HdrOffset))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if h.Attribute&jamDeleted != 0 {
+		return nil, 0, 0, ErrNotFound
+	}
+	return h, int64(idx.HdrOffset), idxOff, nil
+}
+
+func (j *JAM) headerAt(off int64) (*jamHeader, error) {
+	var h jamHeader
+	if err := readAt(j.jhr, off, &h); err != nil {
+		return nil, err
+	}
+	if h.Signature != jamSignature {
+		return nil, fmt.Errorf("%w: bad JAM message header at %d", ErrCorrupt, off)
+	}
+	return &h, nil
+}
+
+// Numbers returns the numbers of the messages in the base.
+func (j *JAM) Numbers() ([]uint32, error) {
+	base, err := j.base()
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := j.indexes()
+	if err != nil {
+		return nil, err
+	}
+	var nums []uint32
+	for i, idx := range indexes {
+		if idx.HdrOffset == jamNone {
+			continue
+		}
+		h, err := j.headerAt(int64(idx.HdrOffset))
+		if err != nil {
+			return nil, err
+		}
+		if h.Attribute&jamDeleted == 0 {
+			nums = append(nums, base.BaseMsgNum+uint32(i))
+		}
+	}
+	return nums, nil
+}
+
+// Read returns a message.  The kludges kept in the header are
+// given first in its text, followed by the body and any SEEN-BY
+// and PATH lines.
+func (j *JAM) Read(num uint32) (*Message, error) {
+	base, err := j.base()
+	if err != nil {
+		return nil, err
+	}
+	h, off, _, err := j.header(base, num)
+	if err != nil {
+		return nil, err
+	}
+	subfields, err := readBytes(j.jhr, off+jamHeaderSize, int64(h.SubfieldLen))
+	if err != nil {
+		return nil, err
+	}
+	body, err := readBytes(j.jdt, int64(h.Offset), int64(h.TxtLen))
+	if err != nil {
+		return nil, err
+	}
+	m := &Message{
+		Written:    fromWallClock(h.DateWritten),
+		Arrived:    fromWallClock(h.DateReceived),
+		Attributes: fromJAM(h.Attribute),
+	}
+	var kludges, seenBy, path strings.Builder
+	for len(subfields) > 0 {
+		if len(subfields) < 8 {
+			return nil, fmt.Errorf("%w: message %d has a short subfield", ErrCorrupt, num)
+		}
+		id := binary.LittleEndian.Uint16(subfields)
+		n := binary.LittleEndian.Uint32(subfields[4:])
+		subfields = subfields[8:]
+		if uint32(len(subfields)) < n {
+			return nil, fmt.Errorf("%w: message %d has a short subfield", ErrCorrupt, num)
+		}
+		value := string(subfields[:n])
+		subfields = subfields[n:]
+		switch id {
+		case jamOrigAddress:
+			m.Origin, _ = ftn.ParseAddress(value)
+		case jamDestAddress:
+			m.Dest, _ = ftn.ParseAddress(value)
+		case jamSenderName:
+			m.From = value
+		case jamReceiverName:
+			m.To = value
+		case jamSubject:
+			m.Subject = value
+		case jamMsgID:
+			kludges.WriteString("\x01MSGID: " + value + "\r")
+		case jamReplyID:
+			kludges.WriteString("\x01REPLY: " + value + "\r")
+		case jamPID:
+			kludges.WriteString("\x01PID: " + value + "\r")
+		case jamFlags:
+			kludges.WriteString("\x01FLAGS " + value + "\r")
+		case jamTZUTC:
+			kludges.WriteString("\x01TZUTC: " + value + "\r")
+		case jamKludge:
+			kludges.WriteString("\x01" + value + "\r")
+		case jamSeenBy:
+			seenBy.WriteString("SEEN-BY: " + value + "\r")
+		case jamPath:
+			path.WriteString("\x01PATH: " + value + "\r")
+		}
+	}
+	text := strings.TrimRight(string(body), "\x00")
+	if text != "" && !strings.HasSuffix(text, "\r") && seenBy.Len()+path.Len() > 0 {
+		text += "\r"
+	}
+	m.Text = kludges.String() + text + seenBy.String() + path.String()
+	return m, nil
+}
+
+// jamEncode returns the subfields and text with which a message
+// is stored, and its MSGID and REPLY.
+func jamEncode(m *Message) ([]byte, string, string, string) {
+	var b []byte
+	add := func(id uint16, value string) {
+		b = binary.LittleEndian.AppendUint16(b, id)
+		b = binary.LittleEndian.AppendUint16(b, 0)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(value)))
+		b = append(b, value...)
+	}
+	if m.Origin != (ftn.Address{}) {
+		add(jamOrigAddress, m.Origin.String())
+	}
+	if m.Dest != (ftn.Address{}) {
+		add(jamDestAddress, m.Dest.String())
+	}
+	add(jamSenderName, m.From)
+	add(jamReceiverName, m.To)
+	add(jamSubject, m.Subject)
+	var msgid, reply string
+	var body, seenBy, path []string
+	for _, line := range textLines(m.Text) {
+		switch line.Kind {
+		case message.Kludge:
+			name, value := splitKludge(line.Text[1:])
+			switch strings.ToUpper(name) {
+			case "MSGID":
+				msgid = value
+				add(jamMsgID, value)
+			case "REPLY":
+				reply = value
+				add(jamReplyID, value)
+			case "PID":
+				add(jamPID, value)
+			case "FLAGS":
+				add(jamFlags, value)
+			case "TZUTC":
+				add(jamTZUTC, value)
+			default:
+				add(jamKludge, line.Text[1:])
+			}
+		case message.SeenBy:
+			seenBy = append(seenBy, strings.TrimSpace(line.Text[len("SEEN-BY:"):]))
+		case message.Path:
+			path = append(path, strings.TrimSpace(line.Text[len("\x01PATH:"):]))
+		default:
+			body = append(body, line.Text)
+		}
+	}
+	for _, s := range seenBy {
+		add(jamSeenBy, s)
+	}
+	for _, s := range path {
+		add(jamPath, s)
+	}
+	return b, strings.Join(body, "\r"), msgid, reply
+}
+
+func jamCRCOf(s string) uint32 {
+	if s == "" {
+		return jamNone
+	}
+	return jamCRC(s)
+}
+
+// Write adds a message to the base.
+func (j *JAM) Write(m *Message) (uint32, error) {
+	if err := lock(j.jhr); err != nil {
+		return 0, err
+	}
+	defer unlock(j.jhr)
+	base, err := j.base()
+	if err != nil {
+		return 0, err
+	}
+	subfields, text, msgid, reply := jamEncode(m)
+	textOff, err := size(j.jdt)
+	if err != nil {
+		return 0, err
+	}
+	hdrOff, err := size(j.jhr)
+	if err != nil {
+		return 0, err
+	}
+	idxOff, err := size(j.jdx)
+	if err != nil {
+		return 0, err
+	}
+	idxOff -= idxOff % jamIndexSize
+	num := base.BaseMsgNum + uint32(idxOff/jamIndexSize)
+	h := jamHeader{
+		Signature:     jamSignature,
+		Revision:      1,
+		SubfieldLen:   uint32(len(subfields)),
+		MsgIDCRC:      jamCRCOf(msgid),
+		ReplyCRC:      jamCRCOf(reply),
+		DateWritten:   wallClock(m.Written),
+		DateReceived:  wallClock(m.Arrived),
+		DateProcessed: wallClock(m.Arrived),
+		MessageNumber: num,
+		Attribute:     toJAM(m.Attributes, 0),
+		Offset:        uint32(textOff),
+		TxtLen:        uint32(len(text)),
+		PasswordCRC:   jamNone,
+	}
+	// The text and header are written before the index entry
+	// that makes the message visible.
+	if _, err := j.jdt.WriteAt([]byte(text), textOff); err != nil {
+		return 0, err
+	}
+	if err := writeAt(j.jhr, hdrOff, &h, subfields); err != nil {
+		return 0, err
+	}
+	if err := writeAt(j.jdx, idxOff, &jamIndex{jamCRC(m.To), uint32(hdrOff)}); err != nil {
+		return 0, err
+	}
+	base.ActiveMsgs++
+	return num, j.updated(base)
+}
+
+// SetAttributes replaces the attributes of a message, keeping
+// any JAM attributes that have no equivalent.
+func (j *JAM) SetAttributes(num uint32, attrs Attribute) error {
+	if err := lock(j.jhr); err != nil {
+		return err
+	}
+	defer unlock(j.jhr)
+	base, err := j.base()
+	if err != nil {
+		return err
+	}
+	h, off, _, err := j.header(base, num)
+	if err != nil {
+		return err
+	}
+	h.Attribute = toJAM(attrs, h.Attribute)
+	if err := writeAt(j.jhr, off, h); err != nil {
+		return err
+	}
+	return j.updated(base)
+}
+
+// Delete deletes a message.  Its header is marked deleted, and
+// its index entry cleared; the space it takes is reclaimed only
+// when the base is packed by other software.
+func (j *JAM) Delete(num uint32) error {
+	if err := lock(j.jhr); err != nil {
+		return err
+	}
+	defer unlock(j.jhr)
+	base, err := j.base()
+	if err != nil {
+		return err
+	}
+	h, off, idxOff, err := j.header(base, num)
+	if err != nil {
+		return err
+	}
+	h.Attribute |= jamDeleted
+	if err := writeAt(j.jhr, off, h); err != nil {
+		return err
+	}
+	if err := writeAt(j.jdx, idxOff, &jamIndex{jamNone, jamNone}); err != nil {
+		return err
+	}
+	if base.ActiveMsgs > 0 {
+		base.ActiveMsgs--
+	}
+	return j.updated(base)
+}
+
+// lastRead returns a user's record in the .jlr file, and its
+// offset, or -1 if the user has none.
+func (j *JAM) lastRead(user string) (*jamLastRead, int64, error) {
+	n, err := size(j.jlr)
+	if err != nil {
+		return nil, 0, err
+	}
+	crc := jamCRC(user)
+	for off := int64(0); off+jamLastReadSize <= n; off += jamLastReadSize {
+		var lr jamLastRead
+		if err := readAt(j.jlr, off, &lr); err != nil {
+			return nil, 0, err
+		}
+		if lr.UserCRC == crc {
+			return &lr, off, nil
+		}
+	}
+	return &jamLastRead{UserCRC: crc, UserID: crc}, -1, nil
+}
+
+// LastRead returns the number of the message a user last read,
+// and of the highest they have read, or zeroes if the base has no
+// record of the user.
+func (j *JAM) LastRead(user string) (uint32, uint32, error) {
+	lr, _, err := j.lastRead(user)
+	if err != nil {
+		return 0, 0, err
+	}
+	return lr.LastRead, lr.HighRead, nil
+}
+
+// SetLastRead records the messages a user last read, and the
+// highest they have read.  Users are known by the CRC of their
+// names, which serves as their ID too.
+func (j *JAM) SetLastRead(user string, last, high uint32) error {
+	if err := lock(j.jhr); err != nil {
+		return err
+	}
+	defer unlock(j.jhr)
+	lr, off, err := j.lastRead(user)
+	if err != nil {
+		return err
+	}
+	if off < 0 {
+		if off, err = size(j.jlr); err != nil {
+			return err
+		}
+		off -= off % jamLastReadSize
+	}
+	lr.LastRead, lr.HighRead = last, high
+	return writeAt(j.jlr, off, lr)
+}
@@ -0,0 +1,196 @@
+package msgbase
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"fat-dragon.org/ginko/ftn/ftntest"
+)
+
+func testMessage(t *testing.T) *Message {
+	return &Message{
+		From:       "Sysop",
+		To:         "All",
+		Subject:    "Test",
+		Origin:     ftntest.Address(t, "1:387/1.2@fidonet"),
+		Dest:       ftntest.Address(t, "1:387/108@fidonet"),
+		Written:    time.Date(2023, time.March, 4, 5, 6, 8, 0, time.UTC),
+		Arrived:    time.Date(2023, time.March, 4, 6, 0, 0, 0, time.UTC),
+		Attributes: Private | Local,
+		Text: "\x01MSGID: 1:387/1.2 12345678\x0d\x01REPLY: 1:387/108 0000abcd\r\x01PID: test\r" +
+			"\x01FLAGS DIR\r\x01TZUTC: 0100\r\x01CHRS: CP437 2\rHello\rthere\r--- test\r" +
+			" * Origin: A BBS (1:387/1.2)\rSEEN-BY: 387/1 108\rSEEN-BY: 387/2\r\x01PATH: 387/1\r",
+	}
+}
+
+func TestJAM(t *testing.T) {
+	name := path.Join(t.TempDir(), "fidotest")
+	j, err := OpenJAM(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	m := testMessage(t)
+	m.Attributes |= Echomail
+	var nums []uint32
+	for i := 0; i < 3; i++ {
+		num, err := j.Write(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nums = append(nums, num)
+	}
+	if !reflect.DeepEqual(nums, []uint32{1, 2, 3}) {
+		t.Errorf("wrote messages %v", nums)
+	}
+	got, err := j.Read(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("read %+v\nwant %+v", got, m)
+	}
+
+	// The message is laid out as JAM has it.
+	h, err := j.headerAt(jamBaseHeaderSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.MessageNumber != 1 || h.MsgIDCRC != jamCRC("1:387/1.2 12345678") || h.Attribute != 0x01000005 ||
+		h.DateWritten != uint32(m.Written.Unix()) || h.Offset != 0 || h.TxtLen != 50 {
+		t.Errorf("header %+v", h)
+	}
+	text, err := readBytes(j.jdt, 0, int64(h.TxtLen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(text) != "Hello\rthere\r--- test\r * Origin: A BBS (1:387/1.2)\r" {
+		t.Errorf("text %q", text)
+	}
+	indexes, err := j.indexes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indexes) != 3 || indexes[0] != (jamIndex{jamCRC("all"), jamBaseHeaderSize}) {
+		t.Errorf("index %+v", indexes)
+	}
+
+	if err := j.SetAttributes(1, Sent); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Delete(2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Read(2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("read a deleted message: %v", err)
+	}
+	if err := j.Delete(2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted a deleted message: %v", err)
+	}
+	for _, num := range []uint32{0, 4} {
+		if _, err := j.Read(num); !errors.Is(err, ErrNotFound) {
+			t.Errorf("read message %d: %v", num, err)
+		}
+	}
+
+	// Another program sees the changes.
+	again, err := OpenJAM(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+	if nums, err := again.Numbers(); err != nil || !reflect.DeepEqual(nums, []uint32{1, 3}) {
+		t.Errorf("numbers %v, %v", nums, err)
+	}
+	if got, err := again.Read(1); err != nil || got.Attributes != Sent {
+		t.Errorf("attributes %v, %v", got, err)
+	}
+	base, err := again.base()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base.ActiveMsgs != 2 || base.ModCounter != 5 || base.BaseMsgNum != 1 {
+		t.Errorf("base header %+v", base)
+	}
+	if num, err := again.Write(m); err != nil || num != 4 {
+		t.Errorf("wrote message %d, %v", num, err)
+	}
+}
+
+func TestJAMLastRead(t *testing.T) {
+	j, err := OpenJAM(path.Join(t.TempDir(), "fidotest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if last, high, err := j.LastRead("Sysop"); last != 0 || high != 0 || err != nil {
+		t.Errorf("LastRead = %d, %d, %v", last, high, err)
+	}
+	if err := j.SetLastRead("Sysop", 3, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.SetLastRead("Someone", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.SetLastRead("SYSOP", 4, 6); err != nil {
+		t.Fatal(err)
+	}
+	if last, high, err := j.LastRead("sysop"); last != 4 || high != 6 || err != nil {
+		t.Errorf("LastRead = %d, %d, %v", last, high, err)
+	}
+	if n, _ := size(j.jlr); n != 2*jamLastReadSize {
+		t.Errorf(".jlr is %d bytes", n)
+	}
+}
+
+func TestJAMCorrupt(t *testing.T) {
+	name := path.Join(t.TempDir(), "bad")
+	if err := os.WriteFile(name+".jhr", []byte("JAN\x00"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenJAM(name); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("opened a short base: %v", err)
+	}
+
+	j, err := OpenJAM(path.Join(t.TempDir(), "fidotest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if _, err := j.Write(testMessage(t)); err != nil {
+		t.Fatal(err)
+	}
+	// A subfield longer than the header says.
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], 1000)
+	if _, err := j.jhr.WriteAt(length[:], jamBaseHeaderSize+jamHeaderSize+4); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Read(1); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("read a corrupt message: %v", err)
+	}
+}
+
+func TestJAMCRC(t *testing.T) {
+	if got := jamCRC("123456789"); got != 0x340bc6d9 {
+		t.Errorf("jamCRC = %#x", got)
+	}
+	for _, s := range []struct {
+		data interface{}
+		size int
+	}{
+		{jamBaseHeader{}, jamBaseHeaderSize},
+		{jamHeader{}, jamHeaderSize},
+		{jamIndex{}, jamIndexSize},
+		{jamLastRead{}, jamLastReadSize},
+	} {
+		if n := binary.Size(s.data); n != s.size {
+			t.Errorf("%T is %d bytes, want %d", s.data, n, s.size)
+		}
+	}
+}
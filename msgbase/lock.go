@@ -0,0 +1,29 @@
+package msgbase
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// lock takes the lock that BBS software takes on a message base
+// while updating it: a write lock on the first byte of one of its
+// files, the header file of a JAM base or the data file of a
+// Squish base.  It waits for the lock if another program holds it.
+func lock(f *os.File) error {
+	return fcntlLock(f, syscall.F_WRLCK)
+}
+
+func unlock(f *os.File) error {
+	return fcntlLock(f, syscall.F_UNLCK)
+}
+
+func fcntlLock(f *os.File, typ int16) error {
+	lk := syscall.Flock_t{Type: typ, Whence: io.SeekStart, Start: 0, Len: 1}
+	for {
+		err := syscall.FcntlFlock(f.Fd(), syscall.F_SETLKW, &lk)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
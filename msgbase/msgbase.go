@@ -0,0 +1,253 @@
+// Package msgbase reads and writes the message bases that BBS
+// software keeps messages in: JAM bases, of `.jhr`, `.jdt`, `.jdx`
+// and `.jlr` files, and Squish bases, of `.sqd` and `.sqi` files.
+// Bases are locked for updating as other software locks them, so
+// they may be shared with a BBS while it runs.
+//
+// A message's text is kept as in a packet: its kludge lines,
+// body, and SEEN-BY and PATH lines, each ending with CR, though
+// without the AREA line of echomail.  Each format stores it in
+// its own way, so the order of the lines may change when stored.
+package msgbase
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"fat-dragon.org/ginko/ftn"
+	"fat-dragon.org/ginko/message"
+)
+
+var (
+	ErrNotFound = errors.New("msgbase: no such message")
+	ErrCorrupt  = errors.New("msgbase: corrupt message base")
+)
+
+// Attribute is the set of attributes of a stored message.  The
+// low 16 bits are those of FTS-0001, as in a packed message.
+type Attribute uint32
+
+const (
+	Private Attribute = 1 << iota
+	Crash
+	Received
+	Sent
+	FileAttached
+	InTransit
+	Orphan
+	KillSent
+	Local
+	Hold
+	_ // Unused.
+	FileRequest
+	ReturnReceiptRequest
+	IsReturnReceipt
+	AuditRequest
+	FileUpdateRequest
+	Scanned // Exported by a scanner.
+	Direct
+	Immediate
+	Echomail // Stored in an echomail area.
+	Netmail  // Stored in a netmail area.
+)
+
+// Has reports whether every attribute in a is set.
+func (attr Attribute) Has(a Attribute) bool {
+	return attr&a == a
+}
+
+// Message is a stored message.  The dates are as the writer of
+// the message and its recipient system saw them: wall-clock
+// times in no particular zone, which are returned in UTC.
+type Message struct {
+	From       string
+	To         string
+	Subject    string
+	Origin     ftn.Address
+	Dest       ftn.Address
+	Written    time.Time
+	Arrived    time.Time
+	Attributes Attribute
+	Text       string // Lines end with CR.
+}
+
+// Base is an open message base.  Messages are known by numbers
+// that do not change as others are added or deleted.
+type Base interface {
+	// Numbers returns the numbers of the messages in the base,
+	// oldest first.
+	Numbers() ([]uint32, error)
+	// Read returns a message.
+	Read(num uint32) (*Message, error)
+	// Write adds a message, returning its number.
+	Write(m *Message) (uint32, error)
+	// SetAttributes replaces the attributes of a message.
+	SetAttributes(num uint32, attrs Attribute) error
+	// Delete deletes a message.
+	Delete(num uint32) error
+	Close() error
+}
+
+// Open opens a message base, creating it if need be.  The base is
+// given as its format and path, without extension, as in
+// `jam:/bbs/msgs/fidotest` or `squish:/bbs/msgs/fidotest`.
+func Open(spec string) (Base, error) {
+	format, name, ok := strings.Cut(spec, ":")
+	if !ok || name == "" {
+		return nil, fmt.Errorf("msgbase: %q is not format:path", spec)
+	}
+	switch strings.ToLower(format) {
+	case "jam":
+		return OpenJAM(name)
+	case "squish":
+		return OpenSquish(name)
+	}
+	return nil, fmt.Errorf("msgbase: unknown format %q", format)
+}
+
+// Scan calls fn with each message posted locally that has not
+// yet been sent, as for export.  The messages are left as they
+// are, to be marked with MarkSent once they have been sent.
+func Scan(b Base, fn func(num uint32, m *Message) error) error {
+	nums, err := b.Numbers()
+	if err != nil {
+		return err
+	}
+	for _, num := range nums {
+		m, err := b.Read(num)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !m.Attributes.Has(Local) || m.Attributes&(Sent|Scanned) != 0 {
+			continue
+		}
+		if err := fn(num, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarkSent marks messages found by Scan as sent, so that they are
+// not scanned again.  Messages since deleted are skipped.
+func MarkSent(b Base, nums []uint32) error {
+	for _, num := range nums {
+		m, err := b.Read(num)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := b.SetAttributes(num, m.Attributes|Sent|Scanned); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wallClock returns a time as seconds since the epoch of its
+// wall-clock reading, as message bases keep dates, or zero for
+// the zero time.
+func wallClock(t time.Time) uint32 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint32(time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC).Unix())
+}
+
+// fromWallClock is the inverse of wallClock.
+func fromWallClock(secs uint32) time.Time {
+	if secs == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(secs), 0).UTC()
+}
+
+// cString returns the string in a NUL-padded field.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// readAt reads a little-endian structure from a file at the given
+// offset.  A structure cut short by the end of the file is corrupt.
+func readAt(f *os.File, off int64, data interface{}) error {
+	buf := make([]byte, binary.Size(data))
+	if n, err := f.ReadAt(buf, off); n < len(buf) {
+		if err == io.EOF {
+			return fmt.Errorf("%w: %s ends at %d", ErrCorrupt, path.Base(f.Name()), off+int64(n))
+		}
+		return err
+	}
+	return binary.Read(bytes.NewReader(buf), binary.LittleEndian, data)
+}
+
+// writeAt writes little-endian structures, or byte slices, one
+// after another to a file at the given offset.
+func writeAt(f *os.File, off int64, data ...interface{}) error {
+	var b bytes.Buffer
+	for _, d := range data {
+		binary.Write(&b, binary.LittleEndian, d)
+	}
+	_, err := f.WriteAt(b.Bytes(), off)
+	return err
+}
+
+// readBytes reads n bytes from a file at the given offset,
+// checking first that the file holds them.
+func readBytes(f *os.File, off int64, n int64) ([]byte, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if off < 0 || n < 0 || off+n > fi.Size() {
+		return nil, fmt.Errorf("%w: %d bytes at %d beyond the end of %s", ErrCorrupt, n, off, path.Base(f.Name()))
+	}
+	buf := make([]byte, n)
+	if _, err := f.ReadAt(buf, off); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// size returns the size of a file.
+func size(f *os.File) (int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// textLines returns text split into lines at CR, without the
+// AREA line of echomail, as message.Parse classifies them.
+func textLines(text string) []message.Line {
+	lines := message.Parse(text).Lines
+	if len(lines) > 0 && lines[0].Kind == message.Area {
+		lines = lines[1:]
+	}
+	return lines
+}
+
+// splitKludge splits a kludge line, without its ^A, into its
+// name and value, which are separated by a colon or a space.
+func splitKludge(text string) (string, string) {
+	i := strings.IndexAny(text, ": ")
+	if i < 0 {
+		return text, ""
+	}
+	return text[:i], strings.TrimSpace(text[i+1:])
+}
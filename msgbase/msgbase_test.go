@@ -0,0 +1,135 @@
+package msgbase
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"fat-dragon.org/ginko/ftn"
+	"fat-dragon.org/ginko/pkt"
+)
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		spec string
+		want string
+	}{
+		{"jam:" + path.Join(dir, "a"), "*msgbase.JAM"},
+		{"Squish:" + path.Join(dir, "b"), "*msgbase.Squish"},
+		{"hudson:" + path.Join(dir, "c"), ""},
+		{path.Join(dir, "d"), ""},
+		{"jam:", ""},
+	} {
+		b, err := Open(tc.spec)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("Open(%q) succeeded", tc.spec)
+				b.Close()
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Open(%q): %v", tc.spec, err)
+			continue
+		}
+		if got := fmt.Sprintf("%T", b); got != tc.want {
+			t.Errorf("Open(%q) = %s, want %s", tc.spec, got, tc.want)
+		}
+		b.Close()
+	}
+}
+
+func TestScan(t *testing.T) {
+	for _, format := range []string{"jam", "squish"} {
+		b, err := Open(format + ":" + path.Join(t.TempDir(), "local"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close()
+		for _, attrs := range []Attribute{Local, 0, Local | Sent, Local | Private, Local} {
+			m := testMessage(t)
+			m.Attributes = attrs
+			if _, err := b.Write(m); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := b.Delete(5); err != nil {
+			t.Fatal(err)
+		}
+		var scanned []uint32
+		scan := func(num uint32, m *Message) error {
+			scanned = append(scanned, num)
+			return nil
+		}
+		if err := Scan(b, scan); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(scanned, []uint32{1, 4}) {
+			t.Errorf("%s: scanned %v", format, scanned)
+		}
+		if m, err := b.Read(4); err != nil || m.Attributes.Has(Sent) {
+			t.Errorf("%s: marked sent by the scan: %+v, %v", format, m, err)
+		}
+		if err := MarkSent(b, scanned); err != nil {
+			t.Fatal(err)
+		}
+		if m, err := b.Read(4); err != nil || !m.Attributes.Has(Local|Private|Sent) {
+			t.Errorf("%s: scanned message %+v, %v", format, m, err)
+		}
+		scanned = nil
+		if err := Scan(b, scan); err != nil || scanned != nil {
+			t.Errorf("%s: scanned %v again, %v", format, scanned, err)
+		}
+	}
+}
+
+func TestPacket(t *testing.T) {
+	packed := &pkt.Message{
+		Origin:     ftn.NewAddress2d(5020, 1),
+		Dest:       ftn.NewAddress2d(387, 108),
+		Attributes: pkt.Private | pkt.Crash | pkt.Local,
+		DateTime:   "04 Mar 23  05:06:07",
+		To:         "Sysop",
+		From:       "Someone",
+		Subject:    "Hi",
+		Text:       "\x01INTL 1:387/108 2:5020/1\r\x01FMPT 3\r\x01TOPT 4\rHello\r",
+	}
+	arrived := time.Date(2023, time.March, 4, 6, 0, 0, 0, time.UTC)
+	m := FromPacket(packed, 1, arrived)
+	want := &Message{
+		From:       "Someone",
+		To:         "Sysop",
+		Subject:    "Hi",
+		Origin:     ftn.NewAddress4d(2, 5020, 1, 3),
+		Dest:       ftn.NewAddress4d(1, 387, 108, 4),
+		Written:    time.Date(2023, time.March, 4, 5, 6, 7, 0, time.UTC),
+		Arrived:    arrived,
+		Attributes: Private | Crash | Local | Netmail,
+		Text:       packed.Text,
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("FromPacket = %+v\nwant %+v", m, want)
+	}
+	repacked := *packed
+	repacked.Attributes = pkt.Private
+	if got := m.Packet(""); !reflect.DeepEqual(got, &repacked) {
+		t.Errorf("Packet = %+v\nwant %+v", got, &repacked)
+	}
+
+	echomail := &pkt.Message{
+		Origin:   ftn.NewAddress2d(387, 1),
+		Dest:     ftn.NewAddress2d(387, 108),
+		DateTime: "garbage",
+		Text:     "AREA:FIDOTEST\r\x01MSGID: 1:387/1 1\rHello\r * Origin: A BBS (1:387/1.2)\rSEEN-BY: 387/1\r",
+	}
+	m = FromPacket(echomail, 1, arrived)
+	if m.Origin != ftn.NewAddress4d(1, 387, 1, 2) || !m.Written.IsZero() || m.Text != echomail.Text[len("AREA:FIDOTEST\r"):] {
+		t.Errorf("FromPacket = %+v", m)
+	}
+	if got := m.Packet("FIDOTEST"); got.Text != echomail.Text {
+		t.Errorf("Packet text %q", got.Text)
+	}
+}
@@ -0,0 +1,73 @@
+package msgbase
+
+import (
+	"strings"
+	"time"
+
+	"fat-dragon.org/ginko/ftn"
+	"fat-dragon.org/ginko/message"
+	"fat-dragon.org/ginko/pkt"
+)
+
+// FromPacket returns a packed message, which arrived at the given
+// time, as it is stored.  The packed message gives only 2D
+// addresses: the zones are taken from its INTL kludge, or failing
+// that are assumed to be the given zone, and the points from its
+// FMPT and TOPT kludges.  The origin of echomail is taken from its
+// origin line, if it gives one.  The message is marked as echomail
+// or netmail.
+func FromPacket(m *pkt.Message, zone ftn.Zone, arrived time.Time) *Message {
+	text := message.Parse(m.Text)
+	origin, dest := m.Origin, m.Dest
+	originZone, destZone := zone, zone
+	if text.Intl != nil {
+		origin, dest = text.Intl.Origin, text.Intl.Dest
+		originZone, destZone = origin.Zone(), dest.Zone()
+	}
+	stored := &Message{
+		From:       m.From,
+		To:         m.To,
+		Subject:    m.Subject,
+		Origin:     ftn.NewAddress4d(originZone, origin.Net(), origin.Node(), text.FromPoint),
+		Dest:       ftn.NewAddress4d(destZone, dest.Net(), dest.Node(), text.ToPoint),
+		Arrived:    arrived,
+		Attributes: Attribute(m.Attributes),
+	}
+	if text.Area != "" {
+		stored.Attributes |= Echomail
+		if addr, ok := text.OriginAddress(); ok {
+			stored.Origin = addr
+		}
+	} else {
+		stored.Attributes |= Netmail
+	}
+	// Dates that cannot be parsed are left unknown.
+	stored.Written, _ = time.Parse(pkt.DateTimeFormat, m.DateTime)
+	lines := textLines(m.Text)
+	texts := make([]string, len(lines))
+	for i, line := range lines {
+		texts[i] = line.Text
+	}
+	stored.Text = strings.Join(texts, "\r")
+	return stored
+}
+
+// Packet returns a stored message packed for sending as
+// echomail in the given area, or as netmail if the area is empty.
+// Its local attributes are cleared.
+func (m *Message) Packet(area string) *pkt.Message {
+	text := m.Text
+	if area != "" {
+		text = "AREA:" + area + "\r" + text
+	}
+	return &pkt.Message{
+		Origin:     ftn.NewAddress2d(m.Origin.Net(), m.Origin.Node()),
+		Dest:       ftn.NewAddress2d(m.Dest.Net(), m.Dest.Node()),
+		Attributes: pkt.Attribute(m.Attributes) &^ pkt.LocalAttributes,
+		DateTime:   pkt.FormatDateTime(m.Written),
+		To:         m.To,
+		From:       m.From,
+		Subject:    m.Subject,
+		Text:       text,
+	}
+}
@@ -0,0 +1,538 @@
+package msgbase
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"fat-dragon.org/ginko/ftn"
+	"fat-dragon.org/ginko/message"
+	"fat-dragon.org/ginko/pkt"
+)
+
+// squishBase begins the .sqd file of a Squish base.  Its
+// messages are kept in frames, in a doubly-linked list from
+// BeginFrame to LastFrame; deleted messages' frames are kept in
+// another, from FreeFrame to LastFreeFrame.
+type squishBase struct {
+	Len           uint16
+	Reserved1     uint16
+	NumMsg        uint32
+	HighMsg       uint32
+	SkipMsg       uint32
+	HighWater     uint32
+	UID           uint32 // The UMSGID of the next message.
+	Base          [80]byte
+	BeginFrame    uint32
+	LastFrame     uint32
+	FreeFrame     uint32
+	LastFreeFrame uint32
+	EndFrame      uint32
+	MaxMsg        uint32
+	KeepDays      uint16
+	SzSqhdr       uint16
+	Reserved2     [124]byte
+}
+
+const squishBaseSize = 256
+
+// squishFrame heads each frame.
+type squishFrame struct {
+	ID          uint32
+	NextFrame   uint32
+	PrevFrame   uint32
+	FrameLength uint32
+	MsgLength   uint32
+	CLen        uint32 // Of the control information.
+	FrameType   uint16
+	Reserved    uint16
+}
+
+const (
+	squishFrameSize = 28
+	squishFrameID   = 0xafae4453
+)
+
+// Frame types.
+const (
+	squishNormal = 0
+	squishFree   = 1
+)
+
+type squishAddress struct {
+	Zone, Net, Node, Point uint16
+}
+
+func toSquishAddress(a ftn.Address) squishAddress {
+	return squishAddress{uint16(a.Zone()), uint16(a.Net()), uint16(a.Node()), uint16(a.Point())}
+}
+
+func (a squishAddress) address() ftn.Address {
+	return ftn.NewAddress4d(ftn.Zone(a.Zone), ftn.Net(a.Net), ftn.Node(a.Node), ftn.Point(a.Point))
+}
+
+// squishStamp is a date and time in the packed form of MS-DOS.
+type squishStamp struct {
+	Date uint16 // Day, month and year since 1980, from the low bits.
+	Time uint16 // Seconds/2, minutes and hours, from the low bits.
+}
+
+func toSquishStamp(t time.Time) squishStamp {
+	if t.Year() < 1980 {
+		return squishStamp{}
+	}
+	return squishStamp{
+		uint16(t.Day() | int(t.Month())<<5 | (t.Year()-1980)<<9),
+		uint16(t.Second()/2 | t.Minute()<<5 | t.Hour()<<11),
+	}
+}
+
+func (s squishStamp) time() time.Time {
+	if s.Date == 0 {
+		return time.Time{}
+	}
+	return time.Date(1980+int(s.Date>>9), time.Month(s.Date>>5&15), int(s.Date&31),
+		int(s.Time>>11), int(s.Time>>5&63), int(s.Time&31)*2, 0, time.UTC)
+}
+
+// squishMessage is the fixed header of a message, which follows
+// its frame header, and is followed by its control information
+// and text.
+type squishMessage struct {
+	Attr        uint32
+	From        [36]byte
+	To          [36]byte
+	Subject     [72]byte
+	Orig        squishAddress
+	Dest        squishAddress
+	DateWritten squishStamp
+	DateArrived squishStamp
+	UTCOffset   int16
+	ReplyTo     uint32
+	Replies     [9]uint32
+	UMsgID      uint32
+	FTSCDate    [20]byte
+}
+
+const squishMessageSize = 238
+
+// squishIndex is an entry of the .sqi file, one for each message
+// in order.
+type squishIndex struct {
+	Offset uint32 // Of the message's frame.
+	UMsgID uint32
+	Hash   uint32
+}
+
+const squishIndexSize = 12
+
+// Attributes beyond those of FTS-0001.
+const (
+	squishAttributes = 0x0001ffff // Those we share, to Scanned.
+	squishUID        = 0x00020000 // UMsgID is set.
+	squishHashRead   = 0x80000000 // In an index hash.
+)
+
+// squishHash returns the hash of a recipient's name, as kept in
+// the index.
+func squishHash(name string) uint32 {
+	var hash uint32
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		// Squish takes characters as signed.
+		hash = hash<<4 + uint32(int8(c))
+		// Squish keeps the high bits, where the ELF hash it
+		// follows would clear them.
+		if g := hash & 0xf0000000; g != 0 {
+			hash |= g >> 24
+			hash |= g
+		}
+	}
+	return hash & 0x7fffffff
+}
+
+func squishIndexHash(to string, attrs Attribute) uint32 {
+	hash := squishHash(to)
+	if attrs.Has(Received) {
+		hash |= squishHashRead
+	}
+	return hash
+}
+
+// Squish is an open Squish message base.  Its messages are known
+// by their UMSGIDs, which Squish assigns in increasing order.
+type Squish struct {
+	sqd, sqi *os.File
+}
+
+// OpenSquish opens the Squish base whose files have the given
+// name, without extension, creating it if need be.
+func OpenSquish(name string) (*Squish, error) {
+	sqd, err := os.OpenFile(name+".sqd", os.O_RDWR|os.O_CREATE, 0660)
+	if err != nil {
+		return nil, err
+	}
+	sqi, err := os.OpenFile(name+".sqi", os.O_RDWR|os.O_CREATE, 0660)
+	if err != nil {
+		sqd.Close()
+		return nil, err
+	}
+	s := &Squish{sqd, sqi}
+	if err := s.init(path.Base(name)); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return s, nil
+}
+
+// init writes the header of a new base, or checks that of an
+// existing one.
+func (s *Squish) init(name string) error {
+	if err := lock(s.sqd); err != nil {
+		return err
+	}
+	defer unlock(s.sqd)
+	n, err := size(s.sqd)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		base := squishBase{
+			Len:      squishBaseSize,
+			UID:      1,
+			EndFrame: squishBaseSize,
+			SzSqhdr:  squishFrameSize,
+		}
+		copy(base.Base[:len(base.Base)-1], name)
+		return writeAt(s.sqd, 0, &base)
+	}
+	_, err = s.base()
+	return err
+}
+
+func (s *Squish) Close() error {
+	err := s.sqd.Close()
+	if cerr := s.sqi.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *Squish) base() (*squishBase, error) {
+	var base squishBase
+	if err := readAt(s.sqd, 0, &base); err != nil {
+		return nil, err
+	}
+	if base.Len != squishBaseSize || base.SzSqhdr != squishFrameSize {
+		return nil, fmt.Errorf("%w: bad Squish header", ErrCorrupt)
+	}
+	return &base, nil
+}
+
+func (s *Squish) frame(off uint32) (*squishFrame, error) {
+	var frame squishFrame
+	if err := readAt(s.sqd, int64(off), &frame); err != nil {
+		return nil, err
+	}
+	if off < squishBaseSize || frame.ID != squishFrameID {
+		return nil, fmt.Errorf("%w: bad Squish frame at %d", ErrCorrupt, off)
+	}
+	return &frame, nil
+}
+
+// setLinks changes the links of the frame at the given offset.
+func (s *Squish) setLinks(off uint32, change func(f *squishFrame)) error {
+	frame, err := s.frame(off)
+	if err != nil {
+		return err
+	}
+	change(frame)
+	return writeAt(s.sqd, int64(off), frame)
+}
+
+func (s *Squish) indexes() ([]squishIndex, error) {
+	n, err := size(s.sqi)
+	if err != nil {
+		return nil, err
+	}
+	indexes := make([]squishIndex, n/squishIndexSize)
+	if len(indexes) > 0 {
+		if err := readAt(s.sqi, 0, indexes); err != nil {
+			return nil, err
+		}
+	}
+	return indexes, nil
+}
+
+// find returns the position of a message in the index, and its
+// entry.  UMSGIDs increase through the index, so it is searched
+// by bisection, as Squish does.
+func (s *Squish) find(num uint32) (int, *squishIndex, error) {
+	n, err := size(s.sqi)
+	if err != nil {
+		return 0, nil, err
+	}
+	var idx squishIndex
+	lo, hi := 0, int(n/squishIndexSize)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if err := readAt(s.sqi, int64(mid)*squishIndexSize, &idx); err != nil {
+			return 0, nil, err
+		}
+		switch {
+		case idx.UMsgID == num:
+			return mid, &idx, nil
+		case idx.UMsgID < num:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return 0, nil, ErrNotFound
+}
+
+// message returns the frame of a message, and its header.
+func (s *Squish) message(off uint32) (*squishFrame, *squishMessage, error) {
+	frame, err := s.frame(off)
+	if err != nil {
+		return nil, nil, err
+	}
+	if frame.FrameType != squishNormal || frame.MsgLength < squishMessageSize ||
+		frame.CLen > frame.MsgLength-squishMessageSize {
+		return nil, nil, fmt.Errorf("%w: bad Squish message frame at %d", ErrCorrupt, off)
+	}
+	var msg squishMessage
+	if err := readAt(s.sqd, int64(off)+squishFrameSize, &msg); err != nil {
+		return nil, nil, err
+	}
+	return frame, &msg, nil
+}
+
+// Numbers returns the UMSGIDs of the messages in the base.
+func (s *Squish) Numbers() ([]uint32, error) {
+	indexes, err := s.indexes()
+	if err != nil {
+		return nil, err
+	}
+	nums := make([]uint32, len(indexes))
+	for i, idx := range indexes {
+		nums[i] = idx.UMsgID
+	}
+	return nums, nil
+}
+
+// Read returns a message.  Its control information is given as
+// kludge lines at the start of its text.
+func (s *Squish) Read(num uint32) (*Message, error) {
+	_, idx, err := s.find(num)
+	if err != nil {
+		return nil, err
+	}
+	off := idx.Offset
+	frame, msg, err := s.message(off)
+	if err != nil {
+		return nil, err
+	}
+	data, err := readBytes(s.sqd, int64(off)+squishFrameSize+squishMessageSize, int64(frame.MsgLength-squishMessageSize))
+	if err != nil {
+		return nil, err
+	}
+	var text strings.Builder
+	for _, kludge := range strings.Split(cString(data[:frame.CLen]), "\x01") {
+		if kludge = strings.TrimRight(kludge, "\r\n"); kludge != "" {
+			text.WriteString("\x01" + kludge + "\r")
+		}
+	}
+	text.WriteString(cString(data[frame.CLen:]))
+	return &Message{
+		From:       cString(msg.From[:]),
+		To:         cString(msg.To[:]),
+		Subject:    cString(msg.Subject[:]),
+		Origin:     msg.Orig.address(),
+		Dest:       msg.Dest.address(),
+		Written:    msg.DateWritten.time(),
+		Arrived:    msg.DateArrived.time(),
+		Attributes: Attribute(msg.Attr & squishAttributes),
+		Text:       text.String(),
+	}, nil
+}
+
+// squishEncode returns the control information and text with
+// which a message is stored: the kludge lines that begin it are
+// its control information, and the rest its text.
+func squishEncode(m *Message) ([]byte, []byte) {
+	lines := textLines(m.Text)
+	var ctrl []byte
+	for len(lines) > 0 && lines[0].Kind == message.Kludge {
+		ctrl = append(ctrl, lines[0].Text...)
+		lines = lines[1:]
+	}
+	if ctrl != nil {
+		ctrl = append(ctrl, 0)
+	}
+	texts := make([]string, len(lines))
+	for i, line := range lines {
+		texts[i] = line.Text
+	}
+	return ctrl, append([]byte(strings.Join(texts, "\r")), 0)
+}
+
+// Write adds a message to the end of the base, returning its
+// UMSGID.  The frames of deleted messages are not reused.
+func (s *Squish) Write(m *Message) (uint32, error) {
+	if err := lock(s.sqd); err != nil {
+		return 0, err
+	}
+	defer unlock(s.sqd)
+	base, err := s.base()
+	if err != nil {
+		return 0, err
+	}
+	idxOff, err := size(s.sqi)
+	if err != nil {
+		return 0, err
+	}
+	idxOff -= idxOff % squishIndexSize
+	ctrl, text := squishEncode(m)
+	msg := squishMessage{
+		Attr:        uint32(m.Attributes)&squishAttributes | squishUID,
+		Orig:        toSquishAddress(m.Origin),
+		Dest:        toSquishAddress(m.Dest),
+		DateWritten: toSquishStamp(m.Written),
+		DateArrived: toSquishStamp(m.Arrived),
+		UMsgID:      base.UID,
+	}
+	copy(msg.From[:len(msg.From)-1], m.From)
+	copy(msg.To[:len(msg.To)-1], m.To)
+	copy(msg.Subject[:len(msg.Subject)-1], m.Subject)
+	if !m.Written.IsZero() {
+		copy(msg.FTSCDate[:len(msg.FTSCDate)-1], pkt.FormatDateTime(m.Written))
+	}
+	length := uint32(squishMessageSize + len(ctrl) + len(text))
+	off := base.EndFrame
+	frame := squishFrame{
+		ID:          squishFrameID,
+		PrevFrame:   base.LastFrame,
+		FrameLength: length,
+		MsgLength:   length,
+		CLen:        uint32(len(ctrl)),
+		FrameType:   squishNormal,
+	}
+	if err := writeAt(s.sqd, int64(off), &frame, &msg, ctrl, text); err != nil {
+		return 0, err
+	}
+	if base.LastFrame != 0 {
+		err := s.setLinks(base.LastFrame, func(f *squishFrame) { f.NextFrame = off })
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		base.BeginFrame = off
+	}
+	idx := squishIndex{off, msg.UMsgID, squishIndexHash(m.To, m.Attributes)}
+	if err := writeAt(s.sqi, idxOff, &idx); err != nil {
+		return 0, err
+	}
+	base.LastFrame = off
+	base.EndFrame = off + squishFrameSize + length
+	base.NumMsg++
+	base.HighMsg = base.NumMsg
+	base.UID++
+	return msg.UMsgID, writeAt(s.sqd, 0, base)
+}
+
+// SetAttributes replaces the attributes of a message.
+func (s *Squish) SetAttributes(num uint32, attrs Attribute) error {
+	if err := lock(s.sqd); err != nil {
+		return err
+	}
+	defer unlock(s.sqd)
+	i, idx, err := s.find(num)
+	if err != nil {
+		return err
+	}
+	_, msg, err := s.message(idx.Offset)
+	if err != nil {
+		return err
+	}
+	msg.Attr = msg.Attr&^squishAttributes | uint32(attrs)&squishAttributes
+	if err := writeAt(s.sqd, int64(idx.Offset)+squishFrameSize, msg); err != nil {
+		return err
+	}
+	idx.Hash = squishIndexHash(cString(msg.To[:]), attrs)
+	return writeAt(s.sqi, int64(i)*squishIndexSize, idx)
+}
+
+// Delete deletes a message, moving its frame to the free list.
+func (s *Squish) Delete(num uint32) error {
+	if err := lock(s.sqd); err != nil {
+		return err
+	}
+	defer unlock(s.sqd)
+	base, err := s.base()
+	if err != nil {
+		return err
+	}
+	i, idx, err := s.find(num)
+	if err != nil {
+		return err
+	}
+	indexes, err := s.indexes()
+	if err != nil {
+		return err
+	}
+	off := idx.Offset
+	frame, _, err := s.message(off)
+	if err != nil {
+		return err
+	}
+	prev, next := frame.PrevFrame, frame.NextFrame
+	if prev != 0 {
+		if err := s.setLinks(prev, func(f *squishFrame) { f.NextFrame = next }); err != nil {
+			return err
+		}
+	} else {
+		base.BeginFrame = next
+	}
+	if next != 0 {
+		if err := s.setLinks(next, func(f *squishFrame) { f.PrevFrame = prev }); err != nil {
+			return err
+		}
+	} else {
+		base.LastFrame = prev
+	}
+	frame.FrameType = squishFree
+	frame.PrevFrame, frame.NextFrame = base.LastFreeFrame, 0
+	if err := writeAt(s.sqd, int64(off), frame); err != nil {
+		return err
+	}
+	if base.LastFreeFrame != 0 {
+		if err := s.setLinks(base.LastFreeFrame, func(f *squishFrame) { f.NextFrame = off }); err != nil {
+			return err
+		}
+	} else {
+		base.FreeFrame = off
+	}
+	base.LastFreeFrame = off
+	rest := indexes[i+1:]
+	if len(rest) > 0 {
+		if err := writeAt(s.sqi, int64(i)*squishIndexSize, rest); err != nil {
+			return err
+		}
+	}
+	if err := s.sqi.Truncate(int64(len(indexes)-1) * squishIndexSize); err != nil {
+		return err
+	}
+	base.NumMsg--
+	base.HighMsg = base.NumMsg
+	if uint32(i) < base.HighWater {
+		// The high water mark counts messages, not UMSGIDs.
+		base.HighWater--
+	}
+	return writeAt(s.sqd, 0, base)
+}
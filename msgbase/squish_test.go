@@ -0,0 +1,193 @@
+package msgbase
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"fat-dragon.org/ginko/ftn"
+)
+
+func TestSquish(t *testing.T) {
+	name := path.Join(t.TempDir(), "fidotest")
+	s, err := OpenSquish(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	m := testMessage(t)
+	var nums []uint32
+	for i := 0; i < 3; i++ {
+		num, err := s.Write(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nums = append(nums, num)
+	}
+	if !reflect.DeepEqual(nums, []uint32{1, 2, 3}) {
+		t.Errorf("wrote messages %v", nums)
+	}
+	got, err := s.Read(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Squish keeps no domains.
+	want := *m
+	want.Origin = ftn.NewAddress4d(1, 387, 1, 2)
+	want.Dest = ftn.NewAddress4d(1, 387, 108, 0)
+	if !reflect.DeepEqual(got, &want) {
+		t.Errorf("read %+v\nwant %+v", got, &want)
+	}
+
+	// The message is laid out as Squish has it.
+	frame, msg, err := s.message(squishBaseSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctrl := "\x01MSGID: 1:387/1.2 12345678\x01REPLY: 1:387/108 0000abcd\x01PID: test\x01FLAGS DIR\x01TZUTC: 0100\x01CHRS: CP437 2\x00"
+	if frame.CLen != uint32(len(ctrl)) || frame.PrevFrame != 0 || frame.NextFrame != squishBaseSize+squishFrameSize+frame.FrameLength {
+		t.Errorf("frame %+v", frame)
+	}
+	data, err := readBytes(s.sqd, squishBaseSize+squishFrameSize+squishMessageSize, int64(frame.CLen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != ctrl {
+		t.Errorf("control information %q", data)
+	}
+	if msg.Attr != 0x20101 || msg.UMsgID != 1 || string(msg.FTSCDate[:19]) != "04 Mar 23  05:06:08" ||
+		msg.DateWritten != (squishStamp{3<<9 | 43<<9 | 3<<5 | 4, 5<<11 | 6<<5 | 4}) {
+		t.Errorf("message header %+v", msg)
+	}
+	indexes, err := s.indexes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indexes) != 3 || indexes[0] != (squishIndex{squishBaseSize, 1, squishHash("All")}) {
+		t.Errorf("index %+v", indexes)
+	}
+
+	if err := s.SetAttributes(1, Sent|Received); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Read(2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("read a deleted message: %v", err)
+	}
+	if err := s.Delete(2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted a deleted message: %v", err)
+	}
+
+	// Another program sees the changes.
+	again, err := OpenSquish(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+	if nums, err := again.Numbers(); err != nil || !reflect.DeepEqual(nums, []uint32{1, 3}) {
+		t.Errorf("numbers %v, %v", nums, err)
+	}
+	if got, err := again.Read(1); err != nil || got.Attributes != Sent|Received {
+		t.Errorf("attributes %v, %v", got, err)
+	}
+	if indexes, _ := again.indexes(); len(indexes) != 2 || indexes[0].Hash != squishHash("All")|squishHashRead {
+		t.Errorf("index %+v", indexes)
+	}
+	base, err := again.base()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := indexes[1].Offset
+	third := indexes[2].Offset
+	if base.NumMsg != 2 || base.HighMsg != 2 || base.UID != 4 || base.BeginFrame != squishBaseSize ||
+		base.LastFrame != third || base.FreeFrame != second || base.LastFreeFrame != second {
+		t.Errorf("base header %+v", base)
+	}
+	if f, _ := again.frame(squishBaseSize); f.NextFrame != third {
+		t.Errorf("first frame %+v", f)
+	}
+	if f, _ := again.frame(third); f.PrevFrame != squishBaseSize {
+		t.Errorf("third frame %+v", f)
+	}
+	if f, _ := again.frame(second); f.FrameType != squishFree {
+		t.Errorf("freed frame %+v", f)
+	}
+
+	// Deleting the rest empties the base.
+	for _, num := range []uint32{3, 1} {
+		if err := again.Delete(num); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if base, _ = again.base(); base.NumMsg != 0 || base.BeginFrame != 0 || base.LastFrame != 0 || base.LastFreeFrame != squishBaseSize {
+		t.Errorf("base header %+v", base)
+	}
+	if num, err := again.Write(m); err != nil || num != 4 {
+		t.Errorf("wrote message %d, %v", num, err)
+	}
+	if nums, err := again.Numbers(); err != nil || !reflect.DeepEqual(nums, []uint32{4}) {
+		t.Errorf("numbers %v, %v", nums, err)
+	}
+}
+
+func TestSquishCorrupt(t *testing.T) {
+	name := path.Join(t.TempDir(), "bad")
+	if err := os.WriteFile(name+".sqd", make([]byte, squishBaseSize), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenSquish(name); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("opened a bad base: %v", err)
+	}
+
+	s, err := OpenSquish(path.Join(t.TempDir(), "fidotest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.Write(testMessage(t)); err != nil {
+		t.Fatal(err)
+	}
+	// Control information longer than the message.
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], 1000)
+	if _, err := s.sqd.WriteAt(length[:], squishBaseSize+20); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Read(1); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("read a corrupt message: %v", err)
+	}
+}
+
+func TestSquishHash(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want uint32
+	}{
+		{"All", 0x682c},
+		{"all", 0x682c},
+		{"", 0},
+		{"Andrew Clarke", 0x7eeb8df5}, // Long enough to carry into the high bits.
+	} {
+		if got := squishHash(tc.name); got != tc.want {
+			t.Errorf("squishHash(%q) = %#x, want %#x", tc.name, got, tc.want)
+		}
+	}
+	for _, s := range []struct {
+		data interface{}
+		size int
+	}{
+		{squishBase{}, squishBaseSize},
+		{squishFrame{}, squishFrameSize},
+		{squishMessage{}, squishMessageSize},
+		{squishIndex{}, squishIndexSize},
+	} {
+		if n := binary.Size(s.data); n != s.size {
+			t.Errorf("%T is %d bytes, want %d", s.data, n, s.size)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package nodelist
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Nodelists are distributed weekly as a full list and as a
+// NODEDIFF: an edit script that turns the previous week's list
+// into the current one.  The first line of a diff is identical
+// to the first line of the list it applies to.  Each following
+// line is a command, one of
+//
+//	Ann	add the next nn lines of the diff
+//	Cnn	copy the next nn lines of the old list
+//	Dnn	delete (skip) the next nn lines of the old list
+//
+// The result carries, in its first line, the CRC of everything
+// after that line, by which it is verified.
+
+// ErrDiffMismatch is returned when a diff does not apply to a
+// nodelist, because it was made from a different list.
+var ErrDiffMismatch = errors.New("nodediff does not apply to this nodelist")
+
+// CRC returns the CRC of a nodelist, as given in its header: the
+// CRC-16 (CCITT polynomial, initial value zero) of every byte
+// after the first line, up to any ^Z.
+func CRC(list []byte) uint16 {
+	_, body := splitHeader(list)
+	var crc uint16
+	for _, b := range body {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Verify checks a nodelist against the CRC in its header.
+func Verify(list []byte) error {
+	header, _ := splitHeader(list)
+	var n Nodelist
+	n.parseHeader(string(bytes.TrimRight(header, "\r\n")))
+	if !n.HasCRC {
+		return errors.New("nodelist header has no CRC")
+	}
+	if crc := CRC(list); crc != n.CRC {
+		return fmt.Errorf("nodelist CRC is %05d, header says %05d", crc, n.CRC)
+	}
+	return nil
+}
+
+// splitHeader splits the first line of a nodelist, including its
+// line ending, from the rest, which ends at any ^Z.
+func splitHeader(list []byte) ([]byte, []byte) {
+	if i := bytes.IndexByte(list, 0x1a); i >= 0 {
+		list = list[:i]
+	}
+	if i := bytes.IndexByte(list, '\n'); i >= 0 {
+		return list[:i+1], list[i+1:]
+	}
+	return list, nil
+}
+
+// lines splits text into lines, each keeping its line ending.
+// Text after any ^Z is ignored.
+func lines(text []byte) [][]byte {
+	if i := bytes.IndexByte(text, 0x1a); i >= 0 {
+		text = text[:i]
+	}
+	var ls [][]byte
+	for len(text) > 0 {
+		i := bytes.IndexByte(text, '\n')
+		if i < 0 {
+			i = len(text) - 1
+		}
+		ls = append(ls, text[:i+1])
+		text = text[i+1:]
+	}
+	return ls
+}
+
+// ApplyDiff applies a nodediff to a nodelist, returning the new
+// list once it has been verified against the CRC in its header.
+// The new list ends with ^Z if the old one did.
+func ApplyDiff(list, diff []byte) ([]byte, error) {
+	old := lines(list)
+	script := lines(diff)
+	if len(old) == 0 || len(script) == 0 {
+		return nil, errors.New("empty nodelist or nodediff")
+	}
+	if !bytes.Equal(bytes.TrimRight(old[0], "\r\n"), bytes.TrimRight(script[0], "\r\n")) {
+		return nil, ErrDiffMismatch
+	}
+	var out bytes.Buffer
+	pos := 0
+	for i := 1; i < len(script); i++ {
+		cmd := bytes.TrimRight(script[i], "\r\n")
+		if len(cmd) == 0 {
+			continue
+		}
+		count, err := strconv.Atoi(string(cmd[1:]))
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("nodediff line %d: malformed command %q", i+1, cmd)
+		}
+		switch cmd[0] {
+		case 'A':
+			if i+count >= len(script) {
+				return nil, fmt.Errorf("nodediff line %d: adds %d lines past end of diff", i+1, count)
+			}
+			for _, line := range script[i+1 : i+1+count] {
+				out.Write(line)
+			}
+			i += count
+		case 'C', 'D':
+			if pos+count > len(old) {
+				return nil, fmt.Errorf("nodediff line %d: %q past end of nodelist", i+1, cmd)
+			}
+			if cmd[0] == 'C' {
+				for _, line := range old[pos : pos+count] {
+					out.Write(line)
+				}
+			}
+			pos += count
+		default:
+			return nil, fmt.Errorf("nodediff line %d: unknown command %q", i+1, cmd)
+		}
+	}
+	if bytes.IndexByte(list, 0x1a) >= 0 {
+		out.WriteByte(0x1a)
+	}
+	result := out.Bytes()
+	if err := Verify(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Update applies the named nodediff to the current nodelist for
+// the given base name, as found by Latest, and returns the name
+// of the new list.  The new list is named with the base name and
+// its day number, unless the base name is itself the list, in
+// which case it is replaced.  If the diff was made from a
+// different list, the error is ErrDiffMismatch.
+func Update(base, diffFile string) (string, error) {
+	current, err := Latest(base)
+	if err != nil {
+		return "", err
+	}
+	list, err := os.ReadFile(current)
+	if err != nil {
+		return "", err
+	}
+	diff, err := os.ReadFile(diffFile)
+	if err != nil {
+		return "", err
+	}
+	result, err := ApplyDiff(list, diff)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", diffFile, err)
+	}
+	name := base
+	if current != base {
+		header, _ := splitHeader(result)
+		var n Nodelist
+		n.parseHeader(string(bytes.TrimRight(header, "\r\n")))
+		name = fmt.Sprintf("%s.%03d", base, n.Day)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(result); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
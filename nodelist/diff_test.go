@@ -0,0 +1,122 @@
+package nodelist
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"fat-dragon.org/ginko/ftn/ftntest"
+)
+
+// makeList builds a nodelist for the given day with the right
+// CRC in its header.
+func makeList(day int, body string) string {
+	crc := CRC([]byte("\r\n" + body))
+	return fmt.Sprintf(";A Test Nodelist for some day -- Day number %03d : %05d\r\n", day, crc) + body + "\x1a"
+}
+
+const (
+	oldBody = ";S Comment\r\n" +
+		"Zone,2,Europe,Somewhere,ZC,-Unpublished-,300,CM\r\n" +
+		"Host,5020,Moscow_Net,Moscow,NH,-Unpublished-,300,CM\r\n" +
+		",1,Node_One,Moscow,S1,-Unpublished-,300,IBN\r\n" +
+		",2,Node_Two,Moscow,S2,-Unpublished-,300,IBN\r\n" +
+		",3,Node_Three,Moscow,S3,-Unpublished-,300,IBN\r\n"
+	newBody = ";S Comment\r\n" +
+		"Zone,2,Europe,Somewhere,ZC,-Unpublished-,300,CM\r\n" +
+		"Host,5020,Moscow_Net,Moscow,NH,-Unpublished-,300,CM\r\n" +
+		",1,Node_One,Moscow,S1,-Unpublished-,300,IBN\r\n" +
+		",3,Node_Three,Moscow,S3,-Unpublished-,300,IBN,INA:three.example.ru\r\n" +
+		",4,Node_Four,Moscow,S4,-Unpublished-,300,IBN\r\n"
+)
+
+func testDiff(oldList, newList string) string {
+	oldHeader, _, _ := strings.Cut(oldList, "\n")
+	newHeader, _, _ := strings.Cut(newList, "\n")
+	return oldHeader + "\n" +
+		"D1\r\n" +
+		"A1\r\n" + newHeader + "\n" +
+		"C4\r\n" +
+		"D2\r\n" +
+		"A2\r\n" +
+		",3,Node_Three,Moscow,S3,-Unpublished-,300,IBN,INA:three.example.ru\r\n" +
+		",4,Node_Four,Moscow,S4,-Unpublished-,300,IBN\r\n"
+}
+
+func TestCRC(t *testing.T) {
+	// The CRC-16/XMODEM check value.
+	if crc := CRC([]byte("header\r\n123456789\x1atrailing")); crc != 0x31c3 {
+		t.Errorf("CRC = %04x, want 31c3", crc)
+	}
+	list := makeList(98, oldBody)
+	if err := Verify([]byte(list)); err != nil {
+		t.Error(err)
+	}
+	if err := Verify([]byte(strings.Replace(list, "Node_Two", "Node_2", 1))); err == nil {
+		t.Error("Verify accepted a corrupt list")
+	}
+	if err := Verify([]byte(";no crc here\r\n" + oldBody)); err == nil {
+		t.Error("Verify accepted a list without a CRC")
+	}
+}
+
+func TestApplyDiff(t *testing.T) {
+	oldList, newList := makeList(91, oldBody), makeList(98, newBody)
+	got, err := ApplyDiff([]byte(oldList), []byte(testDiff(oldList, newList)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != newList {
+		t.Errorf("ApplyDiff =\n%q\nwant\n%q", got, newList)
+	}
+	other := makeList(84, oldBody)
+	if _, err := ApplyDiff([]byte(other), []byte(testDiff(oldList, newList))); !errors.Is(err, ErrDiffMismatch) {
+		t.Errorf("ApplyDiff to the wrong list: %v, want ErrDiffMismatch", err)
+	}
+	corrupt := strings.Replace(testDiff(oldList, newList), "Node_Four", "Node_4", 1)
+	if _, err := ApplyDiff([]byte(oldList), []byte(corrupt)); err == nil {
+		t.Error("ApplyDiff accepted a result with the wrong CRC")
+	}
+	header, _, _ := strings.Cut(oldList, "\n")
+	for _, script := range []string{"C99\r\n", "D99\r\n", "A5\r\nonly one line\r\n", "X1\r\n", "Cfoo\r\n"} {
+		if _, err := ApplyDiff([]byte(oldList), []byte(header+"\n"+script)); err == nil {
+			t.Errorf("ApplyDiff accepted script %q", script)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "NODELIST")
+	oldList, newList := makeList(91, oldBody), makeList(98, newBody)
+	if err := os.WriteFile(base+".091", []byte(oldList), 0660); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(base+".091", old, old)
+	diffFile := filepath.Join(dir, "NODEDIFF.098")
+	if err := os.WriteFile(diffFile, []byte(testDiff(oldList, newList)), 0660); err != nil {
+		t.Fatal(err)
+	}
+	name, err := Update(base, diffFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != base+".098" {
+		t.Errorf("Update wrote %q, want %q", name, base+".098")
+	}
+	n, err := Load(base, "fidonet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Day != 98 || n.Lookup(ftntest.Address(t, "2:5020/4")) == nil || n.Lookup(ftntest.Address(t, "2:5020/2")) != nil {
+		t.Errorf("updated list is day %d with entries %v", n.Day, n.Entries)
+	}
+	if _, err := Update(base, diffFile); !errors.Is(err, ErrDiffMismatch) {
+		t.Errorf("reapplying diff: %v, want ErrDiffMismatch", err)
+	}
+}
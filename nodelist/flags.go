@@ -0,0 +1,105 @@
+package nodelist
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Internet flags, from FTS-5001, that describe how a system may
+// be reached over the Internet.
+var internetFlags = map[string]bool{
+	"IBN": true, // binkp
+	"IFC": true, // raw ifcico
+	"ITN": true, // telnet
+	"IVM": true, // vmodem
+	"IFT": true, // FTP
+	"INA": true, // default host for the other flags
+	"IP":  true, // unspecified protocol
+}
+
+// Internet is a parsed Internet flag, such as `IBN`,
+// `IBN:24555`, `IBN:bbs.example.org` or `INA:bbs.example.org`.
+type Internet struct {
+	Flag string // Upper case flag name, such as "IBN".
+	Host string // Host named by the flag, if any.
+	Port int    // Port named by the flag, or 0.
+}
+
+// ParseInternet parses an Internet flag.  It reports false if
+// the flag is not one.  The host may be a name, an IPv4 address
+// or an IPv6 address in brackets, and may be followed by a port;
+// alternatively the flag may carry only a port.
+func ParseInternet(flag string) (Internet, bool) {
+	name, value, _ := strings.Cut(flag, ":")
+	name = strings.ToUpper(name)
+	if !internetFlags[name] {
+		return Internet{}, false
+	}
+	inet := Internet{Flag: name}
+	if value == "" {
+		return inet, true
+	}
+	if port, err := strconv.Atoi(value); err == nil {
+		if port <= 0 || port > 65535 {
+			return Internet{}, false
+		}
+		inet.Port = port
+		return inet, true
+	}
+	host, portStr := value, ""
+	if strings.HasPrefix(value, "[") {
+		end := strings.Index(value, "]")
+		if end < 0 {
+			return Internet{}, false
+		}
+		host, portStr = value[1:end], strings.TrimPrefix(value[end+1:], ":")
+	} else if strings.Count(value, ":") == 1 {
+		// More than one colon is a bare IPv6 address.
+		i := strings.Index(value, ":")
+		host, portStr = value[:i], value[i+1:]
+	}
+	if host == "" {
+		return Internet{}, false
+	}
+	inet.Host = host
+	if portStr != "" {
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port <= 0 || port > 65535 {
+			return Internet{}, false
+		}
+		inet.Port = port
+	}
+	return inet, true
+}
+
+// Flag returns the value of the first flag with the given name,
+// compared without regard to case, and whether there was one.  A
+// flag without a value, such as `CM`, has an empty value.
+func (e *Entry) Flag(name string) (string, bool) {
+	for _, flag := range e.Flags {
+		fname, value, _ := strings.Cut(flag, ":")
+		if strings.EqualFold(fname, name) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// HasFlag reports whether the entry has the named flag.
+func (e *Entry) HasFlag(name string) bool {
+	_, ok := e.Flag(name)
+	return ok
+}
+
+// Internet returns the entry's Internet flags with the given
+// name, such as "IBN", or all of them if the name is empty.
+func (e *Entry) Internet(name string) []Internet {
+	var inets []Internet
+	for _, flag := range e.Flags {
+		inet, ok := ParseInternet(flag)
+		if ok && (name == "" || strings.EqualFold(inet.Flag, name)) {
+			inets = append(inets, inet)
+		}
+	}
+	return inets
+}
@@ -0,0 +1,313 @@
+// Package nodelist reads FidoNet-style nodelists, as described
+// by FTS-5000, and indexes their entries by FTN address.
+//
+// A nodelist is a text file of comma-separated entries, one per
+// line, of the form
+//
+//	keyword,number,system,location,sysop,phone,baud,flags...
+//
+// where the keyword is one of Zone, Region, Host, Hub, Pvt, Hold
+// or Down, or empty for an ordinary node.  Zone, Region and Host
+// entries set the zone and net of the entries that follow them.
+// Lines beginning with `;` are comments; the first line
+// conventionally carries the list's day number and CRC.
+//
+// Weekly NODEDIFF edit scripts may be applied with Update to
+// keep a list current.
+package nodelist
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"fat-dragon.org/ginko/ftn"
+)
+
+// Kind is the kind of a nodelist entry, given by its keyword.
+type Kind int
+
+const (
+	Node Kind = iota
+	Zone
+	Region
+	Host
+	Hub
+	Pvt
+	Hold
+	Down
+)
+
+var kindNames = []string{"", "Zone", "Region", "Host", "Hub", "Pvt", "Hold", "Down"}
+
+func (k Kind) String() string {
+	if k == Node {
+		return "Node"
+	}
+	if k < 0 || int(k) >= len(kindNames) {
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+	return kindNames[k]
+}
+
+func parseKind(keyword string) (Kind, bool) {
+	for i, name := range kindNames {
+		if strings.EqualFold(keyword, name) {
+			return Kind(i), true
+		}
+	}
+	return Node, false
+}
+
+// Entry is a single system listed in a nodelist.
+type Entry struct {
+	Kind     Kind
+	Address  ftn.Address
+	Region   int         // Region the system is in, or 0.
+	Hub      ftn.Address // Hub the system is under, if any.
+	System   string
+	Location string
+	Sysop    string
+	Phone    string
+	Baud     int
+	Flags    []string
+}
+
+// Reachable reports whether the system accepts mail: Hold and
+// Down systems do not.
+func (e *Entry) Reachable() bool {
+	return e.Kind != Hold && e.Kind != Down
+}
+
+func (e *Entry) String() string {
+	return fmt.Sprintf("%-20s %-6s %s, %s, %s, %s, %d, %s",
+		e.Address, e.Kind, e.System, e.Location, e.Sysop, e.Phone, e.Baud, strings.Join(e.Flags, ","))
+}
+
+// Nodelist is a parsed nodelist.
+type Nodelist struct {
+	Header  string // Text of the first comment line.
+	Day     int    // Day number from the header, or 0.
+	CRC     uint16 // CRC from the header, if HasCRC.
+	HasCRC  bool
+	Entries []*Entry
+	Errors  []error // Malformed lines, which were skipped.
+	index   map[ftn.Address]*Entry
+}
+
+var headerPattern = regexp.MustCompile(`(?i)day number\s+(\d+)\s*:\s*(\d+)`)
+
+// Parse reads a nodelist.  Entries are given the domain, if it
+// is not empty; otherwise it is derived from the zone where
+// possible.  Malformed lines are recorded in Errors and skipped;
+// only read errors cause Parse to fail.
+func Parse(r io.Reader, domain ftn.Domain) (*Nodelist, error) {
+	n := &Nodelist{index: make(map[ftn.Address]*Entry)}
+	var zone, net, region int
+	var hub ftn.Address
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), 1024*1024)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Bytes()
+		if i := bytes.IndexByte(line, 0x1a); i >= 0 {
+			// ^Z marks the end of the file.
+			line = line[:i]
+			if len(bytes.TrimSpace(line)) == 0 {
+				break
+			}
+		}
+		text := strings.TrimRight(string(line), "\r\n\t ")
+		if lineno == 1 && strings.HasPrefix(text, ";") {
+			n.parseHeader(text)
+			continue
+		}
+		if text == "" || text[0] == ';' {
+			continue
+		}
+		fields := strings.Split(text, ",")
+		if len(fields) < 7 {
+			n.Errors = append(n.Errors, fmt.Errorf("line %d: too few fields", lineno))
+			continue
+		}
+		kind, ok := parseKind(fields[0])
+		if !ok {
+			n.Errors = append(n.Errors, fmt.Errorf("line %d: unknown keyword %q", lineno, fields[0]))
+			continue
+		}
+		number, err := strconv.Atoi(fields[1])
+		if err != nil || number < 0 || number > ftn.MaxNode {
+			n.Errors = append(n.Errors, fmt.Errorf("line %d: invalid number %q", lineno, fields[1]))
+			continue
+		}
+		node := number
+		switch kind {
+		case Zone:
+			if number == 0 {
+				n.Errors = append(n.Errors, fmt.Errorf("line %d: invalid zone 0", lineno))
+				continue
+			}
+			zone, net, region, node = number, number, 0, 0
+			hub = ftn.Address{}
+		case Region:
+			net, region, node = number, number, 0
+			hub = ftn.Address{}
+		case Host:
+			net, node = number, 0
+			hub = ftn.Address{}
+		}
+		if zone == 0 {
+			n.Errors = append(n.Errors, fmt.Errorf("line %d: entry before first zone", lineno))
+			continue
+		}
+		addr := ftn.NewAddress(ftn.Zone(zone), ftn.Net(net), ftn.Node(node), 0, domain).Canonical()
+		e := &Entry{
+			Kind:     kind,
+			Address:  addr,
+			Region:   region,
+			Hub:      hub,
+			System:   unescape(fields[2]),
+			Location: unescape(fields[3]),
+			Sysop:    unescape(fields[4]),
+			Phone:    fields[5],
+			Flags:    fields[7:],
+		}
+		e.Baud, _ = strconv.Atoi(fields[6])
+		if kind == Hub {
+			hub = addr
+			e.Hub = ftn.Address{}
+		}
+		if _, dup := n.index[indexKey(addr)]; dup {
+			n.Errors = append(n.Errors, fmt.Errorf("line %d: duplicate entry for %v", lineno, addr))
+			continue
+		}
+		n.index[indexKey(addr)] = e
+		n.Entries = append(n.Entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// ParseFile reads the named nodelist file.
+func ParseFile(name string, domain ftn.Domain) (*Nodelist, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f, domain)
+}
+
+// Latest returns the name of the current nodelist for the
+// given base name.  If the base name is itself a file, that is
+// the list; otherwise it is the most recently modified of the
+// files named with the base name and a day number extension,
+// such as `NODELIST.098`.
+func Latest(base string) (string, error) {
+	if _, err := os.Stat(base); err == nil {
+		return base, nil
+	}
+	names, err := filepath.Glob(base + ".[0-9][0-9][0-9]")
+	if err != nil {
+		return "", err
+	}
+	latest := ""
+	var latestTime time.Time
+	for _, name := range names {
+		info, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		if latest == "" || info.ModTime().After(latestTime) {
+			latest, latestTime = name, info.ModTime()
+		}
+	}
+	if latest == "" {
+		return "", fmt.Errorf("no nodelist found for %s", base)
+	}
+	return latest, nil
+}
+
+// Load reads the current nodelist for the given base name, as
+// found by Latest.
+func Load(base string, domain ftn.Domain) (*Nodelist, error) {
+	name, err := Latest(base)
+	if err != nil {
+		return nil, err
+	}
+	return ParseFile(name, domain)
+}
+
+func (n *Nodelist) parseHeader(line string) {
+	n.Header = line
+	if m := headerPattern.FindStringSubmatch(line); m != nil {
+		n.Day, _ = strconv.Atoi(m[1])
+		if crc, err := strconv.ParseUint(m[2], 10, 16); err == nil {
+			n.CRC = uint16(crc)
+			n.HasCRC = true
+		}
+	}
+}
+
+// unescape converts the underscores used in place of spaces in
+// nodelist text fields back to spaces.
+func unescape(s string) string {
+	return strings.ReplaceAll(s, "_", " ")
+}
+
+// indexKey returns the key of an address in the index.  A list
+// covers a single domain, which may be unknown, so the index is
+// keyed without it.
+func indexKey(addr ftn.Address) ftn.Address {
+	return ftn.NewAddress4d(addr.Zone(), addr.Net(), addr.Node(), addr.Point())
+}
+
+// Lookup returns the entry for the given address, or nil.
+func (n *Nodelist) Lookup(addr ftn.Address) *Entry {
+	if e := n.index[indexKey(addr)]; e != nil && e.Address.Equal(addr) {
+		return e
+	}
+	return nil
+}
+
+// Match returns the entries matching the pattern, in list order.
+func (n *Nodelist) Match(p ftn.Pattern) []*Entry {
+	var entries []*Entry
+	for _, e := range n.Entries {
+		if p.Match(e.Address) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Index searches several nodelists, such as those of different
+// networks, in order.
+type Index []*Nodelist
+
+// Lookup returns the first entry for the given address, or nil.
+func (ix Index) Lookup(addr ftn.Address) *Entry {
+	for _, n := range ix {
+		if e := n.Lookup(addr); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Match returns the entries in every list matching the pattern.
+func (ix Index) Match(p ftn.Pattern) []*Entry {
+	var entries []*Entry
+	for _, n := range ix {
+		entries = append(entries, n.Match(p)...)
+	}
+	return entries
+}
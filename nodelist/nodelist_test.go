@@ -0,0 +1,172 @@
+package nodelist
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"fat-dragon.org/ginko/ftn"
+	"fat-dragon.org/ginko/ftn/ftntest"
+)
+
+const testList = ";A Test Nodelist for Friday, April 8, 2022 -- Day number 098 : 12345\r\n" +
+	";S Comment lines are ignored\r\n" +
+	"Zone,2,Europe,Somewhere,Zone_Coordinator,-Unpublished-,300,CM,IBN,INA:z2.example.org\r\n" +
+	",1,Zone_Gate,Amsterdam,Some_Body,-Unpublished-,300,IBN:gate.example.org:24555\r\n" +
+	"Region,50,Russia,Moscow,Region_Coord,-Unpublished-,300,CM\r\n" +
+	"Host,5020,Moscow_Net,Moscow,Net_Host,7-495-555-0100,9600,CM,XA,IBN:24555,INA:host.example.ru\r\n" +
+	"Hub,100,Moscow_Hub,Moscow,Hub_Sysop,-Unpublished-,300,IBN\r\n" +
+	",101,A_Node,Moscow,A_Sysop,-Unpublished-,300,IBN:[2001:db8::1]:24554,U,ENC\r\n" +
+	"Pvt,102,Private_Node,Moscow,P_Sysop,-Unpublished-,300\r\n" +
+	"Hold,103,Held_Node,Moscow,H_Sysop,-Unpublished-,300\r\n" +
+	"Down,104,Down_Node,Moscow,D_Sysop,-Unpublished-,300\r\n" +
+	"Bogus,105,Bad,Nowhere,X,-Unpublished-,300\r\n" +
+	",106,Short\r\n" +
+	"Zone,1,North_America,Somewhere,Z1C,-Unpublished-,300,CM\r\n" +
+	"Host,387,Texas,Austin,T_Host,-Unpublished-,300,IP:10.0.0.1\r\n" +
+	",1,T_Node,Austin,T_Sysop,-Unpublished-,300,IBN:2001:db8::2\r\n" +
+	"\x1a"
+
+func parseTestList(t *testing.T) *Nodelist {
+	n, err := Parse(strings.NewReader(testList), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestParse(t *testing.T) {
+	n := parseTestList(t)
+	if n.Day != 98 || !n.HasCRC || n.CRC != 12345 {
+		t.Errorf("header: day %d crc %d (%v)", n.Day, n.CRC, n.HasCRC)
+	}
+	if len(n.Entries) != 12 {
+		t.Errorf("parsed %d entries, want 12", len(n.Entries))
+	}
+	if len(n.Errors) != 2 {
+		t.Errorf("errors %v, want 2", n.Errors)
+	}
+	tests := []struct {
+		addr   string
+		kind   Kind
+		system string
+		region int
+		hub    string
+	}{
+		{"2:2/0", Zone, "Europe", 0, ""},
+		{"2:2/1", Node, "Zone Gate", 0, ""},
+		{"2:50/0", Region, "Russia", 50, ""},
+		{"2:5020/0", Host, "Moscow Net", 50, ""},
+		{"2:5020/100", Hub, "Moscow Hub", 50, ""},
+		{"2:5020/101", Node, "A Node", 50, "2:5020/100@fidonet"},
+		{"2:5020/102", Pvt, "Private Node", 50, "2:5020/100@fidonet"},
+		{"2:5020/103", Hold, "Held Node", 50, "2:5020/100@fidonet"},
+		{"2:5020/104", Down, "Down Node", 50, "2:5020/100@fidonet"},
+		{"1:387/1@fidonet", Node, "T Node", 0, ""},
+	}
+	for _, test := range tests {
+		e := n.Lookup(ftntest.Address(t, test.addr))
+		if e == nil {
+			t.Errorf("%s not found", test.addr)
+			continue
+		}
+		hub := ""
+		if e.Hub != (ftn.Address{}) {
+			hub = e.Hub.String()
+		}
+		if e.Kind != test.kind || e.System != test.system || e.Region != test.region || hub != test.hub {
+			t.Errorf("%s: got %v %q region %d hub %q", test.addr, e.Kind, e.System, e.Region, hub)
+		}
+	}
+	if e := n.Lookup(ftntest.Address(t, "2:5020/101")); e.Sysop != "A Sysop" || e.Baud != 300 || !e.HasFlag("enc") {
+		t.Errorf("unexpected entry %v", e)
+	}
+	if n.Lookup(ftntest.Address(t, "2:5020/105")) != nil || n.Lookup(ftntest.Address(t, "2:5020/101@othernet")) != nil {
+		t.Error("found an entry that should not exist")
+	}
+	if e := n.Lookup(ftntest.Address(t, "2:5020/103")); e.Reachable() {
+		t.Error("Hold entry reported reachable")
+	}
+}
+
+func TestInternetFlags(t *testing.T) {
+	n := parseTestList(t)
+	tests := []struct {
+		addr string
+		flag string
+		want []Internet
+	}{
+		{"2:2/0", "", []Internet{{"IBN", "", 0}, {"INA", "z2.example.org", 0}}},
+		{"2:2/1", "IBN", []Internet{{"IBN", "gate.example.org", 24555}}},
+		{"2:5020/0", "IBN", []Internet{{"IBN", "", 24555}}},
+		{"2:5020/101", "IBN", []Internet{{"IBN", "2001:db8::1", 24554}}},
+		{"1:387/0", "IP", []Internet{{"IP", "10.0.0.1", 0}}},
+		{"1:387/1", "IBN", []Internet{{"IBN", "2001:db8::2", 0}}},
+		{"2:5020/102", "", nil},
+	}
+	for _, test := range tests {
+		got := n.Lookup(ftntest.Address(t, test.addr)).Internet(test.flag)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s Internet(%q) = %v, want %v", test.addr, test.flag, got, test.want)
+		}
+	}
+	if host, ok := n.Lookup(ftntest.Address(t, "2:5020/0")).Flag("ina"); !ok || host != "host.example.ru" {
+		t.Errorf("Flag(INA) = %q, %v", host, ok)
+	}
+	for _, bad := range []string{"CM", "IBN:0", "IBN:host:99999", "IBN:[::1", "IBN::24554"} {
+		if inet, ok := ParseInternet(bad); ok {
+			t.Errorf("ParseInternet(%q) = %v", bad, inet)
+		}
+	}
+}
+
+func TestKindString(t *testing.T) {
+	for k, want := range map[Kind]string{Node: "Node", Zone: "Zone", Down: "Down", Down + 1: "Kind(8)", -1: "Kind(-1)"} {
+		if got := k.String(); got != want {
+			t.Errorf("Kind(%d).String() = %q, want %q", int(k), got, want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	ix := Index{parseTestList(t)}
+	p, err := ftn.ParsePattern("2:5020/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(ix.Match(p)); got != 6 {
+		t.Errorf("matched %d entries, want 6", got)
+	}
+	if e := ix.Lookup(ftntest.Address(t, "2:5020/101")); e == nil || e.System != "A Node" {
+		t.Errorf("Index.Lookup = %v", e)
+	}
+}
+
+func TestLoadLatest(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "NODELIST")
+	old := time.Now().Add(-time.Hour)
+	for i, name := range []string{"NODELIST.091", "NODELIST.098"} {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(testList), 0660); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			os.Chtimes(file, old, old)
+		}
+	}
+	name, err := Latest(base)
+	if err != nil || filepath.Base(name) != "NODELIST.098" {
+		t.Errorf("Latest = %q, %v", name, err)
+	}
+	n, err := Load(base, "fidonet")
+	if err != nil || n.Lookup(ftntest.Address(t, "2:5020/101@fidonet")) == nil {
+		t.Errorf("Load: %v", err)
+	}
+	if _, err := Latest(filepath.Join(dir, "MISSING")); err == nil {
+		t.Error("Latest found a missing nodelist")
+	}
+}
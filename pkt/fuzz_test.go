@@ -0,0 +1,64 @@
+package pkt
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func FuzzReader(f *testing.F) {
+	f.Add(testPacket(f))
+	f.Add(rawBytes(f, test2pHeader(), uint16(0)))
+	f.Add(rawBytes(f, rawHeader22{PktSubVers: 2, PktVersion: 2}, uint16(MessageType)))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r, err := NewReader(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		for n := 0; ; n++ {
+			m, err := r.Next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				if m != nil {
+					t.Fatalf("Next returned a message with error %v", err)
+				}
+				return
+			}
+			if n > len(data) {
+				t.Fatalf("read %d messages from %d bytes", n, len(data))
+			}
+			if len(m.To) >= MaxName || len(m.From) >= MaxName || len(m.Subject) >= MaxSubject {
+				t.Fatalf("overlong field in %+v", m)
+			}
+			roundTrip(t, m)
+		}
+	})
+}
+
+// roundTrip checks that a message read from a packet is written
+// and read back unchanged.
+func roundTrip(t *testing.T, m *Message) {
+	var b bytes.Buffer
+	w, err := NewWriter(&b, &Header{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteMessage(m); err != nil {
+		t.Fatalf("cannot write message read from packet: %v", err)
+	}
+	w.Close()
+	r, err := NewReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Fatalf("round trip: got %+v, want %+v", got, m)
+	}
+}
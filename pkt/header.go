@@ -0,0 +1,334 @@
+// Package pkt reads and writes FidoNet "type 2" packets: the envelopes in
+// which netmail and echomail travel between systems.
+//
+// A packet is a 58-byte header followed by a sequence of packed
+// messages and a terminating zero word.  Several incompatible
+// header layouts share the type 2 version number; the layout is
+// detected when the header is read.  Packets are written as
+// type 2+.
+package pkt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"fat-dragon.org/ginko/ftn"
+)
+
+// HeaderSize is the size of a packet header in bytes.
+const HeaderSize = 58
+
+// PacketVersion is the version number shared by every header
+// variant.
+const PacketVersion = 2
+
+// Variant identifies a packet header layout.
+type Variant int
+
+const (
+	// Type2 is the original layout of FTS-0001.
+	Type2 Variant = iota
+	// Type2Plus is the layout of FSC-0048, the most common today.
+	Type2Plus
+	// Type2e is the layout of FSC-0039.
+	Type2e
+	// Type22 is the layout of FSC-0045, with 5D addresses.
+	Type22
+)
+
+var variantNames = []string{"2", "2+", "2e", "2.2"}
+
+func (v Variant) String() string {
+	if v < 0 || int(v) >= len(variantNames) {
+		return fmt.Sprintf("Variant(%d)", int(v))
+	}
+	return variantNames[v]
+}
+
+// Capability bits in the CapWord of 2+ and 2e headers.
+const (
+	CapType2Plus = 0x0001 // Type 2+ (or 2e) packets understood.
+)
+
+// ErrNotPacket is returned when a file does not start with a
+// type 2 packet header.
+var ErrNotPacket = errors.New("pkt: not a type 2 packet")
+
+// Header is a packet header, whatever its layout.
+type Header struct {
+	Variant     Variant
+	Origin      ftn.Address
+	Dest        ftn.Address
+	Time        time.Time // Creator's local time; zero in type 2.2.
+	Baud        int       // Obsolete; zero in type 2.2.
+	ProductCode uint16
+	Revision    [2]byte // Product major and minor revision.
+	Password    string
+	CapWord     uint16  // Capabilities, in 2+ and 2e.
+	ProductData [4]byte // Product-specific data, in 2+ and 2.2.
+}
+
+// rawHeaderStoneAge is the byte-for-byte packet header format
+// defined in FTS-0001.016.
+type rawHeaderStoneAge struct {
+	OriginNode uint16
+	DestNode   uint16
+	Year       uint16
+	Month      uint16
+	Day        uint16
+	Hour       uint16
+	Minute     uint16
+	Second     uint16
+	BaudRate   uint16
+	PktVersion uint16 // 0x02
+	OriginNet  uint16
+	DestNet    uint16
+	ProdCode   uint8
+	SerialNo   uint8
+	Password   [8]byte
+	OriginZone uint16 // Marked optional in FTS-0001.016
+	DestZone   uint16 // Marked optional in FTS-0001.016
+	Filler     [20]byte
+}
+
+// rawHeader2e is the byte-for-byte packet header format defined
+// in FSC-0039.004.  This differs from FSC-0039.001 in that
+// `CapValid` was taken out of the last two bytes of `Filler`.
+type rawHeader2e struct {
+	OriginNode   uint16
+	DestNode     uint16
+	Year         uint16
+	Month        uint16
+	Day          uint16
+	Hour         uint16
+	Minute       uint16
+	Second       uint16
+	BaudRate     uint16
+	PktVersion   uint16 // 0x02
+	OriginNet    uint16
+	DestNet      uint16
+	ProdCodeLow  byte
+	VersionMajor byte
+	Password     [8]byte
+	QOriginZone  uint16 // "ZmailQ,QMail"
+	QDestZone    uint16 // "ZmailQ,QMail"
+	Filler       [2]byte
+	CapValid     uint16
+	ProdCodeHigh byte
+	VersionMinor byte
+	CapWord      uint16
+	OriginZone   uint16
+	DestZone     uint16
+	OriginPoint  uint16
+	DestPoint    uint16
+	ProdSpecData uint16
+	PktTerm      uint16 // Must be zero.
+}
+
+// rawHeader22 is the byte-for-byte packet header format defined
+// in FSC-0045.001.  It supports five-dimensional addressing.
+type rawHeader22 struct {
+	OriginNode   uint16
+	DestNode     uint16
+	OriginPoint  uint16
+	DestPoint    uint16
+	ReservedMB0  [8]byte
+	PktSubVers   uint16 // 0x02
+	PktVersion   uint16 // 0x02
+	OriginNet    uint16
+	DestNet      uint16
+	ProdCode     byte
+	ProdRevLevel byte
+	Password     [8]byte
+	OriginZone   uint16
+	DestZone     uint16
+	OriginDomain [8]byte
+	DestDomain   [8]byte
+	ProdSpecData [4]byte
+}
+
+// rawHeader2p is the byte-for-byte packet header defined
+// in FSC-0048.002.  It is claimed that it is the most common
+// packet header format in common use.
+//
+// Packet types 2+ and 2e are largely compatible, except that
+// 2+ contains the `AuxNet` field in lieu of 2e's `Filler`, and
+// the product-specific data `ProdSpecData` is widened to 4 bytes,
+// absorbing the mandatorily zero `PktTerm` packet terminator
+// of 2e.
+type rawHeader2p struct {
+	OriginNode   uint16
+	DestNode     uint16
+	Year         uint16
+	Month        uint16
+	Day          uint16
+	Hour         uint16
+	Minute       uint16
+	Second       uint16
+	BaudRate     uint16
+	PktVersion   uint16 // 0x02
+	OriginNet    uint16
+	DestNet      uint16
+	ProdCodeLow  byte
+	VersionMajor byte
+	Password     [8]byte
+	QOriginZone  uint16 // "ZmailQ,QMail"
+	QDestZone    uint16 // "ZmailQ,QMail"
+	AuxNet       uint16 // Replaces 2e's `Filler` field
+	CapValid     uint16
+	ProdCodeHigh byte
+	VersionMinor byte
+	CapWord      uint16
+	OriginZone   uint16  // "As in FD etc"
+	DestZone     uint16  // "As in FD etc"
+	OriginPoint  uint16  // "As in FD etc"
+	DestPoint    uint16  // "As in FD etc"
+	ProdSpecData [4]byte // Absorbs 2e's `PktTerm` field.
+}
+
+// ReadHeader reads and decodes a packet header.
+func ReadHeader(r io.Reader) (*Header, error) {
+	var raw [HeaderSize]byte
+	if _, err := io.ReadFull(r, raw[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("%w: short header", ErrNotPacket)
+		}
+		return nil, err
+	}
+	return ParseHeader(raw[:])
+}
+
+// ParseHeader decodes a packet header from its raw bytes.
+//
+// The variant is detected as other tossers do:
+//
+//   - A `PktSubVers` of 2, where older layouts keep the baud
+//     rate, marks type 2.2.
+//   - A capability word with the 2+ bit set, validated by its
+//     byte-swapped copy in `CapValid`, marks type 2+.
+//   - A capability word with the 2+ bit set but no `CapValid`,
+//     as in early revisions of FSC-0039, marks type 2e.
+//   - Anything else is plain type 2.
+func ParseHeader(raw []byte) (*Header, error) {
+	if len(raw) < HeaderSize {
+		return nil, fmt.Errorf("%w: short header", ErrNotPacket)
+	}
+	raw = raw[:HeaderSize]
+	le := binary.LittleEndian
+	if version := le.Uint16(raw[18:]); version != PacketVersion {
+		return nil, fmt.Errorf("%w: version %d", ErrNotPacket, version)
+	}
+	subVersion := le.Uint16(raw[16:])
+	capValid := le.Uint16(raw[40:])
+	capWord := le.Uint16(raw[44:])
+	switch {
+	case subVersion == 2:
+		var h rawHeader22
+		binary.Read(bytes.NewReader(raw), le, &h)
+		return h.header(), nil
+	case capWord&CapType2Plus != 0 && capValid == capWord<<8|capWord>>8:
+		var h rawHeader2p
+		binary.Read(bytes.NewReader(raw), le, &h)
+		return h.header(), nil
+	case capWord&CapType2Plus != 0 && capValid == 0:
+		var h rawHeader2e
+		binary.Read(bytes.NewReader(raw), le, &h)
+		return h.header(), nil
+	default:
+		var h rawHeaderStoneAge
+		binary.Read(bytes.NewReader(raw), le, &h)
+		return h.header(), nil
+	}
+}
+
+// packetTime converts the date and time of an FTS-0001 header,
+// in which months count from zero.
+func packetTime(year, month, day, hour, minute, second uint16) time.Time {
+	return time.Date(int(year), time.Month(month+1), int(day),
+		int(hour), int(minute), int(second), 0, time.UTC)
+}
+
+// cString returns the text of a NUL-padded field.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func (h *rawHeaderStoneAge) header() *Header {
+	return &Header{
+		Variant:     Type2,
+		Origin:      ftn.NewAddress3d(ftn.Zone(h.OriginZone), ftn.Net(h.OriginNet), ftn.Node(h.OriginNode)),
+		Dest:        ftn.NewAddress3d(ftn.Zone(h.DestZone), ftn.Net(h.DestNet), ftn.Node(h.DestNode)),
+		Time:        packetTime(h.Year, h.Month, h.Day, h.Hour, h.Minute, h.Second),
+		Baud:        int(h.BaudRate),
+		ProductCode: uint16(h.ProdCode),
+		Revision:    [2]byte{h.SerialNo, 0},
+		Password:    cString(h.Password[:]),
+	}
+}
+
+// zone returns the zone from the FSC-0039/FSC-0048 zone field,
+// falling back to the one used by QMail and ZMailQ.
+func zone(zone, qzone uint16) ftn.Zone {
+	if zone == 0 {
+		return ftn.Zone(qzone)
+	}
+	return ftn.Zone(zone)
+}
+
+func (h *rawHeader2e) header() *Header {
+	return &Header{
+		Variant:     Type2e,
+		Origin:      ftn.NewAddress4d(zone(h.OriginZone, h.QOriginZone), ftn.Net(h.OriginNet), ftn.Node(h.OriginNode), ftn.Point(h.OriginPoint)),
+		Dest:        ftn.NewAddress4d(zone(h.DestZone, h.QDestZone), ftn.Net(h.DestNet), ftn.Node(h.DestNode), ftn.Point(h.DestPoint)),
+		Time:        packetTime(h.Year, h.Month, h.Day, h.Hour, h.Minute, h.Second),
+		Baud:        int(h.BaudRate),
+		ProductCode: uint16(h.ProdCodeHigh)<<8 | uint16(h.ProdCodeLow),
+		Revision:    [2]byte{h.VersionMajor, h.VersionMinor},
+		Password:    cString(h.Password[:]),
+		CapWord:     h.CapWord,
+		ProductData: [4]byte{byte(h.ProdSpecData), byte(h.ProdSpecData >> 8)},
+	}
+}
+
+func (h *rawHeader2p) header() *Header {
+	// A point originating a 2+ packet puts -1 in the net field,
+	// for the sake of software that knows nothing of points,
+	// and its boss's net in `AuxNet`.
+	originNet := h.OriginNet
+	if originNet == 0xffff && h.OriginPoint != 0 {
+		originNet = h.AuxNet
+	}
+	return &Header{
+		Variant:     Type2Plus,
+		Origin:      ftn.NewAddress4d(zone(h.OriginZone, h.QOriginZone), ftn.Net(originNet), ftn.Node(h.OriginNode), ftn.Point(h.OriginPoint)),
+		Dest:        ftn.NewAddress4d(zone(h.DestZone, h.QDestZone), ftn.Net(h.DestNet), ftn.Node(h.DestNode), ftn.Point(h.DestPoint)),
+		Time:        packetTime(h.Year, h.Month, h.Day, h.Hour, h.Minute, h.Second),
+		Baud:        int(h.BaudRate),
+		ProductCode: uint16(h.ProdCodeHigh)<<8 | uint16(h.ProdCodeLow),
+		Revision:    [2]byte{h.VersionMajor, h.VersionMinor},
+		Password:    cString(h.Password[:]),
+		CapWord:     h.CapWord,
+		ProductData: h.ProdSpecData,
+	}
+}
+
+func (h *rawHeader22) header() *Header {
+	return &Header{
+		Variant: Type22,
+		Origin: ftn.NewAddress(ftn.Zone(h.OriginZone), ftn.Net(h.OriginNet), ftn.Node(h.OriginNode),
+			ftn.Point(h.OriginPoint), ftn.Domain(cString(h.OriginDomain[:]))),
+		Dest: ftn.NewAddress(ftn.Zone(h.DestZone), ftn.Net(h.DestNet), ftn.Node(h.DestNode),
+			ftn.Point(h.DestPoint), ftn.Domain(cString(h.DestDomain[:]))),
+		ProductCode: uint16(h.ProdCode),
+		Revision:    [2]byte{h.ProdRevLevel, 0},
+		Password:    cString(h.Password[:]),
+		ProductData: h.ProdSpecData,
+	}
+}
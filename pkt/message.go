@@ -0,0 +1,102 @@
+package pkt
+
+import (
+	"strings"
+
+	"fat-dragon.org/ginko/ftn"
+)
+
+// MessageType is the type word that begins every packed message.
+const MessageType = 2
+
+// Limits on the lengths of packed message fields, including the
+// terminating NUL, from FTS-0001.  FTS-0001 sets no limit on the
+// text; MaxText is ours, and is far beyond what any real message
+// needs, so that a malformed or hostile packet cannot exhaust
+// memory.  Messages are written within the limits; fields other
+// than the text are truncated to them when read.
+const (
+	MaxDateTime = 20
+	MaxName     = 36
+	MaxSubject  = 72
+	MaxText     = 4 << 20
+)
+
+// Attribute is the attribute word of a message.
+type Attribute uint16
+
+// Message attributes, from FTS-0001.  Some are meaningful only
+// locally, and are cleared in transit; see LocalAttributes.
+const (
+	Private Attribute = 1 << iota
+	Crash
+	Received
+	Sent
+	FileAttached
+	InTransit
+	Orphan
+	KillSent
+	Local
+	Hold
+	_ // Unused.
+	FileRequest
+	ReturnReceiptRequest
+	IsReturnReceipt
+	AuditRequest
+	FileUpdateRequest
+)
+
+// LocalAttributes are meaningful only to the system that has a
+// message: how it is to be sent on, and what has been done with
+// it there.  They are cleared before a message is sent on.
+const LocalAttributes = Crash | Received | Sent | Orphan | KillSent | Local | Hold
+
+var attributeNames = []string{
+	"Private", "Crash", "Received", "Sent", "FileAttached",
+	"InTransit", "Orphan", "KillSent", "Local", "Hold", "Unused",
+	"FileRequest", "ReturnReceiptRequest", "IsReturnReceipt",
+	"AuditRequest", "FileUpdateRequest",
+}
+
+// Has reports whether every attribute in a is set.
+func (attr Attribute) Has(a Attribute) bool {
+	return attr&a == a
+}
+
+func (attr Attribute) String() string {
+	var names []string
+	for i, name := range attributeNames {
+		if attr&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// Message is a packed message: a message as carried in a packet.
+// Its addresses are only 2D; the zones and points are carried in
+// the header and in kludge lines of the text.  The text fields
+// are kept as the bytes found in the packet, in whatever
+// character set the message uses.
+type Message struct {
+	Origin     ftn.Address
+	Dest       ftn.Address
+	Attributes Attribute
+	Cost       int
+	DateTime   string // E.g. "01 Jan 86  02:34:56".
+	To         string
+	From       string
+	Subject    string
+	Text       string // Lines end with CR.
+}
+
+// rawMessageHeader is the packed message header format defined
+// in FTS-0001.016, following the message type word.
+type rawMessageHeader struct {
+	OriginNode uint16
+	DestNode   uint16
+	OriginNet  uint16
+	DestNet    uint16
+	Attributes uint16
+	Cost       uint16
+}
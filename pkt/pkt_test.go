@@ -0,0 +1,226 @@
+package pkt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func rawBytes(t testing.TB, data ...interface{}) []byte {
+	var b bytes.Buffer
+	for _, d := range data {
+		if s, ok := d.(string); ok {
+			b.WriteString(s)
+			b.WriteByte(0)
+			continue
+		}
+		if err := binary.Write(&b, binary.LittleEndian, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b.Bytes()
+}
+
+func password(s string) [8]byte {
+	var p [8]byte
+	copy(p[:], s)
+	return p
+}
+
+func test2pHeader() rawHeader2p {
+	return rawHeader2p{
+		OriginNode: 108, DestNode: 1,
+		Year: 2022, Month: 3, Day: 8, Hour: 13, Minute: 14, Second: 15,
+		PktVersion: 2, OriginNet: 0xffff, DestNet: 387,
+		ProdCodeLow: 0xfe, VersionMajor: 1,
+		Password:    password("SECRET"),
+		QOriginZone: 1, QDestZone: 1,
+		AuxNet:       387,
+		CapValid:     0x0100,
+		ProdCodeHigh: 0x01, VersionMinor: 2,
+		CapWord:    0x0001,
+		OriginZone: 1, DestZone: 1,
+		OriginPoint:  7,
+		ProdSpecData: [4]byte{1, 2, 3, 4},
+	}
+}
+
+func TestParseHeaderVariants(t *testing.T) {
+	tests := []struct {
+		raw     interface{}
+		variant Variant
+		origin  string
+		dest    string
+	}{
+		{test2pHeader(), Type2Plus, "1:387/108.7", "1:387/1"},
+		{rawHeaderStoneAge{OriginNode: 2, DestNode: 3, OriginNet: 100, DestNet: 200,
+			PktVersion: 2, OriginZone: 21, DestZone: 21, BaudRate: 9600, Password: password("PW")},
+			Type2, "21:100/2", "21:200/3"},
+		{rawHeader2e{OriginNode: 2, DestNode: 3, OriginNet: 100, DestNet: 200, PktVersion: 2,
+			QOriginZone: 3, QDestZone: 3, CapWord: 1, OriginPoint: 5, DestPoint: 6},
+			Type2e, "3:100/2.5", "3:200/3.6"},
+		{rawHeader22{OriginNode: 2, DestNode: 3, OriginPoint: 4, DestPoint: 0, PktSubVers: 2,
+			PktVersion: 2, OriginNet: 100, DestNet: 200, OriginZone: 21, DestZone: 21,
+			OriginDomain: password("fsxnet"), DestDomain: password("fsxnet")},
+			Type22, "21:100/2.4@fsxnet", "21:200/3@fsxnet"},
+	}
+	for _, test := range tests {
+		raw := rawBytes(t, test.raw)
+		if len(raw) != HeaderSize {
+			t.Fatalf("%T is %d bytes, want %d", test.raw, len(raw), HeaderSize)
+		}
+		h, err := ParseHeader(raw)
+		if err != nil {
+			t.Errorf("%T: %v", test.raw, err)
+			continue
+		}
+		if h.Variant != test.variant {
+			t.Errorf("%T detected as %v, want %v", test.raw, h.Variant, test.variant)
+		}
+		if h.Origin.String() != test.origin || h.Dest.String() != test.dest {
+			t.Errorf("%v: addresses %v -> %v, want %v -> %v", h.Variant, h.Origin, h.Dest, test.origin, test.dest)
+		}
+	}
+}
+
+func TestParseHeader2Plus(t *testing.T) {
+	h, err := ParseHeader(rawBytes(t, test2pHeader()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2022, time.April, 8, 13, 14, 15, 0, time.UTC); !h.Time.Equal(want) {
+		t.Errorf("time %v, want %v", h.Time, want)
+	}
+	if h.ProductCode != 0x01fe || h.Revision != [2]byte{1, 2} {
+		t.Errorf("product %04x revision %v", h.ProductCode, h.Revision)
+	}
+	if h.Password != "SECRET" || h.CapWord != 1 || h.ProductData != [4]byte{1, 2, 3, 4} {
+		t.Errorf("unexpected header %+v", h)
+	}
+	// Without a valid CapValid, the capability word is not
+	// trusted, and the zone comes from the QMail field.
+	raw := test2pHeader()
+	raw.CapValid = 0x1234
+	h, err = ParseHeader(rawBytes(t, raw))
+	if err != nil || h.Variant != Type2 {
+		t.Errorf("header with invalid CapValid: %v, %v", h, err)
+	}
+}
+
+func TestParseHeaderErrors(t *testing.T) {
+	raw := test2pHeader()
+	raw.PktVersion = 3
+	if _, err := ParseHeader(rawBytes(t, raw)); !errors.Is(err, ErrNotPacket) {
+		t.Errorf("version 3: %v, want ErrNotPacket", err)
+	}
+	if _, err := ReadHeader(bytes.NewReader(make([]byte, 20))); !errors.Is(err, ErrNotPacket) {
+		t.Errorf("short header: %v, want ErrNotPacket", err)
+	}
+}
+
+func testPacket(t testing.TB) []byte {
+	return rawBytes(t, test2pHeader(),
+		uint16(MessageType), rawMessageHeader{108, 1, 387, 387, uint16(Private | Crash), 0},
+		"08 Apr 22  13:14:15", "Sysop", "Some One", "Hello",
+		"\x01MSGID: 1:387/108.7 12345678\rHello, world.\r",
+		uint16(MessageType), rawMessageHeader{108, 2, 387, 388, 0, 5},
+		"08 Apr 22  13:14:16", "All", "Some One", "",
+		"AREA:FSX_GEN\rEchomail.\r",
+		uint16(0))
+}
+
+func TestReader(t *testing.T) {
+	r, err := NewReader(bytes.NewReader(testPacket(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Header.Variant != Type2Plus {
+		t.Errorf("variant %v", r.Header.Variant)
+	}
+	var messages []*Message
+	for {
+		m, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, m)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("read %d messages, want 2", len(messages))
+	}
+	m := messages[0]
+	if m.Origin.String() != "387/108" || m.Dest.String() != "387/1" {
+		t.Errorf("addresses %v -> %v", m.Origin, m.Dest)
+	}
+	if !m.Attributes.Has(Private|Crash) || m.Attributes.Has(Hold) || m.Attributes.String() != "Private|Crash" {
+		t.Errorf("attributes %v", m.Attributes)
+	}
+	if m.DateTime != "08 Apr 22  13:14:15" || m.To != "Sysop" || m.From != "Some One" || m.Subject != "Hello" {
+		t.Errorf("unexpected message %+v", m)
+	}
+	if m.Text != "\x01MSGID: 1:387/108.7 12345678\rHello, world.\r" {
+		t.Errorf("text %q", m.Text)
+	}
+	if m := messages[1]; m.Dest.String() != "388/2" || m.Cost != 5 || m.Subject != "" || m.Text != "AREA:FSX_GEN\rEchomail.\r" {
+		t.Errorf("unexpected message %+v", m)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("Next after end: %v", err)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	packet := testPacket(t)
+	truncated := packet[:len(packet)-10]
+	r, err := NewReader(bytes.NewReader(truncated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.Next()
+	var fe *FormatError
+	if !errors.As(err, &fe) || !errors.Is(err, ErrTruncated) || fe.Message != 2 {
+		t.Errorf("truncated packet: %v", err)
+	}
+	if _, again := r.Next(); again != err {
+		t.Errorf("Next after error: %v, want %v", again, err)
+	}
+
+	badType := rawBytes(t, test2pHeader(), uint16(1))
+	r, _ = NewReader(bytes.NewReader(badType))
+	if _, err := r.Next(); !errors.As(err, &fe) || fe.Offset != HeaderSize+2 {
+		t.Errorf("bad message type: %v", err)
+	}
+
+	// Overlong names and subjects are truncated.
+	long := rawBytes(t, test2pHeader(), uint16(MessageType), rawMessageHeader{},
+		"08 Apr 22  13:14:15", string(bytes.Repeat([]byte("x"), MaxName+10)), "",
+		string(bytes.Repeat([]byte("s"), MaxSubject)), "Hello", uint16(0))
+	r, _ = NewReader(bytes.NewReader(long))
+	m, err := r.Next()
+	if err != nil {
+		t.Fatalf("overlong name: %v", err)
+	}
+	if m.To != strings.Repeat("x", MaxName-1) || m.Subject != strings.Repeat("s", MaxSubject-1) || m.Text != "Hello" {
+		t.Errorf("overlong fields read as %+v", m)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("after overlong fields: %v", err)
+	}
+
+	huge := rawBytes(t, test2pHeader(), uint16(MessageType), rawMessageHeader{},
+		"08 Apr 22  13:14:15", "", "", "", string(bytes.Repeat([]byte("x"), MaxText)), uint16(0))
+	r, _ = NewReader(bytes.NewReader(huge))
+	if _, err := r.Next(); !errors.As(err, &fe) || !strings.Contains(err.Error(), "text longer") {
+		t.Errorf("overlong text: %v", err)
+	}
+}
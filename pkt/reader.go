@@ -0,0 +1,156 @@
+package pkt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"fat-dragon.org/ginko/ftn"
+)
+
+// FormatError describes a malformed packet.
+type FormatError struct {
+	Message int   // The message in which the error was found, from 1.
+	Offset  int64 // The byte offset within the packet.
+	Err     error
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("pkt: message %d at offset %d: %v", e.Message, e.Offset, e.Err)
+}
+
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+// ErrTruncated is wrapped by the error returned when a packet
+// ends before its terminating zero word.
+var ErrTruncated = errors.New("packet truncated")
+
+// Reader reads the messages of a packet.
+type Reader struct {
+	Header *Header
+	r      *bufio.Reader
+	offset int64
+	count  int
+	err    error
+}
+
+// NewReader reads the header of a packet, and returns a reader
+// for its messages.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := ReadHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{header, br, HeaderSize, 0, nil}, nil
+}
+
+// Next returns the next message in the packet.  At the end of the
+// packet it returns io.EOF; a malformed packet gives a
+// *FormatError, after which Next returns the same error.
+func (r *Reader) Next() (*Message, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	m, err := r.next()
+	if err != nil {
+		if err != io.EOF {
+			err = &FormatError{r.count, r.offset, err}
+		}
+		r.err = err
+		return nil, err
+	}
+	return m, nil
+}
+
+func (r *Reader) next() (*Message, error) {
+	r.count++
+	var typ uint16
+	if err := r.read(&typ); err != nil {
+		return nil, err
+	}
+	if typ == 0 {
+		return nil, io.EOF
+	}
+	if typ != MessageType {
+		return nil, fmt.Errorf("unknown message type %d", typ)
+	}
+	var h rawMessageHeader
+	if err := r.read(&h); err != nil {
+		return nil, err
+	}
+	m := &Message{
+		Origin:     ftn.NewAddress2d(ftn.Net(h.OriginNet), ftn.Node(h.OriginNode)),
+		Dest:       ftn.NewAddress2d(ftn.Net(h.DestNet), ftn.Node(h.DestNode)),
+		Attributes: Attribute(h.Attributes),
+		Cost:       int(h.Cost),
+	}
+	var err error
+	if m.DateTime, err = r.readString("date", MaxDateTime, false); err != nil {
+		return nil, err
+	}
+	if m.To, err = r.readString("to name", MaxName, false); err != nil {
+		return nil, err
+	}
+	if m.From, err = r.readString("from name", MaxName, false); err != nil {
+		return nil, err
+	}
+	if m.Subject, err = r.readString("subject", MaxSubject, false); err != nil {
+		return nil, err
+	}
+	if m.Text, err = r.readString("text", MaxText, true); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// read reads a fixed-size little-endian value.
+func (r *Reader) read(data interface{}) error {
+	size := int64(binary.Size(data))
+	if err := binary.Read(r.r, binary.LittleEndian, data); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrTruncated
+		}
+		return err
+	}
+	r.offset += size
+	return nil
+}
+
+// readString reads a NUL-terminated field of at most max bytes,
+// including the NUL.  A longer field is an error if strict, and
+// is otherwise truncated, as some software writes names and
+// subjects longer than FTS-0001 allows.
+func (r *Reader) readString(field string, max int, strict bool) (string, error) {
+	var b bytes.Buffer
+	truncated := false
+	for {
+		c, err := r.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return "", ErrTruncated
+			}
+			return "", err
+		}
+		r.offset++
+		if c == 0 {
+			return b.String(), nil
+		}
+		if truncated {
+			continue
+		}
+		if b.Len()+1 >= max {
+			if strict {
+				return "", fmt.Errorf("%s longer than %d bytes", field, max-1)
+			}
+			truncated = true
+			continue
+		}
+		b.WriteByte(c)
+	}
+}
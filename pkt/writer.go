@@ -0,0 +1,184 @@
+package pkt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"fat-dragon.org/ginko/ftn"
+)
+
+// DateTimeFormat is the layout of the date and time of a packed
+// message, as for time.Format.
+const DateTimeFormat = "02 Jan 06  15:04:05"
+
+// FormatDateTime formats a time for a packed message.
+func FormatDateTime(t time.Time) string {
+	return t.Format(DateTimeFormat)
+}
+
+// Writer writes a type 2+ packet.
+type Writer struct {
+	w   *bufio.Writer
+	err error
+}
+
+// NewWriter writes a type 2+ header for the given header, whose
+// variant is ignored, and returns a writer for the packet's
+// messages.  The 2+ capability is always advertised.
+func NewWriter(w io.Writer, h *Header) (*Writer, error) {
+	raw, err := h.raw2p()
+	if err != nil {
+		return nil, err
+	}
+	pw := &Writer{bufio.NewWriter(w), nil}
+	pw.write(raw)
+	if pw.err != nil {
+		return nil, pw.err
+	}
+	return pw, nil
+}
+
+// raw2p lays out the header as type 2+.
+func (h *Header) raw2p() (*rawHeader2p, error) {
+	if len(h.Password) > 8 {
+		return nil, errors.New("pkt: password longer than 8 bytes")
+	}
+	for _, addr := range []ftn.Address{h.Origin, h.Dest} {
+		if err := check16(addr); err != nil {
+			return nil, err
+		}
+	}
+	capWord := h.CapWord | CapType2Plus
+	raw := &rawHeader2p{
+		OriginNode:   uint16(h.Origin.Node()),
+		DestNode:     uint16(h.Dest.Node()),
+		Year:         uint16(h.Time.Year()),
+		Month:        uint16(h.Time.Month() - 1),
+		Day:          uint16(h.Time.Day()),
+		Hour:         uint16(h.Time.Hour()),
+		Minute:       uint16(h.Time.Minute()),
+		Second:       uint16(h.Time.Second()),
+		BaudRate:     uint16(h.Baud),
+		PktVersion:   PacketVersion,
+		OriginNet:    uint16(h.Origin.Net()),
+		DestNet:      uint16(h.Dest.Net()),
+		ProdCodeLow:  byte(h.ProductCode),
+		VersionMajor: h.Revision[0],
+		QOriginZone:  uint16(h.Origin.Zone()),
+		QDestZone:    uint16(h.Dest.Zone()),
+		CapValid:     capWord<<8 | capWord>>8,
+		ProdCodeHigh: byte(h.ProductCode >> 8),
+		VersionMinor: h.Revision[1],
+		CapWord:      capWord,
+		OriginZone:   uint16(h.Origin.Zone()),
+		DestZone:     uint16(h.Dest.Zone()),
+		OriginPoint:  uint16(h.Origin.Point()),
+		DestPoint:    uint16(h.Dest.Point()),
+		ProdSpecData: h.ProductData,
+	}
+	copy(raw.Password[:], h.Password)
+	if h.Origin.Point() != 0 {
+		// See rawHeader2p.header.
+		raw.OriginNet = 0xffff
+		raw.AuxNet = uint16(h.Origin.Net())
+	}
+	return raw, nil
+}
+
+// check16 checks that an address fits the 16-bit fields of a
+// packet.
+func check16(addr ftn.Address) error {
+	for _, n := range []int{int(addr.Zone()), int(addr.Net()), int(addr.Node()), int(addr.Point())} {
+		if n < 0 || n > 0xffff {
+			return fmt.Errorf("pkt: address %v does not fit in a packet", addr)
+		}
+	}
+	return nil
+}
+
+// WriteMessage writes a packed message.  Fields longer than the
+// limits of FTS-0001, or containing NUL, are refused.
+func (w *Writer) WriteMessage(m *Message) error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := m.check(); err != nil {
+		return err
+	}
+	h := rawMessageHeader{
+		OriginNode: uint16(m.Origin.Node()),
+		DestNode:   uint16(m.Dest.Node()),
+		OriginNet:  uint16(m.Origin.Net()),
+		DestNet:    uint16(m.Dest.Net()),
+		Attributes: uint16(m.Attributes),
+		Cost:       uint16(m.Cost),
+	}
+	w.write(uint16(MessageType))
+	w.write(&h)
+	for _, s := range []string{m.DateTime, m.To, m.From, m.Subject, m.Text} {
+		w.write([]byte(s))
+		w.write(byte(0))
+	}
+	return w.err
+}
+
+// write writes a fixed-size little-endian value, recording the
+// first error.
+func (w *Writer) write(data interface{}) {
+	if w.err == nil {
+		w.err = binary.Write(w.w, binary.LittleEndian, data)
+	}
+}
+
+// check validates a message for writing.
+func (m *Message) check() error {
+	for _, addr := range []ftn.Address{m.Origin, m.Dest} {
+		if err := check16(addr); err != nil {
+			return err
+		}
+	}
+	if m.Cost < 0 || m.Cost > 0xffff {
+		return fmt.Errorf("pkt: cost %d out of range", m.Cost)
+	}
+	fields := []struct {
+		name, value string
+		max         int
+	}{
+		{"date", m.DateTime, MaxDateTime},
+		{"to name", m.To, MaxName},
+		{"from name", m.From, MaxName},
+		{"subject", m.Subject, MaxSubject},
+		{"text", m.Text, MaxText},
+	}
+	for _, f := range fields {
+		if len(f.value) >= f.max {
+			return fmt.Errorf("pkt: %s longer than %d bytes", f.name, f.max-1)
+		}
+		if strings.IndexByte(f.value, 0) >= 0 {
+			return fmt.Errorf("pkt: %s contains NUL", f.name)
+		}
+	}
+	return nil
+}
+
+// Close writes the zero word that ends the packet and flushes
+// it.  It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.write(uint16(0))
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	if w.err != nil {
+		return w.err
+	}
+	w.err = errors.New("pkt: writer closed")
+	return nil
+}
@@ -0,0 +1,134 @@
+package pkt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"fat-dragon.org/ginko/ftn"
+	"fat-dragon.org/ginko/ftn/ftntest"
+)
+
+func TestRoundTrip(t *testing.T) {
+	when := time.Date(2022, time.December, 31, 23, 59, 58, 0, time.UTC)
+	header := &Header{
+		Variant:     Type2Plus,
+		Origin:      ftntest.Address(t, "1:387/108.7"),
+		Dest:        ftntest.Address(t, "1:387/1"),
+		Time:        when,
+		Baud:        33600,
+		ProductCode: 0x1ffe,
+		Revision:    [2]byte{3, 4},
+		Password:    "PASSWORD",
+		CapWord:     CapType2Plus,
+		ProductData: [4]byte{9, 8, 7, 6},
+	}
+	messages := []*Message{{
+		Origin:     ftn.NewAddress2d(387, 108),
+		Dest:       ftn.NewAddress2d(387, 1),
+		Attributes: Private | Crash | FileAttached,
+		Cost:       65535,
+		DateTime:   FormatDateTime(when),
+		To:         strings.Repeat("t", MaxName-1),
+		From:       strings.Repeat("f", MaxName-1),
+		Subject:    strings.Repeat("s", MaxSubject-1),
+		Text:       "\x01INTL 1:387/1 1:387/108\r\x01FMPT 7\rHello\r\x8e\xe4\r",
+	}, {
+		Origin: ftn.NewAddress2d(387, 108),
+		Dest:   ftn.NewAddress2d(5020, 1),
+	}}
+	var b bytes.Buffer
+	w, err := NewWriter(&b, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range messages {
+		if err := w.WriteMessage(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteMessage(messages[1]); err == nil {
+		t.Error("WriteMessage succeeded after Close")
+	}
+
+	raw := b.Bytes()
+	le := binary.LittleEndian
+	if net, auxNet := le.Uint16(raw[20:]), le.Uint16(raw[38:]); net != 0xffff || auxNet != 387 {
+		t.Errorf("point origin net %d, aux net %d; want 65535, 387", net, auxNet)
+	}
+	if capValid, capWord := raw[40:42], raw[44:46]; capValid[0] != capWord[1] || capValid[1] != capWord[0] {
+		t.Errorf("CapValid % x is not CapWord % x swapped", capValid, capWord)
+	}
+	if !bytes.HasSuffix(raw, []byte{0, 0}) {
+		t.Error("packet does not end with a zero word")
+	}
+
+	r, err := NewReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.Header, header) {
+		t.Errorf("header round trip:\n got %+v\nwant %+v", r.Header, header)
+	}
+	for i, want := range messages {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("message %d round trip:\n got %+v\nwant %+v", i, got, want)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("after last message: %v", err)
+	}
+}
+
+func TestWriterNodeOrigin(t *testing.T) {
+	var b bytes.Buffer
+	w, err := NewWriter(&b, &Header{Origin: ftntest.Address(t, "2:5020/1"), Dest: ftntest.Address(t, "2:5020/2")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	h, err := ParseHeader(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Variant != Type2Plus || h.Origin.String() != "2:5020/1" || binary.LittleEndian.Uint16(b.Bytes()[38:]) != 0 {
+		t.Errorf("node origin header %+v", h)
+	}
+}
+
+func TestWriterRefuses(t *testing.T) {
+	if _, err := NewWriter(io.Discard, &Header{Password: "TOOLONGPW"}); err == nil {
+		t.Error("accepted a 9-byte password")
+	}
+	if _, err := NewWriter(io.Discard, &Header{Origin: ftntest.Address(t, "1:1/1.32767"), Dest: ftn.NewAddress3d(1, -1, 1)}); err == nil {
+		t.Error("accepted an address that does not fit")
+	}
+	w, err := NewWriter(io.Discard, &Header{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*Message{
+		{DateTime: strings.Repeat("d", MaxDateTime)},
+		{To: strings.Repeat("t", MaxName)},
+		{From: strings.Repeat("f", MaxName)},
+		{Subject: strings.Repeat("s", MaxSubject)},
+		{Text: "embedded\x00NUL"},
+		{Text: strings.Repeat("x", MaxText)},
+		{Cost: -1},
+	} {
+		if err := w.WriteMessage(m); err == nil {
+			t.Errorf("accepted %+v", m)
+		}
+	}
+}
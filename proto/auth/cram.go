@@ -63,6 +63,13 @@ func ValidateResponse(hashStr string, challenge []byte, response string, passwor
 	return hmac.Equal(responseMAC, expectedMAC)
 }
 
+// SupportsHash reports whether responses can be generated
+// for the named hash type.
+func SupportsHash(hashStr string) bool {
+	_, err := hashNew(hashStr)
+	return err == nil
+}
+
 // hashNew returns a `New` function for the hash type named
 // by `hashStr`.
 func hashNew(hashStr string) (func() hash.Hash, error) {
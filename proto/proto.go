@@ -2,34 +2,24 @@ package proto
 
 import (
 	"context"
-	"log"
 	"net"
 
 	"fat-dragon.org/ginko/config"
 	"fat-dragon.org/ginko/proto/receiver"
 	"fat-dragon.org/ginko/proto/sender"
+	"fat-dragon.org/ginko/proto/session"
 )
 
 // Receiver runs a session for an incoming connection.
-func Receiver(config *config.Config, conn net.Conn) {
+func Receiver(config *config.Config, conn net.Conn) *session.SessionResult {
 	defer conn.Close()
-	log.Println("Receiver session starting")
-	err := receiver.Run(context.Background(), config, conn)
-	if err != nil {
-		log.Println("Receiver session failed:", err)
-		return
-	}
-	log.Println("Receiver session successful")
+	return receiver.Run(context.Background(), config, conn)
 }
 
-// Sender runs a session for an outgoing connection.
-func Sender(config *config.Config, conn net.Conn) {
+// Sender runs a session for an outgoing connection to the given
+// link, which is nil if the link is not known until the distant
+// end presents its addresses.
+func Sender(config *config.Config, link *config.Link, conn net.Conn) *session.SessionResult {
 	defer conn.Close()
-	log.Println("Sender session starting")
-	err := sender.Run(context.Background(), config, conn)
-	if err != nil {
-		log.Println("Sender session failed:", err)
-		return
-	}
-	log.Println("Sender session sucessful")
+	return sender.Run(context.Background(), config, link, conn)
 }
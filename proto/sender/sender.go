@@ -4,31 +4,28 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"net"
-	"strings"
-	"time"
 
 	"fat-dragon.org/ginko/config"
 	"fat-dragon.org/ginko/frame"
+	"fat-dragon.org/ginko/ftn"
 	"fat-dragon.org/ginko/proto/auth"
 	"fat-dragon.org/ginko/proto/session"
 	"fat-dragon.org/ginko/proto/transfer"
 )
 
-func Run(ctx context.Context, config *config.Config, c net.Conn) error {
-	s := session.NewSession(ctx, config, c)
+// Run runs a session as the caller.  The link is that of the
+// system we called, if known; it is nil when polling a bare
+// host, in which case the distant end's addresses decide the
+// link as for an incoming session.
+func Run(ctx context.Context, config *config.Config, link *config.Link, c net.Conn) *session.SessionResult {
+	s := session.NewSession(ctx, session.Sender, config, c)
+	s.Called = link
 	return s.Run(ctx, start)
 }
 
 func start(ctx context.Context, s *session.Session) (session.State, error) {
-	if !s.WriteSyncFrames(ctx,
-		frame.NewNull("SYS "+s.Config.System),
-		frame.NewNull("ZYZ "+s.Config.Admin),
-		frame.NewNull("LOC "+s.Config.Location),
-		frame.NewNull("VER ginko/0.0.1/OpenBSD/x86_64 binkp/1.0"),
-		frame.NewNull("TIME "+time.Now().Format(time.RFC1123Z)),
-		frame.NewAddress(s.Config.Addresses()...)) {
+	if !s.WriteSyncFrames(ctx, append(s.Banner(), s.AddressFrame())...) {
 		return session.End(ctx, s, errors.New("Error sending initial frames"))
 	}
 	return senderWaitForAddress, nil
@@ -41,38 +38,44 @@ func senderWaitForAddress(ctx context.Context, s *session.Session) (session.Stat
 	case f, ok := <-s.ReadFrames:
 		if ctx.Err() != nil || f == nil || !ok {
 			err := errors.New("Error waiting for address")
-			log.Println(err)
+			s.Log.Error(err)
 			return session.End(ctx, s, err)
 		}
 		switch frame := f.(type) {
 		case *frame.AddressCmd:
-			log.Println("received:", frame)
-			if s.LinkAddresses(frame.Addresses()) == nil {
-				err := errors.New("Unlinked session")
-				log.Println(err)
+			s.Log.Info("received:", frame)
+			if err := linkAddresses(s, frame.Addresses()); err != nil {
+				s.Log.Error(err)
 				return session.End(ctx, s, err)
 			}
 			return sendResponse, nil
 		case *frame.ErrorCmd:
 			err := fmt.Errorf("received: %v", frame)
-			log.Println(err)
+			s.Log.Error(err)
 			return session.End(ctx, s, err)
 		case *frame.BusyCmd:
 			err := fmt.Errorf("received: %v", frame)
-			log.Println(err)
+			s.Log.Error(err)
 			return session.End(ctx, s, err)
 		case *frame.NullCmd:
-			log.Println("received:", frame)
+			s.Log.Info("received:", frame)
+			s.NoteRemote(frame)
 		case *frame.OptCmd:
-			log.Println("received:", frame)
-			for _, text := range frame.Options() {
-				if strings.HasPrefix(text, "CRAM-") {
-					return saveChallenge(ctx, text, s)
-				}
+			s.Log.Info("received:", frame)
+			s.NoteRemote(frame)
+			options := s.Remote().Options
+			if hash, challenge, ok := options.CRAM(auth.SupportsHash); ok {
+				return saveChallenge(ctx, hash, challenge, s)
+			}
+			if options.HasCRAM() {
+				err := fmt.Errorf("No supported hash in challenge: %v", frame)
+				s.Log.Error(err)
+				s.SendErrorCmd(ctx, "No supported CRAM hash")
+				return session.End(ctx, s, err)
 			}
 		default:
 			err := fmt.Errorf("unexpected frame: %v", frame)
-			log.Println(err)
+			s.Log.Error(err)
 			s.SendErrorCmd(ctx, "Unexpected frame received")
 			return session.End(ctx, s, err)
 		}
@@ -80,19 +83,37 @@ func senderWaitForAddress(ctx context.Context, s *session.Session) (session.Stat
 	}
 }
 
-func saveChallenge(ctx context.Context, text string, s *session.Session) (session.State, error) {
-	fields := strings.Split(text, "-")
-	if len(fields) != 3 {
-		err := fmt.Errorf("Malformed challenge: %q", text)
-		log.Println(err)
-		s.SendErrorCmd(ctx, "Malformed challenge")
-		return session.End(ctx, s, err)
+// linkAddresses checks that the distant end is the system we
+// called, if any, and decides the session's links.  The called
+// link is the primary one.  A called link without a password is
+// one for direct delivery, and is served alone.
+func linkAddresses(s *session.Session, addrs []ftn.Address) error {
+	if s.Called == nil {
+		_, err := s.LinkAddresses(addrs)
+		return err
 	}
-	s.HashStr = fields[1]
-	challenge, err := auth.DecodeChallenge(fields[2])
+	presented := false
+	for _, addr := range addrs {
+		if s.Config.Zones.Equal(addr, s.Called.Address) {
+			presented = true
+			break
+		}
+	}
+	if !presented {
+		return fmt.Errorf("called %v, but distant end presented %v", s.Called.Address, addrs)
+	}
+	if s.Called.Password == "" {
+		return s.LinkDirect(s.Called, addrs)
+	}
+	return s.LinkCalled(s.Called, addrs)
+}
+
+func saveChallenge(ctx context.Context, hash, text string, s *session.Session) (session.State, error) {
+	s.HashStr = hash
+	challenge, err := auth.DecodeChallenge(text)
 	if err != nil {
 		err := fmt.Errorf("Failed to decode challenge %q: %v", text, err)
-		log.Println(err)
+		s.Log.Error(err)
 		s.SendErrorCmd(ctx, "Challenge decode failed")
 		return session.End(ctx, s, err)
 	}
@@ -101,16 +122,24 @@ func saveChallenge(ctx context.Context, text string, s *session.Session) (sessio
 }
 
 func sendResponse(ctx context.Context, s *session.Session) (session.State, error) {
+	if s.Link.Password == "" {
+		if !s.WriteSyncFrame(ctx, frame.NewPassword("-")) {
+			err := errors.New("Failed to write PWD")
+			s.Log.Error(err)
+			return session.End(ctx, s, err)
+		}
+		return waitForOk, nil
+	}
 	response, err := auth.GenerateResponse(s.HashStr, s.Challenge, s.Link.Password)
 	if err != nil {
 		err := fmt.Errorf("Failed to generate response: %v", err)
-		log.Println(err)
+		s.Log.Error(err)
 		s.SendErrorCmd(ctx, "Challenge response generation failed")
 		return session.End(ctx, s, err)
 	}
 	if !s.WriteSyncFrame(ctx, frame.NewPassword("CRAM-"+s.HashStr+"-"+response)) {
 		err := errors.New("Failed to write PWD")
-		log.Println(err)
+		s.Log.Error(err)
 		return session.End(ctx, s, err)
 	}
 	return waitForOk, nil
@@ -123,28 +152,38 @@ func waitForOk(ctx context.Context, s *session.Session) (session.State, error) {
 	case f, ok := <-s.ReadFrames:
 		if ctx.Err() != nil || f == nil || !ok {
 			err := errors.New("Error waiting for challenge")
-			log.Println(err)
+			s.Log.Error(err)
 			return session.End(ctx, s, err)
 		}
 		switch frame := f.(type) {
 		case *frame.OkCmd:
-			log.Println("received:", frame)
+			s.Log.Info("received:", frame)
+			if err := s.MarkBusy(); err != nil {
+				s.Log.Error(err)
+				if errors.Is(err, session.ErrAllBusy) {
+					s.SendBusyCmd(ctx, "All addresses are busy")
+				}
+				return session.End(ctx, s, err)
+			}
+			s.Authenticated()
 			return transfer.Start, nil
 		case *frame.ErrorCmd:
 			err := fmt.Errorf("received: %v", frame)
-			log.Println(err)
+			s.Log.Error(err)
 			return session.End(ctx, s, err)
 		case *frame.BusyCmd:
 			err := fmt.Errorf("received: %v", frame)
-			log.Println(err)
+			s.Log.Error(err)
 			return session.End(ctx, s, err)
 		case *frame.NullCmd:
-			log.Println("received:", frame)
+			s.Log.Info("received:", frame)
+			s.NoteRemote(frame)
 		case *frame.OptCmd:
-			log.Println("received:", frame)
+			s.Log.Info("received:", frame)
+			s.NoteRemote(frame)
 		default:
 			err := fmt.Errorf("unexpected frame: %v", frame)
-			log.Println(err)
+			s.Log.Error(err)
 			s.SendErrorCmd(ctx, "Unexpected frame received")
 			return session.End(ctx, s, err)
 		}
@@ -0,0 +1,72 @@
+package session
+
+import (
+	"time"
+
+	"fat-dragon.org/ginko/config"
+	"fat-dragon.org/ginko/frame"
+	"fat-dragon.org/ginko/version"
+)
+
+// Banner returns the M_NUL frames with which we introduce
+// ourselves at the start of a session.  Optional lines are
+// omitted if they are not configured.
+func (s *Session) Banner() []frame.Frame {
+	return banner(s.Config, s.bannerNet(), time.Now())
+}
+
+// bannerNet returns the net whose overrides apply to the
+// banner: that of the link, if it is already known or we called
+// it, or the only configured net.  Otherwise the system-wide
+// values are used.
+func (s *Session) bannerNet() *config.Net {
+	if link := s.link(); link != nil && link.LinkedNet != nil {
+		return link.LinkedNet
+	}
+	if len(s.Config.Nets) == 1 {
+		return &s.Config.Nets[0]
+	}
+	return nil
+}
+
+func banner(c *config.Config, net *config.Net, now time.Time) []frame.Frame {
+	frames := []frame.Frame{
+		frame.NewNull("SYS " + c.SystemIn(net)),
+		frame.NewNull("ZYZ " + c.Admin),
+		frame.NewNull("LOC " + c.LocationIn(net)),
+	}
+	if c.NodelistFlags != "" {
+		frames = append(frames, frame.NewNull("NDL "+c.NodelistFlags))
+	}
+	if c.Phone != "" {
+		frames = append(frames, frame.NewNull("PHN "+c.Phone))
+	}
+	if c.URL != "" {
+		frames = append(frames, frame.NewNull("URL "+c.URL))
+	}
+	return append(frames,
+		frame.NewNull("VER "+version.Ver()),
+		frame.NewNull("TIME "+now.Format(time.RFC1123Z)))
+}
+
+// AddressFrame returns an M_ADR frame presenting our addresses
+// as appropriate for the session's link, if it is known yet, or
+// for the system we called.  When we call a system we have no
+// link with, only our main address is presented, as we cannot
+// tell which of our networks it is in.
+func (s *Session) AddressFrame() *frame.AddressCmd {
+	addrs := s.Config.AddressesFor(s.link())
+	if s.link() == nil && s.Role == Sender && len(addrs) > 1 {
+		addrs = addrs[:1]
+	}
+	return frame.NewAddress(addrs...)
+}
+
+// link returns the session's link, or failing that the link we
+// called.
+func (s *Session) link() *config.Link {
+	if s.Link != nil {
+		return s.Link
+	}
+	return s.Called
+}
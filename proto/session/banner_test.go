@@ -0,0 +1,53 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"fat-dragon.org/ginko/config"
+	"fat-dragon.org/ginko/frame"
+	"fat-dragon.org/ginko/version"
+)
+
+func TestBanner(t *testing.T) {
+	c := &config.Config{
+		Admin:         "Sysop",
+		System:        "Main BBS",
+		Location:      "Here",
+		NodelistFlags: "CM,IBN",
+		URL:           "https://example.org/",
+		Nets:          []config.Net{{Name: "fidonet", System: "Fido BBS"}},
+	}
+	now := time.Date(2021, 6, 15, 20, 4, 5, 0, time.UTC)
+
+	var r RemoteInfo
+	frames := banner(c, &c.Nets[0], now)
+	for _, f := range frames {
+		r.Update(f)
+	}
+	if r.System != "Fido BBS" || r.Location != "Here" || r.Sysop != "Sysop" {
+		t.Errorf("unexpected identity %+v", r)
+	}
+	if r.Nodelist != "CM,IBN" || r.URL != "https://example.org/" || r.Phone != "" {
+		t.Errorf("unexpected optional lines %+v", r)
+	}
+	if r.Version != version.Ver() || r.Mailer != "ginko" || r.ProtocolVersion != "1.0" {
+		t.Errorf("unexpected version %q", r.Version)
+	}
+	if when, ok := r.ParsedTime(); !ok || !when.Equal(now) {
+		t.Errorf("unexpected time %v", when)
+	}
+	for _, f := range frames {
+		if n, ok := f.(*frame.NullCmd); ok && n.String()[:3] == "PHN" {
+			t.Errorf("unconfigured PHN line sent: %q", n)
+		}
+	}
+
+	r = RemoteInfo{}
+	for _, f := range banner(c, nil, now) {
+		r.Update(f)
+	}
+	if r.System != "Main BBS" {
+		t.Errorf("system %q without a net, want %q", r.System, c.System)
+	}
+}
@@ -0,0 +1,209 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"fat-dragon.org/ginko/config"
+	"fat-dragon.org/ginko/ftn/ftntest"
+	"fat-dragon.org/ginko/logging"
+)
+
+func linkTestConfig(t *testing.T) *config.Config {
+	dir := t.TempDir()
+	for _, sub := range []string{"a", "b", "c"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0770); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := config.ParseFromString(fmt.Sprintf(`{
+		nets: [{
+			name: "fidonet",
+			address: "1:387/108@fidonet",
+			links: [
+				{ address: "1:387/1@fidonet", password: "secret", out: "%[1]s/a" },
+				{ address: "1:387/1.1@fidonet", password: "secret", out: "%[1]s/b" },
+				{ address: "1:387/2@fidonet", password: "other", out: "%[1]s/c" },
+			],
+		}],
+	}`, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func linkTestSession(c *config.Config) *Session {
+	return &Session{Config: c, Log: logging.New(io.Discard, logging.Info, false), Throttle: &Throttle{}}
+}
+
+func TestLinkAddresses(t *testing.T) {
+	c := linkTestConfig(t)
+	s := linkTestSession(c)
+	defer s.releaseLinks()
+	link, err := s.LinkAddresses(ftntest.Addresses(t, "1:387/99@fidonet", "1:387/1@fidonet", "1:387/2@fidonet", "1:387/1.1@fidonet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Address.String() != "1:387/1@fidonet" || s.Link != link {
+		t.Errorf("primary link %v, want 1:387/1@fidonet", link.Address)
+	}
+	if len(s.Links) != 2 || s.Links[1].Address.String() != "1:387/1.1@fidonet" {
+		t.Errorf("served links %v, want the two sharing a password", s.Links)
+	}
+	if len(s.Unsecured) != 1 || s.Unsecured[0].String() != "1:387/99@fidonet" {
+		t.Errorf("unsecured %v, want 1:387/99@fidonet", s.Unsecured)
+	}
+}
+
+func TestLinkCalled(t *testing.T) {
+	c := linkTestConfig(t)
+	s := linkTestSession(c)
+	called := c.LinkFor(ftntest.Address(t, "1:387/1.1@fidonet"))
+	err := s.LinkCalled(called, ftntest.Addresses(t, "1:387/2@fidonet", "1:387/1@fidonet", "1:387/1.1@fidonet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Link != called {
+		t.Errorf("primary link %v, want the one called", s.Link.Address)
+	}
+	if len(s.Links) != 2 || s.Links[0] != called || s.Links[1].Address.String() != "1:387/1@fidonet" {
+		t.Errorf("served links %v, want the two sharing the called link's password", s.Links)
+	}
+}
+
+// linked returns a session linked to the given addresses, and
+// marked busy.
+func linked(t *testing.T, c *config.Config, ss ...string) (*Session, error) {
+	s := linkTestSession(c)
+	if _, err := s.LinkAddresses(ftntest.Addresses(t, ss...)); err != nil {
+		t.Fatal(err)
+	}
+	return s, s.MarkBusy()
+}
+
+func TestMarkBusy(t *testing.T) {
+	c := linkTestConfig(t)
+	first, err := linked(t, c, "1:387/1@fidonet")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Links are not marked busy before authentication.
+	claimed := linkTestSession(c)
+	if _, err := claimed.LinkAddresses(ftntest.Addresses(t, "1:387/1.1@fidonet")); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := linked(t, c, "1:387/1@fidonet", "1:387/1.1@fidonet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Link.Address.String() != "1:387/1.1@fidonet" || len(second.Links) != 1 {
+		t.Errorf("busy address not skipped: primary %v, links %v", second.Link.Address, second.Links)
+	}
+	second.releaseLinks()
+
+	if _, err := linked(t, c, "1:387/1@fidonet"); !errors.Is(err, ErrAllBusy) {
+		t.Errorf("got %v, want ErrAllBusy", err)
+	}
+
+	first.releaseLinks()
+	fourth, err := linked(t, c, "1:387/1@fidonet")
+	if err != nil {
+		t.Errorf("link still busy after release: %v", err)
+	}
+	fourth.releaseLinks()
+}
+
+func TestMarkBusyNoSpool(t *testing.T) {
+	c, err := config.ParseFromString(`{
+		nets: [{
+			name: "fidonet",
+			address: "1:387/108@fidonet",
+			links: [{ address: "1:387/1@fidonet", password: "secret" }],
+		}],
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := linked(t, c, "1:387/1@fidonet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.releaseLinks()
+	if _, err := os.Stat("Busy"); !os.IsNotExist(err) {
+		t.Errorf("busy mark taken in the working directory: %v", err)
+	}
+}
+
+func TestLinkAddressesUnlinked(t *testing.T) {
+	s := linkTestSession(linkTestConfig(t))
+	if _, err := s.LinkAddresses(ftntest.Addresses(t, "2:5020/1@fidonet")); !errors.Is(err, ErrUnlinked) {
+		t.Errorf("got %v, want ErrUnlinked", err)
+	}
+}
+
+func TestLinkDirect(t *testing.T) {
+	c := linkTestConfig(t)
+	c.DataDir = t.TempDir()
+	remote := ftntest.Addresses(t, "2:5020/1@fidonet", "2:5020/2@fidonet")
+	link, err := c.DirectLink(remote[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := link.OutSpool.Create(); err != nil {
+		t.Fatal(err)
+	}
+	s := linkTestSession(c)
+	s.Called = link
+	if got := s.AddressFrame().Addresses(); len(got) != 1 || got[0].String() != "1:387/108@fidonet" {
+		t.Errorf("presented %v to direct link", got)
+	}
+	if err := s.LinkDirect(link, remote); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MarkBusy(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Link != link || len(s.Links) != 1 || len(s.Unsecured) != 2 {
+		t.Errorf("direct session serves %v, unsecured %v", s.Links, s.Unsecured)
+	}
+	other := linkTestSession(c)
+	if err := other.LinkDirect(link, remote); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.MarkBusy(); !errors.Is(err, ErrAllBusy) {
+		t.Errorf("second direct session: %v, want ErrAllBusy", err)
+	}
+	s.releaseLinks()
+	if err := other.MarkBusy(); err != nil {
+		t.Errorf("direct session after release: %v", err)
+	}
+	other.releaseLinks()
+}
+
+func TestAddressFrameUnknown(t *testing.T) {
+	c, err := config.ParseFromString(`{
+		nets: [
+			{ name: "fidonet", address: "1:387/108@fidonet" },
+			{ name: "fsxnet", address: "21:1/108@fsxnet" },
+		],
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := linkTestSession(c)
+	s.Role = Sender
+	if got := s.AddressFrame().Addresses(); len(got) != 1 || got[0].String() != "1:387/108@fidonet" {
+		t.Errorf("presented %v when calling an unknown system", got)
+	}
+	s.Role = Receiver
+	if got := s.AddressFrame().Addresses(); len(got) != 2 {
+		t.Errorf("presented %v when called by an unknown system", got)
+	}
+}
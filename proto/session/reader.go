@@ -9,6 +9,8 @@ import (
 	"net"
 
 	"fat-dragon.org/ginko/frame"
+	"fat-dragon.org/ginko/logging"
+	"fat-dragon.org/ginko/metrics"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -17,9 +19,11 @@ import (
 // chan of ErrorCmds for dispatching an error frame to the distant
 // end.
 //
+// Every frame read is logged at debug level.
+//
 // Returns a read chan of frames, as well as an error chan and
 // possibly an error for detecting problems at startup.
-func makeFrameReader(ctx context.Context, waiter *errgroup.Group, conn net.Conn) (chan frame.Terminal, chan frame.Frame) {
+func makeFrameReader(ctx context.Context, waiter *errgroup.Group, conn net.Conn, log *logging.Logger) (chan frame.Terminal, chan frame.Frame) {
 	urgentErr := make(chan frame.Terminal)
 	out := make(chan frame.Frame, 16)
 	waiter.Go(func() error {
@@ -34,9 +38,12 @@ func makeFrameReader(ctx context.Context, waiter *errgroup.Group, conn net.Conn)
 					break
 				}
 				msg := fmt.Sprintf("Error reading frame: %v", err)
+				log.Error(msg)
+				metrics.FrameDecodeErrors.Inc()
 				urgentErr <- frame.NewErrorCmd(msg)
 				return errors.New(msg)
 			}
+			log.Debug("received:", f)
 			select {
 			case out <- f:
 				if ctx.Err() != nil {
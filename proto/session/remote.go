@@ -0,0 +1,265 @@
+package session
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fat-dragon.org/ginko/frame"
+)
+
+// RemoteInfo holds what the distant end has told us about itself
+// in M_NUL frames.  The standard keywords are parsed into fields;
+// anything else is kept in Other.
+type RemoteInfo struct {
+	System   string // SYS: system name
+	Sysop    string // ZYZ: sysop name
+	Location string // LOC: location
+	Nodelist string // NDL: nodelist flags
+	Phone    string // PHN: phone number
+	URL      string // URL: web site
+	Time     string // TIME: the remote's local time, as sent
+	Version  string // VER: mailer and protocol version, as sent
+
+	// Parsed from VER, e.g. "binkd/1.1a-112/Linux binkp/1.1".
+	Mailer          string
+	MailerVersion   string
+	ProtocolVersion string
+
+	// TRF: pending netmail and echomail (ARCmail) bytes.
+	NetmailBytes int64
+	ArcmailBytes int64
+	HasTraffic   bool
+
+	// FREQ: whether, and with what restrictions, file requests
+	// are accepted.
+	AcceptsFreqs bool
+	FileRequests string
+
+	Options Options  // OPT: protocol options.
+	Other   []string // Lines with unrecognized keywords.
+
+	parsedTime    time.Time
+	hasParsedTime bool
+}
+
+// clone returns a copy of the info that shares no mutable state.
+func (r RemoteInfo) clone() RemoteInfo {
+	r.Other = append([]string(nil), r.Other...)
+	r.Options = r.Options.clone()
+	return r
+}
+
+// ParsedTime returns the remote's TIME, if it could be parsed.
+func (r *RemoteInfo) ParsedTime() (time.Time, bool) {
+	return r.parsedTime, r.hasParsedTime
+}
+
+// Update folds a frame from the distant end into the info.  Only
+// M_NUL frames (including OPT) are considered; it reports whether
+// the frame was one.
+func (r *RemoteInfo) Update(f frame.Frame) bool {
+	switch f := f.(type) {
+	case *frame.OptCmd:
+		r.Options.add(f.Options()...)
+	case *frame.NullCmd:
+		r.parseLine(f.String())
+	default:
+		return false
+	}
+	return true
+}
+
+func (r *RemoteInfo) parseLine(line string) {
+	key, value, _ := strings.Cut(strings.TrimSpace(line), " ")
+	value = strings.TrimSpace(value)
+	switch strings.ToUpper(key) {
+	case "SYS":
+		r.System = value
+	case "ZYZ":
+		r.Sysop = value
+	case "LOC":
+		r.Location = value
+	case "NDL":
+		r.Nodelist = value
+	case "PHN":
+		r.Phone = value
+	case "URL":
+		r.URL = value
+	case "TIME":
+		r.Time = value
+		r.parseTime(value)
+	case "VER":
+		r.Version = value
+		r.parseVersion(value)
+	case "TRF":
+		r.parseTraffic(value)
+	case "FREQ":
+		r.AcceptsFreqs = true
+		r.FileRequests = value
+	case "OPT":
+		r.Options.add(strings.Fields(value)...)
+	default:
+		r.Other = append(r.Other, line)
+	}
+}
+
+// parseTime parses the TIME keyword.  The binkp specification
+// asks for RFC 822 format, but mailers vary in the details.
+func (r *RemoteInfo) parseTime(value string) {
+	layouts := []string{time.RFC1123Z, time.RFC1123, time.RFC822Z, time.RFC822,
+		"Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST"}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			r.parsedTime = t
+			r.hasParsedTime = true
+			return
+		}
+	}
+}
+
+// parseVersion parses the VER keyword.  By convention this is
+// "mailer/version[/os] binkp/x.y", though some mailers put
+// spaces or other text in the mailer part; we take the first
+// field as the mailer and look for the protocol version anywhere.
+func (r *RemoteInfo) parseVersion(value string) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return
+	}
+	for _, field := range fields {
+		if strings.HasPrefix(strings.ToLower(field), "binkp/") {
+			r.ProtocolVersion = field[len("binkp/"):]
+		}
+	}
+	if strings.HasPrefix(strings.ToLower(fields[0]), "binkp/") {
+		return
+	}
+	parts := strings.Split(fields[0], "/")
+	r.Mailer = parts[0]
+	if len(parts) > 1 {
+		r.MailerVersion = parts[1]
+	}
+}
+
+// parseTraffic parses the TRF keyword: pending netmail and
+// echomail byte counts.
+func (r *RemoteInfo) parseTraffic(value string) {
+	fields := strings.Fields(value)
+	if len(fields) < 2 {
+		return
+	}
+	netmail, err1 := strconv.ParseInt(fields[0], 10, 64)
+	arcmail, err2 := strconv.ParseInt(fields[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return
+	}
+	r.NetmailBytes = netmail
+	r.ArcmailBytes = arcmail
+	r.HasTraffic = true
+}
+
+// ProtocolAtLeast reports whether the distant end claims to
+// speak at least the given binkp version (e.g. 1, 1).
+func (r *RemoteInfo) ProtocolAtLeast(major, minor int) bool {
+	maj, min, ok := parseProtocolVersion(r.ProtocolVersion)
+	if !ok {
+		return false
+	}
+	return maj > major || (maj == major && min >= minor)
+}
+
+func parseProtocolVersion(v string) (int, int, bool) {
+	majStr, minStr, _ := strings.Cut(v, ".")
+	maj, err := strconv.Atoi(majStr)
+	if err != nil {
+		return 0, 0, false
+	}
+	// Ignore any suffix on the minor version, e.g. "1.1a".
+	end := 0
+	for end < len(minStr) && minStr[end] >= '0' && minStr[end] <= '9' {
+		end++
+	}
+	min, err := strconv.Atoi(minStr[:end])
+	if err != nil {
+		min = 0
+	}
+	return maj, min, true
+}
+
+// Options is the set of binkp options advertised with OPT.
+type Options struct {
+	flags      map[string]bool
+	cramHashes []string
+	challenge  string
+}
+
+// add records options, parsing CRAM challenges of the form
+// "CRAM-<hash>[/<hash>...]-<challenge>".
+func (o *Options) add(options ...string) {
+	if o.flags == nil {
+		o.flags = make(map[string]bool)
+	}
+	for _, option := range options {
+		if strings.HasPrefix(option, "CRAM-") {
+			rest := option[len("CRAM-"):]
+			hashes, challenge, ok := strings.Cut(rest, "-")
+			if ok && hashes != "" && challenge != "" {
+				o.cramHashes = strings.Split(hashes, "/")
+				o.challenge = challenge
+			}
+			o.flags["CRAM"] = true
+			continue
+		}
+		o.flags[strings.ToUpper(option)] = true
+	}
+}
+
+func (o Options) clone() Options {
+	flags := make(map[string]bool, len(o.flags))
+	for k, v := range o.flags {
+		flags[k] = v
+	}
+	o.flags = flags
+	o.cramHashes = append([]string(nil), o.cramHashes...)
+	return o
+}
+
+// Has reports whether the distant end advertised the option,
+// e.g. "NR", "ND" or "MB".  CRAM challenges are reported as "CRAM".
+func (o *Options) Has(option string) bool {
+	return o.flags[strings.ToUpper(option)]
+}
+
+// List returns the advertised options in sorted order,
+// excluding CRAM.
+func (o *Options) List() []string {
+	var list []string
+	for option := range o.flags {
+		if option != "CRAM" {
+			list = append(list, option)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// CRAM returns the first hash algorithm offered in a CRAM
+// challenge that satisfies supported, along with the encoded
+// challenge.  Hashes are offered in order of the distant end's
+// preference.
+func (o *Options) CRAM(supported func(string) bool) (hash, challenge string, ok bool) {
+	for _, h := range o.cramHashes {
+		if supported(h) {
+			return h, o.challenge, true
+		}
+	}
+	return "", "", false
+}
+
+// HasCRAM reports whether the distant end offered a CRAM
+// challenge at all.
+func (o *Options) HasCRAM() bool {
+	return o.challenge != ""
+}
@@ -0,0 +1,100 @@
+package session
+
+import (
+	"reflect"
+	"testing"
+
+	"fat-dragon.org/ginko/frame"
+)
+
+func TestRemoteInfoUpdate(t *testing.T) {
+	var r RemoteInfo
+	for _, line := range []string{
+		"SYS Fat Dragon Hub",
+		"ZYZ Some Sysop",
+		"LOC Somewhere, NY",
+		"NDL 115200,TCP,BINKP",
+		"PHN -Unpublished-",
+		"TIME Tue, 15 Jun 2021 20:04:05 -0400",
+		"VER binkd/1.1a-112/Linux binkp/1.1",
+		"TRF 1024 4096",
+		"FREQ",
+		"XYZ something else",
+	} {
+		if !r.Update(frame.NewNull(line)) {
+			t.Fatalf("Update(%q) rejected M_NUL frame", line)
+		}
+	}
+	if r.Update(frame.NewEOB()) {
+		t.Error("Update accepted a non-M_NUL frame")
+	}
+	if r.System != "Fat Dragon Hub" || r.Sysop != "Some Sysop" || r.Location != "Somewhere, NY" {
+		t.Errorf("unexpected identity %+v", r)
+	}
+	if r.Nodelist != "115200,TCP,BINKP" || r.Phone != "-Unpublished-" {
+		t.Errorf("unexpected nodelist info %+v", r)
+	}
+	if r.Mailer != "binkd" || r.MailerVersion != "1.1a-112" || r.ProtocolVersion != "1.1" {
+		t.Errorf("unexpected version %q %q %q", r.Mailer, r.MailerVersion, r.ProtocolVersion)
+	}
+	if !r.ProtocolAtLeast(1, 1) || r.ProtocolAtLeast(1, 2) || !r.ProtocolAtLeast(1, 0) {
+		t.Errorf("ProtocolAtLeast wrong for %q", r.ProtocolVersion)
+	}
+	if !r.HasTraffic || r.NetmailBytes != 1024 || r.ArcmailBytes != 4096 {
+		t.Errorf("unexpected traffic %+v", r)
+	}
+	if !r.AcceptsFreqs {
+		t.Error("FREQ not recognized")
+	}
+	if when, ok := r.ParsedTime(); !ok || when.Year() != 2021 {
+		t.Errorf("TIME not parsed: %v %v", when, ok)
+	}
+	if !reflect.DeepEqual(r.Other, []string{"XYZ something else"}) {
+		t.Errorf("unexpected other lines %q", r.Other)
+	}
+}
+
+func TestRemoteInfoVersionVariants(t *testing.T) {
+	tests := []struct {
+		ver, mailer, version, proto string
+	}{
+		{"ginko/0.0.1/OpenBSD/x86_64 binkp/1.0", "ginko", "0.0.1", "1.0"},
+		{"Mystic/1.12A47 binkp/1.0", "Mystic", "1.12A47", "1.0"},
+		{"binkp/1.1", "", "", "1.1"},
+		{"SomeMailer", "SomeMailer", "", ""},
+	}
+	for _, test := range tests {
+		var r RemoteInfo
+		r.Update(frame.NewNull("VER " + test.ver))
+		if r.Mailer != test.mailer || r.MailerVersion != test.version || r.ProtocolVersion != test.proto {
+			t.Errorf("VER %q: got %q %q %q", test.ver, r.Mailer, r.MailerVersion, r.ProtocolVersion)
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	var r RemoteInfo
+	r.Update(frame.NewOpt("NR", "nd", "CRAM-SHA256/MD5-0123abcd"))
+	opts := r.Options
+	if !opts.Has("NR") || !opts.Has("ND") || opts.Has("MB") {
+		t.Errorf("unexpected options %v", opts.List())
+	}
+	if !reflect.DeepEqual(opts.List(), []string{"ND", "NR"}) {
+		t.Errorf("List() = %v", opts.List())
+	}
+	if !opts.HasCRAM() {
+		t.Fatal("CRAM challenge not recognized")
+	}
+	hash, challenge, ok := opts.CRAM(func(h string) bool { return h == "MD5" })
+	if !ok || hash != "MD5" || challenge != "0123abcd" {
+		t.Errorf("CRAM() = %q, %q, %v", hash, challenge, ok)
+	}
+	if _, _, ok := opts.CRAM(func(string) bool { return false }); ok {
+		t.Error("CRAM() matched an unsupported hash")
+	}
+	clone := r.clone()
+	r.Update(frame.NewOpt("MB"))
+	if clone.Options.Has("MB") {
+		t.Error("clone shares options with original")
+	}
+}
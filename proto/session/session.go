@@ -2,18 +2,47 @@ package session
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
+	"sync/atomic"
+	"time"
 
 	"fat-dragon.org/ginko/config"
 	"fat-dragon.org/ginko/frame"
 	"fat-dragon.org/ginko/ftn"
+	"fat-dragon.org/ginko/logging"
+	"fat-dragon.org/ginko/metrics"
 	"golang.org/x/sync/errgroup"
 )
 
+// Role distinguishes the side of a session that placed the call
+// (the sender) from the side that answered it (the receiver).
+type Role int
+
+const (
+	Sender Role = iota
+	Receiver
+)
+
+func (r Role) String() string {
+	if r == Receiver {
+		return "receiver"
+	}
+	return "sender"
+}
+
 type Session struct {
+	ID          uint64
+	Log         *logging.Logger
+	Role        Role
 	Config      *config.Config
 	Link        *config.Link
+	Links       []*config.Link
+	Called      *config.Link
 	RemoteAddrs []ftn.Address
+	Unsecured   []ftn.Address
+	Throttle    *Throttle
 	HashStr     string
 	Challenge   []byte
 	urgentErr   chan frame.Terminal
@@ -24,24 +53,39 @@ type Session struct {
 	XmitrFrames chan frame.Queueing
 	XmitrDone   chan struct{}
 	waiter      *errgroup.Group
+	stats       *stats
+	release     []func()
 }
 
 const readBufferSize = (32767 + 2) * 2
 
+// lastSessionID is used to number sessions within the process.
+var lastSessionID uint64
+
 // NewSession constructs a new BINKP session and returns a
 // session object.
-func NewSession(ctx context.Context, config *config.Config, conn net.Conn) Session {
+func NewSession(ctx context.Context, role Role, config *config.Config, conn net.Conn) Session {
+	id := atomic.AddUint64(&lastSessionID, 1)
+	log := logging.Default().With("session", id, "role", role, "remote", conn.RemoteAddr())
 	waiter, ctx := errgroup.WithContext(ctx)
-	urgentErr, readFrames := makeFrameReader(ctx, waiter, conn)
-	writeFrames := makeFrameWriter(ctx, waiter, conn, urgentErr)
+	throttle := &Throttle{}
+	urgentErr, readFrames := makeFrameReader(ctx, waiter, conn, log)
+	writeFrames := makeFrameWriter(ctx, waiter, conn, throttle, urgentErr, log)
 	recvrFrames := make(chan frame.Frame)
 	recvrDone := make(chan struct{})
 	xmitrFrames := make(chan frame.Queueing)
 	xmitrDone := make(chan struct{})
 	return Session{
+		id,
+		log,
+		role,
 		config,
 		nil,
 		nil,
+		nil,
+		nil,
+		nil,
+		throttle,
 		"MD5",
 		nil,
 		urgentErr,
@@ -52,6 +96,8 @@ func NewSession(ctx context.Context, config *config.Config, conn net.Conn) Sessi
 		xmitrFrames,
 		xmitrDone,
 		waiter,
+		&stats{result: SessionResult{Start: time.Now(), RemoteAddr: conn.RemoteAddr().String()}},
+		nil,
 	}
 }
 
@@ -105,10 +151,19 @@ func (s *Session) FlushWriter(ctx context.Context) bool {
 
 // SendErrorCmd sends an ErrorCmd to the writer.
 func (s *Session) SendErrorCmd(ctx context.Context, text string) bool {
+	return s.sendTerminal(ctx, frame.NewErrorCmd(text))
+}
+
+// SendBusyCmd sends a BusyCmd to the writer.
+func (s *Session) SendBusyCmd(ctx context.Context, text string) bool {
+	return s.sendTerminal(ctx, frame.NewBusy(text))
+}
+
+func (s *Session) sendTerminal(ctx context.Context, f frame.Terminal) bool {
 	select {
 	case <-ctx.Done():
 		return false
-	case s.urgentErr <- frame.NewErrorCmd(text):
+	case s.urgentErr <- f:
 		if ctx.Err() != nil {
 			return false
 		}
@@ -121,8 +176,13 @@ func End(_ context.Context, _ *Session, err error) (State, error) {
 	return nil, err
 }
 
-// Run starts a session at the initial state.
-func (s *Session) Run(ctx context.Context, initState State) error {
+// Run starts a session at the initial state, and returns a
+// summary of the session once it ends.  The summary, and any
+// incomplete transfers, are also logged.
+func (s *Session) Run(ctx context.Context, initState State) *SessionResult {
+	s.Log.Info("session starting")
+	metrics.SessionsActive.Inc(s.Role.String())
+	defer metrics.SessionsActive.Dec(s.Role.String())
 	s.waiter.Go(func() error {
 		for state := initState; state != nil; {
 			var err error
@@ -133,23 +193,170 @@ func (s *Session) Run(ctx context.Context, initState State) error {
 		}
 		return nil
 	})
-	return s.Wait()
+	result := s.result(s.Wait())
+	s.releaseLinks()
+	for _, f := range result.Sent {
+		if !f.Complete {
+			s.Log.Warn("sent", f)
+		}
+	}
+	for _, f := range result.Received {
+		if !f.Complete {
+			s.Log.Warn("received", f)
+		}
+	}
+	if result.Err != nil {
+		s.Log.Error(result)
+	} else {
+		s.Log.Info(result)
+	}
+	result.exportMetrics()
+	return result
+}
+
+// ErrUnlinked is returned by LinkAddresses when none of the
+// addresses presented by the distant end is a configured link.
+var ErrUnlinked = errors.New("no presented address is a configured link")
+
+// ErrAllBusy is returned by MarkBusy when every link of the
+// session is already being served by another session.
+var ErrAllBusy = errors.New("all addresses are busy")
+
+// LinkAddresses matches the addresses presented by the distant
+// end against our configured links, following binkd:
+//
+//   - Addresses that are not configured links are unsecured.
+//     They are logged and recorded, but not served.
+//   - The first link becomes the session's `Link`; its password
+//     authenticates the session.  Other links are served as
+//     well, but only if they share the password.
+//
+// Every link to be served is listed in `Links`.  The primary
+// link's rate limit applies to the session, and it is added to
+// the logging context.  Links are not marked busy until the
+// distant end has authenticated; see MarkBusy.
+func (s *Session) LinkAddresses(addrs []ftn.Address) (*config.Link, error) {
+	return s.linkAddresses(nil, addrs)
+}
+
+// LinkCalled matches the addresses presented by a system we
+// called as LinkAddresses does, except that the link we called
+// is the primary one, whatever order the distant end presented
+// its addresses in.  Other links are served only if they share
+// its password.
+func (s *Session) LinkCalled(called *config.Link, addrs []ftn.Address) error {
+	_, err := s.linkAddresses(called, addrs)
+	return err
 }
 
-// LinkAddresses looks for a link related to the given address and
-// sets the `Link` member appropriately if it finds one.  It returns
-// the link or nil.
-func (s *Session) LinkAddresses(addrs []ftn.Address) *config.Link {
+// linkAddresses matches presented addresses against our links,
+// with the given primary link, if any, or else the first.
+func (s *Session) linkAddresses(primary *config.Link, addrs []ftn.Address) (*config.Link, error) {
 	s.RemoteAddrs = addrs
-	for _, addr := range s.RemoteAddrs {
-		if link := s.Config.Links[addr]; link != nil {
-			s.Link = link
-			return link
+	seen := make(map[*config.Link]bool)
+	if primary != nil {
+		s.setLink(primary)
+		seen[primary] = true
+	}
+	for _, addr := range addrs {
+		link := s.Config.LinkFor(addr)
+		if link == nil {
+			s.Log.Warnf("address %v is not a configured link; unsecured", addr)
+			s.Unsecured = append(s.Unsecured, addr)
+			continue
+		}
+		if seen[link] {
+			continue
+		}
+		seen[link] = true
+		if s.Link != nil && link.Password != s.Link.Password {
+			s.Log.Warnf("not serving %v: password differs from %v", addr, s.Link.Address)
+			continue
+		}
+		if s.Link == nil {
+			s.setLink(link)
+		} else {
+			s.Links = append(s.Links, link)
 		}
 	}
+	if s.Link == nil {
+		return nil, ErrUnlinked
+	}
+	if len(s.Links) > 1 {
+		s.Log.Infof("serving %d AKAs", len(s.Links))
+	}
+	return s.Link, nil
+}
+
+// LinkDirect serves the given link alone, as when we call a
+// system we have no configured link with to deliver mail
+// directly.  Its addresses are recorded as unsecured.
+func (s *Session) LinkDirect(link *config.Link, addrs []ftn.Address) error {
+	s.RemoteAddrs = addrs
+	s.Unsecured = append(s.Unsecured, addrs...)
+	s.setLink(link)
 	return nil
 }
 
+// setLink makes a link the session's primary one, served first.
+func (s *Session) setLink(link *config.Link) {
+	s.Link = link
+	s.Links = append([]*config.Link{link}, s.Links...)
+	s.Log = s.Log.With("link", link.Address)
+	s.Throttle.SetRate(link.RateLimit)
+}
+
+// MarkBusy marks the session's links busy until the session
+// ends, so that no other session, in this process or another,
+// serves them at the same time.  It is called once the distant
+// end has authenticated, so that a system merely claiming a
+// link's address cannot hold it busy.  Links already being
+// served by another session are dropped, and if the session's
+// `Link` is among them the first remaining link takes its place.
+func (s *Session) MarkBusy() error {
+	// Links may share an outbound spool, which we need only
+	// mark once.
+	marked := make(map[string]bool)
+	var served []*config.Link
+	for _, link := range s.Links {
+		dir := link.OutSpool.Dir()
+		ok, tried := marked[dir]
+		if !tried {
+			release, taken, err := link.OutSpool.TryBusy()
+			if err != nil {
+				s.releaseLinks()
+				return fmt.Errorf("cannot mark %v busy: %v", link.Address, err)
+			}
+			if taken {
+				s.release = append(s.release, release)
+			}
+			marked[dir], ok = taken, taken
+		}
+		if !ok {
+			s.Log.Warnf("not serving %v: busy in another session", link.Address)
+			continue
+		}
+		served = append(served, link)
+	}
+	if len(served) == 0 {
+		return ErrAllBusy
+	}
+	if served[0] != s.Link {
+		s.Link = served[0]
+		s.Throttle.SetRate(s.Link.RateLimit)
+	}
+	s.Links = served
+	return nil
+}
+
+// releaseLinks clears the busy marks taken by MarkBusy.
+func (s *Session) releaseLinks() {
+	for _, release := range s.release {
+		release()
+	}
+	s.release = nil
+}
+
 // Wait waits for the session to end.
 func (s *Session) Wait() error {
 	return s.waiter.Wait()
@@ -0,0 +1,228 @@
+package session
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"fat-dragon.org/ginko/config"
+	"fat-dragon.org/ginko/frame"
+	"fat-dragon.org/ginko/ftn"
+	"fat-dragon.org/ginko/metrics"
+)
+
+// FileStats records the transfer of a single file in either
+// direction.
+type FileStats struct {
+	FileName string
+	Size     int64
+	Offset   int64  // Offset at which the transfer started.
+	Bytes    int64  // Bytes actually transferred.
+	CRC      uint32 // CRC-32 of the data; meaningful only when transferred in full.
+	Start    time.Time
+	End      time.Time
+	Complete bool
+}
+
+// Elapsed returns the time spent transferring the file.
+func (f *FileStats) Elapsed() time.Duration {
+	return f.End.Sub(f.Start)
+}
+
+// CPS returns the transfer rate in characters (bytes) per
+// second.
+func (f *FileStats) CPS() int64 {
+	return cps(f.Bytes, f.Elapsed())
+}
+
+func (f FileStats) String() string {
+	status := "complete"
+	if !f.Complete {
+		status = "incomplete"
+	}
+	return fmt.Sprintf("%q: %d of %d bytes from offset %d in %v (%d cps), %s",
+		f.FileName, f.Bytes, f.Size, f.Offset, f.Elapsed().Round(time.Millisecond), f.CPS(), status)
+}
+
+func cps(bytes int64, elapsed time.Duration) int64 {
+	if elapsed <= 0 {
+		return bytes
+	}
+	return int64(float64(bytes) / elapsed.Seconds())
+}
+
+// SessionResult summarizes a session once it has ended: who it
+// was with, how long authentication took, what was transferred
+// in each direction, and how it ended.
+type SessionResult struct {
+	Role        Role
+	RemoteAddr  string        // Network address of the distant end.
+	RemoteAddrs []ftn.Address // FTN addresses presented by the distant end.
+	Unsecured   []ftn.Address // Presented addresses that are not links.
+	Remote      RemoteInfo    // What the distant end told us about itself.
+	Link        *config.Link  // Nil if the distant end was not linked.
+	Start       time.Time
+	End         time.Time
+	AuthTime    time.Duration // Zero if authentication never completed.
+	Sent        []FileStats
+	Received    []FileStats
+	Err         error
+}
+
+// FilesSent returns the number of files completely sent.
+func (r *SessionResult) FilesSent() int {
+	return completeFiles(r.Sent)
+}
+
+// FilesReceived returns the number of files completely
+// received.
+func (r *SessionResult) FilesReceived() int {
+	return completeFiles(r.Received)
+}
+
+// BytesSent returns the total bytes sent in file data.
+func (r *SessionResult) BytesSent() int64 {
+	return totalBytes(r.Sent)
+}
+
+// BytesReceived returns the total bytes received in file data.
+func (r *SessionResult) BytesReceived() int64 {
+	return totalBytes(r.Received)
+}
+
+// Elapsed returns the duration of the session.
+func (r *SessionResult) Elapsed() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+// Authenticated reports whether the session got as far as
+// authenticating the distant end.
+func (r *SessionResult) Authenticated() bool {
+	return r.AuthTime > 0
+}
+
+func (r *SessionResult) String() string {
+	var b strings.Builder
+	result := "successful"
+	if r.Err != nil {
+		result = "failed: " + r.Err.Error()
+	}
+	fmt.Fprintf(&b, "%v session with %v %s after %v", r.Role, r.RemoteAddrs, result, r.Elapsed().Round(time.Millisecond))
+	if r.Authenticated() {
+		fmt.Fprintf(&b, " (auth %v)", r.AuthTime.Round(time.Millisecond))
+	}
+	fmt.Fprintf(&b, "; sent %d files, %d bytes; received %d files, %d bytes",
+		r.FilesSent(), r.BytesSent(), r.FilesReceived(), r.BytesReceived())
+	return b.String()
+}
+
+func completeFiles(files []FileStats) int {
+	n := 0
+	for _, f := range files {
+		if f.Complete {
+			n++
+		}
+	}
+	return n
+}
+
+func totalBytes(files []FileStats) int64 {
+	var n int64
+	for _, f := range files {
+		n += f.Bytes
+	}
+	return n
+}
+
+// stats accumulates a SessionResult while the session runs.
+// The transmitter and receiver run concurrently, so access is
+// serialized.
+type stats struct {
+	sync.Mutex
+	result SessionResult
+}
+
+// RecordSent records the transfer of a file to the distant end.
+func (s *Session) RecordSent(f FileStats) {
+	s.stats.Lock()
+	defer s.stats.Unlock()
+	s.stats.result.Sent = append(s.stats.result.Sent, f)
+}
+
+// RecordReceived records the transfer of a file from the
+// distant end.
+func (s *Session) RecordReceived(f FileStats) {
+	s.stats.Lock()
+	defer s.stats.Unlock()
+	s.stats.result.Received = append(s.stats.result.Received, f)
+}
+
+// NoteRemote records the contents of an M_NUL or OPT frame
+// received from the distant end.
+func (s *Session) NoteRemote(f frame.Frame) {
+	s.stats.Lock()
+	defer s.stats.Unlock()
+	s.stats.result.Remote.Update(f)
+}
+
+// Remote returns a copy of what the distant end has told us
+// about itself so far.
+func (s *Session) Remote() RemoteInfo {
+	s.stats.Lock()
+	defer s.stats.Unlock()
+	return s.stats.result.Remote.clone()
+}
+
+// Authenticated marks the point at which authentication of the
+// distant end completed.
+func (s *Session) Authenticated() {
+	s.stats.Lock()
+	defer s.stats.Unlock()
+	s.stats.result.AuthTime = time.Since(s.stats.result.Start)
+}
+
+// result finalizes and returns the session's result.
+func (s *Session) result(err error) *SessionResult {
+	s.stats.Lock()
+	defer s.stats.Unlock()
+	result := s.stats.result
+	result.Remote = result.Remote.clone()
+	result.Role = s.Role
+	result.RemoteAddrs = s.RemoteAddrs
+	result.Unsecured = s.Unsecured
+	result.Link = s.Link
+	result.End = time.Now()
+	result.Err = err
+	return &result
+}
+
+// Outcome classifies the result as "success", "auth_failure" or
+// "failure".
+func (r *SessionResult) Outcome() string {
+	switch {
+	case r.Err == nil:
+		return "success"
+	case r.Link != nil && !r.Authenticated():
+		return "auth_failure"
+	default:
+		return "failure"
+	}
+}
+
+// exportMetrics adds the result to the process's metrics.
+func (r *SessionResult) exportMetrics() {
+	outcome := r.Outcome()
+	metrics.Sessions.Inc(r.Role.String(), outcome)
+	if r.Link == nil {
+		return
+	}
+	link := r.Link.Address.String()
+	if outcome == "auth_failure" {
+		metrics.AuthFailures.Inc(link)
+	}
+	metrics.BytesSent.Add(float64(r.BytesSent()), link)
+	metrics.BytesReceived.Add(float64(r.BytesReceived()), link)
+	metrics.FilesSent.Add(float64(r.FilesSent()), link)
+	metrics.FilesReceived.Add(float64(r.FilesReceived()), link)
+}
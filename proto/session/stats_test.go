@@ -0,0 +1,38 @@
+package session
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileStatsCPS(t *testing.T) {
+	start := time.Unix(1000, 0)
+	f := FileStats{FileName: "a.pkt", Size: 4096, Bytes: 4096, Start: start, End: start.Add(2 * time.Second), Complete: true}
+	if cps := f.CPS(); cps != 2048 {
+		t.Errorf("CPS: got %d want 2048", cps)
+	}
+	f.End = f.Start
+	if cps := f.CPS(); cps != 4096 {
+		t.Errorf("CPS for instantaneous transfer: got %d want 4096", cps)
+	}
+}
+
+func TestSessionResultTotals(t *testing.T) {
+	s := &Session{Role: Receiver, stats: &stats{result: SessionResult{Start: time.Now()}}}
+	s.RecordSent(FileStats{FileName: "a.su0", Bytes: 100, Complete: true})
+	s.RecordSent(FileStats{FileName: "b.su0", Bytes: 50})
+	s.RecordReceived(FileStats{FileName: "c.pkt", Bytes: 10, Complete: true})
+	s.Authenticated()
+	result := s.result(errors.New("boom"))
+	if result.FilesSent() != 1 || result.BytesSent() != 150 {
+		t.Errorf("sent: got %d files, %d bytes", result.FilesSent(), result.BytesSent())
+	}
+	if result.FilesReceived() != 1 || result.BytesReceived() != 10 {
+		t.Errorf("received: got %d files, %d bytes", result.FilesReceived(), result.BytesReceived())
+	}
+	if !strings.HasPrefix(result.String(), "receiver session with [] failed: boom") {
+		t.Errorf("unexpected summary %q", result)
+	}
+}
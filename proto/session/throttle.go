@@ -0,0 +1,119 @@
+package session
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Throttle is a token bucket that limits the rate at which
+// bytes are sent on a session.  The bucket holds at most one
+// second's worth of tokens.  A rate of zero, the default,
+// means the session is not throttled.
+type Throttle struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+// minChunkSize is the smallest unit in which a throttled
+// writer sends data.
+const minChunkSize = 256
+
+// SetRate sets the throttle's rate in bytes per second.
+func (t *Throttle) SetRate(bytesPerSec int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rate = bytesPerSec
+	t.tokens = 0
+	t.last = time.Now()
+}
+
+// Rate returns the throttle's rate in bytes per second, or 0
+// if the throttle is unlimited.
+func (t *Throttle) Rate() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rate
+}
+
+// ChunkSize returns the largest amount of data that should be
+// sent at once through the throttle, capped at max.  Chunks
+// are sized to about a quarter second of traffic, so that slow
+// links see a steady trickle rather than long bursts.
+func (t *Throttle) ChunkSize(max int) int {
+	rate := t.Rate()
+	if rate == 0 {
+		return max
+	}
+	chunk := rate / 4
+	if chunk < minChunkSize {
+		chunk = minChunkSize
+	}
+	if chunk > int64(max) {
+		return max
+	}
+	return int(chunk)
+}
+
+// Wait blocks until n bytes may be sent, or the context is
+// done.  Requests larger than the bucket put it into debt,
+// which later callers pay off.
+func (t *Throttle) Wait(ctx context.Context, n int) error {
+	t.mu.Lock()
+	if t.rate == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	t.tokens += now.Sub(t.last).Seconds() * float64(t.rate)
+	if t.tokens > float64(t.rate) {
+		t.tokens = float64(t.rate)
+	}
+	t.last = now
+	t.tokens -= float64(n)
+	var delay time.Duration
+	if t.tokens < 0 {
+		delay = time.Duration(-t.tokens / float64(t.rate) * float64(time.Second))
+	}
+	t.mu.Unlock()
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// throttledWriter passes writes through a Throttle, splitting
+// them into chunks so that buffered writes are not sent in a
+// single burst.
+type throttledWriter struct {
+	ctx      context.Context
+	throttle *Throttle
+	w        io.Writer
+}
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := tw.throttle.ChunkSize(len(p))
+		if err := tw.throttle.Wait(tw.ctx, n); err != nil {
+			return written, err
+		}
+		nb, err := tw.w.Write(p[:n])
+		written += nb
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
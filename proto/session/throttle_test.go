@@ -0,0 +1,48 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestThrottleUnlimited(t *testing.T) {
+	var throttle Throttle
+	if n := throttle.ChunkSize(32767); n != 32767 {
+		t.Errorf("unlimited chunk size: got %d", n)
+	}
+	start := time.Now()
+	if err := throttle.Wait(context.Background(), 1<<30); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 50*time.Millisecond {
+		t.Error("unlimited throttle delayed")
+	}
+}
+
+func TestThrottleRate(t *testing.T) {
+	var throttle Throttle
+	throttle.SetRate(10000)
+	if n := throttle.ChunkSize(32767); n != 2500 {
+		t.Errorf("chunk size: got %d want 2500", n)
+	}
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := throttle.Wait(context.Background(), 500); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("2000 bytes at 10000 B/s took only %v", elapsed)
+	}
+}
+
+func TestThrottleCancel(t *testing.T) {
+	var throttle Throttle
+	throttle.SetRate(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := throttle.Wait(ctx, 1000); err == nil {
+		t.Error("expected cancelled wait to fail")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net"
 
 	"fat-dragon.org/ginko/frame"
+	"fat-dragon.org/ginko/logging"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -15,19 +16,23 @@ import (
 // chan of ErrorCmds for dispatching an error frame to the distant
 // end.
 //
+// Everything written passes through the given throttle, and
+// every frame written is logged at debug level.
+//
 // Returns a read chan of frames, as well as an error chan and
 // possibly an error for detecting problems at startup.
-func makeFrameWriter(ctx context.Context, waiter *errgroup.Group, conn net.Conn, urgentErr chan frame.Terminal) chan frame.Frame {
+func makeFrameWriter(ctx context.Context, waiter *errgroup.Group, conn net.Conn, throttle *Throttle, urgentErr chan frame.Terminal, log *logging.Logger) chan frame.Frame {
 	frames := make(chan frame.Frame, 16)
 	waiter.Go(func() error {
 		const writeBufferSize = (32767 + 2) * 4
-		writer := bufio.NewWriterSize(conn, writeBufferSize)
+		writer := bufio.NewWriterSize(&throttledWriter{ctx, throttle, conn}, writeBufferSize)
 		for {
 			select {
 			case errorFrame, ok := <-urgentErr:
 				if !ok || ctx.Err() != nil {
 					return nil
 				}
+				log.Debug("sending:", errorFrame)
 				if err := errorFrame.WriteBytes(writer); err != nil {
 					return fmt.Errorf("Error writing frame: %v", err)
 				}
@@ -40,7 +45,10 @@ func makeFrameWriter(ctx context.Context, waiter *errgroup.Group, conn net.Conn,
 					if err := writer.Flush(); err != nil {
 						return fmt.Errorf("Error flushing frames: %v", err)
 					}
-				} else if err := f.WriteBytes(writer); err != nil {
+					continue
+				}
+				log.Debug("sending:", f)
+				if err := f.WriteBytes(writer); err != nil {
 					return fmt.Errorf("Error writing frame: %v", err)
 				}
 			case <-ctx.Done():
@@ -4,7 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"hash/crc32"
 	"time"
 
 	"fat-dragon.org/ginko/frame"
@@ -21,6 +21,11 @@ type recvrState func(context.Context, *recvrSession) (recvrState, error)
 func runRecvr(ctx context.Context, s *session.Session) error {
 	defer close(s.RecvrDone)
 	aux := &recvrSession{s, nil}
+	defer func() {
+		if aux.request != nil {
+			s.RecordReceived(aux.request.stats(false))
+		}
+	}()
 	for state, err := waitForFile, error(nil); state != nil; {
 		state, err = state(ctx, aux)
 		if err != nil {
@@ -33,25 +38,25 @@ func runRecvr(ctx context.Context, s *session.Session) error {
 func waitForFile(ctx context.Context, s *recvrSession) (recvrState, error) {
 	select {
 	case <-ctx.Done():
-		return recvEnd(ctx, nil)
+		return recvEnd(ctx, s, nil)
 	case f, ok := <-s.RecvrFrames:
 		if ctx.Err() != nil || f == nil || !ok {
 			err := errors.New("Error waiting for FILE")
-			log.Println(err)
-			return recvEnd(ctx, err)
+			s.Log.Error(err)
+			return recvEnd(ctx, s, err)
 		}
 		switch frame := f.(type) {
 		case *frame.FileCmd:
 			return fileRecvRequested(ctx, s, NewXferDescr(frame))
 		case *frame.EOBCmd:
-			return recvEnd(ctx, nil)
+			return recvEnd(ctx, s, nil)
 		case *frame.Data:
-			log.Println("received:", frame)
+			s.Log.Warn("data received outside of a file:", frame)
 		default:
 			err := fmt.Errorf("Found a weird frame: %v", frame)
-			log.Println(err)
+			s.Log.Error(err)
 			s.SendErrorCmd(ctx, "Invalid received frame")
-			return recvEnd(ctx, err)
+			return recvEnd(ctx, s, err)
 		}
 		return waitForFile, nil
 	}
@@ -62,10 +67,10 @@ func fileRecvRequested(ctx context.Context, s *recvrSession, request *xferDescr)
 	if hasFile(request) {
 		return gotFile, nil
 	}
-	request.spool = &s.Link.InSpool
+	request.spool = s.Link.InSpool.WithLogger(s.Log)
 	spoolKey, file, err := request.spool.TempFileFor(&request.FileKey)
 	if err != nil {
-		return recvEnd(ctx, err)
+		return recvEnd(ctx, s, err)
 	}
 	request.spoolFile = file
 	request.spoolKey = spoolKey
@@ -79,28 +84,28 @@ func hasFile(_ *xferDescr) bool {
 func recvFileData(ctx context.Context, s *recvrSession) (recvrState, error) {
 	select {
 	case <-ctx.Done():
-		return recvEnd(ctx, nil)
+		return recvEnd(ctx, s, nil)
 	case f, ok := <-s.RecvrFrames:
 		if ctx.Err() != nil || f == nil || !ok {
 			err := errors.New("Error waiting for file data")
-			log.Println(err)
-			return recvEnd(ctx, err)
+			s.Log.Error(err)
+			return recvEnd(ctx, s, err)
 		}
 		switch frame := f.(type) {
 		case *frame.Data:
 			return recvDataFrame(ctx, s, frame)
 		case *frame.FileCmd:
-			log.Println("FILE received:", frame, ", previous file incomplete:", s.request)
+			s.Log.Warn("FILE received:", frame, ", previous file incomplete:", s.request)
 			s.request.abort()
+			s.RecordReceived(s.request.stats(false))
 			s.request = nil
 			return fileRecvRequested(ctx, s, NewXferDescr(frame))
 		default:
 			s.SendErrorCmd(ctx, "Invalid received frame")
 			err := fmt.Errorf("Found a weird frame: %f", frame)
-			log.Println(err)
-			return recvEnd(ctx, err)
+			s.Log.Error(err)
+			return recvEnd(ctx, s, err)
 		}
-		return waitForFile, nil
 	}
 }
 
@@ -109,16 +114,17 @@ func recvDataFrame(ctx context.Context, s *recvrSession, frame *frame.Data) (rec
 	data := frame.Data()
 	dataLen := int64(len(data))
 	if descr.offset+dataLen > descr.Size {
-		log.Printf("long write %v: %v", descr, frame)
+		s.Log.Warnf("long write %v: %v", descr, frame)
 	}
 	nb, err := descr.spoolFile.WriteAt(data, descr.offset)
 	if err != nil || nb != len(data) {
 		err := fmt.Errorf("error writing receive file %v: %v", descr.spoolFile, err)
-		log.Println(err)
+		s.Log.Error(err)
 		descr.abort()
-		return recvEnd(ctx, err)
+		return recvEnd(ctx, s, err)
 	}
 	descr.incrOffset(nb)
+	descr.crc = crc32.Update(descr.crc, crc32.IEEETable, data)
 	if !descr.xferComplete() {
 		return recvFileData, nil
 	}
@@ -129,22 +135,27 @@ func gotFile(ctx context.Context, s *recvrSession) (recvrState, error) {
 	descr := s.request
 	s.request = nil
 	if err := descr.publish(); err != nil {
+		s.RecordReceived(descr.stats(false))
 		err := fmt.Errorf("spool publish error: %v", err)
-		log.Println(err)
+		s.Log.Error(err)
 		return recvError(ctx, s, err)
 	}
 	if !s.WriteSyncFrame(ctx, frame.NewGot(descr.FileName, descr.Size, time.Unix(descr.TimeStamp, 0))) {
-		return recvEnd(ctx, errors.New("Error writing GOT frame"))
+		s.RecordReceived(descr.stats(false))
+		return recvEnd(ctx, s, errors.New("Error writing GOT frame"))
 	}
+	stats := descr.stats(true)
+	s.Log.Info("received file", stats)
+	s.RecordReceived(stats)
 	return waitForFile, nil
 }
 
 func recvError(ctx context.Context, s *recvrSession, err error) (recvrState, error) {
 	s.SendErrorCmd(ctx, "internal server error")
-	return recvEnd(ctx, errors.New("internal server error"))
+	return recvEnd(ctx, s, errors.New("internal server error"))
 }
 
-func recvEnd(_ context.Context, err error) (recvrState, error) {
-	log.Println("Transfer: receiver exiting")
+func recvEnd(_ context.Context, s *recvrSession, err error) (recvrState, error) {
+	s.Log.Debug("Transfer: receiver exiting")
 	return nil, err
 }
@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 
 	"fat-dragon.org/ginko/frame"
 	"fat-dragon.org/ginko/proto/session"
@@ -27,12 +26,12 @@ func router(ctx context.Context, s *session.Session) (session.State, error) {
 	var ok bool
 	select {
 	case <-ctx.Done():
-		return routerEnd(nil)
+		return routerEnd(s, nil)
 	case f, ok = <-s.ReadFrames:
 		if ctx.Err() != nil || f == nil || !ok {
 			err := errors.New("Router: error waiting for frame")
-			log.Println(err)
-			return routerEnd(err)
+			s.Log.Error(err)
+			return routerEnd(s, err)
 		}
 	case <-s.RecvrDone:
 		return routeXmitr, nil
@@ -41,36 +40,32 @@ func router(ctx context.Context, s *session.Session) (session.State, error) {
 	}
 	switch frame := f.(type) {
 	case *frame.FileCmd:
-		log.Println("received:", frame)
 		s.RecvrFrames <- frame
 	case *frame.BusyCmd:
-		log.Println("received:", frame)
+		s.Log.Warn("received:", frame)
 	case *frame.ErrorCmd:
-		log.Println("received:", frame)
+		s.Log.Warn("received:", frame)
 	case *frame.EOBCmd:
-		log.Println("received:", frame)
 		s.RecvrFrames <- frame
 	case *frame.Data:
-		log.Println("received:", frame)
 		s.RecvrFrames <- frame
 	case *frame.NullCmd:
-		log.Println("received:", frame)
+		s.Log.Info("received:", frame)
+		s.NoteRemote(frame)
 	case *frame.OptCmd:
-		log.Println("received:", frame)
+		s.Log.Info("received:", frame)
+		s.NoteRemote(frame)
 	case *frame.GetCmd:
-		log.Println("received:", frame)
 		s.XmitrFrames <- frame
 	case *frame.GotCmd:
-		log.Println("received:", frame)
 		s.XmitrFrames <- frame
 	case *frame.SkipCmd:
-		log.Println("received:", frame)
 		s.XmitrFrames <- frame
 	default:
 		err := fmt.Errorf("Found a weird frame: %v", frame)
-		log.Println(err)
+		s.Log.Error(err)
 		s.SendErrorCmd(ctx, "Invalid received frame")
-		return routerEnd(err)
+		return routerEnd(s, err)
 	}
 	return router, nil
 }
@@ -80,41 +75,40 @@ func routeXmitr(ctx context.Context, s *session.Session) (session.State, error)
 	var ok bool
 	select {
 	case <-ctx.Done():
-		return routerEnd(nil)
+		return routerEnd(s, nil)
 	case f, ok = <-s.ReadFrames:
 		if ctx.Err() != nil || f == nil || !ok {
 			err := errors.New("Router: error waiting for frame")
-			log.Println(err)
-			return routerEnd(err)
+			s.Log.Error(err)
+			return routerEnd(s, err)
 		}
 	case <-s.XmitrDone:
-		return routerEnd(nil)
+		return routerEnd(s, nil)
 	}
 	switch frame := f.(type) {
 	case *frame.NullCmd:
-		log.Println("received:", frame)
+		s.Log.Info("received:", frame)
+		s.NoteRemote(frame)
 	case *frame.OptCmd:
-		log.Println("received:", frame)
+		s.Log.Info("received:", frame)
+		s.NoteRemote(frame)
 	case *frame.BusyCmd:
-		log.Println("received:", frame)
-		return routerEnd(nil)
+		s.Log.Warn("received:", frame)
+		return routerEnd(s, nil)
 	case *frame.ErrorCmd:
-		log.Println("received:", frame)
-		return routerEnd(nil)
+		s.Log.Warn("received:", frame)
+		return routerEnd(s, nil)
 	case *frame.GetCmd:
-		log.Println("received:", frame)
 		s.XmitrFrames <- frame
 	case *frame.GotCmd:
-		log.Println("received:", frame)
 		s.XmitrFrames <- frame
 	case *frame.SkipCmd:
-		log.Println("received:", frame)
 		s.XmitrFrames <- frame
 	default:
 		err := fmt.Errorf("Found a weird frame: %v", frame)
-		log.Println(err)
+		s.Log.Error(err)
 		s.SendErrorCmd(ctx, "Invalid received frame")
-		return routerEnd(err)
+		return routerEnd(s, err)
 	}
 	return routeXmitr, nil
 }
@@ -124,46 +118,45 @@ func routeRecvr(ctx context.Context, s *session.Session) (session.State, error)
 	var ok bool
 	select {
 	case <-ctx.Done():
-		return routerEnd(nil)
+		return routerEnd(s, nil)
 	case f, ok = <-s.ReadFrames:
 		if ctx.Err() != nil || f == nil || !ok {
 			err := errors.New("Router: error waiting for frame")
-			log.Println(err)
-			return routerEnd(err)
+			s.Log.Error(err)
+			return routerEnd(s, err)
 		}
 	case <-s.RecvrDone:
-		return routerEnd(nil)
+		return routerEnd(s, nil)
 	}
 	switch frame := f.(type) {
 	case *frame.NullCmd:
-		log.Println("received:", frame)
+		s.Log.Info("received:", frame)
+		s.NoteRemote(frame)
 	case *frame.OptCmd:
-		log.Println("received:", frame)
+		s.Log.Info("received:", frame)
+		s.NoteRemote(frame)
 	case *frame.BusyCmd:
-		log.Println("received:", frame)
-		return routerEnd(nil)
+		s.Log.Warn("received:", frame)
+		return routerEnd(s, nil)
 	case *frame.ErrorCmd:
-		log.Println("received:", frame)
-		return routerEnd(nil)
+		s.Log.Warn("received:", frame)
+		return routerEnd(s, nil)
 	case *frame.FileCmd:
-		log.Println("received:", frame)
 		s.RecvrFrames <- frame
 	case *frame.EOBCmd:
-		log.Println("received:", frame)
 		s.RecvrFrames <- frame
 	case *frame.Data:
-		log.Println("received:", frame)
 		s.RecvrFrames <- frame
 	default:
 		err := fmt.Errorf("Found a weird frame: %v", frame)
-		log.Println(err)
+		s.Log.Error(err)
 		s.SendErrorCmd(ctx, "Invalid received frame")
-		return routerEnd(err)
+		return routerEnd(s, err)
 	}
 	return routeRecvr, nil
 }
 
-func routerEnd(err error) (session.State, error) {
-	log.Println("Frame router exiting")
+func routerEnd(s *session.Session, err error) (session.State, error) {
+	s.Log.Debug("Frame router exiting")
 	return nil, err
 }
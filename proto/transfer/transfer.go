@@ -31,10 +31,13 @@ func Start(ctx context.Context, s *session.Session) (session.State, error) {
 
 type xferDescr struct {
 	spool.FileKey
-	offset    int64
-	spool     *spool.Spool
-	spoolKey  *spool.SpoolKey
-	spoolFile *os.File
+	offset      int64
+	spool       *spool.Spool
+	spoolKey    *spool.SpoolKey
+	spoolFile   *os.File
+	startOffset int64
+	started     time.Time
+	crc         uint32
 }
 
 func (d xferDescr) String() string {
@@ -97,7 +100,21 @@ func (d *xferDescr) incrOffset(n int) {
 	d.offset += int64(n)
 }
 
+// stats returns statistics for the transfer so far.
+func (d *xferDescr) stats(complete bool) session.FileStats {
+	return session.FileStats{
+		FileName: d.FileName,
+		Size:     d.Size,
+		Offset:   d.startOffset,
+		Bytes:    d.offset - d.startOffset,
+		CRC:      d.crc,
+		Start:    d.started,
+		End:      time.Now(),
+		Complete: complete,
+	}
+}
+
 func NewXferDescr(fileCmd *frame.FileCmd) *xferDescr {
 	fileKey := spool.NewFileKey(fileCmd.FileName, fileCmd.Size, fileCmd.TimeStamp)
-	return &xferDescr{fileKey, fileCmd.Offset, nil, nil, nil}
+	return &xferDescr{fileKey, fileCmd.Offset, nil, nil, nil, fileCmd.Offset, time.Now(), 0}
 }
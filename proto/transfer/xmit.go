@@ -4,9 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
-	"log"
+	"sort"
+	"time"
 
+	"fat-dragon.org/ginko/config"
 	"fat-dragon.org/ginko/frame"
 	"fat-dragon.org/ginko/proto/session"
 	"fat-dragon.org/ginko/spool"
@@ -18,61 +21,142 @@ const (
 	queuePending queueStatus = iota
 	queueSkipped
 	queueDone
+	queueHeld // on hold, or outside its schedule window
 )
 
 type xmitrSession struct {
 	*session.Session
-	spool   *spool.Spool
-	lookup  map[spool.FileKey]*queueEntry
-	queue   []*spool.SpoolKey
-	active  []*xferDescr
-	pending int
-	request *xferDescr
+	outbound []*outbound
+	lookup   map[spool.FileKey]*queueEntry
+	active   []*xferDescr
+	pending  int
+	request  *xferDescr
+}
+
+// outbound is the queue of one of the links served by the
+// session.
+type outbound struct {
+	link  *config.Link
+	spool *spool.Spool
+	queue []*spool.SpoolKey
 }
 
 type queueEntry struct {
 	spoolKey *spool.SpoolKey
+	out      *outbound
 	status   queueStatus
+	stats    *session.FileStats // Set while a transfer is in progress.
 }
 
-func makeXmitrSession(outSpool *spool.Spool, s *session.Session) *xmitrSession {
+func makeXmitrSession(s *session.Session) *xmitrSession {
+	links := s.Links
+	if len(links) == 0 {
+		links = []*config.Link{s.Link}
+	}
+	var outbounds []*outbound
+	seen := make(map[string]bool)
+	for _, link := range links {
+		if dir := link.OutSpool.Dir(); !seen[dir] {
+			seen[dir] = true
+			outbounds = append(outbounds, &outbound{link, link.OutSpool.WithLogger(s.Log), nil})
+		}
+	}
 	return &xmitrSession{
 		s,
-		outSpool,
+		outbounds,
 		make(map[spool.FileKey]*queueEntry),
 		nil,
-		nil,
 		0,
 		nil,
 	}
 }
+
+// loadQueue loads the outbound queues of every link served by
+// the session.
 func (s *xmitrSession) loadQueue() error {
-	if err := s.spool.ConsumeAndConcatQueues("new", "Queue", "cur", "Queue"); err != nil {
+	now := time.Now()
+	for _, out := range s.outbound {
+		if err := s.loadOutbound(out, now); err != nil {
+			return err
+		}
+	}
+	s.pending = len(s.active)
+	s.sortActive()
+	return nil
+}
+
+func (s *xmitrSession) loadOutbound(out *outbound, now time.Time) error {
+	if err := out.spool.ConsumeAndConcatQueues("new", "Queue", "cur", "Queue"); err != nil {
 		return err
 	}
-	queue, err := s.spool.ReadQueue("cur", "Queue")
+	queue, err := out.spool.ReadQueue("cur", "Queue")
 	if err != nil {
 		return err
 	}
-	s.pending = len(queue)
-	s.queue = make([]*spool.SpoolKey, len(queue))
-	s.active = make([]*xferDescr, len(queue))
+	out.queue = make([]*spool.SpoolKey, len(queue))
 	for i, entry := range queue {
 		entry := entry
-		s.queue[i] = &entry
-		s.lookup[entry.ToFileKey()] = &queueEntry{&entry, queuePending}
-		s.active[i] = s.xferDescrFromSpoolKey(&entry, 0)
+		out.queue[i] = &entry
+		if _, dup := s.lookup[entry.ToFileKey()]; dup {
+			// The same file is queued for another of the
+			// distant end's AKAs.  It stays queued here, to
+			// be sent in a later session.
+			s.Log.Warn("Deferring duplicate queue entry", entry.ToFileKey())
+			continue
+		}
+		// Held files stay in the queue, but are only offered
+		// when the distant end has polled us.  Likewise, files
+		// outside the link's schedule windows wait for the
+		// window to open.
+		if !s.offerable(out.link, &entry, now) {
+			s.lookup[entry.ToFileKey()] = &queueEntry{&entry, out, queueHeld, nil}
+			continue
+		}
+		s.lookup[entry.ToFileKey()] = &queueEntry{&entry, out, queuePending, nil}
+		s.active = append(s.active, xferDescrFromSpoolKey(out.spool, &entry, 0))
 	}
 	return nil
 }
 
-func (q *xmitrSession) xferDescrFromSpoolKey(key *spool.SpoolKey, offset int64) *xferDescr {
+// offerable reports whether a queued file may be offered to the
+// distant end now.  Immediate files ignore schedule windows.
+func (s *xmitrSession) offerable(link *config.Link, key *spool.SpoolKey, now time.Time) bool {
+	if key.Held() && s.Role != session.Receiver {
+		return false
+	}
+	if key.Flavor == spool.FlavorImmediate {
+		return true
+	}
+	return link.Permits(key.EffectiveClass(), now)
+}
+
+// sortActive orders the active list for transmission: by flavor,
+// then by traffic class, and then optionally by size.  The sort
+// is stable, so queue order breaks any remaining ties.
+func (s *xmitrSession) sortActive() {
+	smallestFirst := s.Link.SmallestFirst
+	sort.SliceStable(s.active, func(i, j int) bool {
+		a, b := s.active[i].spoolKey, s.active[j].spoolKey
+		if a.Precedes(b) {
+			return true
+		}
+		if b.Precedes(a) {
+			return false
+		}
+		return smallestFirst && a.FileKey.Size < b.FileKey.Size
+	})
+}
+
+func xferDescrFromSpoolKey(outSpool *spool.Spool, key *spool.SpoolKey, offset int64) *xferDescr {
 	return &xferDescr{
 		key.ToFileKey(),
 		offset,
-		q.spool,
+		outSpool,
 		key,
 		nil,
+		offset,
+		time.Time{},
+		0,
 	}
 }
 
@@ -92,43 +176,88 @@ func makeKeyFromQueueingFrame(f frame.Queueing) *spool.FileKey {
 }
 
 func (s *xmitrSession) get(key *spool.FileKey, offset int64) {
-	log.Println("remote GET:", *key, "offset:", offset)
+	s.Log.Info("remote GET:", *key, "offset:", offset)
 	qEntry, ok := s.lookup[*key]
 	if !ok {
-		log.Println("Queue entry not found for", *key)
+		s.Log.Warn("Queue entry not found for", *key)
 		return
 	}
+	if qEntry.status == queueHeld {
+		// An explicit request for a held file releases it.
+		s.pending++
+	}
+	s.finishStats(qEntry, false)
 	qEntry.status = queuePending
 	s.removeFromActive(key)
-	s.active = append(s.active, s.xferDescrFromSpoolKey(qEntry.spoolKey, offset))
+	s.active = append(s.active, xferDescrFromSpoolKey(qEntry.out.spool, qEntry.spoolKey, offset))
 	s.request = s.active[0]
 }
 
 func (q *xmitrSession) got(key *spool.FileKey) {
-	log.Println("remote GOT:", *key)
+	q.Log.Info("remote GOT:", *key)
 	qEntry, ok := q.lookup[*key]
 	if !ok {
-		log.Println("Queue entry not found for", *key)
+		q.Log.Warn("Queue entry not found for", *key)
 		return
 	}
+	if qEntry.status != queueHeld {
+		q.pending--
+	}
+	q.finishStats(qEntry, true)
 	qEntry.status = queueDone
-	q.spool.Remove("cur", qEntry.spoolKey)
+	qEntry.out.spool.Remove("cur", qEntry.spoolKey)
 	q.removeFromActive(key)
-	q.pending--
 }
 
 func (q *xmitrSession) skip(key *spool.FileKey) {
-	log.Println("remote SKIP:", *key)
+	q.Log.Info("remote SKIP:", *key)
 	qEntry, ok := q.lookup[*key]
 	if !ok {
-		log.Println("Queue entry not found for", *key)
+		q.Log.Warn("Queue entry not found for", *key)
+		return
+	}
+	if qEntry.status == queueHeld {
 		return
 	}
+	q.finishStats(qEntry, false)
 	qEntry.status = queueSkipped
 	q.removeFromActive(key)
 	q.pending--
 }
 
+// startStats begins collecting statistics for the transfer of
+// the current request.
+func (s *xmitrSession) startStats() *session.FileStats {
+	qEntry, ok := s.lookup[s.request.FileKey]
+	if !ok {
+		return nil
+	}
+	s.finishStats(qEntry, false)
+	qEntry.stats = &session.FileStats{
+		FileName: s.request.FileName,
+		Size:     s.request.Size,
+		Offset:   s.request.offset,
+		Start:    time.Now(),
+	}
+	return qEntry.stats
+}
+
+// finishStats records the statistics for a queue entry's
+// transfer, if one is in progress.
+func (s *xmitrSession) finishStats(qEntry *queueEntry, complete bool) {
+	if qEntry.stats == nil {
+		return
+	}
+	stats := qEntry.stats
+	qEntry.stats = nil
+	stats.End = time.Now()
+	stats.Complete = complete
+	if complete {
+		s.Log.Info("sent file", *stats)
+	}
+	s.RecordSent(*stats)
+}
+
 func (q *xmitrSession) removeFromActive(key *spool.FileKey) {
 	newActive := make([]*xferDescr, 0)
 	for _, entry := range q.active {
@@ -141,22 +270,28 @@ func (q *xmitrSession) removeFromActive(key *spool.FileKey) {
 }
 
 func (q *xmitrSession) put() error {
-	newQueue := []spool.SpoolKey{}
-	for _, v := range q.queue {
-		qEntry, ok := q.lookup[v.FileKey]
-		if !ok || qEntry.status == queueDone {
-			continue
+	var firstErr error
+	for _, out := range q.outbound {
+		newQueue := []spool.SpoolKey{}
+		for _, v := range out.queue {
+			qEntry, ok := q.lookup[v.FileKey]
+			if ok && qEntry.out == out && qEntry.status == queueDone {
+				continue
+			}
+			newQueue = append(newQueue, *v)
+		}
+		if err := out.spool.SaveQueue("cur", "Queue", newQueue); err != nil && firstErr == nil {
+			firstErr = err
 		}
-		newQueue = append(newQueue, *v)
 	}
-	return q.spool.SaveQueue("cur", "Queue", newQueue)
+	return firstErr
 }
 
 type xmitrState func(context.Context, *xmitrSession) (xmitrState, error)
 
 func runXmitr(ctx context.Context, s *session.Session) error {
 	defer close(s.XmitrDone)
-	aux := makeXmitrSession(&s.Link.OutSpool, s)
+	aux := makeXmitrSession(s)
 	for state, err := startXmitr, error(nil); state != nil; {
 		state, err = state(ctx, aux)
 		if err != nil {
@@ -213,7 +348,6 @@ func xmitWaitForRequest(ctx context.Context, s *xmitrSession) (xmitrState, error
 		}
 		return xmitSend, nil
 	}
-	return xmitEnd, nil
 }
 
 func xmitSend(ctx context.Context, s *xmitrSession) (xmitrState, error) {
@@ -230,13 +364,14 @@ func xmitSend(ctx context.Context, s *xmitrSession) (xmitrState, error) {
 		return xmitSendNextRequest, nil
 	}
 	fileCmd := s.request.fileCmd()
-	log.Println("sending:", fileCmd)
+	s.Log.Info("sending:", fileCmd)
 	if !s.WriteFrame(ctx, s.request.fileCmd()) {
 		return xmitSendNextRequest, nil
 	}
+	stats := s.startStats()
 	for !s.request.xferComplete() {
 		select {
-		case <- ctx.Done():
+		case <-ctx.Done():
 			return xmitEnd, nil
 		case f, ok := <-s.XmitrFrames:
 			if ctx.Err() != nil || f == nil || !ok {
@@ -257,12 +392,16 @@ func xmitSend(ctx context.Context, s *xmitrSession) (xmitrState, error) {
 				return xmitSendNextRequest, nil
 			}
 		default:
-			dataFrame, err := frame.ReadDataFrameFrom(s.request.spoolFile, s.request.offset)
+			chunkSize := s.Throttle.ChunkSize(frame.MaxFrameSize)
+			dataFrame, err := frame.ReadDataFrameSize(s.request.spoolFile, chunkSize)
 			if err != nil && err != io.EOF {
 				return xmitEnd, fmt.Errorf("read error: %v", err)
 			}
-			log.Println("sending:", dataFrame.String())
 			s.request.incrOffset(dataFrame.Length())
+			if stats != nil {
+				stats.Bytes += int64(dataFrame.Length())
+				stats.CRC = crc32.Update(stats.CRC, crc32.IEEETable, dataFrame.Data())
+			}
 			if !s.WriteFrame(ctx, dataFrame) {
 				break
 			}
@@ -273,8 +412,11 @@ func xmitSend(ctx context.Context, s *xmitrSession) (xmitrState, error) {
 }
 
 func xmitEnd(ctx context.Context, s *xmitrSession) (xmitrState, error) {
+	for _, qEntry := range s.lookup {
+		s.finishStats(qEntry, false)
+	}
 	s.WriteSyncFrame(ctx, frame.NewEOB())
 	s.put()
-	log.Println("Transfer: sender exiting")
+	s.Log.Debug("Transfer: sender exiting")
 	return nil, nil
 }
@@ -1,6 +1,7 @@
 package spool
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"syscall"
@@ -17,6 +18,16 @@ func unlockFile(f *os.File) error {
 	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
 }
 
+// tryLockFile attempts to take an exclusive lock on f without
+// blocking.  It reports whether the lock was acquired.
+func tryLockFile(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 func openLocked(filename string) (*os.File, error) {
 	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0660)
 	if err != nil {
@@ -49,3 +60,26 @@ func closeMutex(f *os.File) error {
 	}
 	return closeLocked(f)
 }
+
+// syncDir flushes a directory to stable storage, making
+// any renames, links or unlinks within it durable.
+func syncDir(dirname string) error {
+	d, err := os.Open(dirname)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// exists reports whether the named file exists.
+func exists(filename string) (bool, error) {
+	_, err := os.Stat(filename)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return false, err
+}
@@ -9,49 +9,88 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
-	"os/user"
-	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
-var seqC chan uint64
-var pid int
-var hostname string
-var startSeqNo uint64
-var randData [16]byte
-var randKey [16]byte
+// nameSource generates maildir-style unique names for a
+// single spool.  Its state is initialized lazily, the first
+// time a name is needed.
+//
+// Names are unique across processes sharing the spool: each
+// process takes a distinct start sequence number from the
+// spool's `Sequence` file under an advisory lock, and names
+// additionally carry the process ID and host name.
+type nameSource struct {
+	sync.Mutex
+	started    bool
+	pid        int
+	hostname   string
+	startSeqNo uint64
+	seqNo      uint64
+	randData   [16]byte
+	randKey    [16]byte
+}
 
-func init() {
-	copy(randData[:], []byte("deadbeefcafef00d"))
-	pid = os.Getpid()
-	var err error
-	hostname, err = os.Hostname()
-	if err != nil {
-		panic(err)
+var nameSources = struct {
+	sync.Mutex
+	m map[string]*nameSource
+}{m: make(map[string]*nameSource)}
+
+// names returns the unique name source for the spool.
+func (s *Spool) names() *nameSource {
+	nameSources.Lock()
+	defer nameSources.Unlock()
+	ns, ok := nameSources.m[s.baseDir]
+	if !ok {
+		ns = &nameSource{}
+		nameSources.m[s.baseDir] = ns
 	}
-	startSeqNo, err = fetchAndIncrStartSeqNo()
+	return ns
+}
+
+// uniqueName returns a new name, unique within the spool.
+func (s *Spool) uniqueName() (string, error) {
+	ns := s.names()
+	ns.Lock()
+	defer ns.Unlock()
+	if !ns.started {
+		if err := ns.start(s.FileName("", "Sequence")); err != nil {
+			return "", fmt.Errorf("cannot generate unique name in %s: %v", s.baseDir, err)
+		}
+	}
+	seqNo := ns.seqNo
+	ns.seqNo++
+	r, err := ns.cryptoRand(seqNo)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	seqC = make(chan uint64)
-	go func() {
-		seqNo := uint64(0)
-		for {
-			seqC <- seqNo
-			seqNo++
-		}
-	}()
+	now := time.Now()
+	return fmt.Sprintf("%v.X%xR%sM%vP%dQ%v.%s",
+		now.Unix(), ns.startSeqNo, r, now.UnixNano()/1000, ns.pid, seqNo, ns.hostname), nil
 }
 
-func fetchAndIncrStartSeqNo() (uint64, error) {
-	u, err := user.Current()
+func (ns *nameSource) start(seqFileName string) error {
+	hostname, err := os.Hostname()
 	if err != nil {
-		return 0, err
+		return err
 	}
-	startSeqFileName := path.Join(u.HomeDir, ".ginkoseq")
-	f, err := openLocked(startSeqFileName)
+	startSeqNo, err := fetchAndIncrStartSeqNo(seqFileName)
+	if err != nil {
+		return err
+	}
+	copy(ns.randData[:], []byte("deadbeefcafef00d"))
+	ns.pid = os.Getpid()
+	ns.hostname = strings.ReplaceAll(hostname, "/", "\\057")
+	ns.startSeqNo = startSeqNo
+	ns.started = true
+	return nil
+}
+
+func fetchAndIncrStartSeqNo(seqFileName string) (uint64, error) {
+	f, err := openLocked(seqFileName)
 	if err != nil {
 		return 0, err
 	}
@@ -79,24 +118,21 @@ func fetchAndIncrStartSeqNo() (uint64, error) {
 	if _, err := fmt.Fprintf(f, "%v\n", seq); err != nil {
 		return seq, err
 	}
+	if err := f.Sync(); err != nil {
+		return seq, err
+	}
 	return seq, nil
 }
 
-func uniqueName() string {
-	seqNo := <-seqC
-	r, _ := cryptoRand(seqNo)
-	now := time.Now()
-	return fmt.Sprintf("%v.X%xR%sM%vP%dQ%v.%s",
-		now.Unix(), startSeqNo, r, now.UnixNano()/1000, pid, seqNo, hostname)
-}
-
-func cryptoRand(seqNo uint64) (string, error) {
+func (ns *nameSource) cryptoRand(seqNo uint64) (string, error) {
 	// Reseed the HMAC every 1000 iterations.
 	if seqNo%1000 == 0 {
-		rand.Read(randKey[:])
+		if _, err := rand.Read(ns.randKey[:]); err != nil {
+			return "", err
+		}
 	}
-	mac := hmac.New(md5.New, randKey[:])
-	mac.Write(randData[:])
-	copy(randData[:], mac.Sum(nil))
-	return hex.EncodeToString(randData[:]), nil
+	mac := hmac.New(md5.New, ns.randKey[:])
+	mac.Write(ns.randData[:])
+	copy(ns.randData[:], mac.Sum(nil))
+	return hex.EncodeToString(ns.randData[:]), nil
 }
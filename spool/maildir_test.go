@@ -0,0 +1,52 @@
+package spool
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestUniqueNamesDistinct(t *testing.T) {
+	s := &Spool{baseDir: t.TempDir()}
+	seen := make(map[string]bool)
+	for i := 0; i < 2000; i++ {
+		name, err := s.uniqueName()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[name] {
+			t.Fatalf("duplicate unique name %q", name)
+		}
+		seen[name] = true
+	}
+}
+
+func TestUniqueNameSequenceIsSpoolLocal(t *testing.T) {
+	dir := t.TempDir()
+	s := &Spool{baseDir: dir}
+	if _, err := s.uniqueName(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(s.FileName("", "Sequence"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(data)) != "1" {
+		t.Errorf("Sequence: got %q want \"1\"", data)
+	}
+	// A second process would take the next start number.
+	seq, err := fetchAndIncrStartSeqNo(s.FileName("", "Sequence"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 2 {
+		t.Errorf("start sequence: got %d want 2", seq)
+	}
+}
+
+func TestUniqueNameMissingSpool(t *testing.T) {
+	s := &Spool{baseDir: t.TempDir() + "/missing"}
+	if _, err := s.uniqueName(); err == nil {
+		t.Error("expected error generating a name in a missing spool")
+	}
+}
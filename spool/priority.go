@@ -0,0 +1,151 @@
+package spool
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Flavor is the delivery urgency of a spooled file, after the
+// traditional mailer flow flavors.  The zero value is Normal.
+type Flavor int
+
+const (
+	FlavorNormal Flavor = iota
+	FlavorCrash
+	FlavorImmediate
+	FlavorHold
+)
+
+var flavorNames = []string{"normal", "crash", "immediate", "hold"}
+
+func (f Flavor) String() string {
+	if f < 0 || int(f) >= len(flavorNames) {
+		return fmt.Sprintf("Flavor(%d)", int(f))
+	}
+	return flavorNames[f]
+}
+
+// MarshalText encodes a flavor as its name.
+func (f Flavor) MarshalText() ([]byte, error) {
+	if f < 0 || int(f) >= len(flavorNames) {
+		return nil, fmt.Errorf("invalid flavor %d", int(f))
+	}
+	return []byte(flavorNames[f]), nil
+}
+
+// UnmarshalText decodes a flavor from its name.
+func (f *Flavor) UnmarshalText(text []byte) error {
+	for i, name := range flavorNames {
+		if strings.EqualFold(string(text), name) {
+			*f = Flavor(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown flavor %q", text)
+}
+
+// rank orders flavors for transmission.  Held files are never
+// offered unless released, so their rank is immaterial.
+func (f Flavor) rank() int {
+	switch f {
+	case FlavorImmediate:
+		return 0
+	case FlavorCrash:
+		return 1
+	case FlavorHold:
+		return 3
+	default:
+		return 2
+	}
+}
+
+// Class is the kind of traffic a spooled file carries.  The
+// zero value, Unknown, means the class should be inferred from
+// the file name.
+type Class int
+
+const (
+	ClassUnknown Class = iota
+	ClassNetmail
+	ClassEchomail
+	ClassFileEcho
+	ClassOther
+)
+
+var classNames = []string{"unknown", "netmail", "echomail", "fileecho", "other"}
+
+func (c Class) String() string {
+	if c < 0 || int(c) >= len(classNames) {
+		return fmt.Sprintf("Class(%d)", int(c))
+	}
+	return classNames[c]
+}
+
+// MarshalText encodes a class as its name.
+func (c Class) MarshalText() ([]byte, error) {
+	if c < 0 || int(c) >= len(classNames) {
+		return nil, fmt.Errorf("invalid class %d", int(c))
+	}
+	return []byte(classNames[c]), nil
+}
+
+// UnmarshalText decodes a class from its name.
+func (c *Class) UnmarshalText(text []byte) error {
+	for i, name := range classNames {
+		if strings.EqualFold(string(text), name) {
+			*c = Class(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown class %q", text)
+}
+
+// ClassifyFile infers the traffic class of a file from its
+// name.  Bare packets are netmail, ARCmail bundles (named for
+// the day of the week, e.g. `.su0` or `.mo1`) are echomail, and
+// TIC files are file echoes.  Other files, including the files
+// TICs describe, which cannot be told by name, are other
+// traffic; files spooled for a file echo should record their
+// class.
+func ClassifyFile(fileName string) Class {
+	ext := strings.ToLower(path.Ext(fileName))
+	switch ext {
+	case ".pkt":
+		return ClassNetmail
+	case ".tic":
+		return ClassFileEcho
+	}
+	if len(ext) == 4 {
+		switch ext[1:3] {
+		case "su", "mo", "tu", "we", "th", "fr", "sa":
+			return ClassEchomail
+		}
+	}
+	return ClassOther
+}
+
+// EffectiveClass returns the class of the spooled file,
+// inferring it from the file name if it was not recorded.
+func (k *SpoolKey) EffectiveClass() Class {
+	if k.Class != ClassUnknown {
+		return k.Class
+	}
+	return ClassifyFile(k.FileKey.FileName)
+}
+
+// Held reports whether the spooled file is on hold.
+func (k *SpoolKey) Held() bool {
+	return k.Flavor == FlavorHold
+}
+
+// Precedes reports whether k should be transmitted before
+// other: more urgent flavors go first, and within a flavor,
+// netmail goes before echomail bundles, then file echoes, then
+// other files.
+func (k *SpoolKey) Precedes(other *SpoolKey) bool {
+	if kr, or := k.Flavor.rank(), other.Flavor.rank(); kr != or {
+		return kr < or
+	}
+	return k.EffectiveClass() < other.EffectiveClass()
+}
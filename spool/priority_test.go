@@ -0,0 +1,107 @@
+package spool
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClassifyFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		class Class
+	}{
+		{"0000fe9c.pkt", ClassNetmail},
+		{"0000FE9C.PKT", ClassNetmail},
+		{"0a1b2c3d.su0", ClassEchomail},
+		{"0a1b2c3d.MO1", ClassEchomail},
+		{"0a1b2c3d.fra", ClassEchomail},
+		{"nodediff.tic", ClassFileEcho},
+		{"NODEDIFF.TIC", ClassFileEcho},
+		{"nodelist.z42", ClassOther},
+		{"readme", ClassOther},
+	}
+	for _, test := range tests {
+		if class := ClassifyFile(test.name); class != test.class {
+			t.Errorf("ClassifyFile(%q): got %v want %v", test.name, class, test.class)
+		}
+	}
+}
+
+func TestPrecedes(t *testing.T) {
+	key := func(name string, flavor Flavor) *SpoolKey {
+		return &SpoolKey{Name: name, FileKey: NewFileKey(name, 0, time.Unix(0, 0)), Flavor: flavor}
+	}
+	ordered := []*SpoolKey{
+		key("a.su0", FlavorImmediate),
+		key("b.pkt", FlavorCrash),
+		key("c.pkt", FlavorNormal),
+		key("d.mo1", FlavorNormal),
+		key("e.tic", FlavorNormal),
+		key("f.pkt", FlavorHold),
+	}
+	for i := range ordered {
+		for j := range ordered {
+			if got := ordered[i].Precedes(ordered[j]); got != (i < j) {
+				t.Errorf("%s precedes %s: got %v", ordered[i].Name, ordered[j].Name, got)
+			}
+		}
+	}
+}
+
+func TestSpoolKeyJSON(t *testing.T) {
+	key := SpoolKey{Name: "x", FileKey: NewFileKey("x.pkt", 1, time.Unix(0, 0)), Flavor: FlavorCrash, Class: ClassNetmail}
+	data, err := json.Marshal(&key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded SpoolKey
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Flavor != FlavorCrash || decoded.Class != ClassNetmail {
+		t.Errorf("round trip of %s: got flavor %v class %v", data, decoded.Flavor, decoded.Class)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	s := makeTestSpool(t)
+	held := testKey("held")
+	held.Flavor = FlavorHold
+	other := testKey("other")
+	other.Flavor = FlavorHold
+	if err := s.SaveQueue("cur", "Queue", Queue{held, testKey("normal")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveQueue("new", "Queue", Queue{other}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := s.Release("held")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("released %d files, want 1", n)
+	}
+	queue, err := s.ReadQueue("cur", "Queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queue[0].Held() {
+		t.Error("file still held after release")
+	}
+	if n, _ := s.Release(); n != 1 {
+		t.Errorf("released %d files, want 1", n)
+	}
+
+	// Nothing is released while a session serves the spool.
+	unbusy, ok, err := s.TryBusy()
+	if err != nil || !ok {
+		t.Fatalf("TryBusy: %v, %v", ok, err)
+	}
+	defer unbusy()
+	if _, err := s.Release(); !errors.Is(err, ErrBusy) {
+		t.Errorf("release while busy: %v, want ErrBusy", err)
+	}
+}
@@ -0,0 +1,320 @@
+package spool
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// staleQueueAge is how old a temporary queue file in `tmp`
+// must be before recovery considers it abandoned.  Queue
+// files are written and renamed in a matter of milliseconds,
+// so anything this old was left behind by a crash.
+const staleQueueAge = time.Minute
+
+// Recover performs a crash recovery pass over the spool.  It
+// should be run at startup, before any sessions use the spool;
+// it marks the spool busy while it runs, and returns ErrBusy if a
+// session is serving it.
+//
+// Recovery proceeds in four steps:
+//  1. Any interrupted concatenation of `new/Queue` onto
+//     `cur/Queue` is completed.  If `cur/Staging` exists, the
+//     concatenation is durable and we finish it; otherwise, if
+//     `cur/Incoming` exists, we replay it.
+//  2. Files in `new` are reconciled against `new/Queue` under
+//     the `new` mutex.  Publications that were interrupted
+//     before the queue was written are rolled back, while
+//     those interrupted afterwards are completed.
+//  3. Files in `cur` are reconciled against `cur/Queue`.  Queue
+//     entries without files are dropped.
+//  4. Temporary files in `tmp` that are neither locked by a
+//     receiver nor recently written are removed.
+//
+// Files found in `new` or `cur` that are not referenced by any
+// queue are moved to a `lost` directory for inspection rather
+// than deleted.  Everything recovery changes is logged.
+func (s *Spool) Recover() error {
+	if err := s.Create(); err != nil {
+		return err
+	}
+	unbusy, ok, err := s.TryBusy()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrBusy
+	}
+	defer unbusy()
+	if err := s.recoverConcat("cur", "Incoming", "Queue"); err != nil {
+		return err
+	}
+	if err := s.recoverNew(); err != nil {
+		return err
+	}
+	if err := s.recoverCur(); err != nil {
+		return err
+	}
+	return s.removeStaleTemps()
+}
+
+// recoverConcat completes or replays an interrupted call to
+// ConcatQueues.
+func (s *Spool) recoverConcat(toDir, inName, name string) error {
+	stagingName := s.FileName(toDir, "Staging")
+	incomingName := s.FileName(toDir, inName)
+	hasStaging, err := exists(stagingName)
+	if err != nil {
+		return err
+	}
+	if hasStaging {
+		// `Staging` exists only once the concatenation is
+		// durable, so we can simply finish the job.
+		s.logf("completing interrupted concatenation of %s", incomingName)
+		if err := os.Remove(incomingName); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := os.Rename(stagingName, s.FileName(toDir, name)); err != nil {
+			return err
+		}
+		return syncDir(s.FileName(toDir, ""))
+	}
+	hasIncoming, err := exists(incomingName)
+	if err != nil {
+		return err
+	}
+	if hasIncoming {
+		s.logf("replaying interrupted concatenation of %s", incomingName)
+		return s.ConcatQueues(toDir, inName, name)
+	}
+	return nil
+}
+
+// recoverNew reconciles the `new` directory with its queue.
+func (s *Spool) recoverNew() error {
+	m, err := openMutex(s.FileName("new", "Mutex"))
+	if err != nil {
+		return err
+	}
+	defer closeMutex(m)
+
+	queue, err := s.ReadQueue("new", "Queue")
+	if err != nil {
+		return err
+	}
+	queued := make(map[string]bool)
+	newQueue := make(Queue, 0, len(queue))
+	changed := false
+	for _, entry := range queue {
+		pubName := s.FileName("new", entry.Name)
+		tmpName := s.FileName("tmp", entry.Name)
+		hasPub, err := exists(pubName)
+		if err != nil {
+			return err
+		}
+		hasTmp, err := exists(tmpName)
+		if err != nil {
+			return err
+		}
+		switch {
+		case !hasPub && hasTmp:
+			s.logf("relinking queued file %s", entry.Name)
+			if err := os.Link(tmpName, pubName); err != nil {
+				return err
+			}
+		case !hasPub:
+			s.logf("dropping queue entry for missing file %s", entry.Name)
+			changed = true
+			continue
+		}
+		if hasTmp {
+			// The publication was durable; only the
+			// final unlink was lost.
+			s.logf("completing publication of %s", entry.Name)
+			if err := os.Remove(tmpName); err != nil {
+				return err
+			}
+		}
+		queued[entry.Name] = true
+		newQueue = append(newQueue, entry)
+	}
+	if changed {
+		if err := s.SaveQueue("new", "Queue", newQueue); err != nil {
+			return err
+		}
+	}
+
+	names, err := s.spooledNames("new")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if queued[name] {
+			continue
+		}
+		hasTmp, err := exists(s.FileName("tmp", name))
+		if err != nil {
+			return err
+		}
+		if hasTmp {
+			// The file was linked but never queued.  The
+			// distant end was never sent a GOT for it, so
+			// it is safe to roll back; the temporary file
+			// is cleaned up with other stale temporaries.
+			s.logf("rolling back incomplete publication of %s", name)
+			if err := os.Remove(s.FileName("new", name)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.quarantine("new", name); err != nil {
+			return err
+		}
+	}
+	if err := syncDir(s.FileName("tmp", "")); err != nil {
+		return err
+	}
+	return syncDir(s.FileName("new", ""))
+}
+
+// recoverCur reconciles the `cur` directory with its queue.
+func (s *Spool) recoverCur() error {
+	queue, err := s.ReadQueue("cur", "Queue")
+	if err != nil {
+		return err
+	}
+	queued := make(map[string]bool)
+	newQueue := make(Queue, 0, len(queue))
+	for _, entry := range queue {
+		hasFile, err := exists(s.FileName("cur", entry.Name))
+		if err != nil {
+			return err
+		}
+		if !hasFile {
+			s.logf("dropping queue entry for missing file %s", entry.Name)
+			continue
+		}
+		queued[entry.Name] = true
+		newQueue = append(newQueue, entry)
+	}
+	if len(newQueue) != len(queue) {
+		if err := s.SaveQueue("cur", "Queue", newQueue); err != nil {
+			return err
+		}
+	}
+
+	names, err := s.spooledNames("cur")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if queued[name] {
+			continue
+		}
+		if err := s.quarantine("cur", name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeStaleTemps removes abandoned files from `tmp`.  Data
+// files are held locked by their receivers for the duration of
+// a transfer, so an unlocked one is abandoned.  Temporary queue
+// files are never locked, so we rely on their age instead.
+func (s *Spool) removeStaleTemps() error {
+	infos, err := ioutil.ReadDir(s.FileName("tmp", ""))
+	if err != nil {
+		return err
+	}
+	removed := false
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		name := info.Name()
+		if strings.HasSuffix(name, ".Queue") {
+			if time.Since(info.ModTime()) < staleQueueAge {
+				continue
+			}
+		} else {
+			stale, err := s.isUnlocked(s.FileName("tmp", name))
+			if err != nil {
+				return err
+			}
+			if !stale {
+				continue
+			}
+		}
+		s.logf("removing stale temporary file %s", name)
+		if err := os.Remove(s.FileName("tmp", name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		removed = true
+	}
+	if removed {
+		return syncDir(s.FileName("tmp", ""))
+	}
+	return nil
+}
+
+// isUnlocked reports whether no other process holds a lock on
+// the named file.
+func (s *Spool) isUnlocked(filename string) (bool, error) {
+	f, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+	locked, err := tryLockFile(f)
+	if err != nil || !locked {
+		return false, err
+	}
+	return true, unlockFile(f)
+}
+
+// quarantine moves an unreferenced file out of the given
+// directory and into `lost`.
+func (s *Spool) quarantine(dir, name string) error {
+	s.logf("moving unqueued file %s/%s to lost", dir, name)
+	if err := os.MkdirAll(s.FileName("lost", ""), 0770); err != nil {
+		return err
+	}
+	if err := os.Rename(s.FileName(dir, name), s.FileName("lost", name)); err != nil {
+		return err
+	}
+	if err := syncDir(s.FileName("lost", "")); err != nil {
+		return err
+	}
+	return syncDir(s.FileName(dir, ""))
+}
+
+// spooledNames returns the names of spooled files in the given
+// directory, omitting the queue and mutex bookkeeping files.
+func (s *Spool) spooledNames(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(s.FileName(dir, ""))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		switch info.Name() {
+		case "Queue", "Mutex", "Incoming", "Staging":
+			continue
+		}
+		names = append(names, info.Name())
+	}
+	return names, nil
+}
+
+func (s *Spool) logf(format string, args ...interface{}) {
+	s.logger().Infof(format, args...)
+}
@@ -0,0 +1,170 @@
+package spool
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func makeTestSpool(t *testing.T) *Spool {
+	s := &Spool{baseDir: t.TempDir()}
+	for _, dir := range []string{"tmp", "new", "cur"} {
+		if err := os.Mkdir(s.FileName(dir, ""), 0770); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func writeTestFile(t *testing.T, s *Spool, dir, name string) {
+	if err := ioutil.WriteFile(s.FileName(dir, name), []byte(name), 0660); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testKey(name string) SpoolKey {
+	return SpoolKey{Name: name, SpoolTime: time.Unix(0, 0), FileKey: NewFileKey(name+".pkt", int64(len(name)), time.Unix(0, 0))}
+}
+
+func assertExists(t *testing.T, s *Spool, dir, name string, want bool) {
+	t.Helper()
+	got, err := exists(s.FileName(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("%s/%s exists: got %v want %v", dir, name, got, want)
+	}
+}
+
+func assertQueue(t *testing.T, s *Spool, dir string, names ...string) {
+	t.Helper()
+	queue, err := s.ReadQueue(dir, "Queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != len(names) {
+		t.Fatalf("%s/Queue: got %d entries want %d", dir, len(queue), len(names))
+	}
+	for i, name := range names {
+		if queue[i].Name != name {
+			t.Errorf("%s/Queue[%d]: got %q want %q", dir, i, queue[i].Name, name)
+		}
+	}
+}
+
+func TestRecoverReplaysIncoming(t *testing.T) {
+	s := makeTestSpool(t)
+	writeTestFile(t, s, "cur", "a")
+	writeTestFile(t, s, "cur", "b")
+	writeTestFile(t, s, "new", "c")
+	if err := s.SaveQueue("cur", "Queue", Queue{testKey("a")}); err != nil {
+		t.Fatal(err)
+	}
+	// `b` was moved before the crash, `c` was not.
+	if err := s.SaveQueue("cur", "Incoming", Queue{testKey("b"), testKey("c")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	assertQueue(t, s, "cur", "a", "b", "c")
+	assertExists(t, s, "cur", "Incoming", false)
+	assertExists(t, s, "cur", "c", true)
+	assertExists(t, s, "new", "c", false)
+}
+
+func TestRecoverCompletesStaging(t *testing.T) {
+	s := makeTestSpool(t)
+	writeTestFile(t, s, "cur", "a")
+	writeTestFile(t, s, "cur", "b")
+	if err := s.SaveQueue("cur", "Queue", Queue{testKey("a")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveQueue("cur", "Incoming", Queue{testKey("b")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveQueue("cur", "Staging", Queue{testKey("a"), testKey("b")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	assertQueue(t, s, "cur", "a", "b")
+	assertExists(t, s, "cur", "Incoming", false)
+	assertExists(t, s, "cur", "Staging", false)
+}
+
+func TestRecoverPartialPublish(t *testing.T) {
+	s := makeTestSpool(t)
+	// `a` was linked into `new` but never queued.
+	writeTestFile(t, s, "tmp", "a")
+	if err := os.Link(s.FileName("tmp", "a"), s.FileName("new", "a")); err != nil {
+		t.Fatal(err)
+	}
+	// `b` was queued, but its temporary link survived.
+	writeTestFile(t, s, "tmp", "b")
+	if err := os.Link(s.FileName("tmp", "b"), s.FileName("new", "b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveQueue("new", "Queue", Queue{testKey("b")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	assertQueue(t, s, "new", "b")
+	assertExists(t, s, "new", "a", false)
+	assertExists(t, s, "tmp", "a", false)
+	assertExists(t, s, "new", "b", true)
+	assertExists(t, s, "tmp", "b", false)
+}
+
+func TestRecoverReconcilesCur(t *testing.T) {
+	s := makeTestSpool(t)
+	writeTestFile(t, s, "cur", "a")
+	writeTestFile(t, s, "cur", "orphan")
+	if err := s.SaveQueue("cur", "Queue", Queue{testKey("a"), testKey("gone")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	assertQueue(t, s, "cur", "a")
+	assertExists(t, s, "cur", "orphan", false)
+	assertExists(t, s, "lost", "orphan", true)
+}
+
+func TestRecoverKeepsLockedTemps(t *testing.T) {
+	s := makeTestSpool(t)
+	f, err := openLocked(s.FileName("tmp", "busy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeLocked(f)
+	writeTestFile(t, s, "tmp", "stale")
+	if err := s.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	assertExists(t, s, "tmp", "busy", true)
+	assertExists(t, s, "tmp", "stale", false)
+}
+
+func TestRecoverBusy(t *testing.T) {
+	s := makeTestSpool(t)
+	if err := s.SaveQueue("cur", "Incoming", Queue{testKey("a")}); err != nil {
+		t.Fatal(err)
+	}
+	unbusy, ok, err := s.TryBusy()
+	if err != nil || !ok {
+		t.Fatalf("TryBusy: %v, %v", ok, err)
+	}
+	defer unbusy()
+	if err := s.Recover(); !errors.Is(err, ErrBusy) {
+		t.Errorf("recover while busy: %v, want ErrBusy", err)
+	}
+	// A concatenation under way in a session is left alone.
+	assertExists(t, s, "cur", "Incoming", true)
+}
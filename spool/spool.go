@@ -4,12 +4,17 @@ import (
 	"encoding/json"
 	"errors"
 	"io/ioutil"
-	"log"
 	"os"
 	"path"
 	"time"
+
+	"fat-dragon.org/ginko/logging"
 )
 
+// ErrBusy is returned by operations that cannot be made while a
+// session is serving the spool.
+var ErrBusy = errors.New("spool: in use by a session")
+
 // FileKey uniquely identifies a file: it consists
 // of a name, size and timestamp (seconds since the
 // Unix epoch).
@@ -31,11 +36,15 @@ func (k *FileKey) Equal(other *SpoolKey) bool {
 // SpoolKey identifies a file in the spool.  It
 // contains a FileKey and a Name, which is the
 // maildir name of the actual spooled file.  This
-// record is what is stored in the queue.
+// record is what is stored in the queue.  The
+// flavor and class determine the order in which
+// queued files are transmitted.
 type SpoolKey struct {
 	Name      string
 	SpoolTime time.Time
 	FileKey   FileKey
+	Flavor    Flavor `json:",omitempty"`
+	Class     Class  `json:",omitempty"`
 }
 
 // ToFileKey returns the FileKey associated with a SpoolKey.
@@ -50,6 +59,27 @@ type Queue []SpoolKey
 // Spool is a wrapper around a Maildir.
 type Spool struct {
 	baseDir string
+	log     *logging.Logger
+}
+
+// New returns the spool in the given base directory.
+func New(baseDir string) Spool {
+	return Spool{baseDir, nil}
+}
+
+// WithLogger returns a copy of the spool that logs to the given
+// logger, so that spool messages carry the caller's context.
+func (s *Spool) WithLogger(log *logging.Logger) *Spool {
+	return &Spool{s.baseDir, log}
+}
+
+// logger returns the spool's logger, or the default logger
+// annotated with the spool directory if none was set.
+func (s *Spool) logger() *logging.Logger {
+	if s.log != nil {
+		return s.log.With("spool", s.baseDir)
+	}
+	return logging.Default().With("spool", s.baseDir)
 }
 
 // TempFile creates a new file in the spool's `tmp` directory
@@ -57,11 +87,15 @@ type Spool struct {
 // are returned; the file is advisory locked.
 func (s *Spool) TempFileFor(fileKey *FileKey) (spoolKey *SpoolKey, file *os.File, err error) {
 	for i := 0; i < 1000; i++ {
-		tmpName := uniqueName()
+		var tmpName string
+		tmpName, err = s.uniqueName()
+		if err != nil {
+			return
+		}
 		pathname := s.FileName("tmp", tmpName)
 		file, err = openLocked(pathname)
 		if err == nil {
-			spoolKey = &SpoolKey{tmpName, time.Now(), *fileKey}
+			spoolKey = &SpoolKey{Name: tmpName, SpoolTime: time.Now(), FileKey: *fileKey}
 			break
 		}
 	}
@@ -76,17 +110,21 @@ func (s *Spool) TempFileFor(fileKey *FileKey) (spoolKey *SpoolKey, file *os.File
 // crashes and faults.
 //
 // The basic sequence of events is:
-// 1. Remove the uniquename from `new` to clean up from
-//    earlier failures
-// 2. Link the uniquename from `tmp` to `new`
-// 3. Open and lock the mutex file
-// 4. Read the current queue
-// 5. Append the key for this delivery to the queue.
-// 6. Write the new queue to a temporary file
-// 7. Atomically rename the temporary queue uniquename
-//    to the queue file's name
-// 8. Unlink the file's uniquename in tmp
-// 9. Truncate, unlock and close the mutex fil.e
+//  1. Open and lock the mutex file
+//  2. Remove the uniquename from `new` to clean up from
+//     earlier failures
+//  3. Link the uniquename from `tmp` to `new`
+//  4. Read the current queue
+//  5. Append the key for this delivery to the queue.
+//  6. Write the new queue to a temporary file
+//  7. Atomically rename the temporary queue uniquename
+//     to the queue file's name
+//  8. Unlink the file's uniquename in tmp
+//  9. Truncate, unlock and close the mutex file
+//
+// The mutex is taken before the link so that recovery,
+// which also holds the mutex, never observes a link in
+// `new` that a live publisher is about to enqueue.
 func (s *Spool) Publish(spoolKey *SpoolKey) error {
 	var err error
 
@@ -94,12 +132,6 @@ func (s *Spool) Publish(spoolKey *SpoolKey) error {
 	pubName := s.FileName("new", spoolKey.Name)
 	mutexName := s.FileName("new", "Mutex")
 
-	// Remove pubName if it exists; clean up earlier failures.
-	os.Remove(pubName)
-
-	// Link the temporary file to the published file name.
-	os.Link(tmpName, pubName)
-
 	// Open and lock the Mutex file.  The Mutex exists
 	// to prevent a consumer examining the queue file
 	// while we are appending an entry to it.
@@ -109,6 +141,17 @@ func (s *Spool) Publish(spoolKey *SpoolKey) error {
 	}
 	defer closeMutex(m)
 
+	// Remove pubName if it exists; clean up earlier failures.
+	os.Remove(pubName)
+
+	// Link the temporary file to the published file name.
+	if err := os.Link(tmpName, pubName); err != nil {
+		return err
+	}
+	if err := syncDir(s.FileName("new", "")); err != nil {
+		return err
+	}
+
 	// Read the current queue into a slice of SpoolKeys.
 	queue, err := s.ReadQueue("new", "Queue")
 	if err != nil {
@@ -128,6 +171,9 @@ func (s *Spool) Publish(spoolKey *SpoolKey) error {
 	if err := os.Remove(tmpName); err != nil {
 		return err
 	}
+	if err := syncDir(s.FileName("tmp", "")); err != nil {
+		return err
+	}
 
 	// We are done.  Returning here will implicitly
 	// unlock and close the mutex file.
@@ -171,7 +217,11 @@ func (s *Spool) SaveQueue(dir string, name string, queue []SpoolKey) error {
 	if err != nil {
 		return err
 	}
-	tmpQueueName := s.FileName("tmp", uniqueName()+".Queue")
+	uniqueName, err := s.uniqueName()
+	if err != nil {
+		return err
+	}
+	tmpQueueName := s.FileName("tmp", uniqueName+".Queue")
 	file, err := os.OpenFile(tmpQueueName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
 	if err != nil {
 		return err
@@ -205,7 +255,7 @@ func (s *Spool) SaveQueue(dir string, name string, queue []SpoolKey) error {
 		os.Remove(tmpQueueName)
 		return err
 	}
-	return nil
+	return syncDir(s.FileName(dir, ""))
 }
 
 // ConsumeAndConcatQueues takes two queues and combines them.
@@ -240,6 +290,12 @@ func (s *Spool) Consume(fromDir, fromName, toDir, toName string) error {
 	if err := os.Rename(outgoingName, incomingName); err != nil {
 		return err
 	}
+	if err := syncDir(s.FileName(fromDir, "")); err != nil {
+		return err
+	}
+	if err := syncDir(s.FileName(toDir, "")); err != nil {
+		return err
+	}
 
 	// We are done.  The Mutex will be automatically
 	// released and the lock file closed.
@@ -267,11 +323,22 @@ func (s *Spool) ConcatQueues(toDir, inName, name string) error {
 		fromName := s.FileName("new", entry.Name)
 		toName := s.FileName("cur", entry.Name)
 		if err := os.Rename(fromName, toName); err != nil {
-			log.Println("Error moving file:", err)
-			continue
+			// If we are replaying a concatenation that was
+			// interrupted, the file may already have been
+			// moved.
+			if _, serr := os.Stat(toName); serr != nil {
+				s.logger().Error("Error moving file:", err)
+				continue
+			}
 		}
 		queue = append(queue, entry)
 	}
+	if err := syncDir(s.FileName("new", "")); err != nil {
+		return err
+	}
+	if err := syncDir(s.FileName("cur", "")); err != nil {
+		return err
+	}
 	newQueueName := s.FileName(toDir, "Staging")
 	if err := s.SaveQueue(toDir, "Staging", queue); err != nil {
 		os.Remove(newQueueName)
@@ -289,10 +356,170 @@ func (s *Spool) ConcatQueues(toDir, inName, name string) error {
 		return err
 	}
 
-	// We are done.
+	// We are done once the rename is durable.
+	return syncDir(s.FileName(toDir, ""))
+}
+
+// Release takes the named files off hold, so that they are
+// offered in the next session.  If no names are given, every
+// held file is released.  Both the published queue in `new`
+// and the working queue in `cur` are updated.  It returns the
+// number of files released.
+//
+// The working queue belongs to the session serving the spool,
+// which rewrites it when it ends, so files cannot be released
+// while a session runs: Release then returns ErrBusy.
+func (s *Spool) Release(names ...string) (int, error) {
+	match := func(key *SpoolKey) bool {
+		if !key.Held() {
+			return false
+		}
+		if len(names) == 0 {
+			return true
+		}
+		for _, name := range names {
+			if key.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+	release := func(dir string) (int, error) {
+		queue, err := s.ReadQueue(dir, "Queue")
+		if err != nil {
+			return 0, err
+		}
+		released := 0
+		for i := range queue {
+			if match(&queue[i]) {
+				queue[i].Flavor = FlavorNormal
+				released++
+			}
+		}
+		if released == 0 {
+			return 0, nil
+		}
+		return released, s.SaveQueue(dir, "Queue", queue)
+	}
+
+	unbusy, ok, err := s.TryBusy()
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, ErrBusy
+	}
+	defer unbusy()
+	m, err := openMutex(s.FileName("new", "Mutex"))
+	if err != nil {
+		return 0, err
+	}
+	defer closeMutex(m)
+	newReleased, err := release("new")
+	if err != nil {
+		return newReleased, err
+	}
+	curReleased, err := release("cur")
+	return newReleased + curReleased, err
+}
+
+// QueueDepth returns the number of files waiting in the spool:
+// those published to `new` and those in the working queue in
+// `cur`.
+func (s *Spool) QueueDepth() (int, error) {
+	return s.countQueued(func(*SpoolKey) bool { return true })
+}
+
+// ReadyDepth returns the number of files waiting in the spool
+// that are not on hold, and so would be sent in a session.
+func (s *Spool) ReadyDepth() (int, error) {
+	return s.countQueued(func(key *SpoolKey) bool { return !key.Held() })
+}
+
+// countQueued counts the files waiting in the spool that match.
+// Queues are read under the mutex, so as not to see one being
+// published to, and are not created if missing; a spool that
+// does not exist holds nothing.
+func (s *Spool) countQueued(match func(*SpoolKey) bool) (int, error) {
+	m, err := openMutex(s.FileName("new", "Mutex"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer closeMutex(m)
+	count := 0
+	for _, dir := range []string{"new", "cur"} {
+		queue, err := s.peekQueue(dir, "Queue")
+		if err != nil {
+			return count, err
+		}
+		for i := range queue {
+			if match(&queue[i]) {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
+
+// peekQueue reads a queue like ReadQueue, but without creating
+// it: a missing queue is empty.
+func (s *Spool) peekQueue(dir string, name string) (Queue, error) {
+	data, err := os.ReadFile(s.FileName(dir, name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Queue{}, nil
+		}
+		return nil, err
+	}
+	queue := make(Queue, 0)
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &queue)
+	}
+	return queue, err
+}
+
+// Dir returns the spool's base directory.
+func (s *Spool) Dir() string {
+	return s.baseDir
+}
+
+// Create creates the spool's directories, if they do not already
+// exist, as for spools that are made on demand rather than by
+// the administrator.
+func (s *Spool) Create() error {
+	for _, dir := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(path.Join(s.baseDir, dir), 0770); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// TryBusy marks the spool as in use by a session, so that no
+// other session, in this process or another, serves it at the
+// same time.  The mark is an advisory lock on the `Busy` file in
+// the spool's base directory.  It reports whether the mark was
+// taken; if so, the returned function releases it.  A spool
+// with no directory holds nothing, and is never busy.
+func (s *Spool) TryBusy() (func(), bool, error) {
+	if s.baseDir == "" {
+		return func() {}, true, nil
+	}
+	f, err := os.OpenFile(path.Join(s.baseDir, "Busy"), os.O_RDWR|os.O_CREATE, 0660)
+	if err != nil {
+		return nil, false, err
+	}
+	locked, err := tryLockFile(f)
+	if err != nil || !locked {
+		f.Close()
+		return nil, false, err
+	}
+	return func() { closeLocked(f) }, true, nil
+}
+
 // Remove deletes a file from the spool.
 func (s *Spool) Remove(dir string, key *SpoolKey) error {
 	return os.Remove(s.FileName(dir, key.Name))
@@ -306,6 +533,6 @@ func (s *Spool) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, &baseDir); err != nil {
 		return err
 	}
-	*s = Spool{baseDir}
+	*s = New(baseDir)
 	return nil
 }
@@ -0,0 +1,55 @@
+package spool
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestTryBusy(t *testing.T) {
+	s := makeTestSpool(t)
+	release, ok, err := s.TryBusy()
+	if err != nil || !ok {
+		t.Fatalf("TryBusy() = %v, %v", ok, err)
+	}
+	other := &Spool{baseDir: s.baseDir}
+	if _, ok, err := other.TryBusy(); err != nil || ok {
+		t.Errorf("second TryBusy() = %v, %v; want busy", ok, err)
+	}
+	release()
+	release, ok, err = other.TryBusy()
+	if err != nil || !ok {
+		t.Fatalf("TryBusy() after release = %v, %v", ok, err)
+	}
+	release()
+}
+
+func TestQueueDepth(t *testing.T) {
+	s := makeTestSpool(t)
+	if depth, err := s.QueueDepth(); err != nil || depth != 0 {
+		t.Errorf("QueueDepth() of empty spool = %d, %v", depth, err)
+	}
+	for _, dir := range []string{"new", "cur"} {
+		if _, err := os.Stat(s.FileName(dir, "Queue")); !os.IsNotExist(err) {
+			t.Errorf("%s/Queue created by QueueDepth", dir)
+		}
+	}
+	held := testKey("held")
+	held.Flavor = FlavorHold
+	if err := s.SaveQueue("cur", "Queue", Queue{held, testKey("normal")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveQueue("new", "Queue", Queue{testKey("new")}); err != nil {
+		t.Fatal(err)
+	}
+	if depth, err := s.QueueDepth(); err != nil || depth != 3 {
+		t.Errorf("QueueDepth() = %d, %v; want 3", depth, err)
+	}
+	if depth, err := s.ReadyDepth(); err != nil || depth != 2 {
+		t.Errorf("ReadyDepth() = %d, %v; want 2", depth, err)
+	}
+	missing := New(path.Join(t.TempDir(), "missing"))
+	if depth, err := missing.QueueDepth(); err != nil || depth != 0 {
+		t.Errorf("QueueDepth() of missing spool = %d, %v", depth, err)
+	}
+}
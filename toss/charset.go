@@ -0,0 +1,41 @@
+package toss
+
+import (
+	"fat-dragon.org/ginko/charset"
+	"fat-dragon.org/ginko/config"
+	"fat-dragon.org/ginko/message"
+	"fat-dragon.org/ginko/pkt"
+)
+
+// decode returns a copy of a message converted to UTF-8, as it
+// is kept in message bases, from the character set it names or
+// else the default for its net.  Its CHRS kludge is changed to
+// match.
+func (t *Tosser) decode(net *config.Net, m *pkt.Message) *pkt.Message {
+	text, cs := charset.Decode(m.Text, t.Config.CharsetIn(net))
+	return recode(m, cs, charset.UTF8, text)
+}
+
+// encode returns a copy of a message from a message base, in
+// UTF-8 unless it names another character set, converted to the
+// character set of its net for sending.  Its CHRS kludge is
+// changed to match.
+func (t *Tosser) encode(net *config.Net, m *pkt.Message) *pkt.Message {
+	text, cs := charset.Decode(m.Text, charset.UTF8)
+	to := t.Config.CharsetIn(net)
+	return recode(m, cs, to, to.Encode(text))
+}
+
+// recode returns a copy of a message with the given text, its
+// header fields converted from one character set to another,
+// and its CHRS kludge naming the latter.
+func recode(m *pkt.Message, from, to charset.Charset, text string) *pkt.Message {
+	recoded := *m
+	recoded.From = to.Encode(from.Decode(m.From))
+	recoded.To = to.Encode(from.Decode(m.To))
+	recoded.Subject = to.Encode(from.Decode(m.Subject))
+	parsed := message.Parse(text)
+	parsed.SetKludge("CHRS", to.Kludge())
+	recoded.Text = parsed.String()
+	return &recoded
+}
@@ -0,0 +1,124 @@
+package toss
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DupesFile is the name of the dupe database within the data
+// directory.
+const DupesFile = "dupes.txt"
+
+// DupeAge is how long a MSGID is remembered.
+const DupeAge = 60 * 24 * time.Hour
+
+// Dupes records the MSGIDs of the echomail already tossed, so
+// that mail arriving by more than one path is tossed only once.
+// It is kept in a text file, one MSGID to a line, preceded by
+// the time it was first seen and its area.
+type Dupes struct {
+	file   string
+	maxAge time.Duration
+	seen   map[string]int64
+}
+
+// OpenDupes reads the dupe database in the given file, which
+// need not exist, forgetting MSGIDs older than maxAge.
+func OpenDupes(file string, maxAge time.Duration) (*Dupes, error) {
+	d := &Dupes{file, maxAge, make(map[string]int64)}
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		when, key, ok := strings.Cut(scanner.Text(), " ")
+		seen, err := strconv.ParseInt(when, 10, 64)
+		if !ok || err != nil {
+			continue
+		}
+		d.seen[key] = seen
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", file, err)
+	}
+	return d, nil
+}
+
+func dupeKey(area, msgid string) string {
+	return strings.ToUpper(area) + " " + msgid
+}
+
+// Check reports whether a MSGID has been seen before in the
+// given area, and records it if not.
+func (d *Dupes) Check(area, msgid string, now time.Time) bool {
+	key := dupeKey(area, msgid)
+	if d.seenKey(key, now) {
+		return true
+	}
+	d.add(key, now)
+	return false
+}
+
+// seenKey reports whether a key has been recorded, and not aged
+// out.
+func (d *Dupes) seenKey(key string, now time.Time) bool {
+	seen, ok := d.seen[key]
+	return ok && now.Sub(time.Unix(seen, 0)) < d.maxAge
+}
+
+func (d *Dupes) add(key string, now time.Time) {
+	d.seen[key] = now.Unix()
+}
+
+// Save writes the database back to its file, atomically,
+// dropping MSGIDs that have aged out.
+func (d *Dupes) Save(now time.Time) error {
+	keys := make([]string, 0, len(d.seen))
+	for key, seen := range d.seen {
+		if now.Sub(time.Unix(seen, 0)) >= d.maxAge {
+			delete(d.seen, key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if d.seen[keys[i]] != d.seen[keys[j]] {
+			return d.seen[keys[i]] < d.seen[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	tmp := path.Join(path.Dir(d.file), "."+path.Base(d.file)+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%d %s\n", d.seen[key], key)
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, d.file)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package toss
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDupes(t *testing.T) {
+	file := path.Join(t.TempDir(), DupesFile)
+	d, err := OpenDupes(file, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := testTime
+	if d.Check("FIDOTEST", "1:387/1 00000001", now) {
+		t.Error("first sighting is a dupe")
+	}
+	if !d.Check("fidotest", "1:387/1 00000001", now.Add(time.Hour)) {
+		t.Error("second sighting, in the same area, is not a dupe")
+	}
+	if d.Check("OTHER", "1:387/1 00000001", now) {
+		t.Error("sighting in another area is a dupe")
+	}
+	d.Check("OLD", "1:387/1 00000002", now.Add(-20*time.Hour))
+	if err := d.Save(now); err != nil {
+		t.Fatal(err)
+	}
+
+	later := now.Add(5 * time.Hour)
+	d, err = OpenDupes(file, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Check("FIDOTEST", "1:387/1 00000001", later) {
+		t.Error("dupe forgotten")
+	}
+	if err := d.Save(later); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "OLD") {
+		t.Errorf("aged-out MSGID saved:\n%s", data)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("saved %d MSGIDs, want 2:\n%s", lines, data)
+	}
+	if d.Check("OLD", "1:387/1 00000002", later) {
+		t.Error("aged-out MSGID is a dupe")
+	}
+}
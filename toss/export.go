@@ -0,0 +1,91 @@
+package toss
+
+import (
+	"fat-dragon.org/ginko/config"
+	"fat-dragon.org/ginko/message"
+	"fat-dragon.org/ginko/msgbase"
+)
+
+// export sends the echomail posted locally to the message bases
+// of the areas that have them, and not yet sent, to the areas'
+// links.  Each message's MSGID is recorded, so that it is not
+// tossed should it come back, and the message is marked sent
+// only once the packets holding it have been published, so that
+// it is sent again rather than lost if they cannot be.
+func (t *Tosser) export() error {
+	var firstErr error
+	exported := make(map[string][]uint32)
+	var specs []string
+	for i := range t.Config.Nets {
+		net := &t.Config.Nets[i]
+		for j := range net.Areas {
+			area := &net.Areas[j]
+			if area.Base == "" {
+				continue
+			}
+			nums, err := t.exportArea(net, area)
+			if len(nums) > 0 {
+				if exported[area.Base] == nil {
+					specs = append(specs, area.Base)
+				}
+				exported[area.Base] = append(exported[area.Base], nums...)
+			}
+			if err != nil {
+				t.Log.Error("Exporting from", area.Tag, "failed:", err)
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+	}
+	if err := t.flush(); err != nil {
+		return err
+	}
+	for _, spec := range specs {
+		b, err := t.base(spec)
+		if err == nil {
+			err = msgbase.MarkSent(b, exported[spec])
+		}
+		if err != nil {
+			t.Log.Error("Cannot mark messages sent in", spec, "-", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if err := t.closeBases(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	if err := t.Dupes.Save(t.now()); err != nil {
+		return err
+	}
+	return firstErr
+}
+
+// exportArea packs the echomail posted locally in an area,
+// returning the numbers of the messages packed.
+func (t *Tosser) exportArea(net *config.Net, area *config.Area) ([]uint32, error) {
+	b, err := t.base(area.Base)
+	if err != nil {
+		return nil, err
+	}
+	var nums []uint32
+	err = msgbase.Scan(b, func(num uint32, stored *msgbase.Message) error {
+		m := t.encode(net, stored.Packet(area.Tag))
+		text := message.Parse(m.Text)
+		targets := t.spread(area, net.Address, nil, text)
+		m.Text = text.String()
+		if text.MsgID != nil {
+			t.dupe(area.Tag, text.MsgID.String())
+		}
+		t.Log.Debug("Exporting", num, "from", area.Tag, "to", len(targets), "links")
+		t.forward(targets, m)
+		if err := t.commit(); err != nil {
+			return err
+		}
+		t.Stats.Exported++
+		nums = append(nums, num)
+		return nil
+	})
+	return nums, err
+}
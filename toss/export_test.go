@@ -0,0 +1,148 @@
+package toss
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"fat-dragon.org/ginko/charset"
+	"fat-dragon.org/ginko/ftn"
+	"fat-dragon.org/ginko/ftn/ftntest"
+	"fat-dragon.org/ginko/message"
+	"fat-dragon.org/ginko/msgbase"
+	"fat-dragon.org/ginko/pkt"
+)
+
+// post writes a message with the given body to the LOCAL area's
+// base, as posted locally, returning its number.
+func post(t *testing.T, dir, body string) uint32 {
+	b, err := msgbase.Open("squish:" + path.Join(dir, "local"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	num, err := b.Write(&msgbase.Message{
+		From:       "Sysop",
+		To:         "All",
+		Subject:    "Posted",
+		Origin:     ftn.NewAddress4d(1, 387, 108, 0),
+		Written:    testTime,
+		Attributes: msgbase.Local | msgbase.KillSent,
+		Text:       "\x01MSGID: 1:387/108 00000001\r" + body + "\r--- test\r * Origin: Us (1:387/108)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return num
+}
+
+func TestExport(t *testing.T) {
+	tosser, dir := testTosser(t)
+	num := post(t, dir, "Hello")
+	// Echomail tossed to the base is not sent again, even if its
+	// sender left it marked local.
+	link := tosser.Config.LinkFor(ftntest.Address(t, "1:387/1"))
+	tossed := echomail("LOCAL", 2, "387/1")
+	tossed.Attributes = pkt.Local
+	receive(t, &link.InSpool, "0000006b.pkt", packet(t, "1:387/1", "1:387/108", "secret", tossed))
+
+	if err := tosser.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := Stats{Files: 1, Packets: 1, Echomail: 1, Forwarded: 1, Exported: 1}
+	if tosser.Stats != want {
+		t.Errorf("stats %+v, want %+v", tosser.Stats, want)
+	}
+	sent := queued(t, &link.OutSpool, "1:387/1", "Secret")
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages to 1:387/1, want 1", len(sent))
+	}
+	m := sent[0]
+	if m.Subject != "Posted" || m.Origin.String() != "387/108" || m.Dest.String() != "387/1" || m.Attributes != 0 {
+		t.Errorf("sent %+v", m)
+	}
+	if !strings.HasPrefix(m.Text, "AREA:LOCAL\r\x01MSGID: 1:387/108 00000001\r\x01CHRS: CP437 2\rHello\r") {
+		t.Errorf("sent text %q", m.Text)
+	}
+	text := message.Parse(m.Text)
+	if got := fmt.Sprint(text.SeenBy, text.Path); got != "[387/1 387/108] [387/108]" {
+		t.Errorf("SEEN-BY and PATH %s", got)
+	}
+	if !tosser.Dupes.Check("LOCAL", "1:387/108 00000001", testTime) {
+		t.Error("MSGID not recorded")
+	}
+
+	b, err := msgbase.Open("squish:" + path.Join(dir, "local"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	stored, err := b.Read(num)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Attributes.Has(msgbase.Sent | msgbase.Scanned) {
+		t.Errorf("exported message has attributes %#x", stored.Attributes)
+	}
+
+	// Nothing is sent twice.
+	tosser.Stats = Stats{}
+	if err := tosser.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if tosser.Stats.Exported != 0 {
+		t.Errorf("exported %d messages again", tosser.Stats.Exported)
+	}
+	if sent := queued(t, &link.OutSpool, "1:387/1", "Secret"); len(sent) != 1 {
+		t.Errorf("sent %d messages to 1:387/1, want 1", len(sent))
+	}
+}
+
+func TestExportUnpublished(t *testing.T) {
+	tosser, dir := testTosser(t)
+	num := post(t, dir, "Hello")
+	// The packet cannot be published to the link's spool, so the
+	// message is not marked sent, and is sent in the next run.
+	link := tosser.Config.LinkFor(ftntest.Address(t, "1:387/1"))
+	tmp := link.OutSpool.FileName("tmp", "")
+	if err := os.Remove(tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tmp, nil, 0660); err != nil {
+		t.Fatal(err)
+	}
+	if err := tosser.Run(); err == nil {
+		t.Fatal("run succeeded with no spool")
+	}
+	b, err := msgbase.Open("squish:" + path.Join(dir, "local"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	stored, err := b.Read(num)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Attributes.Has(msgbase.Sent) {
+		t.Errorf("unpublished message marked sent: %#x", stored.Attributes)
+	}
+}
+
+func TestExportCharset(t *testing.T) {
+	tosser, dir := testTosser(t)
+	tosser.Config.Nets[0].Charset = charset.CP866
+	post(t, dir, "Привет")
+	if err := tosser.Run(); err != nil {
+		t.Fatal(err)
+	}
+	link := tosser.Config.LinkFor(ftntest.Address(t, "1:387/1"))
+	sent := queued(t, &link.OutSpool, "1:387/1", "Secret")
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages to 1:387/1, want 1", len(sent))
+	}
+	if want := "\x01CHRS: CP866 2\r\x8f\xe0\xa8\xa2\xa5\xe2\r"; !strings.Contains(sent[0].Text, want) {
+		t.Errorf("sent text %q, want %q", sent[0].Text, want)
+	}
+}
@@ -0,0 +1,152 @@
+package toss
+
+import (
+	"strings"
+	"time"
+
+	"fat-dragon.org/ginko/config"
+	"fat-dragon.org/ginko/ftn"
+	"fat-dragon.org/ginko/message"
+	"fat-dragon.org/ginko/pkt"
+	"fat-dragon.org/ginko/spool"
+	"fat-dragon.org/ginko/version"
+)
+
+// netmailDest returns the full destination address of netmail.
+// The packed message gives only the net and node; the zone is
+// taken from the INTL kludge, or failing that is assumed to be
+// the given zone, and the point from the TOPT kludge.
+func netmailDest(m *pkt.Message, text *message.Message, zone ftn.Zone) ftn.Address {
+	net, node := m.Dest.Net(), m.Dest.Node()
+	if text.Intl != nil {
+		if text.Intl.Dest.Zone() != 0 {
+			zone = text.Intl.Dest.Zone()
+		}
+		net, node = text.Intl.Dest.Net(), text.Intl.Dest.Node()
+	}
+	return ftn.NewAddress4d(zone, net, node, text.ToPoint)
+}
+
+// netmailFlavor returns the flavor with which netmail is sent,
+// and whether it is to be sent directly to its destination rather
+// than routed.  Crash and hold are attributes of the message;
+// direct and immediate can only be given in a FLAGS kludge, as
+// crash and hold also may be.
+func netmailFlavor(m *pkt.Message, text *message.Message) (spool.Flavor, bool) {
+	flags := make(map[string]bool)
+	if value, ok := text.Kludge("FLAGS"); ok {
+		for _, flag := range strings.Fields(strings.ToUpper(value)) {
+			flags[flag] = true
+		}
+	}
+	switch {
+	case m.Attributes.Has(pkt.Hold) || flags["HLD"]:
+		return spool.FlavorHold, true
+	case flags["IMM"]:
+		return spool.FlavorImmediate, true
+	case m.Attributes.Has(pkt.Crash) || flags["CRA"]:
+		return spool.FlavorCrash, true
+	case flags["DIR"]:
+		return spool.FlavorNormal, true
+	}
+	return spool.FlavorNormal, false
+}
+
+// boss returns the node of an address: the address itself, or
+// for a point, its boss node.
+func boss(addr ftn.Address) ftn.Address {
+	return ftn.NewAddress(addr.Zone(), addr.Net(), addr.Node(), 0, addr.Domain())
+}
+
+// ours reports whether an address is one of ours.
+func (t *Tosser) ours(addr ftn.Address) bool {
+	for _, a := range t.Config.Addresses() {
+		if t.Config.Zones.Equal(a, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// tossNetmail delivers netmail.  Mail for one of our addresses
+// is left for local readers.  Mail for a link, including our
+// points, is sent to it; crash, hold and direct mail is sent to
+// the node it is for; and other mail goes to the next hop given
+// by the routes of its net.  Mail that would be routed to us is
+// set aside as bad.
+func (t *Tosser) tossNetmail(link *config.Link, m *pkt.Message, text *message.Message) error {
+	t.Stats.Netmail++
+	us := t.ourAddress(link)
+	dest := t.Config.Canonical(netmailDest(m, text, us.Zone()))
+	if t.ours(dest) {
+		t.deliver(t.dirOutput(t.netmailDir(), us), m)
+		return nil
+	}
+	flavor, direct := netmailFlavor(m, text)
+	next := t.Config.LinkFor(dest)
+	if next == nil {
+		hop := boss(dest)
+		if t.ours(hop) {
+			return t.bad(link, m, "netmail for "+dest.String()+", a point of ours with no link")
+		}
+		if !direct {
+			var ok bool
+			if hop, ok = t.Config.Route(dest); !ok {
+				return t.bad(link, m, "no route for netmail to "+dest.String())
+			}
+			if t.ours(hop) {
+				return t.bad(link, m, "netmail for "+dest.String()+" routed to us")
+			}
+		}
+		var err error
+		if next, err = t.linkTo(hop); err != nil {
+			return err
+		}
+	}
+	if next == link {
+		return t.bad(link, m, "netmail for "+dest.String()+" routed back to its sender")
+	}
+	o := t.linkOutput(next, flavor, spool.ClassNetmail)
+	routed := *m
+	routed.Attributes &^= pkt.LocalAttributes
+	routed.Text = addVia(m.Text, o.header.Origin, t.now())
+	t.Log.Debug("Routing netmail for", dest, "via", next.Address, "as", flavor)
+	t.deliver(o, &routed)
+	t.Stats.Routed++
+	return nil
+}
+
+// linkTo returns the link for sending mail to a system: the
+// configured link for it, or else one for direct delivery, whose
+// spools are created if need be.
+func (t *Tosser) linkTo(addr ftn.Address) (*config.Link, error) {
+	if link := t.Config.LinkFor(addr); link != nil {
+		return link, nil
+	}
+	addr = t.Config.Canonical(addr)
+	if link := t.direct[addr]; link != nil {
+		return link, nil
+	}
+	link, err := t.Config.DirectLink(addr)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range []*spool.Spool{&link.InSpool, &link.OutSpool} {
+		if err := s.Create(); err != nil {
+			return nil, err
+		}
+	}
+	t.direct[addr] = link
+	return link, nil
+}
+
+// addVia adds a Via kludge, recording that netmail passed through
+// the given address, to the end of the message's text, as
+// described in FTS-4009.
+func addVia(text string, addr ftn.Address, now time.Time) string {
+	if text != "" && !strings.HasSuffix(text, "\r") {
+		text += "\r"
+	}
+	return text + "\x01Via " + addr.String() + " @" + now.UTC().Format("20060102.150405") + ".UTC " +
+		version.Mailer + " " + version.String() + "\r"
+}
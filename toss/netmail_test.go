@@ -0,0 +1,183 @@
+package toss
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"testing"
+
+	"fat-dragon.org/ginko/config"
+	"fat-dragon.org/ginko/ftn"
+	"fat-dragon.org/ginko/ftn/ftntest"
+	"fat-dragon.org/ginko/pkt"
+	"fat-dragon.org/ginko/spool"
+)
+
+// netmail returns a message from 1:387/2 to the given 4D address,
+// with INTL and TOPT kludges, and the given attributes and FLAGS.
+func netmail(t *testing.T, to string, attrs pkt.Attribute, flags string) *pkt.Message {
+	dest := ftntest.Address(t, to)
+	text := fmt.Sprintf("\x01INTL %d:%d/%d 1:387/2\r", dest.Zone(), dest.Net(), dest.Node())
+	if dest.Point() != 0 {
+		text += fmt.Sprintf("\x01TOPT %d\r", dest.Point())
+	}
+	if flags != "" {
+		text += "\x01FLAGS " + flags + "\r"
+	}
+	return &pkt.Message{
+		Origin:     ftn.NewAddress2d(387, 2),
+		Dest:       ftn.NewAddress2d(dest.Net(), dest.Node()),
+		Attributes: attrs,
+		DateTime:   pkt.FormatDateTime(testTime),
+		To:         "Someone",
+		From:       "Sysop",
+		Subject:    to,
+		Text:       text + "Hello\r",
+	}
+}
+
+// flavors returns the flavors of the packets queued in a spool,
+// and checks that they are spooled as netmail.
+func flavors(t *testing.T, s *spool.Spool) []spool.Flavor {
+	queue, err := s.ReadQueue("new", "Queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var flavors []spool.Flavor
+	for _, key := range queue {
+		if key.Class != spool.ClassNetmail {
+			t.Errorf("%s spooled as %v", key.FileKey.FileName, key.Class)
+		}
+		flavors = append(flavors, key.Flavor)
+	}
+	return flavors
+}
+
+func subjects(messages []*pkt.Message) string {
+	var subjects []string
+	for _, m := range messages {
+		subjects = append(subjects, m.Subject)
+	}
+	return strings.Join(subjects, " ")
+}
+
+func TestTossNetmail(t *testing.T) {
+	tosser, dir := testTosser(t)
+	node := tosser.Config.LinkFor(ftntest.Address(t, "1:387/2"))
+	data := packet(t, "1:387/2", "1:387/108", "LongPass",
+		netmail(t, "1:387/108", 0, ""),
+		netmail(t, "1:387/108.1", 0, ""),
+		netmail(t, "1:387/108.7", 0, ""),
+		netmail(t, "2:5020/1", 0, ""),
+		netmail(t, "1:387/3", 0, ""),
+		netmail(t, "1:123/4", pkt.Crash, ""),
+		netmail(t, "1:123/4.2", 0, "DIR HLD"),
+		netmail(t, "1:387/1", pkt.Crash, ""),
+		netmail(t, "21:1/1", 0, ""),
+	)
+	if err := tosser.TossPacket("0000006b.pkt", bytes.NewReader(data), []*config.Link{node}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tosser.flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := Stats{Packets: 1, Netmail: 9, Routed: 6, Bad: 2}
+	if tosser.Stats != want {
+		t.Errorf("stats %+v, want %+v", tosser.Stats, want)
+	}
+	if got := subjects(left(t, path.Join(dir, "data", NetmailDir))); got != "1:387/108" {
+		t.Errorf("left for us: %s", got)
+	}
+	if got := subjects(left(t, path.Join(dir, "data", BadDir))); got != "1:387/108.7 21:1/1" {
+		t.Errorf("set aside: %s", got)
+	}
+
+	point := tosser.Config.LinkFor(ftntest.Address(t, "1:387/108.1"))
+	toPoint := queued(t, &point.OutSpool, "1:387/108.1", "point")
+	if got := subjects(toPoint); got != "1:387/108.1" {
+		t.Errorf("sent to the point: %s", got)
+	}
+	if via := "\x01Via 1:387/108@fidonet @20230304.050607.UTC ginko "; len(toPoint) == 1 && !strings.Contains(toPoint[0].Text, "Hello\r"+via) {
+		t.Errorf("routed text %q lacks %q", toPoint[0].Text, via)
+	}
+
+	// 1:387/1 is our default route, and gets its own crash mail.
+	uplink := tosser.Config.LinkFor(ftntest.Address(t, "1:387/1"))
+	toUplink := queued(t, &uplink.OutSpool, "1:387/1", "Secret")
+	if got := subjects(toUplink); got != "2:5020/1 1:387/1" {
+		t.Errorf("sent to 1:387/1: %s", got)
+	}
+	for _, m := range toUplink {
+		if m.Attributes != 0 {
+			t.Errorf("%s routed with attributes %v", m.Subject, m.Attributes)
+		}
+	}
+	if got := fmt.Sprint(flavors(t, &uplink.OutSpool)); got != "[normal crash]" {
+		t.Errorf("flavors for 1:387/1: %s", got)
+	}
+
+	for _, tc := range []struct {
+		addr, subjects, flavors string
+	}{
+		{"1:387/3", "1:387/3", "[normal]"},
+		{"1:123/4", "1:123/4 1:123/4.2", "[crash hold]"},
+	} {
+		link, err := tosser.Config.DirectLink(ftntest.Address(t, tc.addr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := subjects(queued(t, &link.OutSpool, tc.addr, "")); got != tc.subjects {
+			t.Errorf("sent to %s: %s, want %s", tc.addr, got, tc.subjects)
+		}
+		if got := fmt.Sprint(flavors(t, &link.OutSpool)); got != tc.flavors {
+			t.Errorf("flavors for %s: %s, want %s", tc.addr, got, tc.flavors)
+		}
+	}
+}
+
+func TestTossNetmailLoop(t *testing.T) {
+	tosser, dir := testTosser(t)
+	uplink := tosser.Config.LinkFor(ftntest.Address(t, "1:387/1"))
+	data := packet(t, "1:387/1", "1:387/108", "Secret", netmail(t, "2:5020/1", 0, ""))
+	if err := tosser.TossPacket("0000006b.pkt", bytes.NewReader(data), []*config.Link{uplink}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tosser.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := subjects(left(t, path.Join(dir, "data", BadDir))); got != "2:5020/1" {
+		t.Errorf("set aside: %s", got)
+	}
+	if got := queued(t, &uplink.OutSpool, "", ""); len(got) != 0 {
+		t.Errorf("routed %d messages back to the sender", len(got))
+	}
+}
+
+func TestTossNetmailRoutedToUs(t *testing.T) {
+	tosser, dir := testTosser(t)
+	to, err := ftn.ParsePattern("2:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	via, err := config.ParseVia("1:387/108")
+	if err != nil {
+		t.Fatal(err)
+	}
+	net := &tosser.Config.Nets[0]
+	net.Routes = append([]config.Route{{To: to, Via: via}}, net.Routes...)
+	node := tosser.Config.LinkFor(ftntest.Address(t, "1:387/2"))
+	data := packet(t, "1:387/2", "1:387/108", "LongPass", netmail(t, "2:5020/1", 0, ""))
+	if err := tosser.TossPacket("0000006b.pkt", bytes.NewReader(data), []*config.Link{node}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tosser.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := subjects(left(t, path.Join(dir, "data", BadDir))); got != "2:5020/1" {
+		t.Errorf("set aside: %s", got)
+	}
+	if tosser.Stats.Routed != 0 {
+		t.Errorf("routed %d messages", tosser.Stats.Routed)
+	}
+}
@@ -0,0 +1,360 @@
+package toss
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"fat-dragon.org/ginko/config"
+	"fat-dragon.org/ginko/ftn"
+	"fat-dragon.org/ginko/msgbase"
+	"fat-dragon.org/ginko/pkt"
+	"fat-dragon.org/ginko/spool"
+)
+
+// MaxPacketSize is the size at which a packet being made is
+// closed, and another begun.
+const MaxPacketSize = 256 << 10
+
+// ProductCode is the product code given in the packets we make;
+// ginko has none assigned.
+const ProductCode = 0xfe
+
+// output collects messages into packets bound for a link's
+// outbound spool, or for a directory.  Packets are spooled with
+// the output's flavor and class.
+type output struct {
+	header pkt.Header
+	spool  *spool.Spool
+	flavor spool.Flavor
+	class  spool.Class
+	dir    string
+	buf    bytes.Buffer
+	w      *pkt.Writer
+}
+
+// packetPassword returns a link's password as it is given in
+// packets, which hold at most eight characters.
+func packetPassword(password string) string {
+	if len(password) > 8 {
+		password = password[:8]
+	}
+	return password
+}
+
+// outputKey identifies the output for packets to a link.  Each
+// link has one for echomail, and one for netmail of each flavor.
+type outputKey struct {
+	link   *config.Link
+	flavor spool.Flavor
+	class  spool.Class
+}
+
+// linkOutput returns the output for packets of the given flavor
+// and class to a link.
+func (t *Tosser) linkOutput(link *config.Link, flavor spool.Flavor, class spool.Class) *output {
+	key := outputKey{link, flavor, class}
+	if o := t.links[key]; o != nil {
+		return o
+	}
+	from := t.Config.Addresses()[0]
+	if addrs := t.Config.AddressesFor(link); len(addrs) > 0 {
+		from = addrs[0]
+	}
+	o := &output{
+		header: pkt.Header{
+			Origin:      from,
+			Dest:        link.Address,
+			ProductCode: ProductCode,
+			Password:    packetPassword(link.Password),
+		},
+		spool:  &link.OutSpool,
+		flavor: flavor,
+		class:  class,
+	}
+	t.links[key] = o
+	return o
+}
+
+// dirOutput returns the output for packets left in a directory
+// for local readers, addressed from and to the given address.
+func (t *Tosser) dirOutput(dir string, addr ftn.Address) *output {
+	if o := t.dirs[dir]; o != nil {
+		return o
+	}
+	o := &output{
+		header: pkt.Header{Origin: addr, Dest: addr, ProductCode: ProductCode},
+		dir:    dir,
+	}
+	t.dirs[dir] = o
+	return o
+}
+
+// pending holds what tossing a file delivers, until the whole
+// file has been tossed.  A file that fails part way is set aside
+// whole, to be tossed again, so none of its messages may be
+// delivered, nor their MSGIDs recorded as dupes.
+type pending struct {
+	writes []pendingWrite
+	stores []pendingStore
+	dupes  map[string]bool // Keys as made by dupeKey.
+}
+
+type pendingWrite struct {
+	o *output
+	m pkt.Message
+}
+
+type pendingStore struct {
+	spec string
+	area string
+	m    *msgbase.Message
+}
+
+// deliver adds a message to an output's packet once the file it
+// came in has been tossed.
+func (t *Tosser) deliver(o *output, m *pkt.Message) {
+	t.pending.writes = append(t.pending.writes, pendingWrite{o, *m})
+}
+
+// deliverToBase adds a message to an area's message base once
+// the file it came in has been tossed.
+func (t *Tosser) deliverToBase(spec, area string, m *msgbase.Message) {
+	t.pending.stores = append(t.pending.stores, pendingStore{spec, area, m})
+}
+
+// dupe reports whether a MSGID has been seen before in an area,
+// whether in the dupe database or earlier in the file being
+// tossed, and records it for the file if not.
+func (t *Tosser) dupe(area, msgid string) bool {
+	key := dupeKey(area, msgid)
+	if t.pending.dupes[key] || t.Dupes.seenKey(key, t.now()) {
+		return true
+	}
+	if t.pending.dupes == nil {
+		t.pending.dupes = make(map[string]bool)
+	}
+	t.pending.dupes[key] = true
+	return false
+}
+
+// whole runs a toss of a file or packet, delivering what it
+// leaves pending only if it succeeds.  If it fails, its messages
+// are dropped, and the stats put back as they were.
+func (t *Tosser) whole(toss func() error) error {
+	stats := t.Stats
+	if err := toss(); err != nil {
+		t.pending = pending{}
+		t.Stats = stats
+		return err
+	}
+	return t.commit()
+}
+
+// commit delivers what has been left pending.
+func (t *Tosser) commit() error {
+	p := t.pending
+	t.pending = pending{}
+	for _, s := range p.stores {
+		if err := t.store(s.spec, s.m); err != nil {
+			return fmt.Errorf("storing in %s: %w", s.area, err)
+		}
+	}
+	for i := range p.writes {
+		if err := t.write(p.writes[i].o, &p.writes[i].m); err != nil {
+			return err
+		}
+	}
+	for key := range p.dupes {
+		t.Dupes.add(key, t.now())
+	}
+	return nil
+}
+
+// base returns a message base, which is opened the first time it
+// is used, and closed when outputs are flushed.
+func (t *Tosser) base(spec string) (msgbase.Base, error) {
+	if b := t.bases[spec]; b != nil {
+		return b, nil
+	}
+	b, err := msgbase.Open(spec)
+	if err != nil {
+		return nil, err
+	}
+	t.bases[spec] = b
+	return b, nil
+}
+
+// store adds a message to a message base.
+func (t *Tosser) store(spec string, m *msgbase.Message) error {
+	b, err := t.base(spec)
+	if err != nil {
+		return err
+	}
+	_, err = b.Write(m)
+	return err
+}
+
+// write adds a message to an output's packet.
+func (t *Tosser) write(o *output, m *pkt.Message) error {
+	if o.w == nil {
+		header := o.header
+		header.Time = t.now()
+		w, err := pkt.NewWriter(&o.buf, &header)
+		if err != nil {
+			return err
+		}
+		o.w = w
+	}
+	if err := o.w.WriteMessage(m); err != nil {
+		return err
+	}
+	if o.buf.Len() >= MaxPacketSize {
+		return t.flushOutput(o)
+	}
+	return nil
+}
+
+// flush closes the packets being made, publishing them to their
+// spools or directories, and the message bases written to.
+// Packets for links are published in order of link address, then
+// flavor and class, so a link's queue is the same from one run to
+// the next.
+func (t *Tosser) flush() error {
+	keys := make([]outputKey, 0, len(t.links))
+	for key := range t.links {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if c := a.link.Address.Compare(b.link.Address); c != 0 {
+			return c < 0
+		}
+		if a.flavor != b.flavor {
+			return a.flavor < b.flavor
+		}
+		return a.class < b.class
+	})
+	firstErr := t.closeBases()
+	for _, key := range keys {
+		if err := t.flushOutput(t.links[key]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, o := range t.dirs {
+		if err := t.flushOutput(o); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// closeBases closes the message bases opened.
+func (t *Tosser) closeBases() error {
+	var firstErr error
+	for spec, b := range t.bases {
+		if err := b.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(t.bases, spec)
+	}
+	return firstErr
+}
+
+func (t *Tosser) flushOutput(o *output) error {
+	if o.w == nil {
+		return nil
+	}
+	defer o.buf.Reset()
+	err := o.w.Close()
+	o.w = nil
+	if err != nil {
+		return err
+	}
+	if o.spool != nil {
+		return t.publish(o, o.buf.Bytes())
+	}
+	return t.leave(o.dir, o.buf.Bytes())
+}
+
+// packetName returns a name for a new packet.  Names are made
+// from a serial number seeded from the clock, so as not to repeat
+// those of earlier runs.
+func (t *Tosser) packetName() string {
+	t.serial++
+	return fmt.Sprintf("%08x.pkt", t.serial)
+}
+
+// publish adds a packet to an output's spool.
+func (t *Tosser) publish(o *output, data []byte) error {
+	s := o.spool
+	fileKey := spool.NewFileKey(t.packetName(), int64(len(data)), t.now())
+	key, f, err := s.TempFileFor(&fileKey)
+	if err != nil {
+		return err
+	}
+	key.Flavor, key.Class = o.flavor, o.class
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		s.Abort(key)
+		return err
+	}
+	return s.Publish(key)
+}
+
+// leave writes a packet into a directory, under a name not
+// already taken.  The packet is written under a temporary name
+// and linked into place, so that readers never see it partial.
+func (t *Tosser) leave(dir string, data []byte) error {
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return err
+	}
+	tmp := path.Join(dir, fmt.Sprintf(".toss%d.tmp", os.Getpid()))
+	if err := os.WriteFile(tmp, data, 0660); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	defer os.Remove(tmp)
+	for i := 0; i < 1000; i++ {
+		err := os.Link(tmp, path.Join(dir, t.packetName()))
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+	}
+	return fmt.Errorf("no free packet name in %s", dir)
+}
+
+// moveAside moves a file that could not be tossed into a
+// directory, keeping its name if it is free.
+func moveAside(file, dir, name string) (string, error) {
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return "", err
+	}
+	target := path.Join(dir, path.Base(name))
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(target); os.IsNotExist(err) {
+			break
+		}
+		if i > 1000 {
+			return "", fmt.Errorf("no free name for %s in %s", name, dir)
+		}
+		target = path.Join(dir, fmt.Sprintf("%s.%d", path.Base(name), i))
+	}
+	return target, os.Rename(file, target)
+}
+
+// now returns the current time, to the second, as packets and
+// spools record it.
+func (t *Tosser) now() time.Time {
+	return t.clock().Truncate(time.Second)
+}
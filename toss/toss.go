@@ -0,0 +1,417 @@
+// Package toss tosses inbound mail.  It unpacks the bundles and
+// packets that links send, checks their passwords, and delivers
+// the messages in them: echomail to the areas it belongs to, and
+// from there to the other links subscribed to each area, in new
+// packets, and to the message bases of the areas that have them.
+// Netmail for this system is left in a directory of packets for
+// local readers; netmail for other systems is routed on towards
+// them.  Echomail posted locally to the message bases of the
+// areas is then sent to their links.
+//
+// Mail that cannot be tossed is kept in the `bad` directory in
+// the data directory: whole files when they cannot be read or
+// fail their password check, and packets of messages for areas
+// that are unknown or not linked to the link they came from, or
+// of netmail that cannot be routed.
+package toss
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"fat-dragon.org/ginko/bundle"
+	"fat-dragon.org/ginko/config"
+	"fat-dragon.org/ginko/ftn"
+	"fat-dragon.org/ginko/logging"
+	"fat-dragon.org/ginko/message"
+	"fat-dragon.org/ginko/msgbase"
+	"fat-dragon.org/ginko/pkt"
+	"fat-dragon.org/ginko/spool"
+)
+
+// Directories within the data directory.
+const (
+	BadDir     = "bad"
+	NetmailDir = "netmail"
+)
+
+var (
+	ErrUnknownOrigin = errors.New("toss: packet from no link of this spool")
+	ErrBadPassword   = errors.New("toss: bad packet password")
+	ErrBusy          = errors.New("toss: another tosser is running")
+)
+
+// Stats counts what a tosser has done.
+type Stats struct {
+	Files     int // Bundles and packets tossed.
+	Packets   int
+	Netmail   int
+	Routed    int // Netmail sent on to other systems.
+	Echomail  int
+	Forwarded int // Copies of echomail sent to links.
+	Exported  int // Echomail posted locally and sent to links.
+	Dupes     int
+	Bad       int // Messages and files set aside.
+}
+
+// Tosser tosses inbound mail.
+type Tosser struct {
+	Config   *config.Config
+	Log      *logging.Logger
+	Unpacker *bundle.Unpacker
+	Dupes    *Dupes
+	Stats    Stats
+
+	links   map[outputKey]*output
+	dirs    map[string]*output
+	bases   map[string]msgbase.Base
+	pending pending
+	direct  map[ftn.Address]*config.Link
+	serial  uint32
+	clock   func() time.Time
+}
+
+// New returns a tosser for the given configuration, with the
+// dupe database from its data directory.
+func New(c *config.Config, log *logging.Logger) (*Tosser, error) {
+	unpacker := &bundle.Unpacker{External: make(map[bundle.Format][]string)}
+	for name, command := range c.Unpack {
+		format, err := bundle.ParseFormat(name)
+		if err != nil {
+			return nil, err
+		}
+		unpacker.External[format] = command
+	}
+	dupes, err := OpenDupes(path.Join(c.DataDir, DupesFile), DupeAge)
+	if err != nil {
+		return nil, err
+	}
+	return &Tosser{
+		Config:   c,
+		Log:      log,
+		Unpacker: unpacker,
+		Dupes:    dupes,
+		links:    make(map[outputKey]*output),
+		dirs:     make(map[string]*output),
+		bases:    make(map[string]msgbase.Base),
+		direct:   make(map[ftn.Address]*config.Link),
+		serial:   uint32(time.Now().UnixNano() / int64(time.Millisecond)),
+		clock:    time.Now,
+	}, nil
+}
+
+// inbound is an inbound spool and the links that share it.
+type inbound struct {
+	spool *spool.Spool
+	links []*config.Link
+}
+
+// inbounds returns every link's inbound spool, in the order the
+// links are configured.
+func (t *Tosser) inbounds() []*inbound {
+	var inbounds []*inbound
+	byDir := make(map[string]*inbound)
+	for i := range t.Config.Nets {
+		for j := range t.Config.Nets[i].Links {
+			link := &t.Config.Nets[i].Links[j]
+			dir := link.InSpool.Dir()
+			if dir == "" {
+				continue
+			}
+			in := byDir[dir]
+			if in == nil {
+				in = &inbound{&link.InSpool, nil}
+				byDir[dir] = in
+				inbounds = append(inbounds, in)
+			}
+			in.links = append(in.links, link)
+		}
+	}
+	return inbounds
+}
+
+// Run tosses the mail waiting in every link's inbound spool, and
+// then exports the echomail posted locally.  Only one tosser runs
+// at a time.
+func (t *Tosser) Run() error {
+	lock, err := os.OpenFile(path.Join(t.Config.DataDir, "toss.lock"), os.O_RDWR|os.O_CREATE, 0660)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if err == syscall.EWOULDBLOCK {
+			return ErrBusy
+		}
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+	var firstErr error
+	for _, in := range t.inbounds() {
+		if err := t.TossSpool(in.spool, in.links); err != nil {
+			t.Log.Error("Tossing", in.spool.Dir(), "failed:", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if err := t.export(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// TossSpool tosses the bundles and packets in an inbound spool
+// shared by the given links.  Other files are left in the spool.
+//
+// The packets made are published, and the dupe database saved,
+// before the tossed files are removed, so mail is not lost if
+// tossing is interrupted; mail tossed again is caught as dupes.
+func (t *Tosser) TossSpool(in *spool.Spool, links []*config.Link) error {
+	in = in.WithLogger(t.Log)
+	if err := in.ConsumeAndConcatQueues("new", "Queue", "cur", "Queue"); err != nil {
+		return err
+	}
+	queue, err := in.ReadQueue("cur", "Queue")
+	if err != nil {
+		return err
+	}
+	var keep, tossed []spool.SpoolKey
+	for _, key := range queue {
+		name := key.FileKey.FileName
+		if !bundle.IsMailName(name) {
+			keep = append(keep, key)
+			continue
+		}
+		file := in.FileName("cur", key.Name)
+		if err := t.tossFile(file, name, links); err != nil {
+			t.Stats.Bad++
+			t.Log.Warn("Cannot toss", name, "from", in.Dir(), "-", err)
+			aside, err := moveAside(file, path.Join(t.Config.DataDir, BadDir), name)
+			if err != nil {
+				t.Log.Error("Cannot set aside", name, "-", err)
+				keep = append(keep, key)
+				continue
+			}
+			t.Log.Warn("Moved", name, "to", aside)
+			continue
+		}
+		t.Stats.Files++
+		tossed = append(tossed, key)
+	}
+	if err := t.flush(); err != nil {
+		return err
+	}
+	if err := t.Dupes.Save(t.now()); err != nil {
+		return err
+	}
+	for i := range tossed {
+		if err := in.Remove("cur", &tossed[i]); err != nil && !os.IsNotExist(err) {
+			t.Log.Warn("Cannot remove", tossed[i].FileKey.FileName, "-", err)
+		}
+	}
+	if keep == nil {
+		keep = []spool.SpoolKey{}
+	}
+	return in.SaveQueue("cur", "Queue", keep)
+}
+
+// tossFile tosses each packet in a bundle, or a bare packet.
+// Files in a bundle that are not packets are skipped.  None of
+// the messages in the file are delivered unless all of it can be
+// tossed.
+func (t *Tosser) tossFile(file, name string, links []*config.Link) error {
+	return t.whole(func() error {
+		return t.Unpacker.Unpack(file, func(member string, r io.Reader) error {
+			if member == path.Base(file) {
+				member = name
+			}
+			err := t.tossPacket(member, r, links)
+			if errors.Is(err, pkt.ErrNotPacket) {
+				t.Log.Warn("Skipping", member, "in", name, "-", err)
+				return nil
+			}
+			return err
+		})
+	})
+}
+
+// sender returns which of the links sent a packet, if any.
+func (t *Tosser) sender(origin ftn.Address, links []*config.Link) *config.Link {
+	for _, link := range links {
+		a := link.Address
+		if (a.Zone() == origin.Zone() || origin.Zone() == 0) &&
+			a.Net() == origin.Net() && a.Node() == origin.Node() && a.Point() == origin.Point() {
+			return link
+		}
+	}
+	if found := t.Config.LinkFor(origin); found != nil {
+		for _, link := range links {
+			if link == found {
+				return link
+			}
+		}
+	}
+	return nil
+}
+
+// TossPacket tosses the messages in a packet from one of the
+// given links, checking its password.  None of them are
+// delivered unless all of the packet can be tossed.
+func (t *Tosser) TossPacket(name string, r io.Reader, links []*config.Link) error {
+	return t.whole(func() error {
+		return t.tossPacket(name, r, links)
+	})
+}
+
+func (t *Tosser) tossPacket(name string, r io.Reader, links []*config.Link) error {
+	pr, err := pkt.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	h := pr.Header
+	link := t.sender(h.Origin, links)
+	if link == nil {
+		return fmt.Errorf("%s: %w: %v", name, ErrUnknownOrigin, h.Origin)
+	}
+	if !strings.EqualFold(h.Password, packetPassword(link.Password)) {
+		return fmt.Errorf("%s: %w from %v", name, ErrBadPassword, link.Address)
+	}
+	t.Stats.Packets++
+	for {
+		m, err := pr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := t.tossMessage(link, m); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+}
+
+func (t *Tosser) tossMessage(link *config.Link, m *pkt.Message) error {
+	text := message.Parse(m.Text)
+	if text.Area == "" {
+		return t.tossNetmail(link, m, text)
+	}
+	return t.tossEchomail(link, m, text)
+}
+
+func (t *Tosser) netmailDir() string {
+	if t.Config.Netmail != "" {
+		return t.Config.Netmail
+	}
+	return path.Join(t.Config.DataDir, NetmailDir)
+}
+
+// ourAddress returns our main address in a link's net.
+func (t *Tosser) ourAddress(link *config.Link) ftn.Address {
+	if link.LinkedNet != nil {
+		return link.LinkedNet.Address
+	}
+	return t.Config.Addresses()[0]
+}
+
+// bad sets a message aside in a packet in the bad directory.
+func (t *Tosser) bad(link *config.Link, m *pkt.Message, reason string) error {
+	t.Stats.Bad++
+	t.Log.Warn("Bad message from", link.Address, "-", reason)
+	t.deliver(t.dirOutput(path.Join(t.Config.DataDir, BadDir), t.ourAddress(link)), m)
+	return nil
+}
+
+// tossEchomail delivers echomail to its area, storing it in the
+// area's message base, and forwards it to the area's other links
+// that have not seen it.  Our address, and those of the links it
+// is forwarded to, are added to its SEEN-BY, and ours to its
+// PATH.
+func (t *Tosser) tossEchomail(link *config.Link, m *pkt.Message, text *message.Message) error {
+	t.Stats.Echomail++
+	if link.LinkedNet == nil {
+		return t.bad(link, m, "echomail from a link in no net")
+	}
+	area := link.LinkedNet.Area(text.Area)
+	if area == nil {
+		return t.bad(link, m, "unknown area "+text.Area)
+	}
+	if !area.Linked(link.Address) {
+		return t.bad(link, m, "not linked to "+area.Tag)
+	}
+	if text.MsgID != nil && t.dupe(area.Tag, text.MsgID.String()) {
+		t.Stats.Dupes++
+		t.Log.Debug("Dupe in", area.Tag, "from", link.Address, "-", text.MsgID)
+		return nil
+	}
+	us := link.LinkedNet.Address
+	targets := t.spread(area, us, link, text)
+	tossed := *m
+	tossed.Text = text.String()
+	if area.Dir != "" {
+		t.deliver(t.dirOutput(area.Dir, us), &tossed)
+	}
+	if area.Base != "" {
+		stored := msgbase.FromPacket(t.decode(link.LinkedNet, &tossed), us.Zone(), t.now())
+		stored.Attributes &^= msgbase.Attribute(pkt.LocalAttributes)
+		t.deliverToBase(area.Base, area.Tag, stored)
+	}
+	t.forward(targets, &tossed)
+	return nil
+}
+
+// spread adds our address, and those of the area's links that
+// have not seen a message, to its SEEN-BY, and ours to its PATH,
+// returning the links to send it to.  The link it came from, if
+// any, is left out.
+func (t *Tosser) spread(area *config.Area, us ftn.Address, from *config.Link, text *message.Message) []*config.Link {
+	seen := make(map[ftn.Address]bool)
+	for _, addr := range text.SeenBy {
+		seen[addr] = true
+	}
+	seenBy := []ftn.Address{ftn.NetNode(us)}
+	var targets []*config.Link
+	for _, addr := range area.Links {
+		if from != nil && t.Config.Zones.Equal(addr, from.Address) {
+			continue
+		}
+		target := t.Config.LinkFor(addr)
+		if target == nil {
+			t.Log.Warn("Area", area.Tag, "lists", addr, "which is not a link")
+			continue
+		}
+		// Points are not listed in SEEN-BY, so are always
+		// sent the mail they did not send themselves.
+		if addr.Point() == 0 {
+			if seen[ftn.NetNode(addr)] {
+				continue
+			}
+			seenBy = append(seenBy, ftn.NetNode(addr))
+		}
+		targets = append(targets, target)
+	}
+	text.AddSeenBy(seenBy...)
+	text.AddPath(us)
+	return targets
+}
+
+// forward sends echomail on to links, from our address in each
+// link's net.
+func (t *Tosser) forward(targets []*config.Link, m *pkt.Message) {
+	for _, target := range targets {
+		o := t.linkOutput(target, spool.FlavorNormal, spool.ClassEchomail)
+		forwarded := *m
+		forwarded.Attributes &^= pkt.LocalAttributes
+		forwarded.Origin = ftn.NetNode(o.header.Origin)
+		forwarded.Dest = ftn.NetNode(target.Address)
+		t.deliver(o, &forwarded)
+		t.Stats.Forwarded++
+	}
+}
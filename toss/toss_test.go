@@ -0,0 +1,473 @@
+package toss
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"fat-dragon.org/ginko/bundle"
+	"fat-dragon.org/ginko/charset"
+	"fat-dragon.org/ginko/config"
+	"fat-dragon.org/ginko/ftn"
+	"fat-dragon.org/ginko/ftn/ftntest"
+	"fat-dragon.org/ginko/logging"
+	"fat-dragon.org/ginko/message"
+	"fat-dragon.org/ginko/msgbase"
+	"fat-dragon.org/ginko/pkt"
+	"fat-dragon.org/ginko/spool"
+)
+
+var testTime = time.Date(2023, time.March, 4, 5, 6, 7, 0, time.UTC)
+
+func testTosser(t *testing.T) (*Tosser, string) {
+	dir := t.TempDir()
+	c, err := config.ParseFromString(fmt.Sprintf(`{
+		dataDir: "%[1]s/data",
+		nets: [{
+			name: "fidonet",
+			address: "1:387/108@fidonet",
+			areas: [
+				{ tag: "FIDOTEST", links: ["1:387/1", "1:387/2", "1:387/108.1"], dir: "%[1]s/fidotest" },
+				{ tag: "LOCAL", links: ["1:387/1"], base: "squish:%[1]s/local" },
+			],
+			routes: [
+				{ to: "1:387/*", via: "direct" },
+				{ to: "*", via: "1:387/1" },
+			],
+			links: [
+				{ address: "1:387/1@fidonet", password: "Secret", in: "%[1]s/in1", out: "%[1]s/out1" },
+				{ address: "1:387/2@fidonet", password: "LongPassword", in: "%[1]s/in2", out: "%[1]s/out2" },
+				{ address: "1:387/108.1@fidonet", password: "point", in: "%[1]s/in2", out: "%[1]s/out3" },
+			],
+		}],
+	}`, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(c.DataDir, 0770); err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range c.Links {
+		for _, s := range []*spool.Spool{&link.InSpool, &link.OutSpool} {
+			if err := s.Create(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	tosser, err := New(c, logging.New(io.Discard, logging.Debug, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tosser.clock = func() time.Time { return testTime }
+	return tosser, dir
+}
+
+// echomail returns a message in the given area, with the given
+// MSGID serial, as sent by 1:387/1.
+func echomail(area string, serial int, seenBy string) *pkt.Message {
+	return &pkt.Message{
+		Origin:   ftn.NewAddress2d(387, 1),
+		Dest:     ftn.NewAddress2d(387, 108),
+		DateTime: pkt.FormatDateTime(testTime),
+		To:       "All",
+		From:     "Sysop",
+		Subject:  "Test",
+		Text: fmt.Sprintf("AREA:%s\r\x01MSGID: 1:387/1 %08x\rHello\r--- test\r * Origin: A BBS (1:387/1)\r"+
+			"SEEN-BY: %s\r\x01PATH: 387/1\r", area, serial, seenBy),
+	}
+}
+
+func packet(t *testing.T, from, to, password string, messages ...*pkt.Message) []byte {
+	var b bytes.Buffer
+	w, err := pkt.NewWriter(&b, &pkt.Header{
+		Origin:   ftntest.Address(t, from),
+		Dest:     ftntest.Address(t, to),
+		Time:     testTime,
+		Password: password,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range messages {
+		if err := w.WriteMessage(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// receive publishes a file to a spool, as a session does.
+func receive(t *testing.T, s *spool.Spool, name string, data []byte) {
+	fileKey := spool.NewFileKey(name, int64(len(data)), testTime)
+	key, f, err := s.TempFileFor(&fileKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := s.Publish(key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// queued returns the messages in the packets published to a
+// spool, and checks the packets' headers.
+func queued(t *testing.T, s *spool.Spool, to, password string) []*pkt.Message {
+	queue, err := s.ReadQueue("new", "Queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []*pkt.Message
+	for _, key := range queue {
+		f, err := os.Open(s.FileName("new", key.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		messages = append(messages, readPacket(t, f, to, password)...)
+	}
+	return messages
+}
+
+// left returns the messages in the packets left in a directory.
+func left(t *testing.T, dir string) []*pkt.Message {
+	files, _ := filepath.Glob(path.Join(dir, "*.pkt"))
+	sort.Strings(files)
+	var messages []*pkt.Message
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		messages = append(messages, readPacket(t, f, "", "")...)
+	}
+	return messages
+}
+
+func readPacket(t *testing.T, r io.Reader, to, password string) []*pkt.Message {
+	pr, err := pkt.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if to != "" && (pr.Header.Dest.String4d() != to || pr.Header.Password != password || pr.Header.Origin.String4d() != "1:387/108") {
+		t.Errorf("packet from %v to %v with password %q, want from 1:387/108 to %s with %q",
+			pr.Header.Origin, pr.Header.Dest, pr.Header.Password, to, password)
+	}
+	var messages []*pkt.Message
+	for {
+		m, err := pr.Next()
+		if err == io.EOF {
+			return messages
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, m)
+	}
+}
+
+func TestTossEchomail(t *testing.T) {
+	tosser, dir := testTosser(t)
+	link := tosser.Config.LinkFor(ftntest.Address(t, "1:387/1"))
+	first := echomail("FIDOTEST", 1, "387/1")
+	first.Attributes = pkt.Private | pkt.Local | pkt.Sent
+	receive(t, &link.InSpool, "0000006b.pkt", packet(t, "1:387/1", "1:387/108", "SECRET",
+		first, echomail("fidotest", 2, "387/1 2")))
+	// The same mail again, bundled, is all dupes.
+	var zipped bytes.Buffer
+	err := bundle.PackZip(&zipped, []bundle.File{
+		{Name: "0000006c.pkt", Modified: testTime, Data: packet(t, "1:387/1", "1:387/108", "secret", echomail("FIDOTEST", 1, "387/1"))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, &link.InSpool, "0000006b.mo0", zipped.Bytes())
+	receive(t, &link.InSpool, "nodelist.z23", []byte("not mail"))
+
+	if err := tosser.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := Stats{Files: 2, Packets: 2, Echomail: 3, Forwarded: 3, Dupes: 1}
+	if tosser.Stats != want {
+		t.Errorf("stats %+v, want %+v", tosser.Stats, want)
+	}
+
+	// 1:387/2 gets only the first message, having seen the
+	// second; the point gets both; the sender gets neither.
+	toNode := queued(t, &tosser.Config.LinkFor(ftntest.Address(t, "1:387/2")).OutSpool, "1:387/2", "LongPass")
+	if len(toNode) != 1 {
+		t.Fatalf("forwarded %d messages to 1:387/2, want 1", len(toNode))
+	}
+	text := message.Parse(toNode[0].Text)
+	if got := fmt.Sprint(text.SeenBy); got != "[387/1 387/2 387/108]" {
+		t.Errorf("SEEN-BY %s", got)
+	}
+	if got := fmt.Sprint(text.Path); got != "[387/1 387/108]" {
+		t.Errorf("PATH %s", got)
+	}
+	if toNode[0].Origin.String() != "387/108" || toNode[0].Dest.String() != "387/2" {
+		t.Errorf("forwarded from %v to %v", toNode[0].Origin, toNode[0].Dest)
+	}
+	if toNode[0].Attributes != pkt.Private {
+		t.Errorf("forwarded with attributes %v", toNode[0].Attributes)
+	}
+	if !strings.HasPrefix(toNode[0].Text, "AREA:FIDOTEST\r\x01MSGID: 1:387/1 00000001\rHello\r") {
+		t.Errorf("forwarded text %q", toNode[0].Text)
+	}
+	if toPoint := queued(t, &tosser.Config.LinkFor(ftntest.Address(t, "1:387/108.1")).OutSpool, "1:387/108.1", "point"); len(toPoint) != 2 {
+		t.Errorf("forwarded %d messages to the point, want 2", len(toPoint))
+	}
+	if toSender := queued(t, &link.OutSpool, "", ""); len(toSender) != 0 {
+		t.Errorf("sent %d messages back to the sender", len(toSender))
+	}
+	if local := left(t, path.Join(dir, "fidotest")); len(local) != 2 {
+		t.Errorf("left %d messages in the area, want 2", len(local))
+	}
+
+	// Only the file that is not mail is left in the spool.
+	queue, err := link.InSpool.ReadQueue("cur", "Queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 1 || queue[0].FileKey.FileName != "nodelist.z23" {
+		t.Errorf("left in spool: %v", queue)
+	}
+	files, _ := os.ReadDir(link.InSpool.FileName("cur", ""))
+	if len(files) != 2 { // The queue and the nodelist.
+		t.Errorf("%d files left in cur", len(files))
+	}
+
+	// The dupes are remembered by the next tosser.
+	again, err := New(tosser.Config, tosser.Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Dupes.Check("FIDOTEST", "1:387/1 00000002", testTime) {
+		t.Error("dupe database not saved")
+	}
+}
+
+func TestTossToBase(t *testing.T) {
+	tosser, dir := testTosser(t)
+	link := tosser.Config.LinkFor(ftntest.Address(t, "1:387/1"))
+	receive(t, &link.InSpool, "0000006b.pkt", packet(t, "1:387/1", "1:387/108", "SECRET",
+		echomail("LOCAL", 1, "387/1"), echomail("LOCAL", 2, "387/1"), echomail("LOCAL", 1, "387/1")))
+
+	if err := tosser.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := Stats{Files: 1, Packets: 1, Echomail: 3, Dupes: 1}
+	if tosser.Stats != want {
+		t.Errorf("stats %+v, want %+v", tosser.Stats, want)
+	}
+	b, err := msgbase.Open("squish:" + path.Join(dir, "local"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	nums, err := b.Numbers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nums) != 2 {
+		t.Fatalf("stored %d messages, want 2", len(nums))
+	}
+	m, err := b.Read(nums[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Squish keeps times to two seconds.
+	if m.From != "Sysop" || m.Origin.String() != "1:387/1" ||
+		testTime.Sub(m.Written) >= 2*time.Second || testTime.Sub(m.Arrived) >= 2*time.Second {
+		t.Errorf("stored %+v", m)
+	}
+	if !strings.HasPrefix(m.Text, "\x01MSGID: 1:387/1 00000001\r\x01CHRS: UTF-8 4\rHello\r") || !strings.Contains(m.Text, "\rSEEN-BY: 387/1 108\r") {
+		t.Errorf("stored text %q", m.Text)
+	}
+}
+
+func TestTossCharset(t *testing.T) {
+	tosser, dir := testTosser(t)
+	tosser.Config.Nets[0].Charset = charset.CP866
+	link := tosser.Config.LinkFor(ftntest.Address(t, "1:387/1"))
+	// The first message is in the net's character set; the second
+	// names its own.
+	m := echomail("LOCAL", 1, "387/1")
+	m.Subject = "\x8f\xe0\xa8\xa2\xa5\xe2"
+	m.Text = strings.Replace(m.Text, "Hello", "\x8f\xe0\xa8\xa2\xa5\xe2", 1)
+	latin := echomail("LOCAL", 2, "387/1")
+	latin.Text = strings.Replace(latin.Text, "Hello", "\x01CHRS: LATIN-1 2\rCaf\xe9", 1)
+	receive(t, &link.InSpool, "0000006b.pkt", packet(t, "1:387/1", "1:387/108", "SECRET", m, latin))
+
+	if err := tosser.Run(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := msgbase.Open("squish:" + path.Join(dir, "local"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	nums, err := b.Numbers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nums) != 2 {
+		t.Fatalf("stored %d messages, want 2", len(nums))
+	}
+	for i, want := range []string{"\x01CHRS: UTF-8 4\rПривет\r", "\x01CHRS: UTF-8 4\rCafé\r"} {
+		stored, err := b.Read(nums[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(stored.Text, want) {
+			t.Errorf("stored text %q, want %q", stored.Text, want)
+		}
+		if i == 0 && stored.Subject != "Привет" {
+			t.Errorf("stored subject %q", stored.Subject)
+		}
+	}
+}
+
+func TestTossBad(t *testing.T) {
+	tosser, dir := testTosser(t)
+	node := tosser.Config.LinkFor(ftntest.Address(t, "1:387/2"))
+	netmail := &pkt.Message{
+		Origin:   ftn.NewAddress2d(387, 2),
+		Dest:     ftn.NewAddress2d(387, 108),
+		DateTime: pkt.FormatDateTime(testTime),
+		To:       "Sysop",
+		From:     "Someone",
+		Text:     "Hi!\r",
+	}
+	receive(t, &node.InSpool, "0000006b.pkt", packet(t, "1:387/2", "1:387/108", "longpass",
+		echomail("NOSUCH", 1, "387/1"), echomail("LOCAL", 2, "387/1"), netmail))
+	receive(t, &node.InSpool, "0000006c.pkt", packet(t, "1:387/2", "1:387/108", "wrong", netmail))
+	receive(t, &node.InSpool, "0000006d.pkt", packet(t, "1:387/3", "1:387/108", "", netmail))
+	receive(t, &node.InSpool, "0000006e.we1", []byte("garbage"))
+
+	if err := tosser.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := Stats{Files: 1, Packets: 1, Netmail: 1, Echomail: 2, Bad: 5}
+	if tosser.Stats != want {
+		t.Errorf("stats %+v, want %+v", tosser.Stats, want)
+	}
+	if got := left(t, path.Join(dir, "data", NetmailDir)); len(got) != 1 || got[0].Text != "Hi!\r" {
+		t.Errorf("netmail left: %v", got)
+	}
+	bad := path.Join(dir, "data", BadDir)
+	if got := left(t, bad); len(got) != 4 { // Two messages, and two whole packets of one.
+		t.Errorf("%d bad messages", len(got))
+	}
+	for _, name := range []string{"0000006c.pkt", "0000006d.pkt", "0000006e.we1"} {
+		if _, err := os.Stat(path.Join(bad, name)); err != nil {
+			t.Errorf("%s not set aside: %v", name, err)
+		}
+	}
+	if queue, _ := node.InSpool.ReadQueue("cur", "Queue"); len(queue) != 0 {
+		t.Errorf("left in spool: %v", queue)
+	}
+}
+
+func TestTossPartial(t *testing.T) {
+	tosser, dir := testTosser(t)
+	link := tosser.Config.LinkFor(ftntest.Address(t, "1:387/1"))
+	// A bundle whose second packet fails is set aside whole, so
+	// nothing from its first may be delivered.
+	var zipped bytes.Buffer
+	err := bundle.PackZip(&zipped, []bundle.File{
+		{Name: "0000006b.pkt", Modified: testTime, Data: packet(t, "1:387/1", "1:387/108", "secret",
+			echomail("FIDOTEST", 1, "387/1"), echomail("LOCAL", 2, "387/1"))},
+		{Name: "0000006c.pkt", Modified: testTime, Data: packet(t, "1:387/1", "1:387/108", "wrong", echomail("FIDOTEST", 3, "387/1"))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, &link.InSpool, "0000006b.mo0", zipped.Bytes())
+	// As is a packet that is cut short.
+	data := packet(t, "1:387/1", "1:387/108", "secret", echomail("FIDOTEST", 4, "387/1"), echomail("FIDOTEST", 5, "387/1"))
+	receive(t, &link.InSpool, "0000006d.pkt", data[:len(data)-20])
+
+	if err := tosser.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if want := (Stats{Bad: 2}); tosser.Stats != want {
+		t.Errorf("stats %+v, want %+v", tosser.Stats, want)
+	}
+	if toNode := queued(t, &tosser.Config.LinkFor(ftntest.Address(t, "1:387/2")).OutSpool, "1:387/2", "LongPass"); len(toNode) != 0 {
+		t.Errorf("forwarded %d messages to 1:387/2", len(toNode))
+	}
+	if local := left(t, path.Join(dir, "fidotest")); len(local) != 0 {
+		t.Errorf("left %d messages in the area", len(local))
+	}
+	b, err := msgbase.Open("squish:" + path.Join(dir, "local"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if nums, err := b.Numbers(); len(nums) != 0 || err != nil {
+		t.Errorf("stored %v in the message base: %v", nums, err)
+	}
+	for _, serial := range []string{"00000001", "00000002", "00000004"} {
+		if tosser.Dupes.Check("FIDOTEST", "1:387/1 "+serial, testTime) || tosser.Dupes.Check("LOCAL", "1:387/1 "+serial, testTime) {
+			t.Errorf("MSGID %s recorded", serial)
+		}
+	}
+	for _, name := range []string{"0000006b.mo0", "0000006d.pkt"} {
+		if _, err := os.Stat(path.Join(dir, "data", BadDir, name)); err != nil {
+			t.Errorf("%s not set aside: %v", name, err)
+		}
+	}
+}
+
+func TestTossPacketErrors(t *testing.T) {
+	tosser, _ := testTosser(t)
+	links := []*config.Link{tosser.Config.LinkFor(ftntest.Address(t, "1:387/1"))}
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"password", packet(t, "1:387/1", "1:387/108", "secrets"), ErrBadPassword},
+		{"origin", packet(t, "1:387/2", "1:387/108", "secret"), ErrUnknownOrigin},
+		{"not a packet", []byte("hello"), pkt.ErrNotPacket},
+	} {
+		err := tosser.TossPacket(tc.name, bytes.NewReader(tc.data), links)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.want)
+		}
+	}
+	if err := tosser.TossPacket("ok", bytes.NewReader(packet(t, "1:387/1", "1:387/108", "sEcReT")), links); err != nil {
+		t.Errorf("password case: %v", err)
+	}
+}
+
+func TestTossBusy(t *testing.T) {
+	tosser, _ := testTosser(t)
+	lock, err := os.OpenFile(path.Join(tosser.Config.DataDir, "toss.lock"), os.O_RDWR|os.O_CREATE, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatal(err)
+	}
+	if err := tosser.Run(); !errors.Is(err, ErrBusy) {
+		t.Errorf("got %v, want ErrBusy", err)
+	}
+}
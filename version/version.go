@@ -0,0 +1,42 @@
+// Package version describes the running build of ginko.
+//
+// Release builds set the version at link time:
+//
+//	go build -ldflags "-X fat-dragon.org/ginko/version.Version=1.0.0" ./cmd/ginko
+//
+// Otherwise the module version recorded by the Go toolchain is
+// used, if there is one.
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Mailer is the name by which ginko identifies itself.
+const Mailer = "ginko"
+
+// Protocol is the version of binkp that ginko speaks.
+const Protocol = "binkp/1.0"
+
+// Version is the release version, injected by the linker.
+var Version string
+
+// String returns the version of the running build.
+func String() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v := info.Main.Version; v != "" && v != "(devel)" {
+			return v
+		}
+	}
+	return "devel"
+}
+
+// Ver returns the text of the binkp VER line, in the customary
+// "mailer/version/os/arch binkp/x.y" form.
+func Ver() string {
+	return Mailer + "/" + String() + "/" + runtime.GOOS + "/" + runtime.GOARCH + " " + Protocol
+}
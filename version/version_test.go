@@ -0,0 +1,25 @@
+package version
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestVer(t *testing.T) {
+	saved := Version
+	defer func() { Version = saved }()
+	Version = "1.2.3"
+	want := "ginko/1.2.3/" + runtime.GOOS + "/" + runtime.GOARCH + " binkp/1.0"
+	if got := Ver(); got != want {
+		t.Errorf("Ver() = %q, want %q", got, want)
+	}
+}
+
+func TestStringDefault(t *testing.T) {
+	saved := Version
+	defer func() { Version = saved }()
+	Version = ""
+	if String() == "" {
+		t.Error("String() is empty without an injected version")
+	}
+}